@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/CrossEngage/CaOps/internal/jolokia"
+	"github.com/spf13/cobra"
+)
+
+var (
+	jmxCmd = &cobra.Command{
+		Use:   "jmx",
+		Short: "Explores the MBeans of the local Cassandra node through its Jolokia agent",
+	}
+	jmxListCmd = &cobra.Command{
+		Use:   "list [domain]",
+		Short: "Lists the MBeans with their attributes and operation signatures",
+		Args:  cobra.MaximumNArgs(1),
+		Run:   runJMXListCmd,
+	}
+	jmxReadCmd = &cobra.Command{
+		Use:   "read <mbean> [attribute]",
+		Short: "Reads an attribute, or all the attributes, of the MBeans matching the name",
+		Args:  cobra.RangeArgs(1, 2),
+		Run:   runJMXReadCmd,
+	}
+	jmxExecCmd = &cobra.Command{
+		Use:   "exec <mbean> <operation> [args...]",
+		Short: "Invokes an operation, converting the arguments to the types of its signature",
+		Long: "Invokes an operation, converting the arguments to the types of its signature.\n" +
+			"Arrays and lists are given as comma separated values.",
+		Args: cobra.MinimumNArgs(2),
+		Run:  runJMXExecCmd,
+	}
+	jmxSearchCmd = &cobra.Command{
+		Use:   "search <pattern>",
+		Short: "Finds the MBeans matching the pattern, like org.apache.cassandra.db:type=Tables,*",
+		Args:  cobra.ExactArgs(1),
+		Run:   runJMXSearchCmd,
+	}
+	jmxOutput string
+)
+
+func init() {
+	baseCmd.AddCommand(jmxCmd)
+	jmxCmd.AddCommand(jmxListCmd, jmxReadCmd, jmxExecCmd, jmxSearchCmd)
+	jmxCmd.PersistentFlags().StringVarP(&jmxOutput, "output", "o", "table", "Output format, table or json")
+}
+
+func jmxClient() jolokia.Client {
+	if jmxOutput != "table" && jmxOutput != "json" {
+		logFatal(fmt.Errorf("Unknown output format '%s'", jmxOutput))
+	}
+	client, err := jolokia.NewClientFromConfig(jolokiaConfig())
+	logFatal(err)
+	return client
+}
+
+func runJMXListCmd(cmd *cobra.Command, args []string) {
+	client := jmxClient()
+	domain := ""
+	if len(args) > 0 {
+		domain = args[0]
+	}
+	tree, err := client.List(context.Background(), domain)
+	logFatal(err)
+	if jmxOutput == "json" {
+		logFatal(writeJSONOutput(os.Stdout, tree))
+		return
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "MBEAN\tKIND\tNAME\tTYPE\tACCESS")
+	for _, domain := range sortedKeys(tree) {
+		for _, properties := range sortedKeys(tree[domain]) {
+			name := domain + ":" + properties
+			mbean := tree[domain][properties]
+			for _, attr := range sortedKeys(mbean.Attributes) {
+				access := "r"
+				if mbean.Attributes[attr].ReadWrite {
+					access = "rw"
+				}
+				fmt.Fprintf(tw, "%s\tattribute\t%s\t%s\t%s\n", name, attr, mbean.Attributes[attr].Type, access)
+			}
+			for _, op := range sortedKeys(mbean.Operations) {
+				for _, signature := range mbean.Operations[op] {
+					fmt.Fprintf(tw, "%s\toperation\t%s\t%s\t\n", name, signature.Signature(op), signature.ReturnType)
+				}
+			}
+		}
+	}
+	logFatal(tw.Flush())
+}
+
+func runJMXReadCmd(cmd *cobra.Command, args []string) {
+	client := jmxClient()
+	value := jolokia.MBeansValue{}
+	batch := jolokia.NewBatch()
+	result := batch.ReadMBeans(&value, args[0], args[1:]...)
+	logFatal(client.SendBatch(context.Background(), batch))
+	logFatal(result.Err())
+	if jmxOutput == "json" {
+		logFatal(writeJSONOutput(os.Stdout, value))
+		return
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "MBEAN\tATTRIBUTE\tVALUE")
+	for _, mbean := range sortedKeys(value) {
+		for _, attr := range sortedKeys(value[mbean]) {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", mbean, attr, formatValue(value[mbean][attr]))
+		}
+	}
+	logFatal(tw.Flush())
+}
+
+func runJMXExecCmd(cmd *cobra.Command, args []string) {
+	client := jmxClient()
+	ctx := context.Background()
+	mbean, name, rawArgs := args[0], args[1], args[2:]
+
+	info, err := client.ListMBean(ctx, mbean)
+	logFatal(err)
+	operation, signature, err := info.Operations.Resolve(name, len(rawArgs))
+	logFatal(err)
+	opArgs, err := jolokia.CoerceArguments(signature, rawArgs)
+	logFatal(err)
+
+	var value interface{}
+	batch := jolokia.NewBatch()
+	result := batch.Exec(&value, mbean, operation, opArgs...)
+	logFatal(client.SendBatch(ctx, batch))
+	logFatal(result.Err())
+	if jmxOutput == "json" {
+		logFatal(writeJSONOutput(os.Stdout, value))
+		return
+	}
+	if value != nil {
+		fmt.Println(formatValue(value))
+	}
+}
+
+func runJMXSearchCmd(cmd *cobra.Command, args []string) {
+	client := jmxClient()
+	mbeans, err := client.Search(context.Background(), args[0])
+	logFatal(err)
+	sort.Strings(mbeans)
+	if jmxOutput == "json" {
+		logFatal(writeJSONOutput(os.Stdout, mbeans))
+		return
+	}
+	fmt.Println("MBEAN")
+	for _, mbean := range mbeans {
+		fmt.Println(mbean)
+	}
+}
+
+func writeJSONOutput(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// formatValue prints scalars as they are, and the other values as compact JSON
+func formatValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return v
+	case bool, float64:
+		return fmt.Sprint(v)
+	}
+	buf, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(buf)
+}
+
+// sortedKeys returns the keys of a map keyed by strings, sorted
+func sortedKeys(m interface{}) []string {
+	keys := make([]string, 0)
+	for _, key := range reflect.ValueOf(m).MapKeys() {
+		keys = append(keys, key.String())
+	}
+	sort.Strings(keys)
+	return keys
+}
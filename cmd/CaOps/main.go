@@ -1,6 +1,7 @@
 package main
 
 import (
+	"github.com/CrossEngage/CaOps/internal/jolokia"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -51,3 +52,23 @@ func initConfig() {
 		logrus.Error("Could not load configuration:", err)
 	}
 }
+
+// jolokiaConfig returns the settings of the Jolokia agent of the local Cassandra node
+func jolokiaConfig() jolokia.Config {
+	return jolokia.Config{
+		URL:                 viper.GetString("cassandra.jolokia_url"),
+		Username:            viper.GetString("cassandra.jolokia.username"),
+		Password:            viper.GetString("cassandra.jolokia.password"),
+		CAFile:              viper.GetString("cassandra.jolokia.ca_file"),
+		InsecureSkipVerify:  viper.GetBool("cassandra.jolokia.insecure_skip_verify"),
+		Timeout:             viper.GetDuration("cassandra.jolokia.timeout"),
+		MaxIdleConns:        viper.GetInt("cassandra.jolokia.max_idle_conns"),
+		MaxConns:            viper.GetInt("cassandra.jolokia.max_conns"),
+		IdleConnTimeout:     viper.GetDuration("cassandra.jolokia.idle_conn_timeout"),
+		ReadRetries:         viper.GetInt("cassandra.jolokia.read_retries"),
+		RetryBackoff:        viper.GetDuration("cassandra.jolokia.retry_backoff"),
+		ProxyTargetURL:      viper.GetString("cassandra.jolokia.proxy.target_url"),
+		ProxyTargetUsername: viper.GetString("cassandra.jolokia.proxy.username"),
+		ProxyTargetPassword: viper.GetString("cassandra.jolokia.proxy.password"),
+	}
+}
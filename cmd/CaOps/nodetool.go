@@ -0,0 +1,442 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/CrossEngage/CaOps/internal/cassandra"
+	"github.com/spf13/cobra"
+)
+
+var (
+	nodetoolCmd = &cobra.Command{
+		Use:   "nodetool",
+		Short: "Reads the state of the local Cassandra node through its Jolokia agent, and manages its compactions, like nodetool",
+	}
+	nodetoolStatusCmd = &cobra.Command{
+		Use:   "status [keyspace]",
+		Short: "Prints the state, load and ownership of the nodes of the cluster",
+		Args:  cobra.MaximumNArgs(1),
+		Run:   runNodetoolStatusCmd,
+	}
+	nodetoolRingCmd = &cobra.Command{
+		Use:   "ring [keyspace]",
+		Short: "Prints the tokens of the ring, with the nodes owning them",
+		Args:  cobra.MaximumNArgs(1),
+		Run:   runNodetoolRingCmd,
+	}
+	nodetoolInfoCmd = &cobra.Command{
+		Use:   "info",
+		Short: "Prints the description of the node",
+		Args:  cobra.NoArgs,
+		Run:   runNodetoolInfoCmd,
+	}
+	nodetoolDescribeClusterCmd = &cobra.Command{
+		Use:   "describecluster",
+		Short: "Prints the name, snitch, partitioner and schema versions of the cluster",
+		Args:  cobra.NoArgs,
+		Run:   runNodetoolDescribeClusterCmd,
+	}
+	nodetoolGossipInfoCmd = &cobra.Command{
+		Use:   "gossipinfo",
+		Short: "Prints the gossip state of the nodes of the cluster",
+		Args:  cobra.NoArgs,
+		Run:   runNodetoolGossipInfoCmd,
+	}
+	nodetoolTPStatsCmd = &cobra.Command{
+		Use:   "tpstats",
+		Short: "Prints the usage statistics of the thread pools, and the dropped messages",
+		Args:  cobra.NoArgs,
+		Run:   runNodetoolTPStatsCmd,
+	}
+	nodetoolTableStatsCmd = &cobra.Command{
+		Use:   "tablestats [keyspace[.table]]",
+		Short: "Prints the statistics of the tables",
+		Args:  cobra.MaximumNArgs(1),
+		Run:   runNodetoolTableStatsCmd,
+	}
+	nodetoolCompactionStatsCmd = &cobra.Command{
+		Use:   "compactionstats",
+		Short: "Prints the running and pending compactions",
+		Args:  cobra.NoArgs,
+		Run:   runNodetoolCompactionStatsCmd,
+	}
+	nodetoolNetStatsCmd = &cobra.Command{
+		Use:   "netstats",
+		Short: "Prints the streams, read repairs and messages of the node",
+		Args:  cobra.NoArgs,
+		Run:   runNodetoolNetStatsCmd,
+	}
+	nodetoolCompactionHistoryCmd = &cobra.Command{
+		Use:   "compactionhistory",
+		Short: "Prints the compactions which ran on the node, most recent first",
+		Args:  cobra.NoArgs,
+		Run:   runNodetoolCompactionHistoryCmd,
+	}
+	nodetoolGetCompactionThroughputCmd = &cobra.Command{
+		Use:   "getcompactionthroughput",
+		Short: "Prints the compaction throughput limit, in MB/s",
+		Args:  cobra.NoArgs,
+		Run:   runNodetoolGetCompactionThroughputCmd,
+	}
+	nodetoolSetCompactionThroughputCmd = &cobra.Command{
+		Use:   "setcompactionthroughput <MB/s>",
+		Short: "Sets the compaction throughput limit, in MB/s, where 0 is unlimited",
+		Args:  cobra.ExactArgs(1),
+		Run:   runNodetoolSetCompactionThroughputCmd,
+	}
+	nodetoolCompactCmd = &cobra.Command{
+		Use:   "compact <keyspace> [tables...] | --user-defined <data files...>",
+		Short: "Runs a major compaction of the tables, or a user-defined compaction of the data files",
+		Args:  cobra.MinimumNArgs(1),
+		Run:   runNodetoolCompactCmd,
+	}
+	nodetoolGarbageCollectCmd = &cobra.Command{
+		Use:   "garbagecollect <keyspace> [tables...]",
+		Short: "Removes the deleted data from the SSTables of the tables",
+		Args:  cobra.MinimumNArgs(1),
+		Run:   runNodetoolGarbageCollectCmd,
+	}
+	nodetoolStopCmd = &cobra.Command{
+		Use:   "stop <compaction type>",
+		Short: "Stops the running compactions of the type, like COMPACTION or VALIDATION",
+		Args:  cobra.ExactArgs(1),
+		Run:   runNodetoolStopCmd,
+	}
+	nodetoolJSON       bool
+	compactSplitOutput bool
+	compactUserDefined bool
+	garbageGranularity string
+)
+
+func init() {
+	baseCmd.AddCommand(nodetoolCmd)
+	nodetoolCmd.AddCommand(nodetoolStatusCmd, nodetoolRingCmd, nodetoolInfoCmd, nodetoolDescribeClusterCmd,
+		nodetoolGossipInfoCmd, nodetoolTPStatsCmd, nodetoolTableStatsCmd, nodetoolCompactionStatsCmd, nodetoolNetStatsCmd,
+		nodetoolCompactionHistoryCmd, nodetoolGetCompactionThroughputCmd, nodetoolSetCompactionThroughputCmd,
+		nodetoolCompactCmd, nodetoolGarbageCollectCmd, nodetoolStopCmd)
+	nodetoolCmd.PersistentFlags().BoolVar(&nodetoolJSON, "json", false, "Print the output as JSON")
+	nodetoolCompactCmd.Flags().BoolVarP(&compactSplitOutput, "split-output", "s", false,
+		"Split the output of the major compaction into SSTables of decreasing sizes")
+	nodetoolCompactCmd.Flags().BoolVar(&compactUserDefined, "user-defined", false,
+		"Compact the given data files together, which must belong to the same table")
+	nodetoolGarbageCollectCmd.Flags().StringVarP(&garbageGranularity, "granularity", "g", cassandra.TombstoneRow,
+		"Remove the deleted rows (ROW), or the deleted cells too (CELL)")
+}
+
+func nodetoolManager() *cassandra.Manager {
+	cassMngr, err := cassandra.NewManager(jolokiaConfig())
+	logFatal(err)
+	return cassMngr
+}
+
+// printNodetoolOutput writes the value as JSON when asked to, or prints it as text otherwise
+func printNodetoolOutput(value interface{}, printText func(w io.Writer)) {
+	if nodetoolJSON {
+		logFatal(writeJSONOutput(os.Stdout, value))
+		return
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	printText(tw)
+	logFatal(tw.Flush())
+}
+
+func optionalArg(args []string) string {
+	if len(args) > 0 {
+		return args[0]
+	}
+	return ""
+}
+
+// groupByDatacenter groups the items by datacenter, keeping their order, and returns the
+// datacenters sorted
+func groupByDatacenter(count int, datacenter func(i int) string) (datacenters []string, indexes map[string][]int) {
+	indexes = make(map[string][]int)
+	for i := 0; i < count; i++ {
+		dc := datacenter(i)
+		if _, ok := indexes[dc]; !ok {
+			datacenters = append(datacenters, dc)
+		}
+		indexes[dc] = append(indexes[dc], i)
+	}
+	sort.Strings(datacenters)
+	return datacenters, indexes
+}
+
+func printDatacenterHeader(w io.Writer, datacenter string) {
+	header := "Datacenter: " + datacenter
+	fmt.Fprintf(w, "%s\n%s\n", header, strings.Repeat("=", len(header)))
+}
+
+func formatOwnership(ownership *float64) string {
+	if ownership == nil {
+		return "?"
+	}
+	return fmt.Sprintf("%.1f%%", *ownership*100)
+}
+
+func runNodetoolStatusCmd(cmd *cobra.Command, args []string) {
+	keyspace := optionalArg(args)
+	endpoints, err := nodetoolManager().Endpoints(context.Background(), keyspace)
+	logFatal(err)
+	printNodetoolOutput(endpoints, func(w io.Writer) {
+		owns := "Owns"
+		if keyspace != "" {
+			owns = "Owns (effective)"
+		}
+		datacenters, indexes := groupByDatacenter(len(endpoints), func(i int) string { return endpoints[i].Datacenter })
+		for _, dc := range datacenters {
+			printDatacenterHeader(w, dc)
+			fmt.Fprintln(w, "Status=Up/Down")
+			fmt.Fprintln(w, "|/ State=Normal/Leaving/Joining/Moving")
+			fmt.Fprintf(w, "--\tAddress\tLoad\tTokens\t%s\tHost ID\tRack\n", owns)
+			for _, i := range indexes[dc] {
+				e := endpoints[i]
+				fmt.Fprintf(w, "%s%s\t%s\t%s\t%d\t%s\t%s\t%s\n", e.Status[:1], e.State[:1], e.Address, e.Load,
+					len(e.Tokens), formatOwnership(e.Ownership), e.HostID, e.Rack)
+			}
+			fmt.Fprintln(w)
+		}
+	})
+}
+
+func runNodetoolRingCmd(cmd *cobra.Command, args []string) {
+	ring, err := nodetoolManager().Ring(context.Background(), optionalArg(args))
+	logFatal(err)
+	printNodetoolOutput(ring, func(w io.Writer) {
+		datacenters, indexes := groupByDatacenter(len(ring), func(i int) string { return ring[i].Datacenter })
+		for _, dc := range datacenters {
+			printDatacenterHeader(w, dc)
+			fmt.Fprintln(w, "Address\tRack\tStatus\tState\tLoad\tOwns\tToken")
+			for _, i := range indexes[dc] {
+				t := ring[i]
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", t.Address, t.Rack, t.Status, t.State, t.Load,
+					formatOwnership(t.Ownership), t.Token)
+			}
+			fmt.Fprintln(w)
+		}
+	})
+}
+
+func runNodetoolInfoCmd(cmd *cobra.Command, args []string) {
+	info, err := nodetoolManager().Info(context.Background())
+	logFatal(err)
+	printNodetoolOutput(info, func(w io.Writer) {
+		mb := func(bytes int64) string { return fmt.Sprintf("%.2f", float64(bytes)/(1024*1024)) }
+		cache := func(c cassandra.CacheInfo) string {
+			return fmt.Sprintf("entries %d, size %d bytes, capacity %d bytes, %d hits, %d requests, %.3f recent hit rate",
+				c.Entries, c.Size, c.Capacity, c.Hits, c.Requests, c.HitRate)
+		}
+		fmt.Fprintf(w, "ID\t: %s\n", info.ID)
+		fmt.Fprintf(w, "Gossip active\t: %t\n", info.GossipActive)
+		fmt.Fprintf(w, "Thrift active\t: %t\n", info.ThriftActive)
+		fmt.Fprintf(w, "Native Transport active\t: %t\n", info.NativeTransportActive)
+		fmt.Fprintf(w, "Load\t: %s\n", info.Load)
+		fmt.Fprintf(w, "Generation No\t: %d\n", info.GenerationNumber)
+		fmt.Fprintf(w, "Uptime (seconds)\t: %d\n", info.UptimeSeconds)
+		fmt.Fprintf(w, "Heap Memory (MB)\t: %s / %s\n", mb(info.HeapMemory.Used), mb(info.HeapMemory.Max))
+		fmt.Fprintf(w, "Off Heap Memory (MB)\t: %s\n", mb(info.OffHeapMemory.Used))
+		fmt.Fprintf(w, "Data Center\t: %s\n", info.Datacenter)
+		fmt.Fprintf(w, "Rack\t: %s\n", info.Rack)
+		fmt.Fprintf(w, "Exceptions\t: %d\n", info.Exceptions)
+		fmt.Fprintf(w, "Key Cache\t: %s\n", cache(info.KeyCache))
+		fmt.Fprintf(w, "Row Cache\t: %s\n", cache(info.RowCache))
+		fmt.Fprintf(w, "Counter Cache\t: %s\n", cache(info.CounterCache))
+		if len(info.Tokens) == 1 {
+			fmt.Fprintf(w, "Token\t: %s\n", info.Tokens[0])
+		} else {
+			fmt.Fprintf(w, "Token\t: (%d tokens, use 'nodetool ring' to see them)\n", len(info.Tokens))
+		}
+	})
+}
+
+func runNodetoolDescribeClusterCmd(cmd *cobra.Command, args []string) {
+	description, err := nodetoolManager().DescribeCluster(context.Background())
+	logFatal(err)
+	printNodetoolOutput(description, func(w io.Writer) {
+		fmt.Fprintln(w, "Cluster Information:")
+		fmt.Fprintf(w, "\tName: %s\n", description.Name)
+		fmt.Fprintf(w, "\tSnitch: %s\n", description.Snitch)
+		fmt.Fprintf(w, "\tPartitioner: %s\n", description.Partitioner)
+		fmt.Fprintln(w, "\tSchema versions:")
+		for _, version := range sortedKeys(description.SchemaVersions) {
+			fmt.Fprintf(w, "\t\t%s: [%s]\n", version, strings.Join(description.SchemaVersions[version], ", "))
+		}
+	})
+}
+
+func runNodetoolGossipInfoCmd(cmd *cobra.Command, args []string) {
+	states, err := nodetoolManager().GossipInfo(context.Background())
+	logFatal(err)
+	printNodetoolOutput(states, func(w io.Writer) {
+		for _, address := range sortedKeys(states) {
+			fmt.Fprintf(w, "/%s\n", address)
+			for _, state := range sortedKeys(states[address]) {
+				fmt.Fprintf(w, "  %s:%s\n", state, states[address][state])
+			}
+		}
+	})
+}
+
+func runNodetoolTPStatsCmd(cmd *cobra.Command, args []string) {
+	stats, err := nodetoolManager().ThreadPoolsStats(context.Background())
+	logFatal(err)
+	printNodetoolOutput(stats, func(w io.Writer) {
+		fmt.Fprintln(w, "Pool Name\tActive\tPending\tCompleted\tBlocked\tAll time blocked")
+		for _, pool := range stats.Pools {
+			fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%d\n", pool.Name, pool.Active, pool.Pending, pool.Completed,
+				pool.Blocked, pool.AllTimeBlocked)
+		}
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "Message type\tDropped")
+		for _, message := range sortedKeys(stats.DroppedMessages) {
+			fmt.Fprintf(w, "%s\t%d\n", message, stats.DroppedMessages[message])
+		}
+	})
+}
+
+func runNodetoolTableStatsCmd(cmd *cobra.Command, args []string) {
+	keyspace, table := "*", "*"
+	if arg := optionalArg(args); arg != "" {
+		parts := strings.SplitN(arg, ".", 2)
+		keyspace = parts[0]
+		if len(parts) == 2 {
+			table = parts[1]
+		}
+	}
+	tables, err := nodetoolManager().TablesStats(context.Background(), keyspace, table)
+	logFatal(err)
+	printNodetoolOutput(tables, func(w io.Writer) {
+		current := ""
+		for _, t := range tables {
+			if t.Keyspace != current {
+				current = t.Keyspace
+				fmt.Fprintf(w, "Keyspace : %s\n", t.Keyspace)
+			}
+			fmt.Fprintf(w, "\tTable: %s\n", t.Table)
+			fmt.Fprintf(w, "\tSSTable count: %d\n", t.SSTableCount)
+			fmt.Fprintf(w, "\tSpace used (live): %d\n", t.SpaceUsedLive)
+			fmt.Fprintf(w, "\tSpace used (total): %d\n", t.SpaceUsedTotal)
+			fmt.Fprintf(w, "\tNumber of partitions (estimate): %d\n", t.EstimatedPartitions)
+			fmt.Fprintf(w, "\tMemtable cell count: %d\n", t.MemtableCellCount)
+			fmt.Fprintf(w, "\tMemtable data size: %d\n", t.MemtableDataSize)
+			fmt.Fprintf(w, "\tMemtable switch count: %d\n", t.MemtableSwitchCount)
+			fmt.Fprintf(w, "\tLocal read count: %d\n", t.LocalReadCount)
+			fmt.Fprintf(w, "\tLocal read latency: %.3f ms\n", t.LocalReadLatency)
+			fmt.Fprintf(w, "\tLocal write count: %d\n", t.LocalWriteCount)
+			fmt.Fprintf(w, "\tLocal write latency: %.3f ms\n", t.LocalWriteLatency)
+			fmt.Fprintf(w, "\tPending flushes: %d\n", t.PendingFlushes)
+			fmt.Fprintf(w, "\tBloom filter false positives: %d\n", t.BloomFilterFalsePositives)
+			fmt.Fprintf(w, "\tBloom filter space used: %d\n", t.BloomFilterSpaceUsed)
+			fmt.Fprintf(w, "\tCompacted partition minimum bytes: %d\n", t.CompactedPartitionMinimum)
+			fmt.Fprintf(w, "\tCompacted partition maximum bytes: %d\n", t.CompactedPartitionMaximum)
+			fmt.Fprintf(w, "\tCompacted partition mean bytes: %d\n", t.CompactedPartitionMean)
+			fmt.Fprintln(w)
+		}
+	})
+}
+
+func runNodetoolCompactionStatsCmd(cmd *cobra.Command, args []string) {
+	stats, err := nodetoolManager().CompactionStats(context.Background())
+	logFatal(err)
+	printNodetoolOutput(stats, func(w io.Writer) {
+		fmt.Fprintf(w, "pending tasks: %d\n", stats.PendingTasks)
+		if len(stats.Compactions) == 0 {
+			return
+		}
+		fmt.Fprintln(w, "id\tcompaction type\tkeyspace\ttable\tcompleted\ttotal\tunit\tprogress")
+		var remaining int64
+		for _, c := range stats.Compactions {
+			progress := "n/a"
+			if c.Total > 0 {
+				progress = fmt.Sprintf("%.2f%%", float64(c.Completed)*100/float64(c.Total))
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%d\t%s\t%s\n", c.ID, c.Type, c.Keyspace, c.Table,
+				c.Completed, c.Total, c.Unit, progress)
+			remaining += c.Total - c.Completed
+		}
+		if stats.ThroughputMbPerSec > 0 {
+			seconds := remaining / (stats.ThroughputMbPerSec * 1024 * 1024)
+			fmt.Fprintf(w, "Active compaction remaining time :\t%s\n", time.Duration(seconds)*time.Second)
+		}
+	})
+}
+
+func runNodetoolNetStatsCmd(cmd *cobra.Command, args []string) {
+	stats, err := nodetoolManager().NetStats(context.Background())
+	logFatal(err)
+	printNodetoolOutput(stats, func(w io.Writer) {
+		fmt.Fprintf(w, "Mode: %s\n", stats.Mode)
+		if len(stats.Streams) == 0 {
+			fmt.Fprintln(w, "Not sending any streams.")
+		}
+		for _, stream := range stats.Streams {
+			fmt.Fprintf(w, "%s %s (%d sessions)\n", stream.Description, stream.PlanID, stream.Sessions)
+		}
+		fmt.Fprintln(w, "Read Repair Statistics:")
+		fmt.Fprintf(w, "Attempted: %d\n", stats.ReadRepairAttempted)
+		fmt.Fprintf(w, "Mismatch (Blocking): %d\n", stats.ReadRepairRepairedBlocking)
+		fmt.Fprintf(w, "Mismatch (Background): %d\n", stats.ReadRepairRepairedBackground)
+		fmt.Fprintln(w, "Pool Name\tActive\tPending\tCompleted\tDropped")
+		for _, pool := range []string{"Large", "Small", "Gossip"} {
+			messages := stats.Messages[pool]
+			fmt.Fprintf(w, "%s messages\tn/a\t%d\t%d\t%d\n", pool, messages.Pending, messages.Completed, messages.Dropped)
+		}
+	})
+}
+
+func runNodetoolCompactionHistoryCmd(cmd *cobra.Command, args []string) {
+	history, err := nodetoolManager().CompactionHistory(context.Background())
+	logFatal(err)
+	printNodetoolOutput(history, func(w io.Writer) {
+		fmt.Fprintln(w, "Compaction History:")
+		fmt.Fprintln(w, "id\tkeyspace_name\tcolumnfamily_name\tcompacted_at\tbytes_in\tbytes_out\trows_merged")
+		for _, c := range history {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%d\t%s\n", c.ID, c.Keyspace, c.Table,
+				c.CompactedAt.Format("2006-01-02T15:04:05.000"), c.BytesIn, c.BytesOut, c.RowsMerged)
+		}
+	})
+}
+
+func runNodetoolGetCompactionThroughputCmd(cmd *cobra.Command, args []string) {
+	mbPerSec, err := nodetoolManager().CompactionThroughput(context.Background())
+	logFatal(err)
+	printNodetoolOutput(map[string]uint64{"mb_per_sec": mbPerSec}, func(w io.Writer) {
+		fmt.Fprintf(w, "Current compaction throughput: %d MB/s\n", mbPerSec)
+	})
+}
+
+func runNodetoolSetCompactionThroughputCmd(cmd *cobra.Command, args []string) {
+	mbPerSec, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		logFatal(fmt.Errorf("Invalid compaction throughput %s", args[0]))
+	}
+	logFatal(nodetoolManager().SetCompactionThroughput(context.Background(), mbPerSec))
+}
+
+func runNodetoolCompactCmd(cmd *cobra.Command, args []string) {
+	if compactUserDefined {
+		logFatal(nodetoolManager().UserDefinedCompaction(context.Background(), args))
+		return
+	}
+	logFatal(nodetoolManager().MajorCompaction(context.Background(), args[0], compactSplitOutput, args[1:]...))
+}
+
+func runNodetoolGarbageCollectCmd(cmd *cobra.Command, args []string) {
+	granularity := strings.ToUpper(garbageGranularity)
+	if granularity != cassandra.TombstoneRow && granularity != cassandra.TombstoneCell {
+		logFatal(fmt.Errorf("Invalid granularity %s, which must be ROW or CELL", garbageGranularity))
+	}
+	logFatal(nodetoolManager().TombstoneCompaction(context.Background(), granularity, args[0], args[1:]...))
+}
+
+func runNodetoolStopCmd(cmd *cobra.Command, args []string) {
+	logFatal(nodetoolManager().StopCompactions(context.Background(), strings.ToUpper(args[0])))
+}
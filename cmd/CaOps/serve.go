@@ -1,6 +1,7 @@
 package main
 
 import (
+	"github.com/CrossEngage/CaOps/internal/repair"
 	"github.com/CrossEngage/CaOps/internal/server"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -18,11 +19,81 @@ func init() {
 }
 
 func runServeCmd(cmd *cobra.Command, args []string) {
+	apiConfig := server.APIConfig{
+		BindAddr:          viper.GetString("api.server.bind_addr"),
+		TLSCertFile:       viper.GetString("api.server.tls.cert_file"),
+		TLSKeyFile:        viper.GetString("api.server.tls.key_file"),
+		TLSClientCAFile:   viper.GetString("api.server.tls.client_ca_file"),
+		TLSReloadInterval: viper.GetDuration("api.server.tls.reload_interval"),
+		MetricsConfigFile: viper.GetString("api.metrics.config_file"),
+	}
+	if err := viper.UnmarshalKey("api.auth.tokens", &apiConfig.Tokens); err != nil {
+		logrus.Fatal(err)
+	}
+
+	repairConfig := server.RepairConfig{
+		StatePath: viper.GetString("repair.state_path"),
+		Orchestrator: repair.Config{
+			MaxPerNode:       viper.GetInt("repair.max_per_node"),
+			MaxPerDatacenter: viper.GetInt("repair.max_per_datacenter"),
+			MaxAttempts:      viper.GetInt("repair.max_attempts"),
+			RetryDelay:       viper.GetDuration("repair.retry_delay"),
+			SegmentTimeout:   viper.GetDuration("repair.segment_timeout"),
+			PollInterval:     viper.GetDuration("repair.poll_interval"),
+		},
+		ScheduleInterval:  viper.GetDuration("repair.schedule.interval"),
+		ScheduleKeyspaces: viper.GetStringSlice("repair.schedule.keyspaces"),
+		ScheduleOptions: repair.Options{
+			Parallelism:      viper.GetString("repair.schedule.parallelism"),
+			SegmentsPerRange: viper.GetInt("repair.schedule.segments_per_range"),
+		},
+	}
+
+	rollingConfig := server.RollingConfig{
+		RestartCommand: viper.GetString("rolling.restart_command"),
+		StopCommand:    viper.GetString("rolling.stop_command"),
+		InstallCommand: viper.GetString("rolling.install_command"),
+		StartCommand:   viper.GetString("rolling.start_command"),
+		StepTimeout:    viper.GetDuration("rolling.step_timeout"),
+		HealthTimeout:  viper.GetDuration("rolling.health_timeout"),
+		PollInterval:   viper.GetDuration("rolling.poll_interval"),
+		Cleanup: server.CleanupConfig{
+			OnJoin:        viper.GetBool("rolling.cleanup.on_join"),
+			Concurrency:   viper.GetInt("rolling.cleanup.concurrency"),
+			DiskHeadroom:  viper.GetFloat64("rolling.cleanup.disk_headroom"),
+			CheckInterval: viper.GetDuration("rolling.cleanup.check_interval"),
+		},
+	}
+
+	replaceConfig := server.ReplaceConfig{
+		DeadThreshold:    viper.GetDuration("replace.dead_threshold"),
+		CheckInterval:    viper.GetDuration("replace.check_interval"),
+		BootstrapTimeout: viper.GetDuration("replace.bootstrap_timeout"),
+	}
+
+	healthConfig := server.HealthConfig{
+		MaxPendingCompactions: viper.GetInt64("health.max_pending_compactions"),
+		MaxDroppedMutations:   viper.GetInt64("health.max_dropped_mutations"),
+		Required:              viper.GetStringMapStringSlice("health.required"),
+	}
+
+	webhooksConfig := server.WebhooksConfig{
+		CheckInterval: viper.GetDuration("webhooks.check_interval"),
+	}
+	if err := viper.UnmarshalKey("webhooks.hooks", &webhooksConfig.Hooks); err != nil {
+		logrus.Fatal(err)
+	}
+
 	CaOps, err := server.NewCaOps(
-		viper.GetString("api.server.bind_addr"),
+		apiConfig,
 		viper.GetString("gossip.bind_addr"),
 		viper.GetString("gossip.snapshot_path"),
-		viper.GetString("cassandra.jolokia_url"),
+		jolokiaConfig(),
+		repairConfig,
+		rollingConfig,
+		replaceConfig,
+		healthConfig,
+		webhooksConfig,
 	)
 	if err != nil {
 		logrus.Fatal(err)
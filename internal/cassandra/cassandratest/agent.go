@@ -0,0 +1,668 @@
+// Package cassandratest provides an in-process fake of the Jolokia agent of a Cassandra
+// node, so the code talking to Cassandra can be tested without a JVM.
+package cassandratest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CrossEngage/CaOps/internal/jolokia"
+)
+
+// MBeans of the fake node
+const (
+	// StorageService is the name of the MBean holding most of the state of the fake node
+	StorageService = "org.apache.cassandra.db:type=StorageService"
+	StorageProxy   = "org.apache.cassandra.db:type=StorageProxy"
+)
+
+// AttributeFunc is an attribute whose value is computed each time it is read. It is called
+// with the state of the agent locked, so it must not call the methods of the agent.
+type AttributeFunc func() interface{}
+
+// Operation is the implementation of an MBean operation. The arguments are decoded from JSON.
+type Operation func(args []interface{}) (interface{}, error)
+
+// Failure is an error response injected for the requests to an MBean
+type Failure struct {
+	Status    int
+	ErrorType string
+	Message   string
+}
+
+// Snapshot is a snapshot of a table taken on the fake node
+type Snapshot struct {
+	Tag      string
+	Keyspace string
+	Table    string
+}
+
+// snapshotSize is the size, in bytes, reported for each snapshot of a table
+const snapshotSize = 1024
+
+// FakeAgent serves the Jolokia protocol over HTTP, for a configurable set of MBeans. It
+// starts with the StorageService of a healthy single node cluster, with the snapshot
+// operations implemented.
+type FakeAgent struct {
+	server *httptest.Server
+
+	mu         sync.Mutex
+	mbeans     map[string]map[string]interface{}
+	operations map[string]map[string]Operation
+	signatures map[string]map[string][]jolokia.MBeanOperation
+	failures   map[string]Failure
+	httpStatus int
+	latency    time.Duration
+	requests   []jolokia.Request
+	tables     map[string][]string
+	snapshots  []Snapshot
+	// the notification clients registered, by id, and the last id given
+	notificationClients map[string]*notificationClient
+	lastClientID        int
+}
+
+// NewFakeAgent starts a fake agent, which must be closed once the test is done
+func NewFakeAgent() *FakeAgent {
+	a := &FakeAgent{
+		mbeans:     make(map[string]map[string]interface{}),
+		operations: make(map[string]map[string]Operation),
+		signatures: make(map[string]map[string][]jolokia.MBeanOperation),
+		failures:   make(map[string]Failure),
+		tables:     make(map[string][]string),
+
+		notificationClients: make(map[string]*notificationClient),
+	}
+	a.mbeans[StorageService] = map[string]interface{}{
+		"ClusterName":                  "Test Cluster",
+		"ReleaseVersion":               "3.11.4",
+		"SchemaVersion":                "59adb24e-f3cd-3e02-97f0-5b395827453f",
+		"AllDataFileLocations":         []string{"/var/lib/cassandra/data"},
+		"CommitLogLocation":            "/var/lib/cassandra/commitlog",
+		"SavedCachesLocation":          "/var/lib/cassandra/saved_caches",
+		"LocalHostId":                  "f3b9b3c5-3fc4-4b5c-9e3a-0d5a7e0c3a71",
+		"PartitionerName":              "org.apache.cassandra.dht.Murmur3Partitioner",
+		"OperationMode":                "NORMAL",
+		"IncrementalBackupsEnabled":    false,
+		"LiveNodes":                    []string{"127.0.0.1"},
+		"UnreachableNodes":             []string{},
+		"JoiningNodes":                 []string{},
+		"LeavingNodes":                 []string{},
+		"MovingNodes":                  []string{},
+		"Tokens":                       []string{"-9223372036854775808"},
+		"TokenToEndpointMap":           map[string]string{"-9223372036854775808": "127.0.0.1"},
+		"EndpointToHostId":             map[string]string{"127.0.0.1": "f3b9b3c5-3fc4-4b5c-9e3a-0d5a7e0c3a71"},
+		"HostIdToEndpoint":             map[string]string{"f3b9b3c5-3fc4-4b5c-9e3a-0d5a7e0c3a71": "127.0.0.1"},
+		"LoadString":                   "1.2 MiB",
+		"LoadMap":                      map[string]string{"127.0.0.1": "1.2 MiB"},
+		"CompactionThroughputMbPerSec": 16,
+		"IsStarting":                   false,
+		"GossipRunning":                true,
+		"NativeTransportRunning":       true,
+		"Initialized":                  true,
+		"Joined":                       true,
+		"Keyspaces":                    []string{},
+		"NonSystemKeyspaces":           []string{},
+		"SnapshotDetails":              AttributeFunc(a.snapshotDetails),
+	}
+	a.operations[StorageService] = map[string]Operation{
+		"takeSnapshot":              a.takeSnapshot,
+		"takeTableSnapshot":         a.takeTableSnapshot,
+		"takeMultipleTableSnapshot": a.takeMultipleTableSnapshot,
+		"clearSnapshot":             a.clearSnapshot,
+		"trueSnapshotsSize":         a.trueSnapshotsSize,
+		"forceKeyspaceFlush":        func(args []interface{}) (interface{}, error) { return nil, nil },
+		"refreshSizeEstimates":      func(args []interface{}) (interface{}, error) { return nil, nil },
+		"stopGossiping":             a.setter("GossipRunning", false),
+		"stopNativeTransport":       a.setter("NativeTransportRunning", false),
+		"drain":                     a.setter("OperationMode", "DRAINED"),
+	}
+	a.mbeans[StorageProxy] = map[string]interface{}{
+		"HintsInProgress": 0,
+		"SchemaVersions":  AttributeFunc(a.schemaVersions),
+	}
+	a.mbeans[jolokia.NotificationStore] = map[string]interface{}{}
+	a.operations[jolokia.NotificationStore] = map[string]Operation{"pull": a.pullNotifications}
+	a.AddTable("system", "local")
+	a.AddTable("system", "peers")
+	a.AddTable("system_auth", "roles")
+
+	a.server = httptest.NewServer(a)
+	return a
+}
+
+// Close shuts the agent down
+func (a *FakeAgent) Close() {
+	a.server.Close()
+}
+
+// URL returns the URL of the agent, to be used as the Jolokia URL
+func (a *FakeAgent) URL() string {
+	return a.server.URL + "/jolokia"
+}
+
+// Config returns the settings of a client of the agent, which does not retry failed reads
+func (a *FakeAgent) Config() jolokia.Config {
+	return jolokia.Config{URL: a.URL(), ReadRetries: -1}
+}
+
+// SetAttribute sets the value of an attribute, registering the MBean if needed
+func (a *FakeAgent) SetAttribute(mbean, attribute string, value interface{}) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, ok := a.mbeans[mbean]; !ok {
+		a.mbeans[mbean] = make(map[string]interface{})
+	}
+	a.mbeans[mbean][attribute] = value
+}
+
+// Attribute returns the value of an attribute, or nil if it does not exist
+func (a *FakeAgent) Attribute(mbean, attribute string) interface{} {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	value := a.mbeans[mbean][attribute]
+	if f, ok := value.(AttributeFunc); ok {
+		return f()
+	}
+	return value
+}
+
+// SetOperation sets the implementation of an operation, registering the MBean if needed
+func (a *FakeAgent) SetOperation(mbean, operation string, op Operation) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, ok := a.mbeans[mbean]; !ok {
+		a.mbeans[mbean] = make(map[string]interface{})
+	}
+	if _, ok := a.operations[mbean]; !ok {
+		a.operations[mbean] = make(map[string]Operation)
+	}
+	a.operations[mbean][operation] = op
+}
+
+// SetSignatures sets the signatures listed for an operation, like the overloads of an
+// operation or its signature in another version of Cassandra. The operations without
+// signatures are listed as taking no arguments.
+func (a *FakeAgent) SetSignatures(mbean, operation string, signatures ...jolokia.MBeanOperation) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, ok := a.signatures[mbean]; !ok {
+		a.signatures[mbean] = make(map[string][]jolokia.MBeanOperation)
+	}
+	a.signatures[mbean][operation] = signatures
+}
+
+// schemaVersions maps the schema version of the node to the live nodes, as they all agree on
+// it, and the unreachable nodes to UNREACHABLE
+func (a *FakeAgent) schemaVersions() interface{} {
+	ss := a.mbeans[StorageService]
+	version, _ := ss["SchemaVersion"].(string)
+	live, _ := ss["LiveNodes"].([]string)
+	versions := map[string][]string{version: live}
+	if unreachable, _ := ss["UnreachableNodes"].([]string); len(unreachable) > 0 {
+		versions["UNREACHABLE"] = unreachable
+	}
+	return versions
+}
+
+// AddTable adds a table, with its MBean, and its keyspace to the Keyspaces attributes
+func (a *FakeAgent) AddTable(keyspace, table string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, ok := a.tables[keyspace]; !ok {
+		ss := a.mbeans[StorageService]
+		ss["Keyspaces"] = appendSorted(ss["Keyspaces"].([]string), keyspace)
+		if !strings.HasPrefix(keyspace, "system") {
+			ss["NonSystemKeyspaces"] = appendSorted(ss["NonSystemKeyspaces"].([]string), keyspace)
+		}
+	}
+	a.tables[keyspace] = appendSorted(a.tables[keyspace], table)
+	mbean := fmt.Sprintf("org.apache.cassandra.db:type=Tables,keyspace=%s,table=%s", keyspace, table)
+	a.mbeans[mbean] = map[string]interface{}{"TableName": table}
+}
+
+// setter returns an operation setting the attribute of the StorageService to the value
+func (a *FakeAgent) setter(attribute string, value interface{}) Operation {
+	return func(args []interface{}) (interface{}, error) {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		a.mbeans[StorageService][attribute] = value
+		return nil, nil
+	}
+}
+
+func appendSorted(list []string, item string) []string {
+	list = append(append([]string{}, list...), item)
+	sort.Strings(list)
+	return list
+}
+
+// Fail makes the requests to the attribute or operation of the MBean fail, with a Jolokia
+// error response. An empty name fails all the requests to the MBean.
+func (a *FakeAgent) Fail(mbean, name string, failure Failure) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if failure.Status == 0 {
+		failure.Status = http.StatusInternalServerError
+	}
+	if failure.ErrorType == "" {
+		failure.ErrorType = "javax.management.MBeanException"
+	}
+	a.failures[mbean+"/"+name] = failure
+}
+
+// FailHTTP makes every HTTP request fail with the status, until it is set back to 0
+func (a *FakeAgent) FailHTTP(status int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.httpStatus = status
+}
+
+// ClearFailures removes all the injected failures
+func (a *FakeAgent) ClearFailures() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.failures = make(map[string]Failure)
+	a.httpStatus = 0
+}
+
+// SetLatency delays every HTTP response, unless the client gives up first
+func (a *FakeAgent) SetLatency(latency time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.latency = latency
+}
+
+// Requests returns the Jolokia requests received so far, including the ones of bulk requests
+func (a *FakeAgent) Requests() []jolokia.Request {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]jolokia.Request{}, a.requests...)
+}
+
+// Executed returns the arguments of each execution of the operation of the MBean
+func (a *FakeAgent) Executed(mbean, operation string) [][]interface{} {
+	executions := make([][]interface{}, 0)
+	for _, request := range a.Requests() {
+		if request.Type == "exec" && request.MBean == mbean && operationName(request.Operation) == operation {
+			executions = append(executions, request.Arguments)
+		}
+	}
+	return executions
+}
+
+// Snapshots returns the snapshots existing on the node
+func (a *FakeAgent) Snapshots() []Snapshot {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]Snapshot{}, a.snapshots...)
+}
+
+// ServeHTTP handles GET reads and version requests, and POST single and bulk requests
+func (a *FakeAgent) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	latency, httpStatus := a.latency, a.httpStatus
+	a.mu.Unlock()
+	if latency > 0 {
+		select {
+		case <-time.After(latency):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	if httpStatus != 0 {
+		http.Error(w, http.StatusText(httpStatus), httpStatus)
+		return
+	}
+
+	if r.Method == "GET" {
+		request, err := parseGetPath(strings.TrimPrefix(r.URL.Path, "/jolokia"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, a.handle(request))
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if strings.HasPrefix(strings.TrimSpace(string(body)), "[") {
+		requests := make([]jolokia.Request, 0)
+		if err := json.Unmarshal(body, &requests); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		responses := make([]map[string]interface{}, 0, len(requests))
+		for _, request := range requests {
+			responses = append(responses, a.handle(request))
+		}
+		writeJSON(w, responses)
+		return
+	}
+	request := jolokia.Request{}
+	if err := json.Unmarshal(body, &request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, a.handle(request))
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// parseGetPath turns a GET path, like /read/<mbean>/<attribute>, into a request
+func parseGetPath(p string) (jolokia.Request, error) {
+	parts := splitEscapedPath(strings.TrimPrefix(p, "/"))
+	switch {
+	case len(parts) == 1 && parts[0] == "version":
+		return jolokia.Request{Type: "version"}, nil
+	case len(parts) == 2 && parts[0] == "read":
+		return jolokia.Request{Type: "read", MBean: parts[1]}, nil
+	case len(parts) == 3 && parts[0] == "read":
+		return jolokia.Request{Type: "read", MBean: parts[1], Attribute: parts[2]}, nil
+	}
+	return jolokia.Request{}, fmt.Errorf("Unsupported path %s", p)
+}
+
+// splitEscapedPath splits a Jolokia path on the slashes which are not escaped by a !
+func splitEscapedPath(p string) []string {
+	parts := make([]string, 0)
+	var part strings.Builder
+	for i := 0; i < len(p); i++ {
+		switch {
+		case p[i] == '!' && i+1 < len(p):
+			i++
+			part.WriteByte(p[i])
+		case p[i] == '/':
+			parts = append(parts, part.String())
+			part.Reset()
+		default:
+			part.WriteByte(p[i])
+		}
+	}
+	return append(parts, part.String())
+}
+
+func (a *FakeAgent) handle(request jolokia.Request) map[string]interface{} {
+	a.mu.Lock()
+	a.requests = append(a.requests, request)
+	a.mu.Unlock()
+
+	value, failure := a.dispatch(request)
+	if failure != nil {
+		return map[string]interface{}{
+			"status":     failure.Status,
+			"request":    request,
+			"error_type": failure.ErrorType,
+			"error":      failure.ErrorType + " : " + failure.Message,
+		}
+	}
+	return map[string]interface{}{
+		"status":    http.StatusOK,
+		"request":   request,
+		"value":     value,
+		"timestamp": time.Now().Unix(),
+	}
+}
+
+func (a *FakeAgent) dispatch(request jolokia.Request) (interface{}, *Failure) {
+	switch request.Type {
+	case "version":
+		return map[string]interface{}{"agent": "1.3.7", "protocol": "7.2"}, nil
+	case "search":
+		return a.search(request.MBean), nil
+	case "read":
+		return a.read(request)
+	case "write":
+		return a.write(request)
+	case "exec":
+		return a.exec(request)
+	case "list":
+		return a.list(request)
+	case "notification":
+		return a.notification(request)
+	}
+	return nil, &Failure{Status: http.StatusBadRequest, ErrorType: "java.lang.IllegalArgumentException",
+		Message: fmt.Sprintf("Unsupported request type %s", request.Type)}
+}
+
+func (a *FakeAgent) failure(mbean, name string) *Failure {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if failure, ok := a.failures[mbean+"/"+name]; ok {
+		return &failure
+	}
+	if failure, ok := a.failures[mbean+"/"]; ok {
+		return &failure
+	}
+	return nil
+}
+
+func instanceNotFound(mbean string) *Failure {
+	return &Failure{Status: http.StatusNotFound, ErrorType: "javax.management.InstanceNotFoundException", Message: mbean}
+}
+
+func attributeNotFound(attribute string) *Failure {
+	return &Failure{Status: http.StatusNotFound, ErrorType: "javax.management.AttributeNotFoundException",
+		Message: fmt.Sprintf("No such attribute: %s", attribute)}
+}
+
+// search returns the sorted names of the MBeans matching the pattern
+func (a *FakeAgent) search(pattern string) []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	names := make([]string, 0)
+	for name := range a.mbeans {
+		if matchMBean(pattern, name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// matchMBean matches an MBean name against a pattern, where the domain and the property
+// values can have wildcards, and a trailing ,* allows any other property
+func matchMBean(pattern, name string) bool {
+	if !jolokia.IsPattern(pattern) {
+		return pattern == name
+	}
+	patternDomain, patternProps := jolokia.ParseMBeanName(pattern)
+	domain, props := jolokia.ParseMBeanName(name)
+	if ok, _ := path.Match(patternDomain, domain); !ok {
+		return false
+	}
+	anyOther := strings.HasSuffix(pattern, ",*") || strings.HasSuffix(pattern, ":*")
+	for key, patternValue := range patternProps {
+		value, ok := props[key]
+		if !ok {
+			return false
+		}
+		if ok, _ := path.Match(patternValue, value); !ok {
+			return false
+		}
+	}
+	return anyOther || len(props) == len(patternProps)
+}
+
+func (a *FakeAgent) read(request jolokia.Request) (interface{}, *Failure) {
+	attributes, single := requestedAttributes(request.Attribute)
+	ignoreErrors := request.Config["ignoreErrors"] == true
+
+	if !jolokia.IsPattern(request.MBean) {
+		values, failure := a.readMBean(request.MBean, attributes, ignoreErrors)
+		if failure != nil {
+			return nil, failure
+		}
+		if single {
+			return values[attributes[0]], nil
+		}
+		return values, nil
+	}
+
+	names := a.search(request.MBean)
+	if len(names) == 0 {
+		return nil, instanceNotFound(request.MBean)
+	}
+	byMBean := make(map[string]interface{}, len(names))
+	for _, name := range names {
+		values, failure := a.readMBean(name, attributes, true)
+		if failure != nil {
+			if ignoreErrors {
+				continue
+			}
+			return nil, failure
+		}
+		byMBean[name] = values
+	}
+	return byMBean, nil
+}
+
+// requestedAttributes returns the attributes of a read, and if a single one was requested
+func requestedAttributes(attribute interface{}) (attributes []string, single bool) {
+	switch attr := attribute.(type) {
+	case string:
+		return []string{attr}, true
+	case []interface{}:
+		for _, a := range attr {
+			attributes = append(attributes, fmt.Sprint(a))
+		}
+	}
+	return attributes, false
+}
+
+// readMBean reads the attributes of an MBean, or all of them when none is given
+func (a *FakeAgent) readMBean(mbean string, attributes []string, ignoreErrors bool) (map[string]interface{}, *Failure) {
+	a.mu.Lock()
+	state, ok := a.mbeans[mbean]
+	if ok && len(attributes) == 0 {
+		for attribute := range state {
+			attributes = append(attributes, attribute)
+		}
+	}
+	a.mu.Unlock()
+	if !ok {
+		return nil, instanceNotFound(mbean)
+	}
+
+	values := make(map[string]interface{}, len(attributes))
+	for _, attribute := range attributes {
+		if failure := a.failure(mbean, attribute); failure != nil {
+			if ignoreErrors {
+				continue
+			}
+			return nil, failure
+		}
+		a.mu.Lock()
+		value, ok := state[attribute]
+		if f, isFunc := value.(AttributeFunc); isFunc {
+			value = f() // called with the lock held
+		}
+		a.mu.Unlock()
+		if !ok {
+			if ignoreErrors {
+				continue
+			}
+			return nil, attributeNotFound(attribute)
+		}
+		values[attribute] = value
+	}
+	return values, nil
+}
+
+func (a *FakeAgent) write(request jolokia.Request) (interface{}, *Failure) {
+	attribute := fmt.Sprint(request.Attribute)
+	if failure := a.failure(request.MBean, attribute); failure != nil {
+		return nil, failure
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	state, ok := a.mbeans[request.MBean]
+	if !ok {
+		return nil, instanceNotFound(request.MBean)
+	}
+	previous, ok := state[attribute]
+	if !ok {
+		return nil, attributeNotFound(attribute)
+	}
+	state[attribute] = request.Value
+	return previous, nil
+}
+
+func (a *FakeAgent) exec(request jolokia.Request) (interface{}, *Failure) {
+	name := operationName(request.Operation)
+	if failure := a.failure(request.MBean, name); failure != nil {
+		return nil, failure
+	}
+	a.mu.Lock()
+	_, exists := a.mbeans[request.MBean]
+	op, ok := a.operations[request.MBean][name]
+	a.mu.Unlock()
+	if !exists {
+		return nil, instanceNotFound(request.MBean)
+	}
+	if !ok {
+		return nil, &Failure{Status: http.StatusNotFound, ErrorType: "java.lang.IllegalArgumentException",
+			Message: fmt.Sprintf("No operation %s found on MBean %s", request.Operation, request.MBean)}
+	}
+	value, err := op(request.Arguments)
+	if err != nil {
+		return nil, &Failure{Status: http.StatusInternalServerError, ErrorType: "java.io.IOException", Message: err.Error()}
+	}
+	return value, nil
+}
+
+// list describes an MBean, given by its path, with its attributes and its operations. Like
+// Jolokia, an operation with a single signature is listed as an object, and an overloaded
+// one as an array of signatures.
+func (a *FakeAgent) list(request jolokia.Request) (interface{}, *Failure) {
+	parts := splitEscapedPath(request.Path)
+	if len(parts) != 2 {
+		return nil, &Failure{Status: http.StatusBadRequest, ErrorType: "java.lang.IllegalArgumentException",
+			Message: fmt.Sprintf("Unsupported list path %s", request.Path)}
+	}
+	mbean := parts[0] + ":" + parts[1]
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	values, ok := a.mbeans[mbean]
+	if !ok {
+		return nil, instanceNotFound(mbean)
+	}
+	attributes := make(map[string]jolokia.MBeanAttribute, len(values))
+	for name := range values {
+		attributes[name] = jolokia.MBeanAttribute{Type: "java.lang.Object"}
+	}
+	operations := make(map[string]interface{}, len(a.operations[mbean]))
+	for name := range a.operations[mbean] {
+		switch signatures := a.signatures[mbean][name]; len(signatures) {
+		case 0:
+			operations[name] = jolokia.MBeanOperation{Arguments: []jolokia.MBeanOperationArgument{}, ReturnType: "void"}
+		case 1:
+			operations[name] = signatures[0]
+		default:
+			operations[name] = signatures
+		}
+	}
+	return map[string]interface{}{"desc": "", "attr": attributes, "op": operations}, nil
+}
+
+// operationName removes the signature from overloaded operation names, like op(int)
+func operationName(operation string) string {
+	if i := strings.Index(operation, "("); i >= 0 {
+		return operation[:i]
+	}
+	return operation
+}
@@ -0,0 +1,30 @@
+package cassandratest
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchMBean(t *testing.T) {
+	name := "org.apache.cassandra.db:type=Tables,keyspace=ks1,table=users"
+	assert.True(t, matchMBean(name, name))
+	assert.True(t, matchMBean("org.apache.cassandra.db:type=Tables,keyspace=*,table=*", name))
+	assert.True(t, matchMBean("org.apache.cassandra.db:type=Tables,*", name))
+	assert.True(t, matchMBean("org.apache.*:type=Tables,keyspace=ks?,table=users", name))
+	assert.False(t, matchMBean("org.apache.cassandra.db:type=Tables,keyspace=*", name))
+	assert.False(t, matchMBean("org.apache.cassandra.db:type=ColumnFamilies,*", name))
+}
+
+func TestSplitEscapedPath(t *testing.T) {
+	assert.Equal(t, []string{"read", "a:path=/var/lib,name=x!", "Attr"}, splitEscapedPath("read/a:path=!/var!/lib,name=x!!/Attr"))
+	assert.Equal(t, []string{"version"}, splitEscapedPath("version"))
+}
+
+func TestTabularData(t *testing.T) {
+	row := map[string]interface{}{"Snapshot name": "tag1", "Keyspace name": "ks1", "Column family name": "users",
+		"True size": "1024 bytes", "Size on disk": "1024 bytes"}
+	data := TabularData(snapshotDetailsIndex, []map[string]interface{}{row})
+	assert.Equal(t, map[string]interface{}{"tag1": map[string]interface{}{"ks1": map[string]interface{}{
+		"users": map[string]interface{}{"1024 bytes": map[string]interface{}{"1024 bytes": row}}}}}, data)
+}
@@ -0,0 +1,142 @@
+package cassandratest
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/CrossEngage/CaOps/internal/jolokia"
+)
+
+// notificationClient is a client registered for notifications, with its pull mode listeners
+// by handle
+type notificationClient struct {
+	listeners  map[string]*notificationListener
+	lastHandle int
+}
+
+type notificationListener struct {
+	mbean         string
+	filter        []string
+	notifications []jolokia.Notification
+}
+
+// matches returns whether the listener receives the notification of the MBean, whose type
+// must start with one of the types of the filter, if any
+func (l *notificationListener) matches(mbean string, notification jolokia.Notification) bool {
+	if l.mbean != mbean {
+		return false
+	}
+	if len(l.filter) == 0 {
+		return true
+	}
+	for _, prefix := range l.filter {
+		if strings.HasPrefix(notification.Type, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Notify emits a notification of the MBean, which is kept for the listeners of the MBean
+// until they pull it
+func (a *FakeAgent) Notify(mbean string, notification jolokia.Notification) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, client := range a.notificationClients {
+		for _, listener := range client.listeners {
+			if listener.matches(mbean, notification) {
+				listener.notifications = append(listener.notifications, notification)
+			}
+		}
+	}
+}
+
+// NotificationListeners returns the number of listeners registered by all the clients
+func (a *FakeAgent) NotificationListeners() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	count := 0
+	for _, client := range a.notificationClients {
+		count += len(client.listeners)
+	}
+	return count
+}
+
+// ResetNotifications forgets all the notification clients, like an agent which restarted
+func (a *FakeAgent) ResetNotifications() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.notificationClients = make(map[string]*notificationClient)
+}
+
+func unknownNotificationClient(id string) *Failure {
+	return &Failure{Status: http.StatusNotFound, ErrorType: "java.lang.IllegalArgumentException",
+		Message: fmt.Sprintf("No client with ID %s registered", id)}
+}
+
+// notification handles the register, add, remove, ping and unregister commands
+func (a *FakeAgent) notification(request jolokia.Request) (interface{}, *Failure) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if request.Command == "register" {
+		a.lastClientID++
+		id := strconv.Itoa(a.lastClientID)
+		a.notificationClients[id] = &notificationClient{listeners: make(map[string]*notificationListener)}
+		return map[string]interface{}{"id": id, "backend": map[string]interface{}{"pull": map[string]interface{}{}}}, nil
+	}
+
+	client, ok := a.notificationClients[request.Client]
+	if !ok {
+		return nil, unknownNotificationClient(request.Client)
+	}
+	switch request.Command {
+	case "add":
+		if request.Mode != "pull" {
+			return nil, &Failure{Status: http.StatusBadRequest, ErrorType: "java.lang.IllegalArgumentException",
+				Message: fmt.Sprintf("Unsupported notification mode %s", request.Mode)}
+		}
+		if _, exists := a.mbeans[request.MBean]; !exists {
+			return nil, instanceNotFound(request.MBean)
+		}
+		client.lastHandle++
+		handle := strconv.Itoa(client.lastHandle)
+		client.listeners[handle] = &notificationListener{mbean: request.MBean, filter: request.Filter}
+		return handle, nil
+	case "remove":
+		delete(client.listeners, request.Handle)
+		return nil, nil
+	case "ping":
+		return nil, nil
+	case "unregister":
+		delete(a.notificationClients, request.Client)
+		return nil, nil
+	}
+	return nil, &Failure{Status: http.StatusBadRequest, ErrorType: "java.lang.IllegalArgumentException",
+		Message: fmt.Sprintf("Unsupported notification command %s", request.Command)}
+}
+
+// pullNotifications implements the pull operation of the notification store
+func (a *FakeAgent) pullNotifications(args []interface{}) (interface{}, error) {
+	if err := argCount(args, 2); err != nil {
+		return nil, err
+	}
+	id, handle := fmt.Sprint(args[0]), fmt.Sprint(args[1])
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	client, ok := a.notificationClients[id]
+	if !ok {
+		return nil, fmt.Errorf("No client with ID %s registered", id)
+	}
+	listener, ok := client.listeners[handle]
+	if !ok {
+		return nil, fmt.Errorf("No listener with handle %s registered", handle)
+	}
+	notifications := listener.notifications
+	listener.notifications = nil
+	if notifications == nil {
+		notifications = []jolokia.Notification{}
+	}
+	return map[string]interface{}{"handle": handle, "dropped": 0, "notifications": notifications}, nil
+}
@@ -0,0 +1,165 @@
+package cassandratest
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// stringArgs converts a JSON array argument into strings
+func stringArgs(arg interface{}) []string {
+	list, _ := arg.([]interface{})
+	strs := make([]string, 0, len(list))
+	for _, item := range list {
+		strs = append(strs, fmt.Sprint(item))
+	}
+	return strs
+}
+
+func argCount(args []interface{}, count int) error {
+	if len(args) != count {
+		return fmt.Errorf("Expected %d arguments, got %d", count, len(args))
+	}
+	return nil
+}
+
+// addSnapshots adds the snapshot of the tables, failing like Cassandra when the tag is
+// already used on one of their keyspaces
+func (a *FakeAgent) addSnapshots(tag string, tables map[string][]string) error {
+	if tag == "" {
+		return fmt.Errorf("You must supply a snapshot name.")
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for keyspace, names := range tables {
+		if _, ok := a.tables[keyspace]; !ok {
+			return fmt.Errorf("Keyspace %s does not exist", keyspace)
+		}
+		for _, snapshot := range a.snapshots {
+			if snapshot.Tag == tag && snapshot.Keyspace == keyspace {
+				return fmt.Errorf("Snapshot %s already exists.", tag)
+			}
+		}
+		for _, table := range names {
+			if !contains(a.tables[keyspace], table) {
+				return fmt.Errorf("Unknown keyspace/cf pair (%s.%s)", keyspace, table)
+			}
+		}
+	}
+	// the snapshots are listed by keyspace, like the directories of Cassandra
+	keyspaces := make([]string, 0, len(tables))
+	for keyspace := range tables {
+		keyspaces = append(keyspaces, keyspace)
+	}
+	sort.Strings(keyspaces)
+	for _, keyspace := range keyspaces {
+		for _, table := range tables[keyspace] {
+			a.snapshots = append(a.snapshots, Snapshot{Tag: tag, Keyspace: keyspace, Table: table})
+		}
+	}
+	return nil
+}
+
+func contains(list []string, item string) bool {
+	for _, i := range list {
+		if i == item {
+			return true
+		}
+	}
+	return false
+}
+
+// takeSnapshot(String tag, String... keyspaceNames), where no keyspace means all of them
+func (a *FakeAgent) takeSnapshot(args []interface{}) (interface{}, error) {
+	if err := argCount(args, 2); err != nil {
+		return nil, err
+	}
+	keyspaces := stringArgs(args[1])
+	tables := make(map[string][]string)
+	a.mu.Lock()
+	if len(keyspaces) == 0 {
+		for keyspace := range a.tables {
+			keyspaces = append(keyspaces, keyspace)
+		}
+	}
+	for _, keyspace := range keyspaces {
+		tables[keyspace] = a.tables[keyspace]
+	}
+	a.mu.Unlock()
+	return nil, a.addSnapshots(fmt.Sprint(args[0]), tables)
+}
+
+// takeTableSnapshot(String keyspaceName, String tableName, String tag)
+func (a *FakeAgent) takeTableSnapshot(args []interface{}) (interface{}, error) {
+	if err := argCount(args, 3); err != nil {
+		return nil, err
+	}
+	keyspace, table := fmt.Sprint(args[0]), fmt.Sprint(args[1])
+	return nil, a.addSnapshots(fmt.Sprint(args[2]), map[string][]string{keyspace: {table}})
+}
+
+// takeMultipleTableSnapshot(String tag, String... tableList), with tables as keyspace.table
+func (a *FakeAgent) takeMultipleTableSnapshot(args []interface{}) (interface{}, error) {
+	if err := argCount(args, 2); err != nil {
+		return nil, err
+	}
+	tables := make(map[string][]string)
+	for _, name := range stringArgs(args[1]) {
+		parts := strings.SplitN(name, ".", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Cannot take a snapshot on secondary index or invalid column family name. You must supply a column family name in the form of keyspace.columnfamily")
+		}
+		tables[parts[0]] = append(tables[parts[0]], parts[1])
+	}
+	return nil, a.addSnapshots(fmt.Sprint(args[0]), tables)
+}
+
+// clearSnapshot(String tag, String... keyspaceNames), where an empty tag means all the
+// snapshots, and no keyspace means all of them
+func (a *FakeAgent) clearSnapshot(args []interface{}) (interface{}, error) {
+	if err := argCount(args, 2); err != nil {
+		return nil, err
+	}
+	tag, keyspaces := fmt.Sprint(args[0]), stringArgs(args[1])
+	if args[0] == nil {
+		tag = ""
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	kept := make([]Snapshot, 0, len(a.snapshots))
+	for _, snapshot := range a.snapshots {
+		tagMatches := tag == "" || snapshot.Tag == tag
+		keyspaceMatches := len(keyspaces) == 0 || contains(keyspaces, snapshot.Keyspace)
+		if !tagMatches || !keyspaceMatches {
+			kept = append(kept, snapshot)
+		}
+	}
+	a.snapshots = kept
+	return nil, nil
+}
+
+func (a *FakeAgent) trueSnapshotsSize(args []interface{}) (interface{}, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return len(a.snapshots) * snapshotSize, nil
+}
+
+// snapshotDetailsIndex are the index names of the SnapshotDetails TabularData
+var snapshotDetailsIndex = []string{"Snapshot name", "Keyspace name", "Column family name", "True size", "Size on disk"}
+
+// snapshotDetails returns the SnapshotDetails TabularData, nested by its five index names
+// like Jolokia serializes it
+func (a *FakeAgent) snapshotDetails() interface{} {
+	rows := make([]map[string]interface{}, 0, len(a.snapshots))
+	for _, snapshot := range a.snapshots {
+		size := fmt.Sprintf("%d bytes", snapshotSize)
+		rows = append(rows, map[string]interface{}{
+			"Snapshot name":      snapshot.Tag,
+			"Keyspace name":      snapshot.Keyspace,
+			"Column family name": snapshot.Table,
+			"Size on disk":       size,
+			"True size":          size,
+		})
+	}
+	return TabularData(snapshotDetailsIndex, rows)
+}
@@ -0,0 +1,37 @@
+package cassandratest
+
+import "fmt"
+
+// TabularData returns the rows of a TabularData the way Jolokia serializes it, nested in one
+// map level per index name, keyed by the value of that index in each row
+func TabularData(indexNames []string, rows []map[string]interface{}) map[string]interface{} {
+	data := make(map[string]interface{})
+	for _, row := range rows {
+		level := data
+		for i, name := range indexNames {
+			key := fmt.Sprint(row[name])
+			if i == len(indexNames)-1 {
+				level[key] = row
+				break
+			}
+			next, ok := level[key].(map[string]interface{})
+			if !ok {
+				next = make(map[string]interface{})
+				level[key] = next
+			}
+			level = next
+		}
+	}
+	return data
+}
+
+// compactionHistoryIndex are the index names of the CompactionHistory TabularData, which
+// indexes its rows by all their items
+var compactionHistoryIndex = []string{"id", "keyspace_name", "columnfamily_name", "compacted_at",
+	"bytes_in", "bytes_out", "rows_merged"}
+
+// CompactionHistory returns the CompactionHistory TabularData of the compaction manager with
+// the rows, like Jolokia serializes it
+func CompactionHistory(rows ...map[string]interface{}) map[string]interface{} {
+	return TabularData(compactionHistoryIndex, rows)
+}
@@ -0,0 +1,217 @@
+package cassandra
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/CrossEngage/CaOps/internal/jolokia"
+)
+
+const (
+	compactionManagerPath     = "org.apache.cassandra.db:type=CompactionManager"
+	pendingCompactionsPath    = "org.apache.cassandra.metrics:type=Compaction,name=PendingTasks"
+	completedCompactionsPath  = "org.apache.cassandra.metrics:type=Compaction,name=CompletedTasks"
+	totalCompactionsCompleted = "org.apache.cassandra.metrics:type=Compaction,name=TotalCompactionsCompleted"
+
+	// the signatures of the overloaded operations triggering compactions, since Cassandra 3.0
+	forceKeyspaceCompactionSignature = "forceKeyspaceCompaction(boolean,java.lang.String,[Ljava.lang.String;)"
+	garbageCollectSignature          = "garbageCollect(java.lang.String,int,java.lang.String,[Ljava.lang.String;)"
+)
+
+// CompactionTypes are the types of the operations of the compaction manager, which can be
+// stopped
+var CompactionTypes = []string{"COMPACTION", "VALIDATION", "KEY_CACHE_SAVE", "ROW_CACHE_SAVE",
+	"COUNTER_CACHE_SAVE", "CLEANUP", "SCRUB", "UPGRADE_SSTABLES", "INDEX_BUILD", "TOMBSTONE_COMPACTION",
+	"ANTICOMPACTION", "VERIFY", "VIEW_BUILD", "INDEX_SUMMARY", "RELOCATE", "GARBAGE_COLLECT"}
+
+// Granularities of the tombstones removed by the garbage collection of tables
+const (
+	TombstoneRow  = "ROW"
+	TombstoneCell = "CELL"
+)
+
+// Compaction is a compaction, or another operation of the compaction manager like a cleanup,
+// running on the node. The progress is counted in the unit, usually bytes.
+type Compaction struct {
+	ID        string `json:"id"`
+	Type      string `json:"type"`
+	Keyspace  string `json:"keyspace"`
+	Table     string `json:"table"`
+	Completed int64  `json:"completed"`
+	Total     int64  `json:"total"`
+	Unit      string `json:"unit"`
+}
+
+// CompactionStats describes the compactions of the node, like nodetool compactionstats
+type CompactionStats struct {
+	PendingTasks   int64         `json:"pending_tasks"`
+	CompletedTasks int64         `json:"completed_tasks"`
+	Compactions    []*Compaction `json:"compactions"`
+	// ThroughputMbPerSec is the compaction throughput limit, where 0 is unlimited
+	ThroughputMbPerSec int64 `json:"throughput_mb_per_sec"`
+}
+
+// CompactionStats returns the compactions running on the node, and the number of pending ones
+func (m *Manager) CompactionStats(ctx context.Context) (*CompactionStats, error) {
+	stats := &CompactionStats{}
+	var compactions []map[string]string
+	var completed int64
+	batch := jolokia.NewBatch()
+	results := []*jolokia.BatchResult{
+		batch.Read(&compactions, compactionManagerPath, "Compactions"),
+		batch.Read(&stats.PendingTasks, pendingCompactionsPath, "Value"),
+		m.storageService.batchRead(batch, &stats.ThroughputMbPerSec, "CompactionThroughputMbPerSec"),
+	}
+	// the completed tasks gauge was removed in later versions, where only the counter is left
+	completedResult := batch.Read(&stats.CompletedTasks, completedCompactionsPath, "Value")
+	batch.Read(&completed, totalCompactionsCompleted, "Count")
+	if err := m.SendBatch(ctx, batch); err != nil {
+		return nil, err
+	}
+	if err := firstErr(results...); err != nil {
+		return nil, err
+	}
+	if completedResult.Err() != nil {
+		stats.CompletedTasks = completed
+	}
+
+	stats.Compactions = make([]*Compaction, 0, len(compactions))
+	for _, compaction := range compactions {
+		id := compaction["compactionId"]
+		if id == "" {
+			id = compaction["id"]
+		}
+		stats.Compactions = append(stats.Compactions, &Compaction{
+			ID:        id,
+			Type:      compaction["taskType"],
+			Keyspace:  compaction["keyspace"],
+			Table:     compaction["columnfamily"],
+			Completed: toInt64(compaction["completed"]),
+			Total:     toInt64(compaction["total"]),
+			Unit:      compaction["unit"],
+		})
+	}
+	return stats, nil
+}
+
+// CompactionHistoryEntry is a compaction which ran on the node, as recorded in the
+// system.compaction_history table
+type CompactionHistoryEntry struct {
+	ID          string    `json:"id"`
+	Keyspace    string    `json:"keyspace"`
+	Table       string    `json:"table"`
+	CompactedAt time.Time `json:"compacted_at"`
+	BytesIn     int64     `json:"bytes_in"`
+	BytesOut    int64     `json:"bytes_out"`
+	// RowsMerged counts the partitions merged from each number of SSTables, like {1:3, 2:1}
+	RowsMerged string `json:"rows_merged"`
+}
+
+// compactionHistoryRow is a row of the CompactionHistory TabularData, whose compaction time
+// is in milliseconds since the epoch
+type compactionHistoryRow struct {
+	ID          string `jolokia:"id"`
+	Keyspace    string `jolokia:"keyspace_name"`
+	Table       string `jolokia:"columnfamily_name"`
+	CompactedAt int64  `jolokia:"compacted_at"`
+	BytesIn     int64  `jolokia:"bytes_in"`
+	BytesOut    int64  `jolokia:"bytes_out"`
+	RowsMerged  string `jolokia:"rows_merged"`
+}
+
+// CompactionHistory returns the compactions which ran on the node, most recent first, read
+// by the compaction manager from the system.compaction_history table
+func (m *Manager) CompactionHistory(ctx context.Context) ([]*CompactionHistoryEntry, error) {
+	var history json.RawMessage
+	batch := jolokia.NewBatch()
+	result := batch.Read(&history, compactionManagerPath, "CompactionHistory")
+	if err := m.SendBatch(ctx, batch); err != nil {
+		return nil, err
+	}
+	if err := result.Err(); err != nil {
+		return nil, err
+	}
+
+	var rows []compactionHistoryRow
+	if err := jolokia.UnmarshalTabularData(history, &rows); err != nil {
+		return nil, fmt.Errorf("Could not decode the compaction history: %s", err)
+	}
+	entries := make([]*CompactionHistoryEntry, 0, len(rows))
+	for _, row := range rows {
+		entries = append(entries, &CompactionHistoryEntry{
+			ID:          row.ID,
+			Keyspace:    row.Keyspace,
+			Table:       row.Table,
+			CompactedAt: time.Unix(0, row.CompactedAt*int64(time.Millisecond)).UTC(),
+			BytesIn:     row.BytesIn,
+			BytesOut:    row.BytesOut,
+			RowsMerged:  row.RowsMerged,
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if !entries[i].CompactedAt.Equal(entries[j].CompactedAt) {
+			return entries[i].CompactedAt.After(entries[j].CompactedAt)
+		}
+		return entries[i].ID < entries[j].ID
+	})
+	return entries, nil
+}
+
+// execCompaction executes an operation of the MBean, which lasts as long as the compaction it
+// runs, without the timeout of the Jolokia requests
+func (m *Manager) execCompaction(ctx context.Context, target interface{}, mbean, operation string, args ...interface{}) error {
+	batch := jolokia.NewBatch()
+	result := batch.Exec(target, mbean, operation, args...)
+	if err := m.jolokiaClient.WithTimeout(0).SendBatch(ctx, batch); err != nil {
+		return err
+	}
+	return result.Err()
+}
+
+// MajorCompaction compacts all the SSTables of the tables of the keyspace, or of all its
+// tables when none is given, into one SSTable per table, or into SSTables of decreasing
+// sizes when the output is split
+func (m *Manager) MajorCompaction(ctx context.Context, keyspace string, splitOutput bool, tables ...string) error {
+	if tables == nil {
+		tables = []string{}
+	}
+	return m.execCompaction(ctx, nil, storageServicePath, forceKeyspaceCompactionSignature, splitOutput, keyspace, tables)
+}
+
+// UserDefinedCompaction compacts the SSTables of the data files together, which must belong
+// to the same table
+func (m *Manager) UserDefinedCompaction(ctx context.Context, dataFiles []string) error {
+	return m.execCompaction(ctx, nil, compactionManagerPath, "forceUserDefinedCompaction", strings.Join(dataFiles, ","))
+}
+
+// TombstoneCompaction rewrites each SSTable of the tables of the keyspace, or of all its
+// tables when none is given, removing the deleted rows, or the deleted cells too by cell
+// granularity, like nodetool garbagecollect
+func (m *Manager) TombstoneCompaction(ctx context.Context, granularity, keyspace string, tables ...string) error {
+	if tables == nil {
+		tables = []string{}
+	}
+	var status int
+	// jobs=0 uses all the compaction threads
+	if err := m.execCompaction(ctx, &status, storageServicePath, garbageCollectSignature, granularity, 0, keyspace, tables); err != nil {
+		return err
+	}
+	if status != 0 {
+		return fmt.Errorf("Could not collect the tombstones of %s, with status %d", keyspace, status)
+	}
+	return nil
+}
+
+// StopCompactions stops the running operations of the type, one of the compaction types
+func (m *Manager) StopCompactions(ctx context.Context, compactionType string) error {
+	batch := jolokia.NewBatch()
+	result := batch.Exec(nil, compactionManagerPath, "stopCompaction", compactionType)
+	if err := m.SendBatch(ctx, batch); err != nil {
+		return err
+	}
+	return result.Err()
+}
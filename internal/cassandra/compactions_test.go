@@ -0,0 +1,54 @@
+package cassandra
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/CrossEngage/CaOps/internal/cassandra/cassandratest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompactionHistory(t *testing.T) {
+	manager, agent := newFakeManager(t)
+	defer agent.Close()
+	agent.SetAttribute(compactionManagerPath, "CompactionHistory", cassandratest.CompactionHistory(
+		map[string]interface{}{"id": "a1", "keyspace_name": "ks1", "columnfamily_name": "users", "compacted_at": 1530000000000,
+			"bytes_in": 2048, "bytes_out": 1024, "rows_merged": "{1:3, 2:1}"},
+		map[string]interface{}{"id": "b2", "keyspace_name": "ks1", "columnfamily_name": "orders", "compacted_at": 1530000060000,
+			"bytes_in": 100, "bytes_out": 100, "rows_merged": "{1:1}"},
+	))
+
+	history, err := manager.CompactionHistory(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, []*CompactionHistoryEntry{
+		{ID: "b2", Keyspace: "ks1", Table: "orders", CompactedAt: time.Date(2018, 6, 26, 8, 1, 0, 0, time.UTC),
+			BytesIn: 100, BytesOut: 100, RowsMerged: "{1:1}"},
+		{ID: "a1", Keyspace: "ks1", Table: "users", CompactedAt: time.Date(2018, 6, 26, 8, 0, 0, 0, time.UTC),
+			BytesIn: 2048, BytesOut: 1024, RowsMerged: "{1:3, 2:1}"},
+	}, history)
+}
+
+func TestCompactions(t *testing.T) {
+	manager, agent := newFakeManager(t)
+	defer agent.Close()
+	ok := func(args []interface{}) (interface{}, error) { return 0, nil }
+	agent.SetOperation(cassandratest.StorageService, "forceKeyspaceCompaction", ok)
+	agent.SetOperation(cassandratest.StorageService, "garbageCollect", ok)
+	agent.SetOperation(compactionManagerPath, "forceUserDefinedCompaction", ok)
+	agent.SetOperation(compactionManagerPath, "stopCompaction", ok)
+	ctx := context.Background()
+
+	assert.Nil(t, manager.MajorCompaction(ctx, "ks1", true, "users"))
+	assert.Nil(t, manager.TombstoneCompaction(ctx, TombstoneCell, "ks1"))
+	assert.Nil(t, manager.UserDefinedCompaction(ctx, []string{"mc-1-big-Data.db", "mc-2-big-Data.db"}))
+	assert.Nil(t, manager.StopCompactions(ctx, "VALIDATION"))
+
+	assert.Equal(t, [][]interface{}{{true, "ks1", []interface{}{"users"}}},
+		agent.Executed(cassandratest.StorageService, "forceKeyspaceCompaction"))
+	assert.Equal(t, [][]interface{}{{"CELL", 0.0, "ks1", []interface{}{}}},
+		agent.Executed(cassandratest.StorageService, "garbageCollect"))
+	assert.Equal(t, [][]interface{}{{"mc-1-big-Data.db,mc-2-big-Data.db"}},
+		agent.Executed(compactionManagerPath, "forceUserDefinedCompaction"))
+	assert.Equal(t, [][]interface{}{{"VALIDATION"}}, agent.Executed(compactionManagerPath, "stopCompaction"))
+}
@@ -0,0 +1,214 @@
+package cassandra
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/CrossEngage/CaOps/internal/jolokia"
+)
+
+const (
+	storageProxyPath    = "org.apache.cassandra.db:type=StorageProxy"
+	failureDetectorPath = "org.apache.cassandra.net:type=FailureDetector"
+	gossiperPath        = "org.apache.cassandra.net:type=Gossiper"
+	runtimePath         = "java.lang:type=Runtime"
+	memoryPath          = "java.lang:type=Memory"
+	exceptionsPath      = "org.apache.cassandra.metrics:type=Storage,name=Exceptions"
+	cacheMetricPattern  = "org.apache.cassandra.metrics:type=Cache,scope=%s,name=%s"
+)
+
+// MemoryUsage is the usage of a JVM memory area, in bytes
+type MemoryUsage struct {
+	Used int64 `json:"used"`
+	Max  int64 `json:"max"`
+}
+
+// CacheInfo describes one of the caches of the node
+type CacheInfo struct {
+	Entries  int64   `json:"entries"`
+	Size     int64   `json:"size"`
+	Capacity int64   `json:"capacity"`
+	Hits     int64   `json:"hits"`
+	Requests int64   `json:"requests"`
+	HitRate  float64 `json:"hit_rate"`
+}
+
+// NodeInfo describes the node, like nodetool info
+type NodeInfo struct {
+	ID                    string      `json:"id"`
+	GossipActive          bool        `json:"gossip_active"`
+	ThriftActive          bool        `json:"thrift_active"`
+	NativeTransportActive bool        `json:"native_transport_active"`
+	Load                  string      `json:"load"`
+	GenerationNumber      int64       `json:"generation_number"`
+	UptimeSeconds         int64       `json:"uptime_seconds"`
+	HeapMemory            MemoryUsage `json:"heap_memory"`
+	OffHeapMemory         MemoryUsage `json:"off_heap_memory"`
+	Datacenter            string      `json:"datacenter"`
+	Rack                  string      `json:"rack"`
+	Exceptions            int64       `json:"exceptions"`
+	KeyCache              CacheInfo   `json:"key_cache"`
+	RowCache              CacheInfo   `json:"row_cache"`
+	CounterCache          CacheInfo   `json:"counter_cache"`
+	Tokens                []string    `json:"tokens"`
+}
+
+// Info returns the description of the node. The attributes which do not exist in all the
+// Cassandra versions are left unset when they can not be read.
+func (m *Manager) Info(ctx context.Context) (*NodeInfo, error) {
+	info := &NodeInfo{}
+	var uptimeMillis int64
+	var hostIDToEndpoint map[string]string
+	ss := m.storageService
+	batch := jolokia.NewBatch()
+	required := []*jolokia.BatchResult{
+		ss.batchRead(batch, &info.ID, "LocalHostId"),
+		ss.batchRead(batch, &info.GossipActive, "GossipRunning"),
+		ss.batchRead(batch, &info.Load, "LoadString"),
+		ss.batchRead(batch, &info.Tokens, "Tokens"),
+		ss.batchRead(batch, &hostIDToEndpoint, "HostIdToEndpoint"),
+		batch.Read(&uptimeMillis, runtimePath, "Uptime"),
+		batch.Read(&info.HeapMemory, memoryPath, "HeapMemoryUsage"),
+		batch.Read(&info.OffHeapMemory, memoryPath, "NonHeapMemoryUsage"),
+		batch.Read(&info.Datacenter, endpointSnitchInfoPath, "Datacenter"),
+		batch.Read(&info.Rack, endpointSnitchInfoPath, "Rack"),
+	}
+	// Thrift was removed in Cassandra 4.0, and the exceptions metric is missing until used
+	ss.batchRead(batch, &info.ThriftActive, "RPCServerRunning")
+	ss.batchRead(batch, &info.NativeTransportActive, "NativeTransportRunning")
+	batch.Read(&info.Exceptions, exceptionsPath, "Count")
+	caches := map[string]*CacheInfo{"KeyCache": &info.KeyCache, "RowCache": &info.RowCache, "CounterCache": &info.CounterCache}
+	cacheValues := make(map[string]*jolokia.MBeansValue, len(caches))
+	for scope := range caches {
+		cacheValues[scope] = &jolokia.MBeansValue{}
+		batch.ReadMBeans(cacheValues[scope], fmt.Sprintf(cacheMetricPattern, scope, "*"), "Value", "Count")
+	}
+	if err := m.SendBatch(ctx, batch); err != nil {
+		return nil, err
+	}
+	if err := firstErr(required...); err != nil {
+		return nil, err
+	}
+
+	info.UptimeSeconds = uptimeMillis / 1000
+	for scope, cache := range caches {
+		*cache = cacheInfo(scope, *cacheValues[scope])
+	}
+	sortTokens(info.Tokens)
+
+	// the generation is only known by the gossiper, which needs the address of the node
+	if address := hostIDToEndpoint[info.ID]; address != "" {
+		var generation int64
+		batch := jolokia.NewBatch()
+		result := batch.Exec(&generation, gossiperPath, "getCurrentGenerationNumber", address)
+		if err := m.SendBatch(ctx, batch); err == nil && result.Err() == nil {
+			info.GenerationNumber = generation
+		}
+	}
+	return info, nil
+}
+
+// cacheInfo builds the description of a cache from its metrics MBeans
+func cacheInfo(scope string, mbeans jolokia.MBeansValue) CacheInfo {
+	metric := func(name string) map[string]interface{} {
+		return mbeans[fmt.Sprintf(cacheMetricPattern, scope, name)]
+	}
+	return CacheInfo{
+		Entries:  toInt64(metric("Entries")["Value"]),
+		Size:     toInt64(metric("Size")["Value"]),
+		Capacity: toInt64(metric("Capacity")["Value"]),
+		Hits:     toInt64(metric("Hits")["Count"]),
+		Requests: toInt64(metric("Requests")["Count"]),
+		HitRate:  toFloat64(metric("HitRate")["Value"]),
+	}
+}
+
+// ClusterDescription describes the cluster, like nodetool describecluster
+type ClusterDescription struct {
+	Name        string `json:"name"`
+	Snitch      string `json:"snitch"`
+	Partitioner string `json:"partitioner"`
+	// SchemaVersions maps each schema version to the addresses of the nodes having it
+	SchemaVersions map[string][]string `json:"schema_versions"`
+}
+
+// DescribeCluster returns the description of the cluster, as seen by the node
+func (m *Manager) DescribeCluster(ctx context.Context) (*ClusterDescription, error) {
+	description := &ClusterDescription{}
+	batch := jolokia.NewBatch()
+	results := []*jolokia.BatchResult{
+		m.storageService.batchRead(batch, &description.Name, "ClusterName"),
+		m.storageService.batchRead(batch, &description.Partitioner, "PartitionerName"),
+		batch.Read(&description.Snitch, endpointSnitchInfoPath, "SnitchName"),
+		batch.Read(&description.SchemaVersions, storageProxyPath, "SchemaVersions"),
+	}
+	if err := m.SendBatch(ctx, batch); err != nil {
+		return nil, err
+	}
+	if err := firstErr(results...); err != nil {
+		return nil, err
+	}
+	for _, addresses := range description.SchemaVersions {
+		sort.Strings(addresses)
+	}
+	return description, nil
+}
+
+// UnreachableSchema is the key of the schema versions listing the nodes whose schema version
+// is unknown, as they are unreachable
+const UnreachableSchema = "UNREACHABLE"
+
+// SchemaVersions maps each schema version to the sorted addresses of the nodes having it, as
+// seen by the node, with the unreachable nodes under UnreachableSchema
+func (m *Manager) SchemaVersions(ctx context.Context) (map[string][]string, error) {
+	versions := make(map[string][]string)
+	batch := jolokia.NewBatch()
+	result := batch.Read(&versions, storageProxyPath, "SchemaVersions")
+	if err := m.SendBatch(ctx, batch); err != nil {
+		return nil, err
+	}
+	if err := result.Err(); err != nil {
+		return nil, err
+	}
+	for _, addresses := range versions {
+		sort.Strings(addresses)
+	}
+	return versions, nil
+}
+
+// GossipInfo returns the gossip state of each endpoint, keyed by its address, like nodetool
+// gossipinfo. Each state maps the application states, like STATUS or SCHEMA, to their values.
+func (m *Manager) GossipInfo(ctx context.Context) (map[string]map[string]string, error) {
+	vr, err := m.jolokiaClient.ReadString(ctx, failureDetectorPath, "AllEndpointStates")
+	if err != nil {
+		return nil, err
+	}
+	return parseEndpointStates(vr.Value), nil
+}
+
+// parseEndpointStates parses the endpoint states dump of the failure detector, which lists
+// each endpoint, like /10.0.0.1, followed by its indented states, like "  STATUS:14:NORMAL,-1"
+func parseEndpointStates(dump string) map[string]map[string]string {
+	states := make(map[string]map[string]string)
+	var current map[string]string
+	for _, line := range strings.Split(dump, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, " ") {
+			current = make(map[string]string)
+			states[endpointAddress(strings.TrimSpace(line))] = current
+			continue
+		}
+		if current == nil {
+			continue
+		}
+		parts := strings.SplitN(strings.TrimSpace(line), ":", 2)
+		if len(parts) == 2 {
+			current[parts[0]] = parts[1]
+		}
+	}
+	return states
+}
@@ -0,0 +1,101 @@
+package cassandra
+
+import (
+	"context"
+	"testing"
+
+	"github.com/CrossEngage/CaOps/internal/cassandra/cassandratest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseEndpointStates(t *testing.T) {
+	dump := "/127.0.0.1\n" +
+		"  generation:1530000000\n" +
+		"  heartbeat:1234\n" +
+		"  STATUS:14:NORMAL,-9223372036854775808\n" +
+		"  SCHEMA:10:59adb24e-f3cd-3e02-97f0-5b395827453f\n" +
+		"cass2.example.com/127.0.0.2\n" +
+		"  STATUS:20:LEAVING,100\n"
+	assert.Equal(t, map[string]map[string]string{
+		"127.0.0.1": {
+			"generation": "1530000000",
+			"heartbeat":  "1234",
+			"STATUS":     "14:NORMAL,-9223372036854775808",
+			"SCHEMA":     "10:59adb24e-f3cd-3e02-97f0-5b395827453f",
+		},
+		"127.0.0.2": {"STATUS": "20:LEAVING,100"},
+	}, parseEndpointStates(dump))
+}
+
+func TestThreadPoolsStats(t *testing.T) {
+	manager, agent := newFakeManager(t)
+	defer agent.Close()
+	pool := "org.apache.cassandra.metrics:type=ThreadPools,path=request,scope=ReadStage,name="
+	agent.SetAttribute(pool+"ActiveTasks", "Value", 2)
+	agent.SetAttribute(pool+"PendingTasks", "Value", 3)
+	agent.SetAttribute(pool+"CompletedTasks", "Value", 100)
+	agent.SetAttribute(pool+"CurrentlyBlockedTasks", "Count", 1)
+	agent.SetAttribute(pool+"TotalBlockedTasks", "Count", 4)
+	agent.SetAttribute("org.apache.cassandra.metrics:type=ThreadPools,path=internal,scope=GossipStage,name=CompletedTasks", "Value", 7)
+	agent.SetAttribute("org.apache.cassandra.metrics:type=DroppedMessage,scope=MUTATION,name=Dropped", "Count", 5)
+
+	stats, err := manager.ThreadPoolsStats(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, &ThreadPoolsStats{
+		Pools: []*ThreadPoolStats{
+			{Name: "GossipStage", Completed: 7},
+			{Name: "ReadStage", Active: 2, Pending: 3, Completed: 100, Blocked: 1, AllTimeBlocked: 4},
+		},
+		DroppedMessages: map[string]int64{"MUTATION": 5},
+	}, stats)
+}
+
+func TestCompactionStats(t *testing.T) {
+	manager, agent := newFakeManager(t)
+	defer agent.Close()
+	agent.SetAttribute(compactionManagerPath, "Compactions", []map[string]string{{
+		"compactionId": "d2b0c6f0-0000-11e8-0000-000000000000",
+		"taskType":     "Compaction",
+		"keyspace":     "ks1",
+		"columnfamily": "users",
+		"completed":    "512",
+		"total":        "1024",
+		"unit":         "bytes",
+	}})
+	agent.SetAttribute(pendingCompactionsPath, "Value", 3)
+	agent.SetAttribute(totalCompactionsCompleted, "Count", 42)
+
+	stats, err := manager.CompactionStats(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, &CompactionStats{
+		PendingTasks:       3,
+		CompletedTasks:     42,
+		ThroughputMbPerSec: 16,
+		Compactions: []*Compaction{{
+			ID: "d2b0c6f0-0000-11e8-0000-000000000000", Type: "Compaction", Keyspace: "ks1", Table: "users",
+			Completed: 512, Total: 1024, Unit: "bytes",
+		}},
+	}, stats)
+}
+
+func TestSchemaVersions(t *testing.T) {
+	manager, agent := newFakeManager(t)
+	defer agent.Close()
+	agent.SetAttribute(cassandratest.StorageService, "LiveNodes", []string{"127.0.0.2", "127.0.0.1"})
+	agent.SetAttribute(cassandratest.StorageService, "UnreachableNodes", []string{"127.0.0.3"})
+
+	versions, err := manager.SchemaVersions(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, map[string][]string{
+		"59adb24e-f3cd-3e02-97f0-5b395827453f": {"127.0.0.1", "127.0.0.2"},
+		UnreachableSchema:                      {"127.0.0.3"},
+	}, versions)
+
+	agent.SetAttribute(cassandratest.StorageProxy, "SchemaVersions", map[string][]string{
+		"59adb24e-f3cd-3e02-97f0-5b395827453f": {"127.0.0.1"},
+		"86afa796-d883-3932-aa73-6b017cef0d19": {"127.0.0.2"},
+	})
+	versions, err = manager.SchemaVersions(context.Background())
+	assert.Nil(t, err)
+	assert.Len(t, versions, 2)
+}
@@ -0,0 +1,129 @@
+package cassandra
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/CrossEngage/CaOps/internal/jolokia"
+)
+
+// Drain prepares the node to be stopped: it leaves gossip, so the other nodes stop sending it
+// requests, stops serving the clients, and then flushes the memtables and stops accepting
+// writes. The node must be restarted afterwards.
+func (m *Manager) Drain(ctx context.Context) error {
+	ss := m.storageService.withoutTimeout()
+	for _, operation := range []string{"stopGossiping", "stopNativeTransport", "drain"} {
+		if err := ss.exec(ctx, nil, operation); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// upgradeSSTablesSignature is the signature of the upgradeSSTables operation, which is
+// overloaded since Cassandra 3.0
+const upgradeSSTablesSignature = "upgradeSSTables(java.lang.String,boolean,int,[Ljava.lang.String;)"
+
+// UpgradeSSTables rewrites the SSTables of the keyspace, or all of them when none is given,
+// which are not in the format of the version of the node, like after an upgrade
+func (m *Manager) UpgradeSSTables(ctx context.Context, keyspaces ...string) error {
+	if len(keyspaces) == 0 {
+		var err error
+		if keyspaces, err = m.Keyspaces(ctx); err != nil {
+			return err
+		}
+	}
+	ss := m.storageService.withoutTimeout()
+	for _, keyspace := range keyspaces {
+		var status int
+		// jobs=0 uses all the compaction threads
+		if err := ss.exec(ctx, &status, upgradeSSTablesSignature, keyspace, true, 0, []string{}); err != nil {
+			return err
+		}
+		if status != 0 {
+			return fmt.Errorf("Could not upgrade the SSTables of %s, with status %d", keyspace, status)
+		}
+	}
+	return nil
+}
+
+// forceKeyspaceCleanupSignature is the signature of the forceKeyspaceCleanup operation, which
+// is overloaded since Cassandra 3.0
+const forceKeyspaceCleanupSignature = "forceKeyspaceCleanup(int,java.lang.String,[Ljava.lang.String;)"
+
+// CleanupKeyspaces removes from the SSTables of the keyspaces, or all the non-system ones
+// when none is given, the data the node no longer owns, like after nodes joined the ring
+func (m *Manager) CleanupKeyspaces(ctx context.Context, keyspaces ...string) error {
+	if len(keyspaces) == 0 {
+		var err error
+		if keyspaces, err = m.NonSystemKeyspaces(ctx); err != nil {
+			return err
+		}
+	}
+	ss := m.storageService.withoutTimeout()
+	for _, keyspace := range keyspaces {
+		var status int
+		// jobs=0 uses all the compaction threads
+		if err := ss.exec(ctx, &status, forceKeyspaceCleanupSignature, 0, keyspace, []string{}); err != nil {
+			return err
+		}
+		if status != 0 {
+			return fmt.Errorf("Could not clean up %s, with status %d", keyspace, status)
+		}
+	}
+	return nil
+}
+
+// HintsInProgress returns the number of hints the node is writing for the nodes which are down
+func (m *Manager) HintsInProgress(ctx context.Context) (int64, error) {
+	var hints int64
+	batch := jolokia.NewBatch()
+	result := batch.Read(&hints, storageProxyPath, "HintsInProgress")
+	if err := m.SendBatch(ctx, batch); err != nil {
+		return 0, err
+	}
+	return hints, result.Err()
+}
+
+// Decommission streams the data of the node to the nodes taking over its ranges, and makes it
+// leave the ring
+func (m *Manager) Decommission(ctx context.Context) error {
+	mbean, err := m.jolokiaClient.ListMBean(ctx, storageServicePath)
+	if err != nil {
+		return err
+	}
+	var args []interface{}
+	operation, _, err := mbean.Operations.Resolve("decommission", 0)
+	if err != nil {
+		// Cassandra 4.0 only has decommission(boolean force), which is not forced like the
+		// decommission of the previous versions
+		if operation, _, err = mbean.Operations.Resolve("decommission", 1); err != nil {
+			return err
+		}
+		args = []interface{}{false}
+	}
+	return m.storageService.withoutTimeout().exec(ctx, nil, operation, args...)
+}
+
+// RemoveNode removes the dead node with the host ID from the ring, streaming its ranges from
+// their other replicas to the nodes taking them over
+func (m *Manager) RemoveNode(ctx context.Context, hostID string) error {
+	return m.storageService.withoutTimeout().exec(ctx, nil, "removeNode", hostID)
+}
+
+// ResetLocalSchema drops the schema of the node and pulls it again from the other nodes, to fix
+// a node which disagrees with the rest of the cluster
+func (m *Manager) ResetLocalSchema(ctx context.Context) error {
+	return m.storageService.exec(ctx, nil, "resetLocalSchema")
+}
+
+// RemovalStatus returns the status of the removal of a node coordinated by this node
+func (m *Manager) RemovalStatus(ctx context.Context) (string, error) {
+	var status string
+	batch := jolokia.NewBatch()
+	result := m.storageService.batchRead(batch, &status, "RemovalStatus")
+	if err := m.SendBatch(ctx, batch); err != nil {
+		return "", err
+	}
+	return status, result.Err()
+}
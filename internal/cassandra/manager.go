@@ -1,8 +1,7 @@
 package cassandra
 
 import (
-	"net/http"
-	"net/url"
+	"context"
 
 	"github.com/CrossEngage/CaOps/internal/jolokia"
 )
@@ -14,12 +13,11 @@ type Manager struct {
 }
 
 // NewManager builds a new Cassandra Manager to encapsulate all interaction with a Cassandra node
-func NewManager(jolokiaAddr string) (*Manager, error) {
-	jolokiaURL, err := url.Parse(jolokiaAddr)
+func NewManager(jolokiaConfig jolokia.Config) (*Manager, error) {
+	jolokiaClient, err := jolokia.NewClientFromConfig(jolokiaConfig)
 	if err != nil {
 		return nil, err
 	}
-	jolokiaClient := jolokia.NewClient(*http.DefaultClient, *jolokiaURL)
 	manager := &Manager{
 		storageService: storageService{jolokiaClient},
 		jolokiaClient:  jolokiaClient,
@@ -27,37 +25,64 @@ func NewManager(jolokiaAddr string) (*Manager, error) {
 	return manager, nil
 }
 
+// JMXTarget returns the JMX service URL of the node when it is managed through a Jolokia
+// proxy, or an empty string when the agent runs in the JVM of the node
+func (m *Manager) JMXTarget() string {
+	if target := m.jolokiaClient.ProxyTarget(); target != nil {
+		return target.URL
+	}
+	return ""
+}
+
 // CheckClusterStability checks if the cluster is stable, or if it have no
 // unreachable, joining, leaving, or moving nodes
-func (m *Manager) CheckClusterStability() error {
-	if err := mustGetEmptyStringList(m.storageService.UnreachableNodes,
-		ErrUnreachableCassandraNodes); err != nil {
-		return err
+func (m *Manager) CheckClusterStability(ctx context.Context) error {
+	return m.CheckClusterStabilityExcept(ctx)
+}
+
+// CheckClusterStabilityExcept checks if the cluster is stable but for the excluded nodes,
+// like a dead node about to be removed
+func (m *Manager) CheckClusterStabilityExcept(ctx context.Context, excluded ...string) error {
+	checks := []struct {
+		attribute string
+		nonEmpty  error
+		nodes     []string
+		result    *jolokia.BatchResult
+	}{
+		{attribute: "UnreachableNodes", nonEmpty: ErrUnreachableCassandraNodes},
+		{attribute: "JoiningNodes", nonEmpty: ErrJoiningCassandraNodes},
+		{attribute: "LeavingNodes", nonEmpty: ErrLeavingCassandraNodes},
+		{attribute: "MovingNodes", nonEmpty: ErrMovingCassandraNodes},
 	}
-	if err := mustGetEmptyStringList(m.storageService.JoiningNodes,
-		ErrJoiningCassandraNodes); err != nil {
-		return err
+	batch := jolokia.NewBatch()
+	for i := range checks {
+		checks[i].result = m.storageService.batchRead(batch, &checks[i].nodes, checks[i].attribute)
 	}
-	if err := mustGetEmptyStringList(m.storageService.LeavingNodes,
-		ErrLeavingCassandraNodes); err != nil {
+	if err := m.SendBatch(ctx, batch); err != nil {
 		return err
 	}
-	if err := mustGetEmptyStringList(m.storageService.MovingNodes,
-		ErrMovingCassandraNodes); err != nil {
-		return err
+	for _, check := range checks {
+		if err := check.result.Err(); err != nil {
+			return err
+		}
+		for _, node := range check.nodes {
+			if !contains(excluded, node) {
+				return check.nonEmpty
+			}
+		}
 	}
 	return nil
 }
 
 // LiveNodes return a list of IPs of the Cassandra nodes with status=LIVE
-func (m *Manager) LiveNodes() ([]string, error) {
-	return m.storageService.LiveNodes()
+func (m *Manager) LiveNodes(ctx context.Context) ([]string, error) {
+	return m.storageService.LiveNodes(ctx)
 }
 
 // JolokiaAgentVersion returns the version of the Jolokia Agent running
 // into the Cassandra's JVM
-func (m *Manager) JolokiaAgentVersion() (string, error) {
-	verResp, err := m.jolokiaClient.Version()
+func (m *Manager) JolokiaAgentVersion(ctx context.Context) (string, error) {
+	verResp, err := m.jolokiaClient.Version(ctx)
 	if err != nil {
 		return "", err
 	}
@@ -66,8 +91,8 @@ func (m *Manager) JolokiaAgentVersion() (string, error) {
 
 // CassandraVersion returns the version of the Cassandra node this manager
 // is connected to
-func (m *Manager) CassandraVersion() (string, error) {
-	cver, err := m.storageService.ReleaseVersion()
+func (m *Manager) CassandraVersion(ctx context.Context) (string, error) {
+	cver, err := m.storageService.ReleaseVersion(ctx)
 	if err != nil {
 		return "", err
 	}
@@ -76,117 +101,178 @@ func (m *Manager) CassandraVersion() (string, error) {
 
 // UnreachableNodes retrieve the list of unreachable nodes in the cluster, as
 // determined by this node's failure detector.
-func (m *Manager) UnreachableNodes() (ips []string, err error) {
-	return m.storageService.UnreachableNodes()
+func (m *Manager) UnreachableNodes(ctx context.Context) (ips []string, err error) {
+	return m.storageService.UnreachableNodes(ctx)
 }
 
 // JoiningNodes retrieve the list of nodes currently bootstrapping into the ring.
-func (m *Manager) JoiningNodes() (ips []string, err error) {
-	return m.storageService.JoiningNodes()
+func (m *Manager) JoiningNodes(ctx context.Context) (ips []string, err error) {
+	return m.storageService.JoiningNodes(ctx)
 }
 
 // LeavingNodes retrieve the list of nodes currently leaving the ring.
-func (m *Manager) LeavingNodes() (ips []string, err error) {
-	return m.storageService.LeavingNodes()
+func (m *Manager) LeavingNodes(ctx context.Context) (ips []string, err error) {
+	return m.storageService.LeavingNodes(ctx)
 }
 
 // MovingNodes retrieve the list of nodes currently moving in the ring.
-func (m *Manager) MovingNodes() (ips []string, err error) {
-	return m.storageService.MovingNodes()
+func (m *Manager) MovingNodes(ctx context.Context) (ips []string, err error) {
+	return m.storageService.MovingNodes(ctx)
 }
 
 // Tokens fetch string representations of the tokens for this node.
-func (m *Manager) Tokens() ([]string, error) {
-	return m.storageService.Tokens()
+func (m *Manager) Tokens(ctx context.Context) ([]string, error) {
+	return m.storageService.Tokens(ctx)
 }
 
 // SchemaVersion fetch a string representation of the current Schema version.
-func (m *Manager) SchemaVersion() (version string, err error) {
-	return m.storageService.SchemaVersion()
+func (m *Manager) SchemaVersion(ctx context.Context) (version string, err error) {
+	return m.storageService.SchemaVersion(ctx)
 }
 
 // AllDataFileLocations returns the list of all data file locations from conf
-func (m *Manager) AllDataFileLocations() (paths []string, err error) {
-	return m.storageService.AllDataFileLocations()
+func (m *Manager) AllDataFileLocations(ctx context.Context) (paths []string, err error) {
+	return m.storageService.AllDataFileLocations(ctx)
 }
 
 // CommitLogLocation returns the location of the commit log
-func (m *Manager) CommitLogLocation() (string, error) {
-	return m.storageService.CommitLogLocation()
+func (m *Manager) CommitLogLocation(ctx context.Context) (string, error) {
+	return m.storageService.CommitLogLocation(ctx)
 }
 
 // SavedCachesLocation returns the location of the saved caches dir
-func (m *Manager) SavedCachesLocation() (string, error) {
-	return m.storageService.SavedCachesLocation()
+func (m *Manager) SavedCachesLocation(ctx context.Context) (string, error) {
+	return m.storageService.SavedCachesLocation(ctx)
 }
 
 // TokenToEndpointMap retrieve a map of tokens to endpoints, including the bootstrapping ones.
-func (m *Manager) TokenToEndpointMap() (map[string]string, error) {
-	return m.storageService.TokenToEndpointMap()
+func (m *Manager) TokenToEndpointMap(ctx context.Context) (map[string]string, error) {
+	return m.storageService.TokenToEndpointMap(ctx)
+}
+
+// RangeToEndpointMap retrieve the ranges of the ring of the keyspace, with their replicas
+func (m *Manager) RangeToEndpointMap(ctx context.Context, keyspace string) ([]TokenRange, error) {
+	return m.storageService.RangeToEndpointMap(ctx, keyspace)
+}
+
+// RangeToRpcaddressMap retrieve the ranges of the ring of the keyspace, with the RPC addresses
+// of their replicas
+func (m *Manager) RangeToRpcaddressMap(ctx context.Context, keyspace string) ([]TokenRange, error) {
+	return m.storageService.RangeToRpcaddressMap(ctx, keyspace)
+}
+
+// DescribeRingJMX retrieve the ranges of the ring of the keyspace, with the location of their
+// replicas
+func (m *Manager) DescribeRingJMX(ctx context.Context, keyspace string) ([]TokenRange, error) {
+	return m.storageService.DescribeRingJMX(ctx, keyspace)
+}
+
+// PendingRangeToEndpointMap retrieve the ranges being streamed, with their future replicas
+func (m *Manager) PendingRangeToEndpointMap(ctx context.Context, keyspace string) ([]TokenRange, error) {
+	return m.storageService.PendingRangeToEndpointMap(ctx, keyspace)
+}
+
+// NaturalEndpoints returns the IPs of the replicas of the partition key of the table
+func (m *Manager) NaturalEndpoints(ctx context.Context, keyspace, table, key string) ([]string, error) {
+	return m.storageService.NaturalEndpoints(ctx, keyspace, table, key)
+}
+
+// EffectiveOwnership returns the fraction of the data each node owns given the keyspace,
+// keyed by IP
+func (m *Manager) EffectiveOwnership(ctx context.Context, keyspace string) (map[string]float64, error) {
+	return m.storageService.EffectiveOwnership(ctx, keyspace)
 }
 
 // LocalHostID returns the hosts unique ID
-func (m *Manager) LocalHostID() (string, error) {
-	return m.storageService.LocalHostID()
+func (m *Manager) LocalHostID(ctx context.Context) (string, error) {
+	return m.storageService.LocalHostID(ctx)
 }
 
 // EndpointToHostID retrieve the mapping of endpoint to host ID
-func (m *Manager) EndpointToHostID() (map[string]string, error) {
-	return m.storageService.EndpointToHostID()
+func (m *Manager) EndpointToHostID(ctx context.Context) (map[string]string, error) {
+	return m.storageService.EndpointToHostID(ctx)
 }
 
 // HostIDToEndpoint retrieve the mapping of host ID to endpoint
-func (m *Manager) HostIDToEndpoint() (map[string]string, error) {
-	return m.storageService.HostIDToEndpoint()
+func (m *Manager) HostIDToEndpoint(ctx context.Context) (map[string]string, error) {
+	return m.storageService.HostIDToEndpoint(ctx)
 }
 
 // Starting returns whether the storage service is starting or not
-func (m *Manager) Starting() (bool, error) {
-	return m.storageService.Starting()
+func (m *Manager) Starting(ctx context.Context) (bool, error) {
+	return m.storageService.Starting(ctx)
 }
 
 // GossipRunning returns whether the gossip is running
-func (m *Manager) GossipRunning() (bool, error) {
-	return m.storageService.GossipRunning()
+func (m *Manager) GossipRunning(ctx context.Context) (bool, error) {
+	return m.storageService.GossipRunning(ctx)
 }
 
 // Keyspaces return the list of keyspaces in the cluster
-func (m *Manager) Keyspaces() ([]string, error) {
-	return m.storageService.Keyspaces()
+func (m *Manager) Keyspaces(ctx context.Context) ([]string, error) {
+	return m.storageService.Keyspaces(ctx)
 }
 
 // NonSystemKeyspaces ...
-func (m *Manager) NonSystemKeyspaces() ([]string, error) {
-	return m.storageService.NonSystemKeyspaces()
+func (m *Manager) NonSystemKeyspaces(ctx context.Context) ([]string, error) {
+	return m.storageService.NonSystemKeyspaces(ctx)
 }
 
 // OperationMode returns the operation mode of the node. STARTING, NORMAL, JOINING,
 // LEAVING, DECOMMISSIONED, MOVING, DRAINING, DRAINED.
-func (m *Manager) OperationMode() (string, error) {
-	return m.storageService.OperationMode()
+func (m *Manager) OperationMode(ctx context.Context) (string, error) {
+	return m.storageService.OperationMode(ctx)
 }
 
 // IncrementalBackupsEnabled is self explanatory
-func (m *Manager) IncrementalBackupsEnabled() (bool, error) {
-	return m.storageService.IncrementalBackupsEnabled()
+func (m *Manager) IncrementalBackupsEnabled(ctx context.Context) (bool, error) {
+	return m.storageService.IncrementalBackupsEnabled(ctx)
 }
 
 // Initialized is self explanatory
-func (m *Manager) Initialized() (bool, error) {
-	return m.storageService.Initialized()
+func (m *Manager) Initialized(ctx context.Context) (bool, error) {
+	return m.storageService.Initialized(ctx)
 }
 
 // Joined is self explanatory
-func (m *Manager) Joined() (bool, error) {
-	return m.storageService.Joined()
+func (m *Manager) Joined(ctx context.Context) (bool, error) {
+	return m.storageService.Joined(ctx)
 }
 
 // ClusterName returns the name of the cluster
-func (m *Manager) ClusterName() (name string, err error) {
-	return m.storageService.ClusterName()
+func (m *Manager) ClusterName(ctx context.Context) (name string, err error) {
+	return m.storageService.ClusterName(ctx)
 }
 
 // PartitionerName returns the cluster partitioner
-func (m *Manager) PartitionerName() (name string, err error) {
-	return m.storageService.PartitionerName()
+func (m *Manager) PartitionerName(ctx context.Context) (name string, err error) {
+	return m.storageService.PartitionerName(ctx)
+}
+
+// RepairAsync starts a repair of the keyspace, tuned by the options, and returns the number
+// of the repair command, or 0 when there is nothing to repair
+func (m *Manager) RepairAsync(ctx context.Context, keyspace string, options map[string]string) (int, error) {
+	return m.storageService.RepairAsync(ctx, keyspace, options)
+}
+
+// ParentRepairStatus returns the status of a repair command, followed by its messages, or
+// an empty status when the command is unknown
+func (m *Manager) ParentRepairStatus(ctx context.Context, command int) (status string, messages []string, err error) {
+	return m.storageService.ParentRepairStatus(ctx, command)
+}
+
+// CompactionThroughput returns the compaction throughput limit, in MB/s, where 0 is unlimited
+func (m *Manager) CompactionThroughput(ctx context.Context) (uint64, error) {
+	return m.storageService.CompactionThroughputMbPerSec(ctx)
+}
+
+// SetCompactionThroughput changes the compaction throughput limit at runtime, in MB/s,
+// where 0 is unlimited
+func (m *Manager) SetCompactionThroughput(ctx context.Context, mbPerSec uint64) error {
+	return m.storageService.SetCompactionThroughputMbPerSec(ctx, mbPerSec)
+}
+
+// SendBatch sends many Jolokia requests to the node in a single HTTP request
+func (m *Manager) SendBatch(ctx context.Context, batch *jolokia.Batch) error {
+	return m.jolokiaClient.SendBatch(ctx, batch)
 }
@@ -0,0 +1,176 @@
+package cassandra
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/CrossEngage/CaOps/internal/cassandra/cassandratest"
+	"github.com/CrossEngage/CaOps/internal/jolokia"
+	"github.com/stretchr/testify/assert"
+)
+
+func newFakeManager(t *testing.T) (*Manager, *cassandratest.FakeAgent) {
+	agent := cassandratest.NewFakeAgent()
+	manager, err := NewManager(agent.Config())
+	assert.Nil(t, err)
+	return manager, agent
+}
+
+func TestCheckClusterStability(t *testing.T) {
+	manager, agent := newFakeManager(t)
+	defer agent.Close()
+	ctx := context.Background()
+
+	assert.Nil(t, manager.CheckClusterStability(ctx))
+	agent.SetAttribute(cassandratest.StorageService, "LeavingNodes", []string{"10.0.0.2"})
+	assert.Equal(t, ErrLeavingCassandraNodes, manager.CheckClusterStability(ctx))
+	agent.SetAttribute(cassandratest.StorageService, "UnreachableNodes", []string{"10.0.0.3"})
+	assert.Equal(t, ErrUnreachableCassandraNodes, manager.CheckClusterStability(ctx))
+	assert.Equal(t, ErrLeavingCassandraNodes, manager.CheckClusterStabilityExcept(ctx, "10.0.0.3"))
+	assert.Nil(t, manager.CheckClusterStabilityExcept(ctx, "10.0.0.2", "10.0.0.3"))
+
+	agent.SetAttribute(cassandratest.StorageService, "UnreachableNodes", []string{})
+	agent.Fail(cassandratest.StorageService, "JoiningNodes", cassandratest.Failure{Message: "boom"})
+	assert.EqualError(t, manager.CheckClusterStability(ctx), "javax.management.MBeanException : boom")
+	agent.FailHTTP(http.StatusBadGateway)
+	assert.NotNil(t, manager.CheckClusterStability(ctx))
+}
+
+func TestParseDataSize(t *testing.T) {
+	for size, bytes := range map[string]int64{"0 bytes": 0, "512 bytes": 512, "1.5 KiB": 1536,
+		"1.2 MiB": 1258291, "2 GB": 2 << 30, "1.000 TiB": 1 << 40} {
+		parsed, err := ParseDataSize(size)
+		assert.Nil(t, err, size)
+		assert.Equal(t, bytes, parsed, size)
+	}
+	for _, size := range []string{"", "12", "1.2 PiB", "many MiB"} {
+		_, err := ParseDataSize(size)
+		assert.NotNil(t, err, size)
+	}
+}
+
+func TestManagerReads(t *testing.T) {
+	manager, agent := newFakeManager(t)
+	defer agent.Close()
+	ctx := context.Background()
+
+	liveNodes, err := manager.LiveNodes(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"127.0.0.1"}, liveNodes)
+
+	version, err := manager.CassandraVersion(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, "3.11.4", version)
+
+	agentVersion, err := manager.JolokiaAgentVersion(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, "1.3.7", agentVersion)
+
+	tokens, err := manager.TokenToEndpointMap(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"-9223372036854775808": "127.0.0.1"}, tokens)
+
+	gossipRunning, err := manager.GossipRunning(ctx)
+	assert.Nil(t, err)
+	assert.True(t, gossipRunning)
+
+	agent.Fail(cassandratest.StorageService, "OperationMode", cassandratest.Failure{Message: "boom"})
+	_, err = manager.OperationMode(ctx)
+	assert.NotNil(t, err)
+}
+
+func TestCompactionThroughput(t *testing.T) {
+	manager, agent := newFakeManager(t)
+	defer agent.Close()
+	ctx := context.Background()
+
+	throughput, err := manager.CompactionThroughput(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(16), throughput)
+	assert.Nil(t, manager.SetCompactionThroughput(ctx, 64))
+	assert.Equal(t, 64.0, agent.Attribute(cassandratest.StorageService, "CompactionThroughputMbPerSec"))
+}
+
+func TestNodeStatus(t *testing.T) {
+	manager, agent := newFakeManager(t)
+	defer agent.Close()
+	agent.AddTable("ks1", "users")
+	agent.Fail(cassandratest.StorageService, "SchemaVersion", cassandratest.Failure{Message: "boom"})
+
+	status := manager.NodeStatus(context.Background())
+	assert.Nil(t, status.ClusterName.Err())
+	assert.Equal(t, "Test Cluster", status.ClusterName.Value)
+	assert.Equal(t, "1.3.7", status.JolokiaAgentVersion.Value)
+	assert.Equal(t, []string{"ks1", "system", "system_auth"}, status.Keyspaces.Value)
+	assert.Equal(t, []string{"ks1"}, status.NonSystemKeyspaces.Value)
+	assert.False(t, status.IncrementalBackupsEnabled.Value)
+	assert.NotNil(t, status.SchemaVersion.Err())
+	assert.Equal(t, "", status.SchemaVersion.Value)
+}
+
+func TestNodeStatusTimeout(t *testing.T) {
+	manager, agent := newFakeManager(t)
+	defer agent.Close()
+	agent.SetLatency(300 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	status := manager.NodeStatus(ctx)
+	assert.NotNil(t, status.ClusterName.Err())
+	assert.NotNil(t, status.LiveNodes.Err())
+}
+
+func TestTables(t *testing.T) {
+	manager, agent := newFakeManager(t)
+	defer agent.Close()
+	agent.AddTable("ks1", "users")
+	agent.AddTable("ks1", "events")
+
+	tables, err := manager.Tables(context.Background(), "ks1")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string][]string{"ks1": {"events", "users"}}, tables)
+
+	tables, err = manager.Tables(context.Background(), "*")
+	assert.Nil(t, err)
+	assert.Len(t, tables, 3)
+	assert.Equal(t, []string{"local", "peers"}, tables["system"])
+}
+
+func TestTablesColumnFamilies(t *testing.T) {
+	manager, agent := newFakeManager(t)
+	defer agent.Close()
+	columnFamily := "org.apache.cassandra.db:type=ColumnFamilies,keyspace=ks1,columnfamily=%s"
+	agent.SetAttribute(fmt.Sprintf(columnFamily, "users"), "ColumnFamilyName", "users")
+
+	// Cassandra 2.2 only has the ColumnFamilies MBeans
+	tables, err := manager.Tables(context.Background(), "ks1")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string][]string{"ks1": {"users"}}, tables)
+
+	// Cassandra 3.x has both, which list the same tables
+	agent.AddTable("ks1", "users")
+	agent.AddTable("ks1", "events")
+	agent.SetAttribute(fmt.Sprintf(columnFamily, "events"), "ColumnFamilyName", "events")
+	tables, err = manager.Tables(context.Background(), "ks1")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string][]string{"ks1": {"events", "users"}}, tables)
+}
+
+func TestDecommission(t *testing.T) {
+	manager, agent := newFakeManager(t)
+	defer agent.Close()
+	ok := func(args []interface{}) (interface{}, error) { return nil, nil }
+	agent.SetOperation(cassandratest.StorageService, "decommission", ok)
+	assert.Nil(t, manager.Decommission(context.Background()))
+	assert.Equal(t, [][]interface{}{nil}, agent.Executed(cassandratest.StorageService, "decommission"))
+
+	// Cassandra 4.0 only has the signature taking whether the decommission is forced
+	agent.SetSignatures(cassandratest.StorageService, "decommission", jolokia.MBeanOperation{
+		Arguments: []jolokia.MBeanOperationArgument{{Name: "force", Type: "boolean"}}, ReturnType: "void",
+	})
+	assert.Nil(t, manager.Decommission(context.Background()))
+	assert.Equal(t, [][]interface{}{nil, {false}}, agent.Executed(cassandratest.StorageService, "decommission"))
+}
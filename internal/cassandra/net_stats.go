@@ -0,0 +1,104 @@
+package cassandra
+
+import (
+	"context"
+
+	"github.com/CrossEngage/CaOps/internal/jolokia"
+)
+
+const (
+	streamManagerPath    = "org.apache.cassandra.net:type=StreamManager"
+	messagingServicePath = "org.apache.cassandra.net:type=MessagingService"
+)
+
+// Stream is a streaming operation of the node, like a bootstrap, a rebuild or a repair
+type Stream struct {
+	PlanID      string `json:"plan_id"`
+	Description string `json:"description"`
+	Sessions    int    `json:"sessions"`
+}
+
+// MessagesStats are the counts of the messages of a connection pool, summed over all the peers
+type MessagesStats struct {
+	Pending   int64 `json:"pending"`
+	Completed int64 `json:"completed"`
+	Dropped   int64 `json:"dropped"`
+}
+
+// NetStats describes the network activity of the node, like nodetool netstats
+type NetStats struct {
+	Mode    string    `json:"mode"`
+	Streams []*Stream `json:"streams"`
+	// the read repair counters were removed in Cassandra 4.0, where they are left at 0
+	ReadRepairAttempted          int64                    `json:"read_repair_attempted"`
+	ReadRepairRepairedBlocking   int64                    `json:"read_repair_repaired_blocking"`
+	ReadRepairRepairedBackground int64                    `json:"read_repair_repaired_background"`
+	Messages                     map[string]MessagesStats `json:"messages"`
+}
+
+// messagePools are the connection pools of the messaging service, by the prefix of their attributes
+var messagePools = map[string]string{
+	"Large":  "LargeMessage",
+	"Small":  "SmallMessage",
+	"Gossip": "GossipMessage",
+}
+
+// NetStats returns the network activity of the node: its streams, read repairs and messages
+func (m *Manager) NetStats(ctx context.Context) (*NetStats, error) {
+	stats := &NetStats{Messages: make(map[string]MessagesStats)}
+	var streams []struct {
+		PlanID      string        `json:"planId"`
+		Description string        `json:"description"`
+		Sessions    []interface{} `json:"sessions"`
+	}
+	batch := jolokia.NewBatch()
+	results := []*jolokia.BatchResult{
+		m.storageService.batchRead(batch, &stats.Mode, "OperationMode"),
+		batch.Read(&streams, streamManagerPath, "CurrentStreams"),
+	}
+	batch.Read(&stats.ReadRepairAttempted, storageProxyPath, "ReadRepairAttempted")
+	batch.Read(&stats.ReadRepairRepairedBlocking, storageProxyPath, "ReadRepairRepairedBlocking")
+	batch.Read(&stats.ReadRepairRepairedBackground, storageProxyPath, "ReadRepairRepairedBackground")
+	messages := make(map[string][]map[string]int64, len(messagePools))
+	for pool, prefix := range messagePools {
+		counts := []map[string]int64{{}, {}, {}}
+		messages[pool] = counts
+		results = append(results,
+			batch.Read(&counts[0], messagingServicePath, prefix+"PendingTasks"),
+			batch.Read(&counts[1], messagingServicePath, prefix+"CompletedTasks"),
+			batch.Read(&counts[2], messagingServicePath, prefix+"DroppedTasks"),
+		)
+	}
+	if err := m.SendBatch(ctx, batch); err != nil {
+		return nil, err
+	}
+	if err := firstErr(results...); err != nil {
+		return nil, err
+	}
+
+	stats.Streams = make([]*Stream, 0, len(streams))
+	for _, stream := range streams {
+		stats.Streams = append(stats.Streams, &Stream{
+			PlanID:      stream.PlanID,
+			Description: stream.Description,
+			Sessions:    len(stream.Sessions),
+		})
+	}
+	for pool, counts := range messages {
+		stats.Messages[pool] = MessagesStats{
+			Pending:   sumByPeer(counts[0]),
+			Completed: sumByPeer(counts[1]),
+			Dropped:   sumByPeer(counts[2]),
+		}
+	}
+	return stats, nil
+}
+
+// sumByPeer sums the counts of the messaging service, which are kept by peer
+func sumByPeer(counts map[string]int64) int64 {
+	var sum int64
+	for _, count := range counts {
+		sum += count
+	}
+	return sum
+}
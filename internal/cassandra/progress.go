@@ -0,0 +1,214 @@
+package cassandra
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/CrossEngage/CaOps/internal/jolokia"
+)
+
+// ProgressEventType is the type of a progress event, in the order of the ordinals of the
+// ProgressEventType enum of Cassandra
+type ProgressEventType string
+
+// The types of the progress events
+const (
+	ProgressStart        ProgressEventType = "START"
+	ProgressProgress     ProgressEventType = "PROGRESS"
+	ProgressError        ProgressEventType = "ERROR"
+	ProgressAbort        ProgressEventType = "ABORT"
+	ProgressSuccess      ProgressEventType = "SUCCESS"
+	ProgressComplete     ProgressEventType = "COMPLETE"
+	ProgressNotification ProgressEventType = "NOTIFICATION"
+)
+
+var progressEventTypes = []ProgressEventType{
+	ProgressStart, ProgressProgress, ProgressError, ProgressAbort, ProgressSuccess, ProgressComplete, ProgressNotification,
+}
+
+const (
+	// progressNotificationType is the type of the JMX notifications of the StorageService
+	// reporting the progress of the repairs and other long running operations
+	progressNotificationType = "progress"
+	// progressPingInterval is how often the agent is told the notification client is in use
+	progressPingInterval = time.Minute
+)
+
+// ProgressEvent reports the progress of a long running operation, like a repair, which is
+// identified by its tag, like repair:1 for the repair command 1
+type ProgressEvent struct {
+	Tag      string            `json:"tag"`
+	Type     ProgressEventType `json:"type"`
+	Progress int64             `json:"progress"`
+	Total    int64             `json:"total"`
+	Message  string            `json:"message,omitempty"`
+	Time     time.Time         `json:"time"`
+}
+
+// parseProgressNotification builds the progress event of a notification, which holds the
+// ordinal of the event type and the progress counts in its user data
+func parseProgressNotification(notification jolokia.Notification) (ProgressEvent, error) {
+	if notification.Type != progressNotificationType {
+		return ProgressEvent{}, fmt.Errorf("Not a progress notification: %s", notification.Type)
+	}
+	data := struct {
+		Type          int   `json:"type"`
+		ProgressCount int64 `json:"progressCount"`
+		Total         int64 `json:"total"`
+	}{}
+	if err := json.Unmarshal(notification.UserData, &data); err != nil {
+		return ProgressEvent{}, fmt.Errorf("Invalid progress notification: %s", err)
+	}
+	if data.Type < 0 || data.Type >= len(progressEventTypes) {
+		return ProgressEvent{}, fmt.Errorf("Unknown progress event type %d", data.Type)
+	}
+	return ProgressEvent{
+		Tag:      fmt.Sprint(notification.Source),
+		Type:     progressEventTypes[data.Type],
+		Progress: data.ProgressCount,
+		Total:    data.Total,
+		Message:  notification.Message,
+		Time:     notification.Time(),
+	}, nil
+}
+
+// OperationProgress sums up the progress events of an operation
+type OperationProgress struct {
+	Tag       string    `json:"tag"`
+	Progress  int64     `json:"progress"`
+	Total     int64     `json:"total"`
+	Message   string    `json:"message,omitempty"`
+	Errors    []string  `json:"errors,omitempty"`
+	Finished  bool      `json:"finished"`
+	Failed    bool      `json:"failed"`
+	StartedAt time.Time `json:"started_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Apply updates the progress of the operation with one of its events. The operations
+// complete after they succeed or fail, so they are only finished once complete.
+func (p *OperationProgress) Apply(event ProgressEvent) {
+	if p.Tag == "" {
+		p.Tag = event.Tag
+		p.StartedAt = event.Time
+	}
+	p.UpdatedAt = event.Time
+	if event.Total > 0 {
+		p.Progress, p.Total = event.Progress, event.Total
+	}
+	if event.Message != "" {
+		p.Message = event.Message
+	}
+	switch event.Type {
+	case ProgressError, ProgressAbort:
+		p.Failed = true
+		p.Errors = append(p.Errors, event.Message)
+	case ProgressComplete:
+		p.Finished = true
+	}
+}
+
+// WatchProgress sends the progress events of the operations of the node, pulled from the
+// agent at the interval, until the context is done. The notification client is registered
+// again whenever the agent forgets it, like after a restart of the node.
+func (m *Manager) WatchProgress(ctx context.Context, interval time.Duration) (<-chan ProgressEvent, error) {
+	watcher := &progressWatcher{client: m.jolokiaClient}
+	if err := watcher.register(ctx); err != nil {
+		return nil, err
+	}
+	events := make(chan ProgressEvent, 64)
+	go watcher.run(ctx, interval, events)
+	return events, nil
+}
+
+type progressWatcher struct {
+	client   jolokia.Client
+	nc       *jolokia.NotificationClient
+	handle   string
+	lastPing time.Time
+}
+
+func (w *progressWatcher) register(ctx context.Context) error {
+	nc, err := w.client.RegisterNotifications(ctx)
+	if err != nil {
+		return err
+	}
+	handle, err := nc.AddListener(ctx, storageServicePath, progressNotificationType)
+	if err != nil {
+		nc.Unregister(ctx)
+		return err
+	}
+	w.nc, w.handle, w.lastPing = nc, handle, time.Now()
+	return nil
+}
+
+func (w *progressWatcher) run(ctx context.Context, interval time.Duration, events chan<- ProgressEvent) {
+	defer close(events)
+	defer func() {
+		if w.nc == nil {
+			return
+		}
+		// the context is done, but the client should still be removed from the agent
+		unregisterCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := w.nc.Unregister(unregisterCtx); err != nil {
+			logrus.Warnf("Could not unregister the notification client %s: %s", w.nc.ID(), err)
+		}
+	}()
+
+	for {
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return
+		}
+		if w.nc == nil {
+			if err := w.register(ctx); err != nil {
+				logrus.Warnf("Could not register for the progress notifications: %s", err)
+				continue
+			}
+		}
+		notifications, err := w.pull(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			logrus.Warnf("Could not pull the progress notifications, registering again: %s", err)
+			w.nc = nil
+			continue
+		}
+		for _, notification := range notifications {
+			event, err := parseProgressNotification(notification)
+			if err != nil {
+				logrus.Warn(err)
+				continue
+			}
+			select {
+			case events <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+func (w *progressWatcher) pull(ctx context.Context) ([]jolokia.Notification, error) {
+	if time.Since(w.lastPing) >= progressPingInterval {
+		if err := w.nc.Ping(ctx); err != nil {
+			return nil, err
+		}
+		w.lastPing = time.Now()
+	}
+	result, err := w.nc.Pull(ctx, w.handle)
+	if err != nil {
+		return nil, err
+	}
+	if result.Dropped > 0 {
+		logrus.Warnf("The agent dropped %d progress notifications", result.Dropped)
+	}
+	return result.Notifications, nil
+}
@@ -0,0 +1,89 @@
+package cassandra
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/CrossEngage/CaOps/internal/cassandra/cassandratest"
+	"github.com/CrossEngage/CaOps/internal/jolokia"
+	"github.com/stretchr/testify/assert"
+)
+
+func progressNotification(tag string, eventType, progress, total int, message string) jolokia.Notification {
+	return jolokia.Notification{
+		TimeStamp: 1514808000000,
+		Type:      "progress",
+		Message:   message,
+		Source:    tag,
+		UserData:  []byte(fmt.Sprintf(`{"type": %d, "progressCount": %d, "total": %d}`, eventType, progress, total)),
+	}
+}
+
+func receiveEvent(t *testing.T, events <-chan ProgressEvent) ProgressEvent {
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for a progress event")
+	}
+	return ProgressEvent{}
+}
+
+func TestWatchProgress(t *testing.T) {
+	agent := cassandratest.NewFakeAgent()
+	defer agent.Close()
+	m, err := NewManager(agent.Config())
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events, err := m.WatchProgress(ctx, 10*time.Millisecond)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, agent.NotificationListeners())
+
+	agent.Notify(storageServicePath, progressNotification("repair:1", 0, 0, 10, "Starting repair command #1"))
+	agent.Notify(storageServicePath, jolokia.Notification{Type: "jmx.attribute.change"})
+	agent.Notify(storageServicePath, progressNotification("repair:1", 1, 4, 10, "Repair session 1 finished"))
+	assert.Equal(t, ProgressEvent{Tag: "repair:1", Type: ProgressStart, Progress: 0, Total: 10,
+		Message: "Starting repair command #1", Time: time.Unix(1514808000, 0)}, receiveEvent(t, events))
+	event := receiveEvent(t, events)
+	assert.Equal(t, ProgressProgress, event.Type)
+	assert.Equal(t, int64(4), event.Progress)
+
+	// an agent forgetting its clients is registered with again
+	agent.ResetNotifications()
+	assert.Equal(t, 0, agent.NotificationListeners())
+	deadline := time.Now().Add(5 * time.Second)
+	for agent.NotificationListeners() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	agent.Notify(storageServicePath, progressNotification("repair:1", 4, 10, 10, "Repair completed successfully"))
+	assert.Equal(t, ProgressSuccess, receiveEvent(t, events).Type)
+
+	cancel()
+	for range events {
+	}
+	assert.Equal(t, 0, agent.NotificationListeners())
+}
+
+func TestOperationProgress(t *testing.T) {
+	start := time.Date(2018, 1, 1, 12, 0, 0, 0, time.UTC)
+	progress := &OperationProgress{}
+	progress.Apply(ProgressEvent{Tag: "repair:2", Type: ProgressStart, Total: 2, Message: "Starting", Time: start})
+	progress.Apply(ProgressEvent{Tag: "repair:2", Type: ProgressProgress, Progress: 1, Total: 2, Message: "Session 1 finished", Time: start.Add(time.Minute)})
+	assert.False(t, progress.Finished)
+	progress.Apply(ProgressEvent{Tag: "repair:2", Type: ProgressError, Progress: 2, Total: 2, Message: "Session 2 failed", Time: start.Add(2 * time.Minute)})
+	progress.Apply(ProgressEvent{Tag: "repair:2", Type: ProgressComplete, Message: "Repair command #2 finished", Time: start.Add(3 * time.Minute)})
+	assert.Equal(t, &OperationProgress{
+		Tag:       "repair:2",
+		Progress:  2,
+		Total:     2,
+		Message:   "Repair command #2 finished",
+		Errors:    []string{"Session 2 failed"},
+		Finished:  true,
+		Failed:    true,
+		StartedAt: start,
+		UpdatedAt: start.Add(3 * time.Minute),
+	}, progress)
+}
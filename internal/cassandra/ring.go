@@ -0,0 +1,211 @@
+package cassandra
+
+import (
+	"context"
+	"math/big"
+	"sort"
+
+	"github.com/CrossEngage/CaOps/internal/jolokia"
+)
+
+const endpointSnitchInfoPath = "org.apache.cassandra.db:type=EndpointSnitchInfo"
+
+// Endpoint states, as seen by the failure detector and the token metadata of the node
+const (
+	EndpointUp      = "Up"
+	EndpointDown    = "Down"
+	EndpointNormal  = "Normal"
+	EndpointJoining = "Joining"
+	EndpointLeaving = "Leaving"
+	EndpointMoving  = "Moving"
+)
+
+// Endpoint describes a node of the cluster, as seen by the node the manager talks to
+type Endpoint struct {
+	Address    string   `json:"address"`
+	Datacenter string   `json:"datacenter"`
+	Rack       string   `json:"rack"`
+	Status     string   `json:"status"`
+	State      string   `json:"state"`
+	Load       string   `json:"load"`
+	HostID     string   `json:"host_id"`
+	Tokens     []string `json:"tokens"`
+	// Ownership is the fraction of the ring owned by the node, effective for the keyspace if
+	// one was given, or nil when unknown
+	Ownership *float64 `json:"ownership"`
+}
+
+// Endpoints returns all the nodes of the cluster, sorted by datacenter, rack and address,
+// like nodetool status. The ownership is effective for the keyspace when one is given.
+func (m *Manager) Endpoints(ctx context.Context, keyspace string) ([]*Endpoint, error) {
+	var live, unreachable, joining, leaving, moving []string
+	var tokens, load, hostIDs map[string]string
+	var ownership map[string]float64
+	ss := m.storageService
+	batch := jolokia.NewBatch()
+	results := []*jolokia.BatchResult{
+		ss.batchRead(batch, &live, "LiveNodes"),
+		ss.batchRead(batch, &unreachable, "UnreachableNodes"),
+		ss.batchRead(batch, &joining, "JoiningNodes"),
+		ss.batchRead(batch, &leaving, "LeavingNodes"),
+		ss.batchRead(batch, &moving, "MovingNodes"),
+		ss.batchRead(batch, &tokens, "TokenToEndpointMap"),
+		ss.batchRead(batch, &load, "LoadMap"),
+		ss.batchRead(batch, &hostIDs, "EndpointToHostId"),
+	}
+	var ownershipResult *jolokia.BatchResult
+	if keyspace != "" {
+		ownershipResult = batch.Exec(&ownership, storageServicePath, "effectiveOwnership", keyspace)
+	} else {
+		ownershipResult = ss.batchRead(batch, &ownership, "Ownership")
+	}
+	if err := m.SendBatch(ctx, batch); err != nil {
+		return nil, err
+	}
+	if err := firstErr(results...); err != nil {
+		return nil, err
+	}
+	// without a keyspace, the ownership is unknown when the keyspaces are replicated differently
+	if keyspace != "" {
+		if err := ownershipResult.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	byAddress := make(map[string]*Endpoint)
+	endpoint := func(address string) *Endpoint {
+		if e, ok := byAddress[address]; ok {
+			return e
+		}
+		e := &Endpoint{Address: address, Status: EndpointDown, State: EndpointNormal, Tokens: make([]string, 0)}
+		byAddress[address] = e
+		return e
+	}
+	for token, address := range tokens {
+		e := endpoint(address)
+		e.Tokens = append(e.Tokens, token)
+	}
+	for _, address := range unreachable {
+		endpoint(address).Status = EndpointDown
+	}
+	for _, address := range live {
+		endpoint(address).Status = EndpointUp
+	}
+	for state, addresses := range map[string][]string{EndpointJoining: joining, EndpointLeaving: leaving, EndpointMoving: moving} {
+		for _, address := range addresses {
+			endpoint(address).State = state
+		}
+	}
+	for address, l := range load {
+		if e, ok := byAddress[address]; ok {
+			e.Load = l
+		}
+	}
+	for address, hostID := range hostIDs {
+		if e, ok := byAddress[address]; ok {
+			e.HostID = hostID
+		}
+	}
+	for address, owns := range ownership {
+		if e, ok := byAddress[endpointAddress(address)]; ok {
+			owns := owns
+			e.Ownership = &owns
+		}
+	}
+
+	endpoints := make([]*Endpoint, 0, len(byAddress))
+	for _, e := range byAddress {
+		sortTokens(e.Tokens)
+		endpoints = append(endpoints, e)
+	}
+	if err := m.locateEndpoints(ctx, endpoints); err != nil {
+		return nil, err
+	}
+	sort.Slice(endpoints, func(i, j int) bool {
+		a, b := endpoints[i], endpoints[j]
+		if a.Datacenter != b.Datacenter {
+			return a.Datacenter < b.Datacenter
+		}
+		if a.Rack != b.Rack {
+			return a.Rack < b.Rack
+		}
+		return a.Address < b.Address
+	})
+	return endpoints, nil
+}
+
+// locateEndpoints sets the datacenter and rack of the endpoints, as told by the snitch
+func (m *Manager) locateEndpoints(ctx context.Context, endpoints []*Endpoint) error {
+	if len(endpoints) == 0 {
+		return nil
+	}
+	batch := jolokia.NewBatch()
+	results := make([]*jolokia.BatchResult, 0, 2*len(endpoints))
+	for _, e := range endpoints {
+		results = append(results,
+			batch.Exec(&e.Datacenter, endpointSnitchInfoPath, "getDatacenter", e.Address),
+			batch.Exec(&e.Rack, endpointSnitchInfoPath, "getRack", e.Address))
+	}
+	if err := m.SendBatch(ctx, batch); err != nil {
+		return err
+	}
+	return firstErr(results...)
+}
+
+// RingToken is a token of the ring, with the node owning it
+type RingToken struct {
+	Token      string   `json:"token"`
+	Address    string   `json:"address"`
+	Datacenter string   `json:"datacenter"`
+	Rack       string   `json:"rack"`
+	Status     string   `json:"status"`
+	State      string   `json:"state"`
+	Load       string   `json:"load"`
+	Ownership  *float64 `json:"ownership"`
+}
+
+// Ring returns all the tokens of the ring, sorted, like nodetool ring
+func (m *Manager) Ring(ctx context.Context, keyspace string) ([]RingToken, error) {
+	endpoints, err := m.Endpoints(ctx, keyspace)
+	if err != nil {
+		return nil, err
+	}
+	ring := make([]RingToken, 0)
+	for _, e := range endpoints {
+		for _, token := range e.Tokens {
+			ring = append(ring, RingToken{
+				Token:      token,
+				Address:    e.Address,
+				Datacenter: e.Datacenter,
+				Rack:       e.Rack,
+				Status:     e.Status,
+				State:      e.State,
+				Load:       e.Load,
+				Ownership:  e.Ownership,
+			})
+		}
+	}
+	sort.SliceStable(ring, func(i, j int) bool { return compareTokens(ring[i].Token, ring[j].Token) < 0 })
+	return ring, nil
+}
+
+// sortTokens sorts tokens by their numeric value, as the Murmur3 and Random partitioners use
+// numeric tokens, which can be negative, or larger than 64 bits
+func sortTokens(tokens []string) {
+	sort.Slice(tokens, func(i, j int) bool { return compareTokens(tokens[i], tokens[j]) < 0 })
+}
+
+func compareTokens(a, b string) int {
+	x, okA := new(big.Int).SetString(a, 10)
+	y, okB := new(big.Int).SetString(b, 10)
+	if okA && okB {
+		return x.Cmp(y)
+	}
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,95 @@
+package cassandra
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/CrossEngage/CaOps/internal/cassandra/cassandratest"
+	"github.com/stretchr/testify/assert"
+)
+
+// setRing sets up a cluster of two datacenters, where 127.0.0.3 is down and 127.0.0.4 leaving
+func setRing(agent *cassandratest.FakeAgent) {
+	ss := cassandratest.StorageService
+	agent.SetAttribute(ss, "LiveNodes", []string{"127.0.0.1", "127.0.0.2", "127.0.0.4"})
+	agent.SetAttribute(ss, "UnreachableNodes", []string{"127.0.0.3"})
+	agent.SetAttribute(ss, "LeavingNodes", []string{"127.0.0.4"})
+	agent.SetAttribute(ss, "TokenToEndpointMap", map[string]string{
+		"-9223372036854775808": "127.0.0.1",
+		"-100":                 "127.0.0.2",
+		"20":                   "127.0.0.3",
+		"100":                  "127.0.0.1",
+		"9000000000000000000":  "127.0.0.4",
+	})
+	agent.SetAttribute(ss, "LoadMap", map[string]string{"127.0.0.1": "1 MiB", "127.0.0.2": "2 MiB"})
+	agent.SetAttribute(ss, "Ownership", map[string]float64{"/127.0.0.1": 0.5, "/127.0.0.2": 0.5})
+	agent.SetOperation(ss, "effectiveOwnership", func(args []interface{}) (interface{}, error) {
+		if args[0] != "ks1" {
+			return nil, fmt.Errorf("Keyspace %s does not exist", args[0])
+		}
+		return map[string]float64{"/127.0.0.1": 1, "/127.0.0.2": 1, "/127.0.0.3": 1, "/127.0.0.4": 1}, nil
+	})
+	datacenters := map[string]string{"127.0.0.1": "dc2", "127.0.0.2": "dc1", "127.0.0.3": "dc1", "127.0.0.4": "dc2"}
+	agent.SetOperation(endpointSnitchInfoPath, "getDatacenter", func(args []interface{}) (interface{}, error) {
+		return datacenters[fmt.Sprint(args[0])], nil
+	})
+	agent.SetOperation(endpointSnitchInfoPath, "getRack", func(args []interface{}) (interface{}, error) {
+		return "rack1", nil
+	})
+}
+
+func TestEndpoints(t *testing.T) {
+	manager, agent := newFakeManager(t)
+	defer agent.Close()
+	setRing(agent)
+
+	endpoints, err := manager.Endpoints(context.Background(), "")
+	assert.Nil(t, err)
+	if !assert.Len(t, endpoints, 4) {
+		return
+	}
+	half := 0.5
+	assert.Equal(t, &Endpoint{
+		Address: "127.0.0.2", Datacenter: "dc1", Rack: "rack1", Status: EndpointUp, State: EndpointNormal,
+		Load: "2 MiB", Tokens: []string{"-100"}, Ownership: &half,
+	}, endpoints[0])
+	assert.Equal(t, "127.0.0.3", endpoints[1].Address)
+	assert.Equal(t, EndpointDown, endpoints[1].Status)
+	assert.Nil(t, endpoints[1].Ownership)
+	assert.Equal(t, "127.0.0.1", endpoints[2].Address)
+	assert.Equal(t, []string{"-9223372036854775808", "100"}, endpoints[2].Tokens)
+	assert.Equal(t, "f3b9b3c5-3fc4-4b5c-9e3a-0d5a7e0c3a71", endpoints[2].HostID)
+	assert.Equal(t, "127.0.0.4", endpoints[3].Address)
+	assert.Equal(t, EndpointLeaving, endpoints[3].State)
+
+	endpoints, err = manager.Endpoints(context.Background(), "ks1")
+	assert.Nil(t, err)
+	for _, e := range endpoints {
+		if assert.NotNil(t, e.Ownership, e.Address) {
+			assert.Equal(t, 1.0, *e.Ownership)
+		}
+	}
+	_, err = manager.Endpoints(context.Background(), "unknown")
+	assert.NotNil(t, err)
+}
+
+func TestRing(t *testing.T) {
+	manager, agent := newFakeManager(t)
+	defer agent.Close()
+	setRing(agent)
+
+	ring, err := manager.Ring(context.Background(), "")
+	assert.Nil(t, err)
+	tokens := make([]string, 0, len(ring))
+	for _, token := range ring {
+		tokens = append(tokens, token.Token+"@"+token.Address)
+	}
+	assert.Equal(t, []string{
+		"-9223372036854775808@127.0.0.1",
+		"-100@127.0.0.2",
+		"20@127.0.0.3",
+		"100@127.0.0.1",
+		"9000000000000000000@127.0.0.4",
+	}, tokens)
+}
@@ -1,6 +1,7 @@
 package cassandra
 
 import (
+	"context"
 	"fmt"
 	"time"
 
@@ -9,19 +10,19 @@ import (
 )
 
 // SnapshotKeyspaces triggers a snapshot for the given list of keyspaces, and returns a generated tag
-func (m *Manager) SnapshotKeyspaces(keyspaces []string) (snapshotPaths []string, tag string, err error) {
+func (m *Manager) SnapshotKeyspaces(ctx context.Context, keyspaces []string) (snapshotPaths []string, tag string, err error) {
 	tag = m.genSnapshotName()
-	if err = m.storageService.TakeSnapshot(tag, keyspaces...); err != nil {
+	if err = m.storageService.TakeSnapshot(ctx, tag, keyspaces...); err != nil {
 		return
 	}
 
-	dataDirs, err := m.AllDataFileLocations()
+	dataDirs, err := m.AllDataFileLocations(ctx)
 	if err != nil {
 		return
 	}
 	logrus.Debug(dataDirs)
 
-	details, err := m.storageService.SnapshotDetails()
+	details, err := m.storageService.SnapshotDetails(ctx)
 	if err != nil {
 		return
 	}
@@ -35,21 +36,29 @@ func (m *Manager) SnapshotKeyspaces(keyspaces []string) (snapshotPaths []string,
 }
 
 // SnapshotTable triggers a snapshot for the specified keyspace and table, and returns a generated tag
-func (m *Manager) SnapshotTable(keyspace, table string) (tag string, err error) {
+func (m *Manager) SnapshotTable(ctx context.Context, keyspace, table string) (tag string, err error) {
 	tag = m.genSnapshotName()
-	return tag, m.storageService.TakeTableSnapshot(tag, keyspace, table)
+	return tag, m.storageService.TakeTableSnapshot(ctx, tag, keyspace, table)
 }
 
 // SnapshotTables triggers a snapshot for the specified keyspace.table combinations, and returns a generated tag
-func (m *Manager) SnapshotTables(tables []string) (tag string, err error) {
+func (m *Manager) SnapshotTables(ctx context.Context, tables []string) (tag string, err error) {
 	tag = m.genSnapshotName()
-	return tag, m.storageService.TakeMultipleTableSnapshot(tag, tables...)
+	return tag, m.storageService.TakeMultipleTableSnapshot(ctx, tag, tables...)
+}
+
+// TakeSnapshot takes a snapshot of the keyspaces, or all of them when none is given, with the tag
+func (m *Manager) TakeSnapshot(ctx context.Context, tag string, keyspaces ...string) error {
+	if keyspaces == nil {
+		keyspaces = []string{}
+	}
+	return m.storageService.TakeSnapshot(ctx, tag, keyspaces...)
 }
 
 // MatchKeyspaces returns a list of keyspace names that matches the glob
-func (m *Manager) MatchKeyspaces(keyspaceGlob string) ([]string, error) {
+func (m *Manager) MatchKeyspaces(ctx context.Context, keyspaceGlob string) ([]string, error) {
 	kg := glob.MustCompile(keyspaceGlob)
-	allKeyspaces, err := m.storageService.Keyspaces()
+	allKeyspaces, err := m.storageService.Keyspaces(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -67,6 +76,6 @@ func (m *Manager) genSnapshotName() string {
 }
 
 // ClearSnapshot is similar to nodetool clearsnapshot
-func (m *Manager) ClearSnapshot() error {
-	return m.storageService.ClearSnapshot("")
+func (m *Manager) ClearSnapshot(ctx context.Context) error {
+	return m.storageService.ClearSnapshot(ctx, "")
 }
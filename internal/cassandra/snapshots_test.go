@@ -0,0 +1,71 @@
+package cassandra
+
+import (
+	"context"
+	"testing"
+
+	"github.com/CrossEngage/CaOps/internal/cassandra/cassandratest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchKeyspaces(t *testing.T) {
+	manager, agent := newFakeManager(t)
+	defer agent.Close()
+	agent.AddTable("ks1", "users")
+	agent.AddTable("ks2", "users")
+
+	keyspaces, err := manager.MatchKeyspaces(context.Background(), "ks*")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"ks1", "ks2"}, keyspaces)
+	keyspaces, err = manager.MatchKeyspaces(context.Background(), "system_*")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"system_auth"}, keyspaces)
+}
+
+func TestSnapshotsAndDetails(t *testing.T) {
+	manager, agent := newFakeManager(t)
+	defer agent.Close()
+	agent.AddTable("ks1", "users")
+	agent.AddTable("ks1", "events")
+	agent.AddTable("ks2", "orders")
+	ctx := context.Background()
+
+	_, tag, err := manager.SnapshotKeyspaces(ctx, []string{"ks1"})
+	assert.Nil(t, err)
+	tableTag, err := manager.SnapshotTable(ctx, "ks2", "orders")
+	assert.Nil(t, err)
+	assert.Equal(t, []cassandratest.Snapshot{
+		{Tag: tag, Keyspace: "ks1", Table: "events"},
+		{Tag: tag, Keyspace: "ks1", Table: "users"},
+		{Tag: tableTag, Keyspace: "ks2", Table: "orders"},
+	}, agent.Snapshots())
+
+	details, err := manager.storageService.SnapshotDetails(ctx)
+	assert.Nil(t, err)
+	assert.Len(t, details.Value, 3)
+	assert.Contains(t, details.Value, TableSnapshot{
+		SnapshotName: tableTag, Keyspace: "ks2", Table: "orders", SizeOnDisk: "1024 bytes", TrueSize: "1024 bytes",
+	})
+
+	size, err := manager.storageService.AllSnapshotsSize(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(3*1024), size)
+
+	assert.Nil(t, manager.ClearSnapshot(ctx))
+	assert.Empty(t, agent.Snapshots())
+	assert.Len(t, agent.Executed(cassandratest.StorageService, "clearSnapshot"), 1)
+}
+
+func TestSnapshotFailures(t *testing.T) {
+	manager, agent := newFakeManager(t)
+	defer agent.Close()
+	ctx := context.Background()
+
+	_, err := manager.SnapshotTable(ctx, "system", "missing")
+	assert.NotNil(t, err)
+
+	agent.Fail(cassandratest.StorageService, "takeSnapshot", cassandratest.Failure{Message: "disk full"})
+	_, _, err = manager.SnapshotKeyspaces(ctx, []string{"system"})
+	assert.EqualError(t, err, "javax.management.MBeanException : disk full")
+	assert.Empty(t, agent.Snapshots())
+}
@@ -0,0 +1,97 @@
+package cassandra
+
+import (
+	"context"
+
+	"github.com/CrossEngage/CaOps/internal/jolokia"
+)
+
+// StringResult is a string attribute, with the error got while reading it
+type StringResult struct {
+	Value  string
+	result *jolokia.BatchResult
+}
+
+// Err returns the error got while reading the attribute
+func (r *StringResult) Err() error {
+	return r.result.Err()
+}
+
+// StringListResult is a string list attribute, with the error got while reading it
+type StringListResult struct {
+	Value  []string
+	result *jolokia.BatchResult
+}
+
+// Err returns the error got while reading the attribute
+func (r *StringListResult) Err() error {
+	return r.result.Err()
+}
+
+// BoolResult is a boolean attribute, with the error got while reading it
+type BoolResult struct {
+	Value  bool
+	result *jolokia.BatchResult
+}
+
+// Err returns the error got while reading the attribute
+func (r *BoolResult) Err() error {
+	return r.result.Err()
+}
+
+// NodeStatus holds the attributes describing the node, and the cluster as seen by it
+type NodeStatus struct {
+	JolokiaAgentVersion       StringResult
+	ReleaseVersion            StringResult
+	SchemaVersion             StringResult
+	AllDataFileLocations      StringListResult
+	CommitLogLocation         StringResult
+	SavedCachesLocation       StringResult
+	LocalHostID               StringResult
+	PartitionerName           StringResult
+	OperationMode             StringResult
+	IncrementalBackupsEnabled BoolResult
+
+	ClusterName        StringResult
+	LiveNodes          StringListResult
+	UnreachableNodes   StringListResult
+	JoiningNodes       StringListResult
+	LeavingNodes       StringListResult
+	MovingNodes        StringListResult
+	Keyspaces          StringListResult
+	NonSystemKeyspaces StringListResult
+}
+
+// NodeStatus reads all the attributes of the status in a single Jolokia request. Each
+// attribute has its own error, so an attribute failing to be read does not fail the others.
+func (m *Manager) NodeStatus(ctx context.Context) *NodeStatus {
+	s := &NodeStatus{}
+	batch := jolokia.NewBatch()
+	agentVersion := &jolokia.VersionResponseValue{}
+	s.JolokiaAgentVersion.result = batch.Version(agentVersion)
+
+	ss := m.storageService
+	s.ReleaseVersion.result = ss.batchRead(batch, &s.ReleaseVersion.Value, "ReleaseVersion")
+	s.SchemaVersion.result = ss.batchRead(batch, &s.SchemaVersion.Value, "SchemaVersion")
+	s.AllDataFileLocations.result = ss.batchRead(batch, &s.AllDataFileLocations.Value, "AllDataFileLocations")
+	s.CommitLogLocation.result = ss.batchRead(batch, &s.CommitLogLocation.Value, "CommitLogLocation")
+	s.SavedCachesLocation.result = ss.batchRead(batch, &s.SavedCachesLocation.Value, "SavedCachesLocation")
+	s.LocalHostID.result = ss.batchRead(batch, &s.LocalHostID.Value, "LocalHostId")
+	s.PartitionerName.result = ss.batchRead(batch, &s.PartitionerName.Value, "PartitionerName")
+	s.OperationMode.result = ss.batchRead(batch, &s.OperationMode.Value, "OperationMode")
+	s.IncrementalBackupsEnabled.result = ss.batchRead(batch, &s.IncrementalBackupsEnabled.Value, "IncrementalBackupsEnabled")
+
+	s.ClusterName.result = ss.batchRead(batch, &s.ClusterName.Value, "ClusterName")
+	s.LiveNodes.result = ss.batchRead(batch, &s.LiveNodes.Value, "LiveNodes")
+	s.UnreachableNodes.result = ss.batchRead(batch, &s.UnreachableNodes.Value, "UnreachableNodes")
+	s.JoiningNodes.result = ss.batchRead(batch, &s.JoiningNodes.Value, "JoiningNodes")
+	s.LeavingNodes.result = ss.batchRead(batch, &s.LeavingNodes.Value, "LeavingNodes")
+	s.MovingNodes.result = ss.batchRead(batch, &s.MovingNodes.Value, "MovingNodes")
+	s.Keyspaces.result = ss.batchRead(batch, &s.Keyspaces.Value, "Keyspaces")
+	s.NonSystemKeyspaces.result = ss.batchRead(batch, &s.NonSystemKeyspaces.Value, "NonSystemKeyspaces")
+
+	// the errors are kept by each attribute
+	m.SendBatch(ctx, batch)
+	s.JolokiaAgentVersion.Value = agentVersion.Agent
+	return s
+}
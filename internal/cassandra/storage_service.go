@@ -1,7 +1,7 @@
 package cassandra
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"io"
 
@@ -20,10 +20,15 @@ const (
 	storageServicePath = "org.apache.cassandra.db:type=StorageService"
 )
 
+// batchRead adds the read of an attribute to a batch of requests
+func (ss storageService) batchRead(batch *jolokia.Batch, target interface{}, attribute string) *jolokia.BatchResult {
+	return batch.Read(target, storageServicePath, attribute)
+}
+
 // LiveNodes retrieve the list of live nodes in the cluster, where "liveness"
 // is determined by the failure detector of the node being queried.
-func (ss storageService) LiveNodes() (ips []string, err error) {
-	resp, err := ss.jolokiaClient.ReadStringList(storageServicePath + "/LiveNodes")
+func (ss storageService) LiveNodes(ctx context.Context) (ips []string, err error) {
+	resp, err := ss.jolokiaClient.ReadStringList(ctx, storageServicePath, "LiveNodes")
 	if err != nil {
 		return nil, err
 	}
@@ -32,8 +37,8 @@ func (ss storageService) LiveNodes() (ips []string, err error) {
 
 // UnreachableNodes retrieve the list of unreachable nodes in the cluster, as
 // determined by this node's failure detector.
-func (ss storageService) UnreachableNodes() (ips []string, err error) {
-	resp, err := ss.jolokiaClient.ReadStringList(storageServicePath + "/UnreachableNodes")
+func (ss storageService) UnreachableNodes(ctx context.Context) (ips []string, err error) {
+	resp, err := ss.jolokiaClient.ReadStringList(ctx, storageServicePath, "UnreachableNodes")
 	if err != nil {
 		return nil, err
 	}
@@ -41,8 +46,8 @@ func (ss storageService) UnreachableNodes() (ips []string, err error) {
 }
 
 // JoiningNodes retrieve the list of nodes currently bootstrapping into the ring.
-func (ss storageService) JoiningNodes() (ips []string, err error) {
-	resp, err := ss.jolokiaClient.ReadStringList(storageServicePath + "/JoiningNodes")
+func (ss storageService) JoiningNodes(ctx context.Context) (ips []string, err error) {
+	resp, err := ss.jolokiaClient.ReadStringList(ctx, storageServicePath, "JoiningNodes")
 	if err != nil {
 		return nil, err
 	}
@@ -50,8 +55,8 @@ func (ss storageService) JoiningNodes() (ips []string, err error) {
 }
 
 // LeavingNodes retrieve the list of nodes currently leaving the ring.
-func (ss storageService) LeavingNodes() (ips []string, err error) {
-	resp, err := ss.jolokiaClient.ReadStringList(storageServicePath + "/LeavingNodes")
+func (ss storageService) LeavingNodes(ctx context.Context) (ips []string, err error) {
+	resp, err := ss.jolokiaClient.ReadStringList(ctx, storageServicePath, "LeavingNodes")
 	if err != nil {
 		return nil, err
 	}
@@ -59,8 +64,8 @@ func (ss storageService) LeavingNodes() (ips []string, err error) {
 }
 
 // MovingNodes retrieve the list of nodes currently moving in the ring.
-func (ss storageService) MovingNodes() (ips []string, err error) {
-	resp, err := ss.jolokiaClient.ReadStringList(storageServicePath + "/MovingNodes")
+func (ss storageService) MovingNodes(ctx context.Context) (ips []string, err error) {
+	resp, err := ss.jolokiaClient.ReadStringList(ctx, storageServicePath, "MovingNodes")
 	if err != nil {
 		return nil, err
 	}
@@ -68,8 +73,8 @@ func (ss storageService) MovingNodes() (ips []string, err error) {
 }
 
 // Tokens fetch string representations of the tokens for this node.
-func (ss storageService) Tokens() ([]string, error) {
-	resp, err := ss.jolokiaClient.ReadStringList(storageServicePath + "/Tokens")
+func (ss storageService) Tokens(ctx context.Context) ([]string, error) {
+	resp, err := ss.jolokiaClient.ReadStringList(ctx, storageServicePath, "Tokens")
 	if err != nil {
 		return nil, err
 	}
@@ -77,8 +82,8 @@ func (ss storageService) Tokens() ([]string, error) {
 }
 
 // ReleaseVersion fetch a string representation of the Cassandra version.
-func (ss storageService) ReleaseVersion() (version string, err error) {
-	resp, err := ss.jolokiaClient.ReadString(storageServicePath + "/ReleaseVersion")
+func (ss storageService) ReleaseVersion(ctx context.Context) (version string, err error) {
+	resp, err := ss.jolokiaClient.ReadString(ctx, storageServicePath, "ReleaseVersion")
 	if err != nil {
 		return "", err
 	}
@@ -86,8 +91,8 @@ func (ss storageService) ReleaseVersion() (version string, err error) {
 }
 
 // SchemaVersion fetch a string representation of the current Schema version.
-func (ss storageService) SchemaVersion() (version string, err error) {
-	resp, err := ss.jolokiaClient.ReadString(storageServicePath + "/SchemaVersion")
+func (ss storageService) SchemaVersion(ctx context.Context) (version string, err error) {
+	resp, err := ss.jolokiaClient.ReadString(ctx, storageServicePath, "SchemaVersion")
 	if err != nil {
 		return "", err
 	}
@@ -95,8 +100,8 @@ func (ss storageService) SchemaVersion() (version string, err error) {
 }
 
 // AllDataFileLocations returns the list of all data file locations from conf
-func (ss storageService) AllDataFileLocations() (paths []string, err error) {
-	resp, err := ss.jolokiaClient.ReadStringList(storageServicePath + "/AllDataFileLocations")
+func (ss storageService) AllDataFileLocations(ctx context.Context) (paths []string, err error) {
+	resp, err := ss.jolokiaClient.ReadStringList(ctx, storageServicePath, "AllDataFileLocations")
 	if err != nil {
 		return nil, err
 	}
@@ -104,8 +109,8 @@ func (ss storageService) AllDataFileLocations() (paths []string, err error) {
 }
 
 // CommitLogLocation returns the location of the commit log
-func (ss storageService) CommitLogLocation() (string, error) {
-	resp, err := ss.jolokiaClient.ReadString(storageServicePath + "/CommitLogLocation")
+func (ss storageService) CommitLogLocation(ctx context.Context) (string, error) {
+	resp, err := ss.jolokiaClient.ReadString(ctx, storageServicePath, "CommitLogLocation")
 	if err != nil {
 		return "", err
 	}
@@ -113,32 +118,75 @@ func (ss storageService) CommitLogLocation() (string, error) {
 }
 
 // SavedCachesLocation returns the location of the saved caches dir
-func (ss storageService) SavedCachesLocation() (string, error) {
-	resp, err := ss.jolokiaClient.ReadString(storageServicePath + "/SavedCachesLocation")
+func (ss storageService) SavedCachesLocation(ctx context.Context) (string, error) {
+	resp, err := ss.jolokiaClient.ReadString(ctx, storageServicePath, "SavedCachesLocation")
 	if err != nil {
 		return "", err
 	}
 	return resp.Value, nil
 }
 
-// // Retrieve a map of range to end points that describe the ring topology
-// // of a Cassandra cluster.
-// // Map<List<String>, List<String>>
-// func (ss storageService) RangeToEndpointMap(keyspace string) map[[]string][]string {}
+// withoutTimeout returns a copy of the storage service without the timeout of the Jolokia
+// requests, for the operations which last longer, like drains, cleanups or decommissions,
+// which are then only limited by their context
+func (ss storageService) withoutTimeout() storageService {
+	return storageService{ss.jolokiaClient.WithTimeout(0)}
+}
 
-// // Retrieve a map of range to rpc addresses that describe the ring topology
-// // of a Cassandra cluster.
-// func (ss storageService) RangeToRpcaddressMap(keyspace string) map[[]string][]string {}
+// exec executes an operation of the storage service, decoding its return value into the
+// target, unless it is nil
+func (ss storageService) exec(ctx context.Context, target interface{}, operation string, args ...interface{}) error {
+	batch := jolokia.NewBatch()
+	result := batch.Exec(target, storageServicePath, operation, args...)
+	if err := ss.jolokiaClient.SendBatch(ctx, batch); err != nil {
+		return err
+	}
+	return result.Err()
+}
 
-// // The TokenRange for a given keyspace.
-// func (ss storageService) DescribeRingJMX(keyspace string) (tokenRange []string) {}
+// RangeToEndpointMap retrieve the ranges of the ring of the keyspace, with the addresses of
+// their replicas, sorted by token
+func (ss storageService) RangeToEndpointMap(ctx context.Context, keyspace string) ([]TokenRange, error) {
+	rangeMap := make(map[string][]string)
+	if err := ss.exec(ctx, &rangeMap, "getRangeToEndpointMap", keyspace); err != nil {
+		return nil, err
+	}
+	return parseRangeMap(rangeMap, false)
+}
 
-// // Retrieve a map of pending ranges to endpoints that describe the ring topology
-// func (ss storageService) PendingRangeToEndpointMap(keyspace string) map[[]string][]string {}
+// RangeToRpcaddressMap retrieve the ranges of the ring of the keyspace, with the RPC addresses
+// of their replicas, sorted by token
+func (ss storageService) RangeToRpcaddressMap(ctx context.Context, keyspace string) ([]TokenRange, error) {
+	rangeMap := make(map[string][]string)
+	if err := ss.exec(ctx, &rangeMap, "getRangeToRpcaddressMap", keyspace); err != nil {
+		return nil, err
+	}
+	return parseRangeMap(rangeMap, true)
+}
+
+// DescribeRingJMX retrieve the ranges of the ring of the keyspace, with the addresses, RPC
+// addresses, datacenters and racks of their replicas, sorted by token
+func (ss storageService) DescribeRingJMX(ctx context.Context, keyspace string) ([]TokenRange, error) {
+	var ring []string
+	if err := ss.exec(ctx, &ring, "describeRingJMX", keyspace); err != nil {
+		return nil, err
+	}
+	return parseTokenRanges(ring)
+}
+
+// PendingRangeToEndpointMap retrieve the ranges being streamed to joining, leaving or moving
+// nodes, with the addresses of their future replicas, sorted by token
+func (ss storageService) PendingRangeToEndpointMap(ctx context.Context, keyspace string) ([]TokenRange, error) {
+	rangeMap := make(map[string][]string)
+	if err := ss.exec(ctx, &rangeMap, "getPendingRangeToEndpointMap", keyspace); err != nil {
+		return nil, err
+	}
+	return parseRangeMap(rangeMap, false)
+}
 
 // TokenToEndpointMap retrieve a map of tokens to endpoints, including the bootstrapping ones.
-func (ss storageService) TokenToEndpointMap() (map[string]string, error) {
-	resp, err := ss.jolokiaClient.ReadStringMapString(storageServicePath + "/TokenToEndpointMap")
+func (ss storageService) TokenToEndpointMap(ctx context.Context) (map[string]string, error) {
+	resp, err := ss.jolokiaClient.ReadStringMapString(ctx, storageServicePath, "TokenToEndpointMap")
 	if err != nil {
 		return nil, err
 	}
@@ -146,8 +194,8 @@ func (ss storageService) TokenToEndpointMap() (map[string]string, error) {
 }
 
 // LocalHostID returns the hosts unique ID
-func (ss storageService) LocalHostID() (string, error) {
-	resp, err := ss.jolokiaClient.ReadString(storageServicePath + "/LocalHostId")
+func (ss storageService) LocalHostID(ctx context.Context) (string, error) {
+	resp, err := ss.jolokiaClient.ReadString(ctx, storageServicePath, "LocalHostId")
 	if err != nil {
 		return "", err
 	}
@@ -155,8 +203,8 @@ func (ss storageService) LocalHostID() (string, error) {
 }
 
 // EndpointToHostID retrieve the mapping of endpoint to host ID
-func (ss storageService) EndpointToHostID() (map[string]string, error) {
-	resp, err := ss.jolokiaClient.ReadStringMapString(storageServicePath + "/EndpointToHostId")
+func (ss storageService) EndpointToHostID(ctx context.Context) (map[string]string, error) {
+	resp, err := ss.jolokiaClient.ReadStringMapString(ctx, storageServicePath, "EndpointToHostId")
 	if err != nil {
 		return nil, err
 	}
@@ -164,8 +212,8 @@ func (ss storageService) EndpointToHostID() (map[string]string, error) {
 }
 
 // HostIDToEndpoint retrieve the mapping of host ID to endpoint
-func (ss storageService) HostIDToEndpoint() (map[string]string, error) {
-	resp, err := ss.jolokiaClient.ReadStringMapString(storageServicePath + "/HostIdToEndpoint")
+func (ss storageService) HostIDToEndpoint(ctx context.Context) (map[string]string, error) {
+	resp, err := ss.jolokiaClient.ReadStringMapString(ctx, storageServicePath, "HostIdToEndpoint")
 	if err != nil {
 		return nil, err
 	}
@@ -173,8 +221,8 @@ func (ss storageService) HostIDToEndpoint() (map[string]string, error) {
 }
 
 // LoadString human-readable load value
-func (ss storageService) LoadString() (string, error) {
-	resp, err := ss.jolokiaClient.ReadString(storageServicePath + "/LoadString")
+func (ss storageService) LoadString(ctx context.Context) (string, error) {
+	resp, err := ss.jolokiaClient.ReadString(ctx, storageServicePath, "LoadString")
 	if err != nil {
 		return "", err
 	}
@@ -182,24 +230,38 @@ func (ss storageService) LoadString() (string, error) {
 }
 
 // LoadMap human-readable load value. Keys are IP addresses.
-func (ss storageService) LoadMap() (map[string]string, error) {
-	resp, err := ss.jolokiaClient.ReadStringMapString(storageServicePath + "/LoadMap")
+func (ss storageService) LoadMap(ctx context.Context) (map[string]string, error) {
+	resp, err := ss.jolokiaClient.ReadStringMapString(ctx, storageServicePath, "LoadMap")
 	if err != nil {
 		return nil, err
 	}
 	return resp.Value, nil
 }
 
-// // This method returns the N endpoints that are responsible for storing the
-// // specified key i.e for replication.
-// func (ss storageService) NaturalEndpoints(keyspaceName, cf, key string) []net.IP {}
+// naturalEndpointsSignature disambiguates getNaturalEndpoints from its overload taking the
+// key as a ByteBuffer
+const naturalEndpointsSignature = "getNaturalEndpoints(java.lang.String,java.lang.String,java.lang.String)"
+
+// NaturalEndpoints returns the IPs of the nodes responsible for storing the partition key of
+// the table, i.e. its replicas. The key is given as CQL would print it.
+func (ss storageService) NaturalEndpoints(ctx context.Context, keyspaceName, cf, key string) ([]string, error) {
+	var addresses []interface{}
+	if err := ss.exec(ctx, &addresses, naturalEndpointsSignature, keyspaceName, cf, key); err != nil {
+		return nil, err
+	}
+	ips := make([]string, 0, len(addresses))
+	for _, address := range addresses {
+		ips = append(ips, inetAddress(address))
+	}
+	return ips, nil
+}
 
 // TakeSnapshot is self-explanatory
-func (ss storageService) TakeSnapshot(tag string, keyspaces ...string) error {
+func (ss storageService) TakeSnapshot(ctx context.Context, tag string, keyspaces ...string) error {
 	args := make([]interface{}, 2)
 	args[0] = tag
 	args[1] = keyspaces
-	r, err := ss.jolokiaClient.Exec(storageServicePath, "takeSnapshot", args...)
+	r, err := ss.jolokiaClient.Exec(ctx, storageServicePath, "takeSnapshot", args...)
 	if err != nil {
 		return err
 	}
@@ -208,12 +270,12 @@ func (ss storageService) TakeSnapshot(tag string, keyspaces ...string) error {
 }
 
 // TakeTableSnapshot is self-explanatory
-func (ss storageService) TakeTableSnapshot(tag, keyspace, table string) error {
+func (ss storageService) TakeTableSnapshot(ctx context.Context, tag, keyspace, table string) error {
 	args := make([]interface{}, 3)
 	args[0] = keyspace
 	args[1] = table
 	args[2] = tag
-	r, err := ss.jolokiaClient.Exec(storageServicePath, "takeTableSnapshot", args...)
+	r, err := ss.jolokiaClient.Exec(ctx, storageServicePath, "takeTableSnapshot", args...)
 	if err != nil {
 		return err
 	}
@@ -223,11 +285,11 @@ func (ss storageService) TakeTableSnapshot(tag, keyspace, table string) error {
 
 // TakeMultipleTableSnapshot takes the snapshot of a multiple column family from different
 // keyspaces. A snapshot name must be specified.
-func (ss storageService) TakeMultipleTableSnapshot(tag string, tableList ...string) error {
+func (ss storageService) TakeMultipleTableSnapshot(ctx context.Context, tag string, tableList ...string) error {
 	args := make([]interface{}, 2)
 	args[0] = tag
 	args[1] = tableList
-	r, err := ss.jolokiaClient.Exec(storageServicePath, "takeMultipleTableSnapshot", args...)
+	r, err := ss.jolokiaClient.Exec(ctx, storageServicePath, "takeMultipleTableSnapshot", args...)
 	if err != nil {
 		return err
 	}
@@ -237,7 +299,7 @@ func (ss storageService) TakeMultipleTableSnapshot(tag string, tableList ...stri
 
 // ClearSnapshot remove the snapshot with the given name from the given keyspaces.
 // If no tag is specified we will remove all snapshots.
-func (ss storageService) ClearSnapshot(tag string, keyspaces ...string) error {
+func (ss storageService) ClearSnapshot(ctx context.Context, tag string, keyspaces ...string) error {
 	args := make([]interface{}, 2)
 	args[0] = tag
 	if keyspaces != nil {
@@ -245,7 +307,7 @@ func (ss storageService) ClearSnapshot(tag string, keyspaces ...string) error {
 	} else {
 		args[1] = []string{}
 	}
-	r, err := ss.jolokiaClient.Exec(storageServicePath, "clearSnapshot", args...)
+	r, err := ss.jolokiaClient.Exec(ctx, storageServicePath, "clearSnapshot", args...)
 	if err != nil {
 		return err
 	}
@@ -263,42 +325,18 @@ type SnapshotDetailsResponse struct {
 // SnapshotDetails ...
 type SnapshotDetails []TableSnapshot
 
-// UnmarshalJSON handles the messy hierarchical json for tabular data that comes from Jolokia
+// UnmarshalJSON decodes the TabularData that comes from Jolokia
 func (sd *SnapshotDetails) UnmarshalJSON(buf []byte) error {
-	if string(buf) == "null" {
-		return nil
-	}
-
-	var rawMaps map[string]map[string]map[string]map[string]map[string]map[string]TableSnapshot
-	if err := json.NewDecoder(bytes.NewBuffer(buf)).Decode(&rawMaps); err != nil {
-		return err
-	}
-
-	// Yes, I know this is ugly, but it was the faster (quick-and-dirty) way to decode a mess
-	for _, lv1 := range rawMaps {
-		for _, lv2 := range lv1 {
-			for _, lv3 := range lv2 {
-				for _, lv4 := range lv3 {
-					for _, lv5 := range lv4 {
-						for _, ts := range lv5 {
-							*sd = append(*sd, ts)
-						}
-					}
-				}
-			}
-		}
-	}
-
-	return nil
+	return jolokia.UnmarshalTabularData(buf, (*[]TableSnapshot)(sd))
 }
 
 // TableSnapshot ...
 type TableSnapshot struct {
-	SnapshotName string `json:"Snapshot name"`
-	Keyspace     string `json:"Keyspace name"`
-	Table        string `json:"Column family name"`
-	SizeOnDisk   string `json:"Size on disk"`
-	TrueSize     string `json:"True size"`
+	SnapshotName string `jolokia:"Snapshot name" json:"snapshot_name"`
+	Keyspace     string `jolokia:"Keyspace name" json:"keyspace"`
+	Table        string `jolokia:"Column family name" json:"table"`
+	SizeOnDisk   string `jolokia:"Size on disk" json:"size_on_disk"`
+	TrueSize     string `jolokia:"True size" json:"true_size"`
 }
 
 // DecodeJSON ...
@@ -311,16 +349,16 @@ func (sdr *SnapshotDetailsResponse) DecodeJSON(r io.Reader) error {
 }
 
 // SnapshotDetails get the details of all the snapshots
-func (ss storageService) SnapshotDetails() (*SnapshotDetailsResponse, error) {
+func (ss storageService) SnapshotDetails(ctx context.Context) (*SnapshotDetailsResponse, error) {
 	details := &SnapshotDetailsResponse{}
-	err := ss.jolokiaClient.ReadInto(storageServicePath+"/SnapshotDetails", details)
+	err := ss.jolokiaClient.ReadInto(ctx, storageServicePath, "SnapshotDetails", details)
 	return details, err
 }
 
 // AllSnapshotsSize get the true size taken by all snapshots across all keyspaces.
-func (ss storageService) AllSnapshotsSize() (uint64, error) {
+func (ss storageService) AllSnapshotsSize(ctx context.Context) (uint64, error) {
 	response := &jolokia.Uint64ValueResponse{}
-	err := ss.jolokiaClient.ExecInto(response, storageServicePath, "trueSnapshotsSize")
+	err := ss.jolokiaClient.ExecInto(ctx, response, storageServicePath, "trueSnapshotsSize")
 	if err != nil {
 		return 0, err
 	}
@@ -330,8 +368,8 @@ func (ss storageService) AllSnapshotsSize() (uint64, error) {
 
 // RefreshSizeEstimates forces refresh of values stored in system.size_estimates of all
 // column families.
-func (ss storageService) refreshSizeEstimates() error {
-	r, err := ss.jolokiaClient.Exec(storageServicePath, "refreshSizeEstimates")
+func (ss storageService) refreshSizeEstimates(ctx context.Context) error {
+	r, err := ss.jolokiaClient.Exec(ctx, storageServicePath, "refreshSizeEstimates")
 	if err != nil {
 		return err
 	}
@@ -342,16 +380,16 @@ func (ss storageService) refreshSizeEstimates() error {
 // // Verify (checksums of) the given keyspace.
 // // If tableNames array is empty, all CFs are verified.
 // // The entire sstable will be read to ensure each cell validates if extendedVerify is true
-// func (ss storageService) verify(extendedVerify bool, keyspaceName string, tableNames ...string) (int, error) {
+// func (ss storageService) verify(ctx context.Context, extendedVerify bool, keyspaceName string, tableNames ...string) (int, error) {
 // }
 
 // ForceKeyspaceFlush flush all memtables for the given column families, or all columnfamilies for
 // the given keyspace if none are explicitly listed.
-func (ss storageService) ForceKeyspaceFlush(keyspace string, tables ...string) error {
+func (ss storageService) ForceKeyspaceFlush(ctx context.Context, keyspace string, tables ...string) error {
 	args := make([]interface{}, 2)
 	args[0] = keyspace
 	args[1] = tables
-	r, err := ss.jolokiaClient.Exec(storageServicePath, "forceKeyspaceFlush", args...)
+	r, err := ss.jolokiaClient.Exec(ctx, storageServicePath, "forceKeyspaceFlush", args...)
 	if err != nil {
 		return err
 	}
@@ -359,9 +397,53 @@ func (ss storageService) ForceKeyspaceFlush(keyspace string, tables ...string) e
 	return nil
 }
 
+// RepairAsync starts a repair of the keyspace, tuned by the options, like ranges,
+// parallelism or incremental, and returns the number of the repair command, or 0 when
+// there is nothing to repair.
+func (ss storageService) RepairAsync(ctx context.Context, keyspace string, options map[string]string) (int, error) {
+	var command int
+	if err := ss.exec(ctx, &command, "repairAsync", keyspace, options); err != nil {
+		return 0, err
+	}
+	return command, nil
+}
+
+// ParentRepairStatus returns the status of a repair command, IN_PROGRESS, COMPLETED or
+// FAILED, followed by its messages. The status is empty when the command is unknown, as
+// the node only remembers the recent ones. Only available since Cassandra 4.0.
+func (ss storageService) ParentRepairStatus(ctx context.Context, command int) (status string, messages []string, err error) {
+	var result []string
+	if err := ss.exec(ctx, &result, "getParentRepairStatus", command); err != nil {
+		return "", nil, err
+	}
+	if len(result) == 0 {
+		return "", nil, nil
+	}
+	return result[0], result[1:], nil
+}
+
+// CompactionThroughputMbPerSec returns the compaction throughput limit, in MB/s, where 0 is unlimited
+func (ss storageService) CompactionThroughputMbPerSec(ctx context.Context) (uint64, error) {
+	response := &jolokia.Uint64ValueResponse{}
+	if err := ss.jolokiaClient.ReadInto(ctx, storageServicePath, "CompactionThroughputMbPerSec", response); err != nil {
+		return 0, err
+	}
+	return response.Value, nil
+}
+
+// SetCompactionThroughputMbPerSec sets the compaction throughput limit, in MB/s, where 0 is unlimited
+func (ss storageService) SetCompactionThroughputMbPerSec(ctx context.Context, mbPerSec uint64) error {
+	previous, err := ss.jolokiaClient.Write(ctx, storageServicePath, "CompactionThroughputMbPerSec", mbPerSec)
+	if err != nil {
+		return err
+	}
+	logrus.Debugf("Compaction throughput changed from %v to %d MB/s", previous, mbPerSec)
+	return nil
+}
+
 // Starting returns whether the storage service is starting or not
-func (ss storageService) Starting() (bool, error) {
-	resp, err := ss.jolokiaClient.ReadBool(storageServicePath + "/IsStarting")
+func (ss storageService) Starting(ctx context.Context) (bool, error) {
+	resp, err := ss.jolokiaClient.ReadBool(ctx, storageServicePath, "IsStarting")
 	if err != nil {
 		return false, err
 	}
@@ -369,24 +451,32 @@ func (ss storageService) Starting() (bool, error) {
 }
 
 // GossipRunning returns whether the gossip is running
-func (ss storageService) GossipRunning() (bool, error) {
-	resp, err := ss.jolokiaClient.ReadBool(storageServicePath + "/GossipRunning")
+func (ss storageService) GossipRunning(ctx context.Context) (bool, error) {
+	resp, err := ss.jolokiaClient.ReadBool(ctx, storageServicePath, "GossipRunning")
 	if err != nil {
 		return false, err
 	}
 	return resp.Value, nil
 }
 
-// // Effective ownership is % of the data each node owns given the keyspace
-// // we calculate the percentage using replication factor.
-// // If Keyspace == null, this method will try to verify if all the keyspaces
-// // in the cluster have the same replication strategies and if yes then we will
-// // use the first else a empty Map is returned.
-// func (ss storageService) effectiveOwnership(keyspace string) (map[net.IP]float, error) {}
+// EffectiveOwnership returns the fraction of the data each node owns given the keyspace,
+// keyed by IP, calculated using its replication factor. Without a keyspace, the ownership
+// can only be calculated when all the keyspaces have the same replication strategy.
+func (ss storageService) EffectiveOwnership(ctx context.Context, keyspace string) (map[string]float64, error) {
+	var ownership map[string]float64
+	var ks interface{}
+	if keyspace != "" {
+		ks = keyspace
+	}
+	if err := ss.exec(ctx, &ownership, "effectiveOwnership", ks); err != nil {
+		return nil, err
+	}
+	return ownershipByAddress(ownership), nil
+}
 
 // Keyspaces return the list of keyspaces in the cluster
-func (ss storageService) Keyspaces() ([]string, error) {
-	resp, err := ss.jolokiaClient.ReadStringList(storageServicePath + "/Keyspaces")
+func (ss storageService) Keyspaces(ctx context.Context) ([]string, error) {
+	resp, err := ss.jolokiaClient.ReadStringList(ctx, storageServicePath, "Keyspaces")
 	if err != nil {
 		return nil, err
 	}
@@ -395,8 +485,8 @@ func (ss storageService) Keyspaces() ([]string, error) {
 
 // NonSystemKeyspaces ...
 // BUG - Cassandra 2.2.x does not seems to differ system keyspaces
-func (ss storageService) NonSystemKeyspaces() ([]string, error) {
-	resp, err := ss.jolokiaClient.ReadStringList(storageServicePath + "/NonSystemKeyspaces")
+func (ss storageService) NonSystemKeyspaces(ctx context.Context) ([]string, error) {
+	resp, err := ss.jolokiaClient.ReadStringList(ctx, storageServicePath, "NonSystemKeyspaces")
 	if err != nil {
 		return nil, err
 	}
@@ -405,8 +495,8 @@ func (ss storageService) NonSystemKeyspaces() ([]string, error) {
 
 // OperationMode returns the operation mode of the node. STARTING, NORMAL, JOINING,
 // LEAVING, DECOMMISSIONED, MOVING, DRAINING, DRAINED.
-func (ss storageService) OperationMode() (string, error) {
-	resp, err := ss.jolokiaClient.ReadString(storageServicePath + "/OperationMode")
+func (ss storageService) OperationMode(ctx context.Context) (string, error) {
+	resp, err := ss.jolokiaClient.ReadString(ctx, storageServicePath, "OperationMode")
 	if err != nil {
 		return "", err
 	}
@@ -414,8 +504,8 @@ func (ss storageService) OperationMode() (string, error) {
 }
 
 // IncrementalBackupsEnabled is self explanatory
-func (ss storageService) IncrementalBackupsEnabled() (bool, error) {
-	resp, err := ss.jolokiaClient.ReadBool(storageServicePath + "/IncrementalBackupsEnabled")
+func (ss storageService) IncrementalBackupsEnabled(ctx context.Context) (bool, error) {
+	resp, err := ss.jolokiaClient.ReadBool(ctx, storageServicePath, "IncrementalBackupsEnabled")
 	if err != nil {
 		return false, err
 	}
@@ -423,8 +513,8 @@ func (ss storageService) IncrementalBackupsEnabled() (bool, error) {
 }
 
 // Initialized is self explanatory
-func (ss storageService) Initialized() (bool, error) {
-	resp, err := ss.jolokiaClient.ReadBool(storageServicePath + "/Initialized")
+func (ss storageService) Initialized(ctx context.Context) (bool, error) {
+	resp, err := ss.jolokiaClient.ReadBool(ctx, storageServicePath, "Initialized")
 	if err != nil {
 		return false, err
 	}
@@ -432,8 +522,8 @@ func (ss storageService) Initialized() (bool, error) {
 }
 
 // Joined is self explanatory
-func (ss storageService) Joined() (bool, error) {
-	resp, err := ss.jolokiaClient.ReadBool(storageServicePath + "/Joined")
+func (ss storageService) Joined(ctx context.Context) (bool, error) {
+	resp, err := ss.jolokiaClient.ReadBool(ctx, storageServicePath, "Joined")
 	if err != nil {
 		return false, err
 	}
@@ -441,8 +531,8 @@ func (ss storageService) Joined() (bool, error) {
 }
 
 // ClusterName returns the name of the cluster
-func (ss storageService) ClusterName() (name string, err error) {
-	resp, err := ss.jolokiaClient.ReadString(storageServicePath + "/ClusterName")
+func (ss storageService) ClusterName(ctx context.Context) (name string, err error) {
+	resp, err := ss.jolokiaClient.ReadString(ctx, storageServicePath, "ClusterName")
 	if err != nil {
 		return "", err
 	}
@@ -450,8 +540,8 @@ func (ss storageService) ClusterName() (name string, err error) {
 }
 
 // PartitionerName returns the cluster partitioner
-func (ss storageService) PartitionerName() (name string, err error) {
-	resp, err := ss.jolokiaClient.ReadString(storageServicePath + "/PartitionerName")
+func (ss storageService) PartitionerName(ctx context.Context) (name string, err error) {
+	resp, err := ss.jolokiaClient.ReadString(ctx, storageServicePath, "PartitionerName")
 	if err != nil {
 		return "", err
 	}
@@ -0,0 +1,123 @@
+package cassandra
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/CrossEngage/CaOps/internal/jolokia"
+)
+
+const (
+	// tableMetricsPattern matches the per-table metrics of Cassandra 3.0 and later
+	tableMetricsPattern = "org.apache.cassandra.metrics:type=Table,keyspace=%s,scope=%s,name=*"
+	// columnFamilyMetricsPattern matches the per-table metrics of Cassandra 2.2
+	columnFamilyMetricsPattern = "org.apache.cassandra.metrics:type=ColumnFamily,keyspace=%s,scope=%s,name=*"
+)
+
+// TableStats are the statistics of a table, like nodetool tablestats. The latencies are in
+// milliseconds, and the sizes in bytes.
+type TableStats struct {
+	Keyspace                  string  `json:"keyspace"`
+	Table                     string  `json:"table"`
+	SSTableCount              int64   `json:"sstable_count"`
+	SpaceUsedLive             int64   `json:"space_used_live"`
+	SpaceUsedTotal            int64   `json:"space_used_total"`
+	EstimatedPartitions       int64   `json:"estimated_partitions"`
+	MemtableCellCount         int64   `json:"memtable_cell_count"`
+	MemtableDataSize          int64   `json:"memtable_data_size"`
+	MemtableSwitchCount       int64   `json:"memtable_switch_count"`
+	LocalReadCount            int64   `json:"local_read_count"`
+	LocalReadLatency          float64 `json:"local_read_latency_ms"`
+	LocalWriteCount           int64   `json:"local_write_count"`
+	LocalWriteLatency         float64 `json:"local_write_latency_ms"`
+	PendingFlushes            int64   `json:"pending_flushes"`
+	BloomFilterFalsePositives int64   `json:"bloom_filter_false_positives"`
+	BloomFilterSpaceUsed      int64   `json:"bloom_filter_space_used"`
+	CompactedPartitionMinimum int64   `json:"compacted_partition_minimum_bytes"`
+	CompactedPartitionMaximum int64   `json:"compacted_partition_maximum_bytes"`
+	CompactedPartitionMean    int64   `json:"compacted_partition_mean_bytes"`
+}
+
+// TablesStats reads the statistics of the tables of the keyspace from their metrics, sorted
+// by keyspace and table. The keyspace and table can be *, to read all of them.
+func (m *Manager) TablesStats(ctx context.Context, keyspace, table string) ([]*TableStats, error) {
+	tables, columnFamilies := jolokia.MBeansValue{}, jolokia.MBeansValue{}
+	batch := jolokia.NewBatch()
+	attributes := []string{"Value", "Count", "Mean"}
+	tablesResult := batch.ReadMBeans(&tables, fmt.Sprintf(tableMetricsPattern, keyspace, table), attributes...)
+	columnFamiliesResult := batch.ReadMBeans(&columnFamilies, fmt.Sprintf(columnFamilyMetricsPattern, keyspace, table), attributes...)
+	if err := m.SendBatch(ctx, batch); err != nil {
+		return nil, err
+	}
+	// only one of the patterns exists, depending on the Cassandra version
+	if tablesResult.Err() != nil && columnFamiliesResult.Err() != nil {
+		return nil, tablesResult.Err()
+	}
+
+	byTable := make(map[string]*TableStats)
+	for _, metrics := range []jolokia.MBeansValue{tables, columnFamilies} {
+		for mbean, values := range metrics {
+			_, properties := jolokia.ParseMBeanName(mbean)
+			key := properties["keyspace"] + "." + properties["scope"]
+			stats, ok := byTable[key]
+			if !ok {
+				stats = &TableStats{Keyspace: properties["keyspace"], Table: properties["scope"]}
+				byTable[key] = stats
+			}
+			stats.set(properties["name"], values)
+		}
+	}
+
+	all := make([]*TableStats, 0, len(byTable))
+	for _, stats := range byTable {
+		all = append(all, stats)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].Keyspace != all[j].Keyspace {
+			return all[i].Keyspace < all[j].Keyspace
+		}
+		return all[i].Table < all[j].Table
+	})
+	return all, nil
+}
+
+// set sets the statistic matching the metric, where gauges have a Value, counters a Count,
+// and timers a Count and a Mean in microseconds
+func (s *TableStats) set(metric string, values map[string]interface{}) {
+	value, count := toInt64(values["Value"]), toInt64(values["Count"])
+	switch metric {
+	case "LiveSSTableCount":
+		s.SSTableCount = value
+	case "LiveDiskSpaceUsed":
+		s.SpaceUsedLive = count
+	case "TotalDiskSpaceUsed":
+		s.SpaceUsedTotal = count
+	case "EstimatedPartitionCount", "EstimatedRowCount":
+		s.EstimatedPartitions = value
+	case "MemtableColumnsCount":
+		s.MemtableCellCount = value
+	case "MemtableLiveDataSize":
+		s.MemtableDataSize = value
+	case "MemtableSwitchCount":
+		s.MemtableSwitchCount = count
+	case "ReadLatency":
+		s.LocalReadCount = count
+		s.LocalReadLatency = toFloat64(values["Mean"]) / 1000
+	case "WriteLatency":
+		s.LocalWriteCount = count
+		s.LocalWriteLatency = toFloat64(values["Mean"]) / 1000
+	case "PendingFlushes":
+		s.PendingFlushes = count
+	case "BloomFilterFalsePositives":
+		s.BloomFilterFalsePositives = value
+	case "BloomFilterDiskSpaceUsed":
+		s.BloomFilterSpaceUsed = value
+	case "MinPartitionSize", "MinRowSize":
+		s.CompactedPartitionMinimum = value
+	case "MaxPartitionSize", "MaxRowSize":
+		s.CompactedPartitionMaximum = value
+	case "MeanPartitionSize", "MeanRowSize":
+		s.CompactedPartitionMean = value
+	}
+}
@@ -0,0 +1,51 @@
+package cassandra
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/CrossEngage/CaOps/internal/jolokia"
+)
+
+const (
+	// tablesPattern matches the per-table MBeans of Cassandra 3.0 and later
+	tablesPattern = "org.apache.cassandra.db:type=Tables,keyspace=%s,table=*"
+	// columnFamiliesPattern matches the per-table MBeans of Cassandra 2.2
+	columnFamiliesPattern = "org.apache.cassandra.db:type=ColumnFamilies,keyspace=%s,columnfamily=*"
+)
+
+// Tables returns the names of the tables of the keyspace, or of all keyspaces when it is
+// "*", keyed by keyspace. They are discovered from the per-table MBeans.
+func (m *Manager) Tables(ctx context.Context, keyspace string) (map[string][]string, error) {
+	var tables, columnFamilies []string
+	batch := jolokia.NewBatch()
+	tablesResult := batch.Search(&tables, fmt.Sprintf(tablesPattern, keyspace))
+	columnFamiliesResult := batch.Search(&columnFamilies, fmt.Sprintf(columnFamiliesPattern, keyspace))
+	if err := m.SendBatch(ctx, batch); err != nil {
+		return nil, err
+	}
+	// Cassandra 3.0 and later register the MBeans of both patterns for each table, and
+	// Cassandra 2.2 only the ColumnFamilies ones
+	if tablesResult.Err() != nil && columnFamiliesResult.Err() != nil {
+		return nil, tablesResult.Err()
+	}
+	mbeans := tables
+	if len(mbeans) == 0 {
+		mbeans = columnFamilies
+	}
+
+	byKeyspace := make(map[string][]string)
+	for _, mbean := range mbeans {
+		_, properties := jolokia.ParseMBeanName(mbean)
+		table, ok := properties["table"]
+		if !ok {
+			table = properties["columnfamily"]
+		}
+		byKeyspace[properties["keyspace"]] = append(byKeyspace[properties["keyspace"]], table)
+	}
+	for _, names := range byKeyspace {
+		sort.Strings(names)
+	}
+	return byKeyspace, nil
+}
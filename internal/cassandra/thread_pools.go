@@ -0,0 +1,80 @@
+package cassandra
+
+import (
+	"context"
+	"sort"
+
+	"github.com/CrossEngage/CaOps/internal/jolokia"
+)
+
+const (
+	threadPoolsPattern     = "org.apache.cassandra.metrics:type=ThreadPools,path=*,scope=*,name=*"
+	droppedMessagesPattern = "org.apache.cassandra.metrics:type=DroppedMessage,scope=*,name=Dropped"
+)
+
+// ThreadPoolStats are the usage statistics of a thread pool of the node
+type ThreadPoolStats struct {
+	Name           string `json:"name"`
+	Active         int64  `json:"active"`
+	Pending        int64  `json:"pending"`
+	Completed      int64  `json:"completed"`
+	Blocked        int64  `json:"blocked"`
+	AllTimeBlocked int64  `json:"all_time_blocked"`
+}
+
+// ThreadPoolsStats holds the statistics of the thread pools, and the number of dropped
+// messages, keyed by message type, like nodetool tpstats
+type ThreadPoolsStats struct {
+	Pools           []*ThreadPoolStats `json:"pools"`
+	DroppedMessages map[string]int64   `json:"dropped_messages"`
+}
+
+// ThreadPoolsStats reads the statistics of all the thread pools from their metrics
+func (m *Manager) ThreadPoolsStats(ctx context.Context) (*ThreadPoolsStats, error) {
+	pools, dropped := jolokia.MBeansValue{}, jolokia.MBeansValue{}
+	batch := jolokia.NewBatch()
+	poolsResult := batch.ReadMBeans(&pools, threadPoolsPattern, "Value", "Count")
+	droppedResult := batch.ReadMBeans(&dropped, droppedMessagesPattern, "Count")
+	if err := m.SendBatch(ctx, batch); err != nil {
+		return nil, err
+	}
+	if err := poolsResult.Err(); err != nil {
+		return nil, err
+	}
+
+	byName := make(map[string]*ThreadPoolStats)
+	for mbean, attributes := range pools {
+		_, properties := jolokia.ParseMBeanName(mbean)
+		pool, ok := byName[properties["scope"]]
+		if !ok {
+			pool = &ThreadPoolStats{Name: properties["scope"]}
+			byName[pool.Name] = pool
+		}
+		switch properties["name"] {
+		case "ActiveTasks":
+			pool.Active = toInt64(attributes["Value"])
+		case "PendingTasks":
+			pool.Pending = toInt64(attributes["Value"])
+		case "CompletedTasks":
+			pool.Completed = toInt64(attributes["Value"])
+		case "CurrentlyBlockedTasks":
+			pool.Blocked = toInt64(attributes["Count"])
+		case "TotalBlockedTasks":
+			pool.AllTimeBlocked = toInt64(attributes["Count"])
+		}
+	}
+	stats := &ThreadPoolsStats{Pools: make([]*ThreadPoolStats, 0, len(byName)), DroppedMessages: make(map[string]int64)}
+	for _, pool := range byName {
+		stats.Pools = append(stats.Pools, pool)
+	}
+	sort.Slice(stats.Pools, func(i, j int) bool { return stats.Pools[i].Name < stats.Pools[j].Name })
+
+	// there are no dropped message metrics until a message is dropped, in some versions
+	if droppedResult.Err() == nil {
+		for mbean, attributes := range dropped {
+			_, properties := jolokia.ParseMBeanName(mbean)
+			stats.DroppedMessages[properties["scope"]] = toInt64(attributes["Count"])
+		}
+	}
+	return stats, nil
+}
@@ -0,0 +1,157 @@
+package cassandra
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/CrossEngage/CaOps/internal/jolokia"
+)
+
+// TokenRange is a range of the ring, from its start token, exclusive, to its end token,
+// inclusive, with the nodes replicating it
+type TokenRange struct {
+	Start           string            `json:"start_token"`
+	End             string            `json:"end_token"`
+	Endpoints       []string          `json:"endpoints"`
+	RPCEndpoints    []string          `json:"rpc_endpoints,omitempty"`
+	EndpointDetails []EndpointDetails `json:"endpoint_details,omitempty"`
+}
+
+// EndpointDetails locates a replica of a token range
+type EndpointDetails struct {
+	Host       string `json:"host"`
+	Datacenter string `json:"datacenter"`
+	Rack       string `json:"rack,omitempty"`
+}
+
+// RingDescription describes the ranges of the ring of a keyspace, with their replicas
+type RingDescription struct {
+	Keyspace string       `json:"keyspace"`
+	Ranges   []TokenRange `json:"ranges"`
+	// PendingRanges are the ranges being streamed to joining, leaving or moving nodes
+	PendingRanges []TokenRange `json:"pending_ranges"`
+	// Ownership is the effective fraction of the data owned by each node, keyed by its IP
+	Ownership map[string]float64 `json:"ownership"`
+}
+
+// DescribeRing returns the ranges of the keyspace with their replicas, the pending ranges,
+// and the effective ownership of the nodes, in a single request to the node
+func (m *Manager) DescribeRing(ctx context.Context, keyspace string) (*RingDescription, error) {
+	var ring []string
+	var pending map[string][]string
+	var ownership map[string]float64
+	batch := jolokia.NewBatch()
+	results := []*jolokia.BatchResult{
+		batch.Exec(&ring, storageServicePath, "describeRingJMX", keyspace),
+		batch.Exec(&pending, storageServicePath, "getPendingRangeToEndpointMap", keyspace),
+		batch.Exec(&ownership, storageServicePath, "effectiveOwnership", keyspace),
+	}
+	if err := m.SendBatch(ctx, batch); err != nil {
+		return nil, err
+	}
+	if err := firstErr(results...); err != nil {
+		return nil, err
+	}
+
+	description := &RingDescription{Keyspace: keyspace, Ownership: ownershipByAddress(ownership)}
+	var err error
+	if description.Ranges, err = parseTokenRanges(ring); err != nil {
+		return nil, err
+	}
+	if description.PendingRanges, err = parseRangeMap(pending, false); err != nil {
+		return nil, err
+	}
+	return description, nil
+}
+
+// parseRangeMap parses a map of ranges, serialized like [start, end], to the addresses of
+// their replicas, which are set as RPC addresses when rpc is true, sorted by token
+func parseRangeMap(rangeMap map[string][]string, rpc bool) ([]TokenRange, error) {
+	ranges := make([]TokenRange, 0, len(rangeMap))
+	for key, addresses := range rangeMap {
+		bounds := strings.Split(strings.Trim(key, "[]"), ",")
+		if len(bounds) != 2 {
+			return nil, fmt.Errorf("Invalid token range '%s'", key)
+		}
+		for i := range addresses {
+			addresses[i] = endpointAddress(addresses[i])
+		}
+		r := TokenRange{Start: strings.TrimSpace(bounds[0]), End: strings.TrimSpace(bounds[1])}
+		if rpc {
+			r.RPCEndpoints = addresses
+		} else {
+			r.Endpoints = addresses
+		}
+		ranges = append(ranges, r)
+	}
+	sortTokenRanges(ranges)
+	return ranges, nil
+}
+
+var (
+	startTokenRegexp      = regexp.MustCompile(`start_token:([^,)]+)`)
+	endTokenRegexp        = regexp.MustCompile(`end_token:([^,)]+)`)
+	endpointsRegexp       = regexp.MustCompile(`[(\s]endpoints:\[([^\]]*)\]`)
+	rpcEndpointsRegexp    = regexp.MustCompile(`rpc_endpoints:\[([^\]]*)\]`)
+	endpointDetailsRegexp = regexp.MustCompile(`EndpointDetails\(host:([^,)]+), datacenter:([^,)]+)(?:, rack:([^,)]+))?\)`)
+)
+
+// parseTokenRanges parses the token ranges of describeRingJMX, serialized by the Thrift
+// TokenRange toString, like TokenRange(start_token:-1, end_token:1, endpoints:[10.0.0.1],
+// rpc_endpoints:[10.0.0.1], endpoint_details:[EndpointDetails(host:10.0.0.1, datacenter:dc1,
+// rack:rack1)]), sorted by token
+func parseTokenRanges(ring []string) ([]TokenRange, error) {
+	ranges := make([]TokenRange, 0, len(ring))
+	for _, s := range ring {
+		start, end := startTokenRegexp.FindStringSubmatch(s), endTokenRegexp.FindStringSubmatch(s)
+		if start == nil || end == nil {
+			return nil, fmt.Errorf("Invalid token range '%s'", s)
+		}
+		r := TokenRange{
+			Start:        strings.TrimSpace(start[1]),
+			End:          strings.TrimSpace(end[1]),
+			Endpoints:    parseAddressList(endpointsRegexp.FindStringSubmatch(s)),
+			RPCEndpoints: parseAddressList(rpcEndpointsRegexp.FindStringSubmatch(s)),
+		}
+		for _, details := range endpointDetailsRegexp.FindAllStringSubmatch(s, -1) {
+			r.EndpointDetails = append(r.EndpointDetails, EndpointDetails{
+				Host:       strings.TrimSpace(details[1]),
+				Datacenter: strings.TrimSpace(details[2]),
+				Rack:       strings.TrimSpace(details[3]),
+			})
+		}
+		ranges = append(ranges, r)
+	}
+	sortTokenRanges(ranges)
+	return ranges, nil
+}
+
+// parseAddressList parses the submatch of a list of addresses, like [10.0.0.1, 10.0.0.2]
+func parseAddressList(match []string) []string {
+	addresses := make([]string, 0)
+	if match == nil {
+		return addresses
+	}
+	for _, address := range strings.Split(match[1], ",") {
+		if address = strings.TrimSpace(address); address != "" {
+			addresses = append(addresses, endpointAddress(address))
+		}
+	}
+	return addresses
+}
+
+func sortTokenRanges(ranges []TokenRange) {
+	sort.Slice(ranges, func(i, j int) bool { return compareTokens(ranges[i].Start, ranges[j].Start) < 0 })
+}
+
+// ownershipByAddress keys the ownership of the nodes by their IP, instead of their InetAddress
+func ownershipByAddress(ownership map[string]float64) map[string]float64 {
+	byAddress := make(map[string]float64, len(ownership))
+	for endpoint, owns := range ownership {
+		byAddress[endpointAddress(endpoint)] = owns
+	}
+	return byAddress
+}
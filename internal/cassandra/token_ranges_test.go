@@ -0,0 +1,102 @@
+package cassandra
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/CrossEngage/CaOps/internal/cassandra/cassandratest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTokenRanges(t *testing.T) {
+	ranges, err := parseTokenRanges([]string{
+		"TokenRange(start_token:100, end_token:-9223372036854775808, endpoints:[127.0.0.2, 127.0.0.1], " +
+			"rpc_endpoints:[10.0.0.2, 10.0.0.1], endpoint_details:[EndpointDetails(host:127.0.0.2, datacenter:dc1, rack:r2), " +
+			"EndpointDetails(host:127.0.0.1, datacenter:dc1, rack:r1)])",
+		"TokenRange(start_token:-9223372036854775808, end_token:100, endpoints:[127.0.0.1], " +
+			"rpc_endpoints:[10.0.0.1], endpoint_details:[EndpointDetails(host:127.0.0.1, datacenter:dc1)])",
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []TokenRange{
+		{
+			Start: "-9223372036854775808", End: "100",
+			Endpoints: []string{"127.0.0.1"}, RPCEndpoints: []string{"10.0.0.1"},
+			EndpointDetails: []EndpointDetails{{Host: "127.0.0.1", Datacenter: "dc1"}},
+		},
+		{
+			Start: "100", End: "-9223372036854775808",
+			Endpoints: []string{"127.0.0.2", "127.0.0.1"}, RPCEndpoints: []string{"10.0.0.2", "10.0.0.1"},
+			EndpointDetails: []EndpointDetails{
+				{Host: "127.0.0.2", Datacenter: "dc1", Rack: "r2"},
+				{Host: "127.0.0.1", Datacenter: "dc1", Rack: "r1"},
+			},
+		},
+	}, ranges)
+
+	_, err = parseTokenRanges([]string{"TokenRange(endpoints:[])"})
+	assert.NotNil(t, err)
+}
+
+func TestParseRangeMap(t *testing.T) {
+	ranges, err := parseRangeMap(map[string][]string{
+		"[100, -100]": {"/127.0.0.1"},
+		"[-100, 100]": {"cass2/127.0.0.2", "/127.0.0.1"},
+	}, false)
+	assert.Nil(t, err)
+	assert.Equal(t, []TokenRange{
+		{Start: "-100", End: "100", Endpoints: []string{"127.0.0.2", "127.0.0.1"}},
+		{Start: "100", End: "-100", Endpoints: []string{"127.0.0.1"}},
+	}, ranges)
+
+	_, err = parseRangeMap(map[string][]string{"[100]": {}}, false)
+	assert.NotNil(t, err)
+}
+
+func TestNaturalEndpoints(t *testing.T) {
+	manager, agent := newFakeManager(t)
+	defer agent.Close()
+	agent.SetOperation(cassandratest.StorageService, "getNaturalEndpoints", func(args []interface{}) (interface{}, error) {
+		if fmt.Sprint(args...) != "ks1userskey1" {
+			return nil, fmt.Errorf("Unexpected arguments %v", args)
+		}
+		// Jolokia serializes InetAddress as a bean
+		return []interface{}{
+			map[string]interface{}{"hostAddress": "127.0.0.2", "hostName": "cass2"},
+			map[string]interface{}{"hostAddress": "127.0.0.1", "hostName": "cass1"},
+		}, nil
+	})
+
+	endpoints, err := manager.NaturalEndpoints(context.Background(), "ks1", "users", "key1")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"127.0.0.2", "127.0.0.1"}, endpoints)
+	assert.Equal(t, naturalEndpointsSignature, agent.Requests()[len(agent.Requests())-1].Operation)
+}
+
+func TestDescribeRing(t *testing.T) {
+	manager, agent := newFakeManager(t)
+	defer agent.Close()
+	ss := cassandratest.StorageService
+	agent.SetOperation(ss, "describeRingJMX", func(args []interface{}) (interface{}, error) {
+		return []string{"TokenRange(start_token:-100, end_token:-100, endpoints:[127.0.0.1], rpc_endpoints:[127.0.0.1], " +
+			"endpoint_details:[EndpointDetails(host:127.0.0.1, datacenter:dc1, rack:r1)])"}, nil
+	})
+	agent.SetOperation(ss, "getPendingRangeToEndpointMap", func(args []interface{}) (interface{}, error) {
+		return map[string][]string{"[-100, 0]": {"/127.0.0.2"}}, nil
+	})
+	agent.SetOperation(ss, "effectiveOwnership", func(args []interface{}) (interface{}, error) {
+		return map[string]float64{"/127.0.0.1": 1}, nil
+	})
+
+	description, err := manager.DescribeRing(context.Background(), "ks1")
+	assert.Nil(t, err)
+	assert.Equal(t, &RingDescription{
+		Keyspace: "ks1",
+		Ranges: []TokenRange{{
+			Start: "-100", End: "-100", Endpoints: []string{"127.0.0.1"}, RPCEndpoints: []string{"127.0.0.1"},
+			EndpointDetails: []EndpointDetails{{Host: "127.0.0.1", Datacenter: "dc1", Rack: "r1"}},
+		}},
+		PendingRanges: []TokenRange{{Start: "-100", End: "0", Endpoints: []string{"127.0.0.2"}}},
+		Ownership:     map[string]float64{"127.0.0.1": 1},
+	}, description)
+}
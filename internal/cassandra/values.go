@@ -0,0 +1,105 @@
+package cassandra
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/CrossEngage/CaOps/internal/jolokia"
+)
+
+// firstErr returns the first error of the requests of a batch
+func firstErr(results ...*jolokia.BatchResult) error {
+	for _, result := range results {
+		if err := result.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// toInt64 converts a number read through Jolokia, which may be serialized as a string
+func toInt64(value interface{}) int64 {
+	switch v := value.(type) {
+	case float64:
+		return int64(v)
+	case json.Number:
+		i, _ := v.Int64()
+		return i
+	case string:
+		i, _ := strconv.ParseInt(v, 10, 64)
+		return i
+	}
+	return 0
+}
+
+// toFloat64 converts a number read through Jolokia, which may be serialized as a string
+func toFloat64(value interface{}) float64 {
+	switch v := value.(type) {
+	case float64:
+		return v
+	case json.Number:
+		f, _ := v.Float64()
+		return f
+	case string:
+		f, _ := strconv.ParseFloat(v, 64)
+		return f
+	}
+	return 0
+}
+
+// endpointAddress returns the IP of an InetAddress serialized by its toString, like
+// /10.0.0.1 or cass1.example.com/10.0.0.1
+func endpointAddress(endpoint string) string {
+	return endpoint[strings.LastIndex(endpoint, "/")+1:]
+}
+
+// inetAddress returns the IP of an InetAddress read through Jolokia, which serializes it by
+// its toString, or as a bean of its getters
+func inetAddress(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return endpointAddress(v)
+	case map[string]interface{}:
+		if address, ok := v["hostAddress"].(string); ok {
+			return address
+		}
+	}
+	return fmt.Sprint(value)
+}
+
+func contains(list []string, item string) bool {
+	for _, i := range list {
+		if i == item {
+			return true
+		}
+	}
+	return false
+}
+
+// dataSizeUnits are the units of the sizes printed by Cassandra, like the loads, in bytes
+var dataSizeUnits = map[string]float64{
+	"bytes": 1,
+	"KiB":   1 << 10, "KB": 1 << 10,
+	"MiB": 1 << 20, "MB": 1 << 20,
+	"GiB": 1 << 30, "GB": 1 << 30,
+	"TiB": 1 << 40, "TB": 1 << 40,
+}
+
+// ParseDataSize parses a size printed by Cassandra, like the loads of the nodes, into bytes
+func ParseDataSize(size string) (int64, error) {
+	fields := strings.Fields(size)
+	if len(fields) != 2 {
+		return 0, fmt.Errorf("Invalid data size %q", size)
+	}
+	unit, ok := dataSizeUnits[fields[1]]
+	if !ok {
+		return 0, fmt.Errorf("Invalid data size %q, of unknown unit", size)
+	}
+	value, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid data size %q", size)
+	}
+	return int64(value * unit), nil
+}
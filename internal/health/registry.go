@@ -0,0 +1,252 @@
+// Package health runs named checks of the health of the cluster, like the checks which must
+// pass before an operation, collecting all their results rather than stopping at the first
+// failure.
+package health
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Severity tells how bad the failure of a check is
+type Severity string
+
+// Severities of the checks: the cluster is unhealthy when a critical check fails, while the
+// failure of a warning check only needs a look
+const (
+	Critical Severity = "critical"
+	Warning  Severity = "warning"
+)
+
+// Status is the status of the result of a check
+type Status string
+
+// Statuses of a result: a check errors when it could not tell whether it passes, like when
+// Jolokia does not respond
+const (
+	Passed  Status = "passed"
+	Failed  Status = "failed"
+	Errored Status = "error"
+)
+
+// CheckFunc runs a check, ignoring the excluded nodes, like a dead node about to be removed.
+// It returns nil when the check passes, a Failure when it fails, or another error when it
+// could not be run.
+type CheckFunc func(ctx context.Context, excluded []string) error
+
+// Check is a named check, with its severity
+type Check struct {
+	Name     string
+	Severity Severity
+	Run      CheckFunc
+}
+
+// Failure is the error of a check which fails
+type Failure struct {
+	Message string
+}
+
+func (f *Failure) Error() string {
+	return f.Message
+}
+
+// Failf returns the failure of a check, with the formatted message
+func Failf(format string, args ...interface{}) error {
+	return &Failure{Message: fmt.Sprintf(format, args...)}
+}
+
+// Result is the result of a check
+type Result struct {
+	Name     string   `json:"name"`
+	Severity Severity `json:"severity"`
+	Status   Status   `json:"status"`
+	Message  string   `json:"message,omitempty"`
+}
+
+// OperationError lists the failed results of the checks required by an operation
+type OperationError struct {
+	Operation string
+	Results   []*Result
+}
+
+func (e *OperationError) Error() string {
+	failures := make([]string, 0, len(e.Results))
+	for _, result := range e.Results {
+		failures = append(failures, fmt.Sprintf("%s %s: %s", result.Name, result.Status, result.Message))
+	}
+	return fmt.Sprintf("The %s is not allowed, as checks did not pass: %s", e.Operation, strings.Join(failures, "; "))
+}
+
+// Registry holds the checks, in the order they were registered, and the checks which must
+// pass before each declared operation
+type Registry struct {
+	mtx        sync.Mutex
+	checks     []*Check
+	byName     map[string]*Check
+	operations map[string][]string
+}
+
+// NewRegistry returns an empty registry
+func NewRegistry() *Registry {
+	return &Registry{byName: make(map[string]*Check), operations: make(map[string][]string)}
+}
+
+// Register adds the check, whose name must be unique
+func (r *Registry) Register(check Check) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if check.Name == "" || check.Run == nil {
+		return fmt.Errorf("A check needs a name and a function")
+	}
+	if _, ok := r.byName[check.Name]; ok {
+		return fmt.Errorf("Check %s is already registered", check.Name)
+	}
+	r.checks = append(r.checks, &check)
+	r.byName[check.Name] = &check
+	return nil
+}
+
+// Declare declares the operation, with the checks which must pass before it
+func (r *Registry) Declare(operation string, checks ...string) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if _, ok := r.operations[operation]; ok {
+		return fmt.Errorf("Operation %s is already declared", operation)
+	}
+	r.operations[operation] = []string{}
+	return r.require(operation, checks)
+}
+
+// Require adds checks which must pass before the declared operation to the ones already
+// required
+func (r *Registry) Require(operation string, checks ...string) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if _, ok := r.operations[operation]; !ok {
+		return fmt.Errorf("Unknown operation %s", operation)
+	}
+	return r.require(operation, checks)
+}
+
+func (r *Registry) require(operation string, checks []string) error {
+	for _, name := range checks {
+		if _, ok := r.byName[name]; !ok {
+			return fmt.Errorf("Unknown check %s required by the %s", name, operation)
+		}
+		if !contains(r.operations[operation], name) {
+			r.operations[operation] = append(r.operations[operation], name)
+		}
+	}
+	return nil
+}
+
+// Operations returns the checks required by each operation
+func (r *Registry) Operations() map[string][]string {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	operations := make(map[string][]string, len(r.operations))
+	for operation, checks := range r.operations {
+		operations[operation] = append([]string{}, checks...)
+	}
+	return operations
+}
+
+// Names returns the names of the checks, in the order they were registered
+func (r *Registry) Names() []string {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	names := make([]string, 0, len(r.checks))
+	for _, check := range r.checks {
+		names = append(names, check.Name)
+	}
+	return names
+}
+
+// Run runs the checks, or all of them when none is given, at once, returning their results in
+// the order the checks were registered
+func (r *Registry) Run(ctx context.Context, excluded []string, names ...string) ([]*Result, error) {
+	r.mtx.Lock()
+	wanted := make(map[string]bool, len(names))
+	for _, name := range names {
+		if _, ok := r.byName[name]; !ok {
+			r.mtx.Unlock()
+			return nil, fmt.Errorf("Unknown check %s", name)
+		}
+		wanted[name] = true
+	}
+	checks := make([]*Check, 0, len(r.checks))
+	for _, check := range r.checks {
+		if len(names) == 0 || wanted[check.Name] {
+			checks = append(checks, check)
+		}
+	}
+	r.mtx.Unlock()
+
+	results := make([]*Result, len(checks))
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func(i int, check *Check) {
+			defer wg.Done()
+			results[i] = run(ctx, check, excluded)
+		}(i, check)
+	}
+	wg.Wait()
+	return results, nil
+}
+
+func run(ctx context.Context, check *Check, excluded []string) *Result {
+	result := &Result{Name: check.Name, Severity: check.Severity, Status: Passed}
+	if err := check.Run(ctx, excluded); err != nil {
+		result.Message = err.Error()
+		result.Status = Errored
+		if _, ok := err.(*Failure); ok {
+			result.Status = Failed
+		}
+	}
+	return result
+}
+
+// CheckOperation runs the checks required by the operation, failing with an OperationError
+// unless all of them passed
+func (r *Registry) CheckOperation(ctx context.Context, operation string, excluded ...string) error {
+	names := r.Operations()[operation]
+	if len(names) == 0 {
+		return nil
+	}
+	results, err := r.Run(ctx, excluded, names...)
+	if err != nil {
+		return err
+	}
+	failed := make([]*Result, 0)
+	for _, result := range results {
+		if result.Status != Passed {
+			failed = append(failed, result)
+		}
+	}
+	if len(failed) > 0 {
+		return &OperationError{Operation: operation, Results: failed}
+	}
+	return nil
+}
+
+// Healthy tells whether all the critical checks of the results passed
+func Healthy(results []*Result) bool {
+	for _, result := range results {
+		if result.Severity == Critical && result.Status != Passed {
+			return false
+		}
+	}
+	return true
+}
+
+func contains(list []string, item string) bool {
+	for _, i := range list {
+		if i == item {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,81 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func passing(ctx context.Context, excluded []string) error {
+	return nil
+}
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	assert.Nil(t, r.Register(Check{Name: "unreachable-nodes", Severity: Critical, Run: func(ctx context.Context, excluded []string) error {
+		if len(excluded) == 0 {
+			return Failf("Nodes 127.0.0.3 are unreachable")
+		}
+		return nil
+	}}))
+	assert.Nil(t, r.Register(Check{Name: "jolokia", Severity: Critical, Run: passing}))
+	assert.Nil(t, r.Register(Check{Name: "pending-compactions", Severity: Warning, Run: func(ctx context.Context, excluded []string) error {
+		return fmt.Errorf("connection refused")
+	}}))
+	assert.NotNil(t, r.Register(Check{Name: "jolokia", Severity: Warning, Run: passing}))
+	assert.NotNil(t, r.Register(Check{Name: "nameless"}))
+	assert.Equal(t, []string{"unreachable-nodes", "jolokia", "pending-compactions"}, r.Names())
+
+	results, err := r.Run(context.Background(), nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []*Result{
+		{Name: "unreachable-nodes", Severity: Critical, Status: Failed, Message: "Nodes 127.0.0.3 are unreachable"},
+		{Name: "jolokia", Severity: Critical, Status: Passed},
+		{Name: "pending-compactions", Severity: Warning, Status: Errored, Message: "connection refused"},
+	}, results)
+	assert.False(t, Healthy(results))
+	assert.True(t, Healthy(results[1:]))
+
+	// the results are in the order of the registration, whatever the order of the names
+	results, err = r.Run(context.Background(), nil, "jolokia", "unreachable-nodes")
+	assert.Nil(t, err)
+	if assert.Len(t, results, 2) {
+		assert.Equal(t, "unreachable-nodes", results[0].Name)
+	}
+	_, err = r.Run(context.Background(), nil, "unknown")
+	assert.NotNil(t, err)
+}
+
+func TestCheckOperation(t *testing.T) {
+	r := NewRegistry()
+	assert.Nil(t, r.Register(Check{Name: "unreachable-nodes", Severity: Critical, Run: func(ctx context.Context, excluded []string) error {
+		if len(excluded) == 0 {
+			return Failf("Nodes 127.0.0.3 are unreachable")
+		}
+		return nil
+	}}))
+	assert.Nil(t, r.Register(Check{Name: "hints", Severity: Warning, Run: func(ctx context.Context, excluded []string) error {
+		return Failf("3 hints are still in progress")
+	}}))
+	assert.Nil(t, r.Declare("backup"))
+	assert.Nil(t, r.Declare("restart", "unreachable-nodes"))
+	assert.NotNil(t, r.Declare("restart"))
+	assert.Nil(t, r.Require("restart", "hints", "unreachable-nodes"))
+	assert.NotNil(t, r.Require("restart", "unknown"))
+	assert.EqualError(t, r.Require("backups", "hints"), "Unknown operation backups")
+	assert.Equal(t, map[string][]string{"backup": {}, "restart": {"unreachable-nodes", "hints"}}, r.Operations())
+
+	assert.Nil(t, r.CheckOperation(context.Background(), "backup"))
+	err := r.CheckOperation(context.Background(), "restart")
+	if assert.IsType(t, &OperationError{}, err) {
+		assert.Len(t, err.(*OperationError).Results, 2)
+		assert.Equal(t, "The restart is not allowed, as checks did not pass: unreachable-nodes failed: "+
+			"Nodes 127.0.0.3 are unreachable; hints failed: 3 hints are still in progress", err.Error())
+	}
+	err = r.CheckOperation(context.Background(), "restart", "127.0.0.3")
+	if assert.IsType(t, &OperationError{}, err) {
+		assert.Len(t, err.(*OperationError).Results, 1)
+	}
+}
@@ -0,0 +1,124 @@
+package jolokia
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Resolve picks the signature of the operation taking the given number of arguments, and
+// returns the operation name to send. Jolokia needs the argument types in the name, like
+// takeSnapshot(java.lang.String,[Ljava.lang.String;), to tell overloaded signatures apart.
+func (ops MBeanOperations) Resolve(name string, argc int) (operation string, signature MBeanOperation, err error) {
+	signatures, ok := ops[name]
+	if !ok {
+		return "", MBeanOperation{}, fmt.Errorf("Operation '%s' not found", name)
+	}
+	matches := make([]MBeanOperation, 0, len(signatures))
+	for _, s := range signatures {
+		if len(s.Arguments) == argc {
+			matches = append(matches, s)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return "", MBeanOperation{}, fmt.Errorf("Operation '%s' does not take %d arguments, its signatures are %s",
+			name, argc, strings.Join(signatureNames(name, signatures), ", "))
+	case 1:
+		if len(signatures) == 1 {
+			return name, matches[0], nil
+		}
+		return matches[0].Signature(name), matches[0], nil
+	}
+	return "", MBeanOperation{}, fmt.Errorf("Operation '%s' has many signatures taking %d arguments: %s",
+		name, argc, strings.Join(signatureNames(name, matches), ", "))
+}
+
+// Signature returns the operation name followed by its argument types
+func (op MBeanOperation) Signature(name string) string {
+	types := make([]string, 0, len(op.Arguments))
+	for _, arg := range op.Arguments {
+		types = append(types, arg.Type)
+	}
+	return name + "(" + strings.Join(types, ",") + ")"
+}
+
+func signatureNames(name string, signatures []MBeanOperation) []string {
+	names := make([]string, 0, len(signatures))
+	for _, s := range signatures {
+		names = append(names, s.Signature(name))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// CoerceArguments converts the arguments given as strings, like in a command line, into the
+// types of the signature. Arrays and lists are given as comma separated values. Types with no
+// JSON counterpart are sent as strings, for the agent to convert them.
+func CoerceArguments(signature MBeanOperation, args []string) ([]interface{}, error) {
+	if len(args) != len(signature.Arguments) {
+		return nil, fmt.Errorf("Expected %d arguments, got %d", len(signature.Arguments), len(args))
+	}
+	coerced := make([]interface{}, 0, len(args))
+	for i, arg := range args {
+		value, err := coerceArgument(signature.Arguments[i].Type, arg)
+		if err != nil {
+			return nil, fmt.Errorf("Argument %d (%s): %s", i+1, signature.Arguments[i].Type, err)
+		}
+		coerced = append(coerced, value)
+	}
+	return coerced, nil
+}
+
+func coerceArgument(javaType, arg string) (interface{}, error) {
+	if elemType, ok := arrayElementType(javaType); ok {
+		if arg == "" {
+			return []interface{}{}, nil
+		}
+		values := make([]interface{}, 0)
+		for _, item := range strings.Split(arg, ",") {
+			value, err := coerceArgument(elemType, strings.TrimSpace(item))
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+		}
+		return values, nil
+	}
+
+	switch javaType {
+	case "int", "long", "short", "byte",
+		"java.lang.Integer", "java.lang.Long", "java.lang.Short", "java.lang.Byte":
+		return strconv.ParseInt(arg, 10, 64)
+	case "double", "float", "java.lang.Double", "java.lang.Float":
+		return strconv.ParseFloat(arg, 64)
+	case "boolean", "java.lang.Boolean":
+		return strconv.ParseBool(arg)
+	}
+	return arg, nil
+}
+
+// arrayElementType returns the type of the elements of array and collection types, which
+// Jolokia names like [I, [Ljava.lang.String; or java.util.List
+func arrayElementType(javaType string) (string, bool) {
+	switch javaType {
+	case "java.util.List", "java.util.Set", "java.util.Collection":
+		return "java.lang.String", true
+	}
+	if !strings.HasPrefix(javaType, "[") {
+		return "", false
+	}
+	elem := javaType[1:]
+	if strings.HasPrefix(elem, "L") && strings.HasSuffix(elem, ";") {
+		return elem[1 : len(elem)-1], true
+	}
+	primitives := map[string]string{
+		"I": "int", "J": "long", "S": "short", "B": "byte",
+		"D": "double", "F": "float", "Z": "boolean", "C": "char",
+	}
+	if primitive, ok := primitives[elem]; ok {
+		return primitive, true
+	}
+	return elem, true
+}
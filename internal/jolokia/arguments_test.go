@@ -0,0 +1,55 @@
+package jolokia
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveOperation(t *testing.T) {
+	ops := MBeanOperations{
+		"forceKeyspaceCleanup": {
+			{Arguments: []MBeanOperationArgument{{Type: "java.lang.String"}, {Type: "[Ljava.lang.String;"}}},
+			{Arguments: []MBeanOperationArgument{{Type: "int"}, {Type: "java.lang.String"}, {Type: "[Ljava.lang.String;"}}},
+		},
+		"getTokens": {
+			{Arguments: []MBeanOperationArgument{}},
+			{Arguments: []MBeanOperationArgument{{Type: "java.lang.String"}}},
+			{Arguments: []MBeanOperationArgument{{Type: "java.net.InetAddress"}}},
+		},
+		"drain": {{Arguments: []MBeanOperationArgument{}}},
+	}
+
+	operation, _, err := ops.Resolve("drain", 0)
+	assert.Nil(t, err)
+	assert.Equal(t, "drain", operation)
+
+	operation, signature, err := ops.Resolve("forceKeyspaceCleanup", 3)
+	assert.Nil(t, err)
+	assert.Equal(t, "forceKeyspaceCleanup(int,java.lang.String,[Ljava.lang.String;)", operation)
+	assert.Len(t, signature.Arguments, 3)
+
+	_, _, err = ops.Resolve("getTokens", 1)
+	assert.NotNil(t, err)
+	_, _, err = ops.Resolve("drain", 1)
+	assert.NotNil(t, err)
+	_, _, err = ops.Resolve("missing", 0)
+	assert.NotNil(t, err)
+}
+
+func TestCoerceArguments(t *testing.T) {
+	signature := MBeanOperation{Arguments: []MBeanOperationArgument{
+		{Type: "int"}, {Type: "java.lang.String"}, {Type: "[Ljava.lang.String;"},
+		{Type: "boolean"}, {Type: "double"}, {Type: "[J"},
+	}}
+	args, err := CoerceArguments(signature, []string{"2", "ks", "t1, t2", "true", "1.5", "1,2"})
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{
+		int64(2), "ks", []interface{}{"t1", "t2"}, true, 1.5, []interface{}{int64(1), int64(2)},
+	}, args)
+
+	_, err = CoerceArguments(signature, []string{"x", "ks", "", "true", "1.5", ""})
+	assert.NotNil(t, err)
+	_, err = CoerceArguments(signature, []string{"2"})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,143 @@
+package jolokia
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// Batch accumulates read, search, write, exec and version requests, to send them all to the agent in a
+// single HTTP request. Each request decodes its value into its own target, and gets its
+// own error, so a failing request does not fail the others.
+type Batch struct {
+	results []*BatchResult
+}
+
+// BatchResult is the outcome of one of the requests of a batch
+type BatchResult struct {
+	Request  Request
+	Response Response
+	target   interface{}
+	err      error
+}
+
+// Err returns the error of the request, which is only set once the batch is sent
+func (br *BatchResult) Err() error {
+	return br.err
+}
+
+// batchValueDecoder is implemented by targets which need the request to decode the value
+type batchValueDecoder interface {
+	decodeBatchValue(request Request, value json.RawMessage) error
+}
+
+// NewBatch builds a new empty batch of requests
+func NewBatch() *Batch {
+	return &Batch{results: make([]*BatchResult, 0)}
+}
+
+// Len returns the number of requests in the batch
+func (b *Batch) Len() int {
+	return len(b.results)
+}
+
+func (b *Batch) add(request Request, target interface{}) *BatchResult {
+	result := &BatchResult{Request: request, target: target}
+	b.results = append(b.results, result)
+	return result
+}
+
+// Read adds the read of an MBean attribute, decoded into the target
+func (b *Batch) Read(target interface{}, mbean, attribute string) *BatchResult {
+	return b.add(Request{Type: "read", MBean: mbean, Attribute: attribute}, target)
+}
+
+// ReadMBeans adds the read of the given attributes, or all of them if none is given, of all
+// the MBeans matching the pattern. Attributes that can not be read are skipped.
+func (b *Batch) ReadMBeans(target *MBeansValue, pattern string, attributes ...string) *BatchResult {
+	request := Request{Type: "read", MBean: pattern, Config: map[string]interface{}{"ignoreErrors": true}}
+	if len(attributes) > 0 {
+		request.Attribute = attributes
+	}
+	return b.add(request, target)
+}
+
+// Exec adds the execution of an MBean operation, whose return value is decoded into the
+// target, unless it is nil
+func (b *Batch) Exec(target interface{}, mbean, operation string, args ...interface{}) *BatchResult {
+	return b.add(Request{Type: "exec", MBean: mbean, Operation: operation, Arguments: args}, target)
+}
+
+// Search adds the search of the names of the MBeans matching the pattern
+func (b *Batch) Search(target *[]string, pattern string) *BatchResult {
+	return b.add(Request{Type: "search", MBean: pattern}, target)
+}
+
+// Write adds the write of an MBean attribute, whose previous value is decoded into the
+// target, unless it is nil
+func (b *Batch) Write(target interface{}, mbean, attribute string, value interface{}) *BatchResult {
+	return b.add(Request{Type: "write", MBean: mbean, Attribute: attribute, Value: value}, target)
+}
+
+// Version adds the request of the agent version
+func (b *Batch) Version(target *VersionResponseValue) *BatchResult {
+	return b.add(Request{Type: "version"}, target)
+}
+
+// SendBatch sends all the requests of the batch in a single HTTP request, and decodes their
+// responses. The error returned is about the HTTP request, and is also set on every request.
+func (c Client) SendBatch(ctx context.Context, b *Batch) error {
+	if len(b.results) == 0 {
+		return nil
+	}
+	err := c.sendBatch(ctx, b)
+	if err != nil {
+		for _, result := range b.results {
+			result.err = err
+		}
+	}
+	return err
+}
+
+func (c Client) sendBatch(ctx context.Context, b *Batch) error {
+	requests := make([]Request, 0, len(b.results))
+	idempotent := true
+	for _, result := range b.results {
+		requests = append(requests, result.Request)
+		idempotent = idempotent && result.Request.Type != "exec" && result.Request.Type != "write"
+	}
+
+	// batches without execs and writes can be retried
+	responses := make([]struct {
+		Response
+		Value json.RawMessage `json:"value,omitempty"`
+	}, 0, len(requests))
+	if err := c.do(ctx, "POST", "/", requests, &responses, idempotent); err != nil {
+		return err
+	}
+	if len(responses) != len(requests) {
+		return fmt.Errorf("Got %d responses for a batch of %d requests", len(responses), len(requests))
+	}
+
+	for i, result := range b.results {
+		result.Response = responses[i].Response
+		result.err = result.decode(responses[i].Value)
+	}
+	return nil
+}
+
+func (br *BatchResult) decode(value json.RawMessage) error {
+	if err := br.Response.Error(); err != nil {
+		return err
+	}
+	if br.Response.Status != 0 && br.Response.Status != 200 {
+		return fmt.Errorf("Jolokia request failed with status %d", br.Response.Status)
+	}
+	if br.target == nil || len(value) == 0 {
+		return nil
+	}
+	if decoder, ok := br.target.(batchValueDecoder); ok {
+		return decoder.decodeBatchValue(br.Request, value)
+	}
+	return json.Unmarshal(value, br.target)
+}
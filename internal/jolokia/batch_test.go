@@ -0,0 +1,60 @@
+package jolokia
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSendBatch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests := make([]Request, 0)
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&requests))
+		assert.Len(t, requests, 4)
+		w.Write([]byte(`[
+			{"status": 200, "request": {"type": "read", "mbean": "a:type=A", "attribute": "Nodes"}, "value": ["10.0.0.1"]},
+			{"status": 404, "request": {"type": "read", "mbean": "a:type=B", "attribute": "Nodes"},
+			 "error_type": "javax.management.InstanceNotFoundException", "error": "a:type=B"},
+			{"status": 200, "request": {"type": "exec", "mbean": "a:type=A", "operation": "size"}, "value": 42},
+			{"status": 200, "request": {"type": "read", "mbean": "a:type=A"}, "value": {"Mode": "NORMAL"}}
+		]`))
+	}))
+	defer server.Close()
+	baseURL, _ := url.Parse(server.URL)
+	client := NewClient(*http.DefaultClient, *baseURL)
+
+	var nodes, missing []string
+	var size uint64
+	var mbeans MBeansValue
+	batch := NewBatch()
+	nodesResult := batch.Read(&nodes, "a:type=A", "Nodes")
+	missingResult := batch.Read(&missing, "a:type=B", "Nodes")
+	sizeResult := batch.Exec(&size, "a:type=A", "size")
+	mbeansResult := batch.ReadMBeans(&mbeans, "a:type=A")
+
+	assert.Nil(t, client.SendBatch(context.Background(), batch))
+	assert.Nil(t, nodesResult.Err())
+	assert.Equal(t, []string{"10.0.0.1"}, nodes)
+	assert.EqualError(t, missingResult.Err(), "a:type=B")
+	assert.Nil(t, missing)
+	assert.Nil(t, sizeResult.Err())
+	assert.Equal(t, uint64(42), size)
+	assert.Nil(t, mbeansResult.Err())
+	assert.Equal(t, MBeansValue{"a:type=A": {"Mode": "NORMAL"}}, mbeans)
+}
+
+func TestSendBatchHTTPError(t *testing.T) {
+	baseURL, _ := url.Parse("http://127.0.0.1:1/jolokia")
+	client := NewClient(*http.DefaultClient, *baseURL)
+	var version VersionResponseValue
+	batch := NewBatch()
+	result := batch.Version(&version)
+	err := client.SendBatch(context.Background(), batch)
+	assert.NotNil(t, err)
+	assert.Equal(t, err, result.Err())
+}
@@ -0,0 +1,122 @@
+package jolokia
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Default connection settings, used when the Config leaves them unset
+const (
+	DefaultTimeout         = 10 * time.Second
+	DefaultMaxIdleConns    = 2
+	DefaultMaxConns        = 4
+	DefaultIdleConnTimeout = 90 * time.Second
+	DefaultReadRetries     = 2
+	DefaultRetryBackoff    = 500 * time.Millisecond
+)
+
+// Config holds the settings of a Jolokia client. Unset settings get the defaults, and a
+// negative number of read retries disables retries.
+type Config struct {
+	URL      string
+	Username string
+	Password string
+
+	// CAFile is a PEM bundle used instead of the system CAs to verify an HTTPS agent
+	CAFile             string
+	InsecureSkipVerify bool
+
+	// Timeout limits each HTTP request, including reading its response
+	Timeout         time.Duration
+	MaxIdleConns    int
+	MaxConns        int
+	IdleConnTimeout time.Duration
+
+	// ReadRetries is how many times reads are retried, waiting RetryBackoff before the first
+	// retry, and twice as long before each of the following ones
+	ReadRetries  int
+	RetryBackoff time.Duration
+
+	// ProxyTargetURL, when set, is the JMX service URL of the node, like the one returned by
+	// JMXServiceURL, to which the agent at URL forwards the requests, acting as a proxy
+	ProxyTargetURL      string
+	ProxyTargetUsername string
+	ProxyTargetPassword string
+}
+
+func (config Config) withDefaults() Config {
+	if config.Timeout == 0 {
+		config.Timeout = DefaultTimeout
+	}
+	if config.MaxIdleConns == 0 {
+		config.MaxIdleConns = DefaultMaxIdleConns
+	}
+	if config.MaxConns == 0 {
+		config.MaxConns = DefaultMaxConns
+	}
+	if config.IdleConnTimeout == 0 {
+		config.IdleConnTimeout = DefaultIdleConnTimeout
+	}
+	if config.ReadRetries == 0 {
+		config.ReadRetries = DefaultReadRetries
+	}
+	if config.RetryBackoff == 0 {
+		config.RetryBackoff = DefaultRetryBackoff
+	}
+	return config
+}
+
+// NewClientFromConfig builds a new Jolokia Agent client object, with its own connection pool
+func NewClientFromConfig(config Config) (Client, error) {
+	config = config.withDefaults()
+	baseURL, err := url.Parse(config.URL)
+	if err != nil {
+		return Client{}, err
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: config.InsecureSkipVerify}
+	if config.CAFile != "" {
+		pem, err := ioutil.ReadFile(config.CAFile)
+		if err != nil {
+			return Client{}, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return Client{}, fmt.Errorf("No certificates found in %s", config.CAFile)
+		}
+	}
+
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   config.Timeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSClientConfig:     tlsConfig,
+		TLSHandshakeTimeout: config.Timeout,
+		MaxIdleConns:        config.MaxIdleConns,
+		MaxIdleConnsPerHost: config.MaxIdleConns,
+		MaxConnsPerHost:     config.MaxConns,
+		IdleConnTimeout:     config.IdleConnTimeout,
+	}
+
+	client := NewClient(http.Client{Transport: transport, Timeout: config.Timeout}, *baseURL)
+	client.username = config.Username
+	client.password = config.Password
+	client.readRetries = config.ReadRetries
+	client.retryBackoff = config.RetryBackoff
+	if config.ProxyTargetURL != "" {
+		client = client.WithProxyTarget(ProxyTarget{
+			URL:      config.ProxyTargetURL,
+			User:     config.ProxyTargetUsername,
+			Password: config.ProxyTargetPassword,
+		})
+	}
+	return client, nil
+}
@@ -0,0 +1,142 @@
+package jolokia
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+)
+
+var pathEscaper = strings.NewReplacer("!", "!!", "/", "!/", `"`, `!"`)
+
+// EscapePath escapes a part of a Jolokia path, like an MBean name or an attribute, so the
+// slashes, exclamation marks and quotes it may contain are not taken as path separators
+func EscapePath(part string) string {
+	return pathEscaper.Replace(part)
+}
+
+// mbeanPath converts an MBean name into the domain/properties path used by list requests
+func mbeanPath(mbean string) string {
+	parts := strings.SplitN(mbean, ":", 2)
+	if len(parts) == 1 {
+		return EscapePath(parts[0])
+	}
+	return EscapePath(parts[0]) + "/" + EscapePath(parts[1])
+}
+
+// ParseMBeanName splits an MBean name, like org.apache.cassandra.db:type=Tables,keyspace=ks,table=t,
+// into its domain and its key properties. Quoted values are unquoted.
+func ParseMBeanName(mbean string) (domain string, properties map[string]string) {
+	properties = make(map[string]string)
+	parts := strings.SplitN(mbean, ":", 2)
+	if len(parts) != 2 {
+		return mbean, properties
+	}
+	for _, pair := range strings.Split(parts[1], ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) == 2 {
+			properties[kv[0]] = strings.Trim(kv[1], `"`)
+		}
+	}
+	return parts[0], properties
+}
+
+// MBeanOperations maps operation names to their signatures. Overloaded operations have many.
+type MBeanOperations map[string][]MBeanOperation
+
+// UnmarshalJSON handles Jolokia listing overloaded operations as an array of signatures,
+// and the other ones as a single signature
+func (ops *MBeanOperations) UnmarshalJSON(buf []byte) error {
+	raw := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(buf, &raw); err != nil {
+		return err
+	}
+	*ops = make(MBeanOperations, len(raw))
+	for name, value := range raw {
+		if strings.HasPrefix(strings.TrimSpace(string(value)), "[") {
+			signatures := make([]MBeanOperation, 0)
+			if err := json.Unmarshal(value, &signatures); err != nil {
+				return err
+			}
+			(*ops)[name] = signatures
+			continue
+		}
+		signature := MBeanOperation{}
+		if err := json.Unmarshal(value, &signature); err != nil {
+			return err
+		}
+		(*ops)[name] = []MBeanOperation{signature}
+	}
+	return nil
+}
+
+// List returns the MBeans tree, keyed by domain and then by the MBean properties. When a
+// domain is given, only its MBeans are listed.
+func (c Client) List(ctx context.Context, domain string) (ListResponseValue, error) {
+	if domain == "" {
+		lr := &ListResponse{}
+		if err := c.do(ctx, "POST", "/", &Request{Type: "list"}, lr, true); err != nil {
+			return nil, err
+		}
+		if err := lr.Error(); err != nil {
+			return nil, err
+		}
+		return lr.Value, nil
+	}
+	request := &Request{Type: "list", Path: EscapePath(domain)}
+	pr := &struct {
+		Response
+		Value MBeanPackage `json:"value,omitempty"`
+	}{}
+	if err := c.do(ctx, "POST", "/", request, pr, true); err != nil {
+		return nil, err
+	}
+	if err := pr.Error(); err != nil {
+		return nil, err
+	}
+	return ListResponseValue{domain: pr.Value}, nil
+}
+
+// ListMBean returns the attributes and operations of an MBean
+func (c Client) ListMBean(ctx context.Context, mbean string) (*MBean, error) {
+	request := &Request{Type: "list", Path: mbeanPath(mbean)}
+	mr := &struct {
+		Response
+		Value MBean `json:"value,omitempty"`
+	}{}
+	if err := c.do(ctx, "POST", "/", request, mr, true); err != nil {
+		return nil, err
+	}
+	if err := mr.Error(); err != nil {
+		return nil, err
+	}
+	return &mr.Value, nil
+}
+
+// Search returns the names of the MBeans matching the pattern
+func (c Client) Search(ctx context.Context, pattern string) ([]string, error) {
+	request := &Request{Type: "search", MBean: pattern}
+	vr := &StringListValueResponse{}
+	if err := c.do(ctx, "POST", "/", request, vr, true); err != nil {
+		return nil, err
+	}
+	if err := vr.Error(); err != nil {
+		return nil, err
+	}
+	return vr.Value, nil
+}
+
+// Write sets the value of an MBean attribute, and returns its previous value
+func (c Client) Write(ctx context.Context, mbean, attribute string, value interface{}) (previous interface{}, err error) {
+	request := &Request{Type: "write", MBean: mbean, Attribute: attribute, Value: value}
+	vr := &struct {
+		Response
+		Value interface{} `json:"value,omitempty"`
+	}{}
+	if err := c.do(ctx, "POST", "/", request, vr, false); err != nil {
+		return nil, err
+	}
+	if err := vr.Error(); err != nil {
+		return nil, err
+	}
+	return vr.Value, nil
+}
@@ -0,0 +1,55 @@
+package jolokia
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEscapePath(t *testing.T) {
+	assert.Equal(t, "org.apache.cassandra.db:type=StorageService", EscapePath("org.apache.cassandra.db:type=StorageService"))
+	assert.Equal(t, `a:path=!/var!/lib,name=!"x!!!"`, EscapePath(`a:path=/var/lib,name="x!"`))
+	assert.Equal(t, "a!/b/type=X,name=c!/d", mbeanPath("a/b:type=X,name=c/d"))
+}
+
+func TestParseMBeanName(t *testing.T) {
+	domain, properties := ParseMBeanName(`org.apache.cassandra.metrics:type=Table,keyspace=ks,scope="t",name=ReadLatency`)
+	assert.Equal(t, "org.apache.cassandra.metrics", domain)
+	assert.Equal(t, map[string]string{"type": "Table", "keyspace": "ks", "scope": "t", "name": "ReadLatency"}, properties)
+}
+
+func TestListMBeanWithOverloadedOperations(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request := Request{}
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&request))
+		assert.Equal(t, "list", request.Type)
+		assert.Equal(t, "org.apache.cassandra.db/type=StorageService", request.Path)
+		w.Write([]byte(`{"status": 200, "value": {
+			"desc": "StorageService",
+			"attr": {"OperationMode": {"desc": "mode", "rw": false, "type": "java.lang.String"}},
+			"op": {
+				"drain": {"desc": "drain", "ret": "void", "args": []},
+				"takeSnapshot": [
+					{"desc": "", "ret": "void", "args": [{"name": "p1", "type": "java.lang.String", "desc": ""}]},
+					{"desc": "", "ret": "void", "args": [
+						{"name": "p1", "type": "java.lang.String", "desc": ""},
+						{"name": "p2", "type": "[Ljava.lang.String;", "desc": ""}]}
+				]
+			}
+		}}`))
+	}))
+	defer server.Close()
+	baseURL, _ := url.Parse(server.URL)
+
+	mbean, err := NewClient(*http.DefaultClient, *baseURL).ListMBean(context.Background(), "org.apache.cassandra.db:type=StorageService")
+	assert.Nil(t, err)
+	assert.Equal(t, "java.lang.String", mbean.Attributes["OperationMode"].Type)
+	assert.Len(t, mbean.Operations["drain"], 1)
+	assert.Len(t, mbean.Operations["takeSnapshot"], 2)
+	assert.Equal(t, "[Ljava.lang.String;", mbean.Operations["takeSnapshot"][1].Arguments[1].Type)
+}
@@ -2,17 +2,29 @@ package jolokia
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
 )
 
 // Client implements a Jolokia client inspired by the official Java client
 type Client struct {
-	httpClient http.Client
-	baseURL    url.URL
+	httpClient   http.Client
+	baseURL      url.URL
+	username     string
+	password     string
+	readRetries  int
+	retryBackoff time.Duration
+	target       *ProxyTarget
 }
 
 // NewClient builds a new Jolokia Agent client object
@@ -20,112 +32,163 @@ func NewClient(httpClient http.Client, baseURL url.URL) Client {
 	return Client{httpClient: httpClient, baseURL: baseURL}
 }
 
+// WithTimeout returns a copy of the client whose HTTP requests are limited by the timeout,
+// instead of the configured one. A zero timeout leaves them only limited by their context,
+// for the operations which take long, like a drain.
+func (c Client) WithTimeout(timeout time.Duration) Client {
+	c.httpClient.Timeout = timeout
+	return c
+}
+
 func (c Client) getURL(path string) string {
 	url := c.baseURL
 	url.Path += path
 	return url.String()
 }
 
-// ReadInto ...
-func (c Client) ReadInto(mbean string, response ValueResponse) (err error) {
-	resp, err := c.httpClient.Get(c.getURL("/read/" + mbean))
-	if err != nil {
-		return err
+// do sends the request and decodes the JSON response into the target. Idempotent requests are
+// retried, with an exponential backoff, when the agent can not be reached or fails with a 5xx.
+func (c Client) do(ctx context.Context, method, path string, body interface{}, target interface{}, idempotent bool) error {
+	var payload []byte
+	if body != nil {
+		body = c.withTarget(body)
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return err
+		}
 	}
-	if err := response.DecodeJSON(resp.Body); err != nil {
-		return err
+
+	retries := 0
+	if idempotent && c.readRetries > 0 {
+		retries = c.readRetries
 	}
-	if err := response.Error(); err != nil {
-		return err
+	backoff := c.retryBackoff
+	for attempt := 0; ; attempt++ {
+		retryable, err := c.doOnce(ctx, method, path, payload, target)
+		if err == nil || !retryable || attempt >= retries {
+			return err
+		}
+		logrus.Warnf("Jolokia request %s %s failed (attempt %d of %d), retrying in %s: %s",
+			method, path, attempt+1, retries+1, backoff, err)
+		select {
+		case <-time.After(backoff):
+			backoff *= 2
+		case <-ctx.Done():
+			return ctx.Err()
+		}
 	}
-	return
 }
 
-// ReadStringList ...
-func (c Client) ReadStringList(mbean string) (vr *StringListValueResponse, err error) {
-	resp, err := c.httpClient.Get(c.getURL("/read/" + mbean))
+func (c Client) doOnce(ctx context.Context, method, path string, payload []byte, target interface{}) (retryable bool, err error) {
+	var body io.Reader
+	if payload != nil {
+		body = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequest(method, c.getURL(path), body)
 	if err != nil {
-		return nil, err
+		return false, err
 	}
-	dec := json.NewDecoder(resp.Body)
-	if err := dec.Decode(&vr); err != nil {
-		return nil, err
+	req = req.WithContext(ctx)
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
 	}
-	if err := vr.Error(); err != nil {
-		return nil, err
+	if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
 	}
-	return
-}
 
-// ReadString ...
-func (c Client) ReadString(mbean string) (vr *StringValueResponse, err error) {
-	resp, err := c.httpClient.Get(c.getURL("/read/" + mbean))
+	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return nil, err
+		return ctx.Err() == nil, err
 	}
-	dec := json.NewDecoder(resp.Body)
-	if err := dec.Decode(&vr); err != nil {
-		return nil, err
+	defer func() {
+		// drain what is left, so the connection can be reused
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests,
+			fmt.Errorf("Jolokia agent responded with HTTP status %s", resp.Status)
 	}
-	if err := vr.Error(); err != nil {
-		return nil, err
+	if decoder, ok := target.(ValueResponse); ok {
+		return false, decoder.DecodeJSON(resp.Body)
 	}
-	return
+	return false, json.NewDecoder(resp.Body).Decode(target)
 }
 
-// ReadStringMapString ...
-func (c Client) ReadStringMapString(mbean string) (vr *StringMapStringValueResponse, err error) {
-	resp, err := c.httpClient.Get(c.getURL("/read/" + mbean))
-	if err != nil {
-		return nil, err
+func (c Client) read(ctx context.Context, mbean, attribute string, response AnyResponse) error {
+	if c.target != nil {
+		// the target can only be given in the body of POST requests
+		request := &Request{Type: "read", MBean: mbean}
+		if attribute != "" {
+			request.Attribute = attribute
+		}
+		if err := c.do(ctx, "POST", "/", request, response, true); err != nil {
+			return err
+		}
+		return response.Error()
 	}
-	dec := json.NewDecoder(resp.Body)
-	if err := dec.Decode(&vr); err != nil {
-		return nil, err
+	path := "/read/" + EscapePath(mbean)
+	if attribute != "" {
+		path += "/" + EscapePath(attribute)
+	}
+	if err := c.do(ctx, "GET", path, nil, response, true); err != nil {
+		return err
 	}
-	if err := vr.Error(); err != nil {
+	return response.Error()
+}
+
+// ReadInto ...
+func (c Client) ReadInto(ctx context.Context, mbean, attribute string, response ValueResponse) error {
+	return c.read(ctx, mbean, attribute, response)
+}
+
+// ReadStringList ...
+func (c Client) ReadStringList(ctx context.Context, mbean, attribute string) (vr *StringListValueResponse, err error) {
+	vr = &StringListValueResponse{}
+	if err := c.read(ctx, mbean, attribute, vr); err != nil {
 		return nil, err
 	}
 	return
 }
 
-// ReadBool ...
-func (c Client) ReadBool(mbean string) (vr *BoolValueResponse, err error) {
-	resp, err := c.httpClient.Get(c.getURL("/read/" + mbean))
-	if err != nil {
+// ReadString ...
+func (c Client) ReadString(ctx context.Context, mbean, attribute string) (vr *StringValueResponse, err error) {
+	vr = &StringValueResponse{}
+	if err := c.read(ctx, mbean, attribute, vr); err != nil {
 		return nil, err
 	}
-	dec := json.NewDecoder(resp.Body)
-	if err := dec.Decode(&vr); err != nil {
+	return
+}
+
+// ReadStringMapString ...
+func (c Client) ReadStringMapString(ctx context.Context, mbean, attribute string) (vr *StringMapStringValueResponse, err error) {
+	vr = &StringMapStringValueResponse{}
+	if err := c.read(ctx, mbean, attribute, vr); err != nil {
 		return nil, err
 	}
-	if err := vr.Error(); err != nil {
+	return
+}
+
+// ReadBool ...
+func (c Client) ReadBool(ctx context.Context, mbean, attribute string) (vr *BoolValueResponse, err error) {
+	vr = &BoolValueResponse{}
+	if err := c.read(ctx, mbean, attribute, vr); err != nil {
 		return nil, err
 	}
 	return
 }
 
 // Exec ...
-func (c Client) Exec(mbean, operation string, args ...interface{}) (r *Response, err error) {
+func (c Client) Exec(ctx context.Context, mbean, operation string, args ...interface{}) (r *Response, err error) {
 	request := &Request{
 		Type:      "exec",
 		MBean:     mbean,
 		Operation: operation,
 		Arguments: args,
 	}
-
-	jsonBytes, err := json.Marshal(request)
-	if err != nil {
-		return nil, err
-	}
-	buffer := bytes.NewBuffer(jsonBytes)
-	resp, err := c.httpClient.Post(c.getURL("/"), "application/json", buffer)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+	r = &Response{}
+	if err := c.do(ctx, "POST", "/", request, r, false); err != nil {
 		return nil, err
 	}
 	if err := r.Error(); err != nil {
@@ -135,32 +198,17 @@ func (c Client) Exec(mbean, operation string, args ...interface{}) (r *Response,
 }
 
 // ExecInto ...
-func (c Client) ExecInto(response ValueResponse, mbean, operation string, args ...interface{}) error {
+func (c Client) ExecInto(ctx context.Context, response ValueResponse, mbean, operation string, args ...interface{}) error {
 	request := &Request{
 		Type:      "exec",
 		MBean:     mbean,
 		Operation: operation,
 		Arguments: args,
 	}
-
-	jsonBytes, err := json.Marshal(request)
-	if err != nil {
+	if err := c.do(ctx, "POST", "/", request, response, false); err != nil {
 		return err
 	}
-	buffer := bytes.NewBuffer(jsonBytes)
-	resp, err := c.httpClient.Post(c.getURL("/"), "application/json", buffer)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	if err := response.DecodeJSON(resp.Body); err != nil {
-		return err
-	}
-	if err := response.Error(); err != nil {
-		return err
-	}
-	return nil
+	return response.Error()
 }
 
 // StringMapStringValueResponse contains the response envelop and a string list value
@@ -196,6 +244,28 @@ func (vr *Uint64ValueResponse) DecodeJSON(r io.Reader) error {
 	return nil
 }
 
+// MBeansValue holds the attributes of each MBean read, keyed by the MBean name
+type MBeansValue map[string]map[string]interface{}
+
+// decodeBatchValue decodes the value of a read. Reads of a single MBean, instead of a pattern,
+// get a plain map of attributes, which is keyed by the MBean name like the others.
+func (v *MBeansValue) decodeBatchValue(request Request, value json.RawMessage) error {
+	if IsPattern(request.MBean) {
+		return json.Unmarshal(value, v)
+	}
+	attributes := make(map[string]interface{})
+	if err := json.Unmarshal(value, &attributes); err != nil {
+		return err
+	}
+	*v = MBeansValue{request.MBean: attributes}
+	return nil
+}
+
+// IsPattern returns whether the MBean name is a pattern, which may match many MBeans
+func IsPattern(mbean string) bool {
+	return strings.ContainsAny(mbean, "*?")
+}
+
 // BoolValueResponse contains the response envelop and a boolean value
 type BoolValueResponse struct {
 	Response
@@ -213,10 +283,22 @@ type ListResponse struct {
 
 // Request ...
 type Request struct {
-	Type      string        `json:"type,omitempty"`
-	MBean     string        `json:"mbean,omitempty"`
-	Operation string        `json:"operation,omitempty"`
-	Arguments []interface{} `json:"arguments,omitempty"`
+	Type      string                 `json:"type,omitempty"`
+	MBean     string                 `json:"mbean,omitempty"`
+	Attribute interface{}            `json:"attribute,omitempty"`
+	Operation string                 `json:"operation,omitempty"`
+	Arguments []interface{}          `json:"arguments,omitempty"`
+	Value     interface{}            `json:"value,omitempty"`
+	Path      string                 `json:"path,omitempty"`
+	Config    map[string]interface{} `json:"config,omitempty"`
+	Target    *ProxyTarget           `json:"target,omitempty"`
+	// the fields of the notification requests
+	Command  string      `json:"command,omitempty"`
+	Client   string      `json:"client,omitempty"`
+	Mode     string      `json:"mode,omitempty"`
+	Handle   string      `json:"handle,omitempty"`
+	Filter   []string    `json:"filter,omitempty"`
+	Handback interface{} `json:"handback,omitempty"`
 }
 
 // Response represents a Jolokia response envelope
@@ -275,13 +357,12 @@ type VersionResponse struct {
 }
 
 // Version ...
-func (c Client) Version() (versionResp *VersionResponse, err error) {
-	resp, err := c.httpClient.Get(c.getURL("/version"))
-	if err != nil {
+func (c Client) Version(ctx context.Context) (versionResp *VersionResponse, err error) {
+	versionResp = &VersionResponse{}
+	if err := c.do(ctx, "GET", "/version", nil, versionResp, true); err != nil {
 		return nil, err
 	}
-	dec := json.NewDecoder(resp.Body)
-	if err := dec.Decode(&versionResp); err != nil {
+	if err := versionResp.Error(); err != nil {
 		return nil, err
 	}
 	return
@@ -0,0 +1,70 @@
+package jolokia
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientRetriesReads(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		user, pass, ok := r.BasicAuth()
+		assert.True(t, ok)
+		assert.Equal(t, "jolokia", user)
+		assert.Equal(t, "secret", pass)
+		if calls < 3 {
+			http.Error(w, "GC pause", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"status": 200, "value": "NORMAL"}`))
+	}))
+	defer server.Close()
+
+	client, err := NewClientFromConfig(Config{
+		URL:          server.URL + "/jolokia",
+		Username:     "jolokia",
+		Password:     "secret",
+		ReadRetries:  2,
+		RetryBackoff: time.Millisecond,
+	})
+	assert.Nil(t, err)
+	vr, err := client.ReadString(context.Background(), "org.apache.cassandra.db:type=StorageService", "OperationMode")
+	assert.Nil(t, err)
+	assert.Equal(t, "NORMAL", vr.Value)
+	assert.Equal(t, 3, calls)
+}
+
+func TestClientDoesNotRetryExecs(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		http.Error(w, "GC pause", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	client, err := NewClientFromConfig(Config{URL: server.URL, RetryBackoff: time.Millisecond})
+	assert.Nil(t, err)
+	_, err = client.Exec(context.Background(), "org.apache.cassandra.db:type=StorageService", "forceKeyspaceFlush")
+	assert.EqualError(t, err, "Jolokia agent responded with HTTP status 503 Service Unavailable")
+	assert.Equal(t, 1, calls)
+}
+
+func TestClientStopsRetryingWhenCancelled(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "GC pause", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	client, err := NewClientFromConfig(Config{URL: server.URL, ReadRetries: 5, RetryBackoff: time.Hour})
+	assert.Nil(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = client.Version(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
@@ -7,7 +7,7 @@ type MBeanPackage map[string]MBean
 type MBean struct {
 	Attributes  map[string]MBeanAttribute `json:"attr"`
 	Description string                    `json:"desc"`
-	Operations  map[string]MBeanOperation `json:"op"`
+	Operations  MBeanOperations           `json:"op"`
 }
 
 // MBeanAttribute ...
@@ -0,0 +1,123 @@
+package jolokia
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// NotificationStore is the MBean of the agent holding the notifications of the pull mode
+// listeners, until they are pulled
+const NotificationStore = "jolokia:type=NotificationStore"
+
+// ErrNotificationsThroughProxy is returned when registering for notifications through an
+// agent acting as a proxy, which does not support them
+var ErrNotificationsThroughProxy = errors.New("JMX notifications are not supported through a Jolokia proxy")
+
+// Notification is a JMX notification received by a pull mode listener
+type Notification struct {
+	TimeStamp      int64           `json:"timeStamp"`
+	SequenceNumber int64           `json:"sequenceNumber"`
+	Type           string          `json:"type"`
+	Message        string          `json:"message,omitempty"`
+	Source         interface{}     `json:"source,omitempty"`
+	UserData       json.RawMessage `json:"userData,omitempty"`
+}
+
+// Time returns the time the notification was emitted at
+func (n Notification) Time() time.Time {
+	return time.Unix(0, n.TimeStamp*int64(time.Millisecond))
+}
+
+// PullResult holds the notifications received by a listener since its last pull
+type PullResult struct {
+	Handle        string         `json:"handle"`
+	Dropped       int64          `json:"dropped"`
+	Notifications []Notification `json:"notifications"`
+}
+
+// NotificationClient is a client registered on the agent for notifications, which keeps its
+// listeners until it is unregistered, or it stops pinging the agent for too long
+type NotificationClient struct {
+	client Client
+	id     string
+}
+
+// RegisterNotifications registers a new notification client on the agent. Requires an agent
+// of version 1.2 or later, running in the JVM of the node.
+func (c Client) RegisterNotifications(ctx context.Context) (*NotificationClient, error) {
+	if c.target != nil {
+		return nil, ErrNotificationsThroughProxy
+	}
+	value := &struct {
+		ID string `json:"id"`
+	}{}
+	if err := c.sendNotificationRequest(ctx, &Request{Type: "notification", Command: "register"}, value); err != nil {
+		return nil, err
+	}
+	if value.ID == "" {
+		return nil, fmt.Errorf("The agent did not return the id of the notification client")
+	}
+	return &NotificationClient{client: c, id: value.ID}, nil
+}
+
+func (c Client) sendNotificationRequest(ctx context.Context, request *Request, target interface{}) error {
+	vr := &struct {
+		Response
+		Value json.RawMessage `json:"value,omitempty"`
+	}{}
+	if err := c.do(ctx, "POST", "/", request, vr, false); err != nil {
+		return err
+	}
+	if err := vr.Error(); err != nil {
+		return err
+	}
+	if target == nil || len(vr.Value) == 0 {
+		return nil
+	}
+	return json.Unmarshal(vr.Value, target)
+}
+
+// ID returns the id given to the client by the agent
+func (nc *NotificationClient) ID() string {
+	return nc.id
+}
+
+// AddListener adds a pull mode listener for the notifications of the MBean, of the given
+// types or all of them if none is given, and returns its handle
+func (nc *NotificationClient) AddListener(ctx context.Context, mbean string, types ...string) (string, error) {
+	request := &Request{Type: "notification", Command: "add", Client: nc.id, Mode: "pull", MBean: mbean, Filter: types}
+	var handle string
+	if err := nc.client.sendNotificationRequest(ctx, request, &handle); err != nil {
+		return "", err
+	}
+	return handle, nil
+}
+
+// RemoveListener removes the listener with the handle
+func (nc *NotificationClient) RemoveListener(ctx context.Context, handle string) error {
+	request := &Request{Type: "notification", Command: "remove", Client: nc.id, Handle: handle}
+	return nc.client.sendNotificationRequest(ctx, request, nil)
+}
+
+// Ping tells the agent the client is still in use, so its listeners are kept
+func (nc *NotificationClient) Ping(ctx context.Context) error {
+	return nc.client.sendNotificationRequest(ctx, &Request{Type: "notification", Command: "ping", Client: nc.id}, nil)
+}
+
+// Unregister removes the client, and all its listeners, from the agent
+func (nc *NotificationClient) Unregister(ctx context.Context) error {
+	return nc.client.sendNotificationRequest(ctx, &Request{Type: "notification", Command: "unregister", Client: nc.id}, nil)
+}
+
+// Pull returns, and removes from the agent, the notifications received by the listener
+func (nc *NotificationClient) Pull(ctx context.Context, handle string) (*PullResult, error) {
+	request := &Request{Type: "exec", MBean: NotificationStore, Operation: "pull", Arguments: []interface{}{nc.id, handle}}
+	result := &PullResult{}
+	if err := nc.client.sendNotificationRequest(ctx, request, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
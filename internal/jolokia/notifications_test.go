@@ -0,0 +1,74 @@
+package jolokia
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNotificationClient(t *testing.T) {
+	requests := make([]Request, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request := Request{}
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&request))
+		requests = append(requests, request)
+		switch {
+		case request.Command == "register":
+			w.Write([]byte(`{"status": 200, "value": {"id": "c1", "backend": {"pull": {}}}}`))
+		case request.Command == "add":
+			w.Write([]byte(`{"status": 200, "value": "h1"}`))
+		case request.Type == "exec":
+			w.Write([]byte(`{"status": 200, "value": {"handle": "h1", "dropped": 2, "notifications": [
+				{"timeStamp": 1514808000000, "sequenceNumber": 3, "type": "progress", "message": "Starting repair",
+				 "source": "repair:1", "userData": {"type": 0, "progressCount": 0, "total": 100}}]}}`))
+		default:
+			w.Write([]byte(`{"status": 200}`))
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewClientFromConfig(Config{URL: server.URL})
+	assert.Nil(t, err)
+	ctx := context.Background()
+	nc, err := client.RegisterNotifications(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, "c1", nc.ID())
+	handle, err := nc.AddListener(ctx, "org.apache.cassandra.db:type=StorageService", "progress")
+	assert.Nil(t, err)
+	assert.Equal(t, "h1", handle)
+
+	result, err := nc.Pull(ctx, handle)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), result.Dropped)
+	if assert.Len(t, result.Notifications, 1) {
+		notification := result.Notifications[0]
+		assert.Equal(t, "progress", notification.Type)
+		assert.Equal(t, "repair:1", notification.Source)
+		assert.Equal(t, int64(1514808000), notification.Time().Unix())
+		assert.JSONEq(t, `{"type": 0, "progressCount": 0, "total": 100}`, string(notification.UserData))
+	}
+	assert.Nil(t, nc.Ping(ctx))
+	assert.Nil(t, nc.RemoveListener(ctx, handle))
+	assert.Nil(t, nc.Unregister(ctx))
+
+	assert.Equal(t, []Request{
+		{Type: "notification", Command: "register"},
+		{Type: "notification", Command: "add", Client: "c1", Mode: "pull", MBean: "org.apache.cassandra.db:type=StorageService", Filter: []string{"progress"}},
+		{Type: "exec", MBean: NotificationStore, Operation: "pull", Arguments: []interface{}{"c1", "h1"}},
+		{Type: "notification", Command: "ping", Client: "c1"},
+		{Type: "notification", Command: "remove", Client: "c1", Handle: "h1"},
+		{Type: "notification", Command: "unregister", Client: "c1"},
+	}, requests)
+}
+
+func TestNotificationsThroughProxy(t *testing.T) {
+	client, err := NewClientFromConfig(Config{URL: "http://proxy:8778/jolokia"})
+	assert.Nil(t, err)
+	client = client.WithProxyTarget(ProxyTarget{URL: JMXServiceURL("10.0.0.1", 7199)})
+	_, err = client.RegisterNotifications(context.Background())
+	assert.Equal(t, ErrNotificationsThroughProxy, err)
+}
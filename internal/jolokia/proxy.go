@@ -0,0 +1,59 @@
+package jolokia
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+)
+
+// ProxyTarget is the JMX server a Jolokia agent running in proxy mode forwards the requests to
+type ProxyTarget struct {
+	URL      string `json:"url"`
+	User     string `json:"user,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+// JMXServiceURL returns the RMI service URL of the JMX server of a Cassandra node, which
+// listens on port 7199 by default
+func JMXServiceURL(host string, port int) string {
+	return fmt.Sprintf("service:jmx:rmi:///jndi/rmi://%s/jmxrmi", net.JoinHostPort(host, strconv.Itoa(port)))
+}
+
+// WithProxyTarget returns a copy of the client which sends its requests through the agent,
+// acting as a proxy, to the JMX server of the target
+func (c Client) WithProxyTarget(target ProxyTarget) Client {
+	c.target = &target
+	return c
+}
+
+// ProxyTarget returns the JMX server the requests are proxied to, or nil when the agent
+// runs in the JVM of the node
+func (c Client) ProxyTarget() *ProxyTarget {
+	return c.target
+}
+
+// withTarget sets the proxy target on the requests of the body. The version requests are
+// left as they are, as they are answered by the agent itself.
+func (c Client) withTarget(body interface{}) interface{} {
+	if c.target == nil {
+		return body
+	}
+	switch b := body.(type) {
+	case *Request:
+		request := *b
+		if request.Type != "version" {
+			request.Target = c.target
+		}
+		return &request
+	case []Request:
+		requests := make([]Request, len(b))
+		for i, request := range b {
+			if request.Type != "version" {
+				request.Target = c.target
+			}
+			requests[i] = request
+		}
+		return requests
+	}
+	return body
+}
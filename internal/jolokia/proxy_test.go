@@ -0,0 +1,59 @@
+package jolokia
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJMXServiceURL(t *testing.T) {
+	assert.Equal(t, "service:jmx:rmi:///jndi/rmi://10.0.0.1:7199/jmxrmi", JMXServiceURL("10.0.0.1", 7199))
+	assert.Equal(t, "service:jmx:rmi:///jndi/rmi://[::1]:7199/jmxrmi", JMXServiceURL("::1", 7199))
+}
+
+func TestProxyTarget(t *testing.T) {
+	target := ProxyTarget{URL: JMXServiceURL("10.0.0.1", 7199), User: "jmx", Password: "secret"}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "POST", r.Method)
+		var body json.RawMessage
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&body))
+		if body[0] == '[' {
+			requests := make([]Request, 0)
+			assert.Nil(t, json.Unmarshal(body, &requests))
+			assert.Len(t, requests, 2)
+			assert.Equal(t, &target, requests[0].Target)
+			assert.Nil(t, requests[1].Target)
+			w.Write([]byte(`[{"status": 200, "value": "NORMAL"}, {"status": 200, "value": {"agent": "1.3.7"}}]`))
+			return
+		}
+		request := Request{}
+		assert.Nil(t, json.Unmarshal(body, &request))
+		assert.Equal(t, "read", request.Type)
+		assert.Equal(t, "OperationMode", request.Attribute)
+		assert.Equal(t, &target, request.Target)
+		w.Write([]byte(`{"status": 200, "value": "NORMAL"}`))
+	}))
+	defer server.Close()
+	baseURL, _ := url.Parse(server.URL)
+	client := NewClient(*http.DefaultClient, *baseURL).WithProxyTarget(target)
+	assert.Equal(t, &target, client.ProxyTarget())
+
+	vr, err := client.ReadString(context.Background(), "org.apache.cassandra.db:type=StorageService", "OperationMode")
+	assert.Nil(t, err)
+	assert.Equal(t, "NORMAL", vr.Value)
+
+	var mode string
+	var version VersionResponseValue
+	batch := NewBatch()
+	batch.Read(&mode, "org.apache.cassandra.db:type=StorageService", "OperationMode")
+	batch.Version(&version)
+	assert.Nil(t, client.SendBatch(context.Background(), batch))
+	assert.Equal(t, "NORMAL", mode)
+	assert.Equal(t, "1.3.7", version.Agent)
+	assert.Nil(t, batch.results[0].Request.Target, "the batch itself is not changed")
+}
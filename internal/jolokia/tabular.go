@@ -0,0 +1,240 @@
+package jolokia
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// UnmarshalTabularData decodes a TabularData value, as serialized by Jolokia, into the slice
+// pointed by rows. Jolokia nests the rows in one map level per index column, so the depth
+// depends on the MBean. Every level is unwrapped, and each row, a CompositeData, is decoded
+// like by UnmarshalCompositeData. The rows are sorted by their index values.
+func UnmarshalTabularData(buf []byte, rows interface{}) error {
+	rv := reflect.ValueOf(rows)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("TabularData must be decoded into a pointer to a slice, not %T", rows)
+	}
+	data, err := decodeGeneric(buf)
+	if err != nil {
+		return err
+	}
+	return appendTabularRows(data, rv.Elem())
+}
+
+// UnmarshalCompositeData decodes a CompositeData value, as serialized by Jolokia, into the
+// struct pointed by target. Each key is mapped to the field with the same name in its
+// jolokia tag, like `jolokia:"Keyspace name"`, or else to the field with the same name,
+// ignoring case. Numbers, booleans and strings are converted into each other as needed.
+func UnmarshalCompositeData(buf []byte, target interface{}) error {
+	rv := reflect.ValueOf(target)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("CompositeData must be decoded into a pointer to a struct, not %T", target)
+	}
+	data, err := decodeGeneric(buf)
+	if err != nil {
+		return err
+	}
+	composite, ok := data.(map[string]interface{})
+	if !ok {
+		if data == nil {
+			return nil
+		}
+		return fmt.Errorf("CompositeData must be an object, not %T", data)
+	}
+	return decodeComposite(composite, rv.Elem())
+}
+
+func decodeGeneric(buf []byte) (interface{}, error) {
+	var data interface{}
+	dec := json.NewDecoder(bytes.NewReader(buf))
+	dec.UseNumber()
+	if err := dec.Decode(&data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func appendTabularRows(data interface{}, slice reflect.Value) error {
+	elemType := slice.Type().Elem()
+	keys := compositeKeys(elemType)
+	return walkTabular(data, keys, func(row map[string]interface{}) error {
+		elem := reflect.New(elemType).Elem()
+		if err := assign(row, elem); err != nil {
+			return err
+		}
+		slice.Set(reflect.Append(slice, elem))
+		return nil
+	})
+}
+
+// walkTabular calls the row function for each CompositeData found in the TabularData
+func walkTabular(node interface{}, keys map[string]bool, row func(map[string]interface{}) error) error {
+	switch n := node.(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		for _, item := range n {
+			if err := walkTabular(item, keys, row); err != nil {
+				return err
+			}
+		}
+		return nil
+	case map[string]interface{}:
+		// TabularData with complex index types is serialized as a list of rows
+		if values, ok := n["values"].([]interface{}); ok && len(n) == 2 && n["indexNames"] != nil {
+			return walkTabular(values, keys, row)
+		}
+		if isCompositeRow(n, keys) {
+			return row(n)
+		}
+		indexes := make([]string, 0, len(n))
+		for index := range n {
+			indexes = append(indexes, index)
+		}
+		sort.Strings(indexes)
+		for _, index := range indexes {
+			if err := walkTabular(n[index], keys, row); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("Unexpected %T in TabularData", node)
+}
+
+// isCompositeRow tells a row from an index level, as rows have the keys of the target
+// struct, or values which are not nested maps
+func isCompositeRow(node map[string]interface{}, keys map[string]bool) bool {
+	for key, value := range node {
+		if keys[strings.ToLower(key)] {
+			return true
+		}
+		if _, ok := value.(map[string]interface{}); !ok {
+			return true
+		}
+	}
+	return false
+}
+
+// compositeKeys returns the lower cased keys mapped by the fields of the struct type
+func compositeKeys(t reflect.Type) map[string]bool {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	keys := make(map[string]bool)
+	if t.Kind() != reflect.Struct {
+		return keys
+	}
+	for i := 0; i < t.NumField(); i++ {
+		if key, ok := fieldKey(t.Field(i)); ok {
+			keys[strings.ToLower(key)] = true
+		}
+	}
+	return keys
+}
+
+func fieldKey(field reflect.StructField) (key string, ok bool) {
+	if field.PkgPath != "" { // unexported
+		return "", false
+	}
+	tag := field.Tag.Get("jolokia")
+	if tag == "-" {
+		return "", false
+	}
+	if tag != "" {
+		return tag, true
+	}
+	return field.Name, true
+}
+
+func decodeComposite(composite map[string]interface{}, target reflect.Value) error {
+	lowerKeys := make(map[string]string, len(composite))
+	for key := range composite {
+		lowerKeys[strings.ToLower(key)] = key
+	}
+	t := target.Type()
+	for i := 0; i < t.NumField(); i++ {
+		key, ok := fieldKey(t.Field(i))
+		if !ok {
+			continue
+		}
+		value, ok := composite[key]
+		if !ok {
+			if value, ok = composite[lowerKeys[strings.ToLower(key)]]; !ok {
+				continue
+			}
+		}
+		if err := assign(value, target.Field(i)); err != nil {
+			return fmt.Errorf("CompositeData key '%s': %s", key, err)
+		}
+	}
+	return nil
+}
+
+// assign sets the decoded JSON value into the target, converting it as needed
+func assign(value interface{}, target reflect.Value) error {
+	if value == nil {
+		return nil
+	}
+	if target.Kind() == reflect.Ptr {
+		if target.IsNil() {
+			target.Set(reflect.New(target.Type().Elem()))
+		}
+		return assign(value, target.Elem())
+	}
+
+	str := fmt.Sprint(value)
+	var err error
+	switch target.Kind() {
+	case reflect.String:
+		if _, ok := value.(map[string]interface{}); ok {
+			break
+		}
+		target.SetString(str)
+		return nil
+	case reflect.Bool:
+		var b bool
+		if b, err = strconv.ParseBool(str); err == nil {
+			target.SetBool(b)
+		}
+		return err
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var i int64
+		if i, err = strconv.ParseInt(str, 10, target.Type().Bits()); err == nil {
+			target.SetInt(i)
+		}
+		return err
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		var u uint64
+		if u, err = strconv.ParseUint(str, 10, target.Type().Bits()); err == nil {
+			target.SetUint(u)
+		}
+		return err
+	case reflect.Float32, reflect.Float64:
+		var f float64
+		if f, err = strconv.ParseFloat(str, target.Type().Bits()); err == nil {
+			target.SetFloat(f)
+		}
+		return err
+	case reflect.Struct:
+		if composite, ok := value.(map[string]interface{}); ok {
+			return decodeComposite(composite, target)
+		}
+	case reflect.Slice:
+		// a nested TabularData, unless it is a plain array
+		if _, ok := value.(map[string]interface{}); ok {
+			return appendTabularRows(value, target)
+		}
+	}
+
+	buf, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(buf, target.Addr().Interface())
+}
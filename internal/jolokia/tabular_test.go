@@ -0,0 +1,70 @@
+package jolokia
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type snapshotRow struct {
+	Name     string `jolokia:"Snapshot name"`
+	Keyspace string `jolokia:"Keyspace name"`
+	TrueSize string `jolokia:"True size"`
+}
+
+func TestUnmarshalTabularDataNested(t *testing.T) {
+	buf := []byte(`{
+		"snap2": {"ks": {"t2": {"1 KB": {"2 KB": {"x": {"Snapshot name": "snap2", "Keyspace name": "ks", "True size": "1 KB"}}}}}},
+		"snap1": {"ks": {"t1": {"3 KB": {"4 KB": {"x": {"Snapshot name": "snap1", "Keyspace name": "ks", "True size": "3 KB"}}}}}}
+	}`)
+	rows := make([]snapshotRow, 0)
+	assert.Nil(t, UnmarshalTabularData(buf, &rows))
+	assert.Equal(t, []snapshotRow{
+		{Name: "snap1", Keyspace: "ks", TrueSize: "3 KB"},
+		{Name: "snap2", Keyspace: "ks", TrueSize: "1 KB"},
+	}, rows)
+}
+
+type compactionRow struct {
+	ID           string           `jolokia:"id"`
+	KeyspaceName string           `jolokia:"keyspace_name"`
+	CompactedAt  int64            `jolokia:"compacted_at"`
+	BytesIn      uint64           `jolokia:"bytes_in"`
+	RowsMerged   map[string]int64 `jolokia:"rows_merged"`
+	Ignored      string           `jolokia:"-"`
+	Table        string
+}
+
+func TestUnmarshalTabularDataIndexNamesAndConversions(t *testing.T) {
+	buf := []byte(`{
+		"indexNames": ["id", "keyspace_name"],
+		"values": [
+			{"id": "a1", "keyspace_name": "ks", "compacted_at": 1507000000000, "bytes_in": "2048",
+			 "rows_merged": {"1": 10}, "Ignored": "x", "table": "t"}
+		]
+	}`)
+	rows := make([]compactionRow, 0)
+	assert.Nil(t, UnmarshalTabularData(buf, &rows))
+	assert.Equal(t, []compactionRow{{
+		ID: "a1", KeyspaceName: "ks", CompactedAt: 1507000000000, BytesIn: 2048,
+		RowsMerged: map[string]int64{"1": 10}, Table: "t",
+	}}, rows)
+
+	invalid := make([]snapshotRow, 0)
+	assert.NotNil(t, UnmarshalTabularData([]byte(`[{"Snapshot name": {"nested": 1}}]`), &invalid))
+}
+
+func TestUnmarshalCompositeData(t *testing.T) {
+	usage := struct {
+		Init      uint64
+		Used      uint64
+		Committed float64 `jolokia:"committed"`
+		Max       *int64
+	}{}
+	assert.Nil(t, UnmarshalCompositeData([]byte(`{"init": 1, "used": 2, "committed": 3, "max": -1}`), &usage))
+	assert.Equal(t, uint64(1), usage.Init)
+	assert.Equal(t, uint64(2), usage.Used)
+	assert.Equal(t, 3.0, usage.Committed)
+	assert.Equal(t, int64(-1), *usage.Max)
+	assert.NotNil(t, UnmarshalCompositeData([]byte(`{}`), usage))
+}
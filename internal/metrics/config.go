@@ -0,0 +1,71 @@
+package metrics
+
+import (
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Metric types supported by the exporter
+const (
+	TypeGauge   = "gauge"
+	TypeCounter = "counter"
+)
+
+const defaultCacheTTL = 5 * time.Second
+
+// Config lists the MBeans collected by the exporter, and how they are relabeled
+type Config struct {
+	// CacheTTL is for how long a scrape result is served to the following scrapes
+	CacheTTL time.Duration `yaml:"cache_ttl"`
+	Rules    []Rule        `yaml:"rules"`
+}
+
+// Rule describes the metrics exported from the MBeans matching a pattern
+type Rule struct {
+	// MBean is the name, or pattern, of the MBeans to read
+	MBean string `yaml:"mbean"`
+	// Name is the prefix of the metric names, which are suffixed by the attribute names
+	Name string `yaml:"name"`
+	Help string `yaml:"help"`
+	// Attributes maps each attribute to export to its metric type, gauge or counter
+	Attributes map[string]string `yaml:"attributes"`
+	// Labels maps the MBean name key properties to the label names they are exported as
+	Labels map[string]string `yaml:"labels"`
+	// StaticLabels are added as-is to all the metrics of the rule
+	StaticLabels map[string]string `yaml:"static_labels"`
+}
+
+// LoadConfig reads and validates the exporter configuration file
+func LoadConfig(path string) (*Config, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config := &Config{}
+	if err := yaml.Unmarshal(buf, config); err != nil {
+		return nil, err
+	}
+	if config.CacheTTL == 0 {
+		config.CacheTTL = defaultCacheTTL
+	}
+	for i, rule := range config.Rules {
+		if rule.MBean == "" || rule.Name == "" {
+			return nil, fmt.Errorf("Metrics rule %d must have an mbean and a name", i)
+		}
+		if !validMetricName.MatchString(rule.Name) {
+			return nil, fmt.Errorf("Metrics rule %d has an invalid name '%s'", i, rule.Name)
+		}
+		if len(rule.Attributes) == 0 {
+			return nil, fmt.Errorf("Metrics rule %d (%s) has no attributes", i, rule.Name)
+		}
+		for attr, typ := range rule.Attributes {
+			if typ != TypeGauge && typ != TypeCounter {
+				return nil, fmt.Errorf("Attribute %s of metrics rule %d (%s) has an unknown type '%s'", attr, i, rule.Name, typ)
+			}
+		}
+	}
+	return config, nil
+}
@@ -0,0 +1,222 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/CrossEngage/CaOps/internal/jolokia"
+	"github.com/Sirupsen/logrus"
+)
+
+// BatchSender sends many Jolokia requests in a single HTTP request
+type BatchSender interface {
+	SendBatch(ctx context.Context, batch *jolokia.Batch) error
+}
+
+// Exporter serves the MBean attributes selected by its configuration in the Prometheus
+// text format. All the MBeans are read in a single Jolokia request, and scrapes within
+// the cache TTL of each other are served the same result.
+type Exporter struct {
+	sender BatchSender
+	config *Config
+
+	mtx      sync.Mutex
+	cachedAt time.Time
+	cached   []byte
+}
+
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+var (
+	validMetricName   = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	invalidNameChars  = regexp.MustCompile(`[^a-zA-Z0-9_]+`)
+	labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+)
+
+// NewExporter builds a new Prometheus exporter, reading MBeans through the sender
+func NewExporter(sender BatchSender, config *Config) *Exporter {
+	return &Exporter{sender: sender, config: config}
+}
+
+func (e *Exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	w.Header().Set("Content-Type", contentType)
+	w.Write(e.scrape(r.Context()))
+}
+
+func (e *Exporter) scrape(ctx context.Context) []byte {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	if e.cached != nil && time.Since(e.cachedAt) < e.config.CacheTTL {
+		return e.cached
+	}
+	var buf bytes.Buffer
+	e.collect(ctx).write(&buf)
+	e.cached, e.cachedAt = buf.Bytes(), time.Now()
+	return e.cached
+}
+
+type sample struct {
+	labels string
+	value  float64
+}
+
+type family struct {
+	help    string
+	typ     string
+	samples []sample
+}
+
+type families map[string]*family
+
+func (fs families) add(name, help, typ string, labels map[string]string, value float64) {
+	f, ok := fs[name]
+	if !ok {
+		f = &family{help: help, typ: typ}
+		fs[name] = f
+	}
+	f.samples = append(f.samples, sample{labels: formatLabels(labels), value: value})
+}
+
+func (e *Exporter) collect(ctx context.Context) families {
+	fs := make(families)
+	start := time.Now()
+
+	batch := jolokia.NewBatch()
+	values := make([]jolokia.MBeansValue, len(e.config.Rules))
+	results := make([]*jolokia.BatchResult, len(e.config.Rules))
+	for i, rule := range e.config.Rules {
+		attributes := make([]string, 0, len(rule.Attributes))
+		for attr := range rule.Attributes {
+			attributes = append(attributes, attr)
+		}
+		results[i] = batch.ReadMBeans(&values[i], rule.MBean, attributes...)
+	}
+	if err := e.sender.SendBatch(ctx, batch); err != nil {
+		logrus.Errorf("Could not read MBeans for metrics: %s", err)
+	}
+
+	for i, rule := range e.config.Rules {
+		failed := 0.0
+		if err := results[i].Err(); err != nil {
+			logrus.Errorf("Could not read MBeans %s for metrics: %s", rule.MBean, err)
+			failed = 1
+		}
+		fs.add("caops_scrape_error", "Whether the MBeans of a rule could not be read", TypeGauge,
+			map[string]string{"mbean": rule.MBean}, failed)
+		for mbean, attributes := range values[i] {
+			rule.collect(fs, mbean, attributes)
+		}
+	}
+	fs.add("caops_scrape_duration_seconds", "Time spent reading the MBeans through Jolokia", TypeGauge,
+		nil, time.Since(start).Seconds())
+	return fs
+}
+
+func (rule Rule) collect(fs families, mbean string, values map[string]interface{}) {
+	labels := make(map[string]string)
+	for key, value := range rule.StaticLabels {
+		labels[key] = value
+	}
+	_, properties := jolokia.ParseMBeanName(mbean)
+	for property, label := range rule.Labels {
+		if label == "" {
+			label = toSnakeCase(property)
+		}
+		if value, ok := properties[property]; ok {
+			labels[label] = value
+		}
+	}
+	for attr, value := range values {
+		typ, ok := rule.Attributes[attr]
+		if !ok {
+			continue
+		}
+		addValue(fs, rule.Name+"_"+toSnakeCase(attr), rule.Help, typ, labels, value)
+	}
+}
+
+// addValue adds a numeric or boolean value, or the numeric fields of a CompositeData value
+func addValue(fs families, name, help, typ string, labels map[string]string, value interface{}) {
+	switch v := value.(type) {
+	case float64:
+		fs.add(name, help, typ, labels, v)
+	case bool:
+		if v {
+			fs.add(name, help, typ, labels, 1)
+		} else {
+			fs.add(name, help, typ, labels, 0)
+		}
+	case string:
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			fs.add(name, help, typ, labels, f)
+		}
+	case map[string]interface{}:
+		for key, field := range v {
+			addValue(fs, name+"_"+toSnakeCase(key), help, typ, labels, field)
+		}
+	}
+}
+
+func (fs families) write(w io.Writer) {
+	names := make([]string, 0, len(fs))
+	for name := range fs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		f := fs[name]
+		if f.help != "" {
+			fmt.Fprintf(w, "# HELP %s %s\n", name, strings.Replace(f.help, "\n", " ", -1))
+		}
+		fmt.Fprintf(w, "# TYPE %s %s\n", name, f.typ)
+		sort.Slice(f.samples, func(i, j int) bool { return f.samples[i].labels < f.samples[j].labels })
+		for _, s := range f.samples {
+			fmt.Fprintf(w, "%s%s %s\n", name, s.labels, strconv.FormatFloat(s.value, 'g', -1, 64))
+		}
+	}
+}
+
+func formatLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, key, labelValueEscaper.Replace(labels[key])))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// toSnakeCase converts JMX names, like 99thPercentile or CollectionTime, into valid
+// metric name parts, like 99th_percentile or collection_time
+func toSnakeCase(name string) string {
+	var buf bytes.Buffer
+	runes := []rune(name)
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			if i > 0 && (unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1]) ||
+				(i+1 < len(runes) && unicode.IsLower(runes[i+1]) && unicode.IsUpper(runes[i-1]))) {
+				buf.WriteRune('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		buf.WriteRune(r)
+	}
+	return strings.Trim(invalidNameChars.ReplaceAllString(buf.String(), "_"), "_")
+}
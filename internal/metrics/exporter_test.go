@@ -0,0 +1,111 @@
+package metrics
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/CrossEngage/CaOps/internal/jolokia"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeAgent answers bulk reads with the MBeans of each pattern, or an error for unknown ones.
+// Like Jolokia, reads of a single MBean get its attributes, not keyed by the MBean name.
+type fakeAgent map[string]map[string]map[string]interface{}
+
+func (fa fakeAgent) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	requests := make([]jolokia.Request, 0)
+	json.NewDecoder(r.Body).Decode(&requests)
+	responses := make([]map[string]interface{}, 0, len(requests))
+	for _, request := range requests {
+		if mbeans, ok := fa[request.MBean]; ok {
+			var value interface{} = mbeans
+			if !jolokia.IsPattern(request.MBean) {
+				value = mbeans[request.MBean]
+			}
+			responses = append(responses, map[string]interface{}{"status": 200, "request": request, "value": value})
+		} else {
+			responses = append(responses, map[string]interface{}{"status": 404, "request": request,
+				"error": "javax.management.InstanceNotFoundException : " + request.MBean})
+		}
+	}
+	json.NewEncoder(w).Encode(responses)
+}
+
+func newFakeAgentClient(fa fakeAgent) (jolokia.Client, func()) {
+	server := httptest.NewServer(fa)
+	baseURL, _ := url.Parse(server.URL + "/jolokia")
+	return jolokia.NewClient(*http.DefaultClient, *baseURL), server.Close
+}
+
+func TestToSnakeCase(t *testing.T) {
+	for name, expected := range map[string]string{
+		"Count":            "count",
+		"99thPercentile":   "99th_percentile",
+		"CollectionTime":   "collection_time",
+		"HeapMemoryUsage":  "heap_memory_usage",
+		"TotalDiskSpaceMB": "total_disk_space_mb",
+		"used":             "used",
+	} {
+		assert.Equal(t, expected, toSnakeCase(name))
+	}
+}
+
+func TestExporter(t *testing.T) {
+	client, closeAgent := newFakeAgentClient(fakeAgent{
+		"org.apache.cassandra.metrics:type=ClientRequest,scope=*,name=Latency": {
+			"org.apache.cassandra.metrics:type=ClientRequest,scope=Read,name=Latency": {
+				"Count": 42.0, "99thPercentile": "NaN", "Max": 1250.5,
+			},
+		},
+		"java.lang:type=Memory": {
+			"java.lang:type=Memory": {
+				"HeapMemoryUsage": map[string]interface{}{"used": 1024.0, "max": 4096.0},
+			},
+		},
+	})
+	defer closeAgent()
+	config := &Config{CacheTTL: defaultCacheTTL, Rules: []Rule{
+		{
+			MBean:        "org.apache.cassandra.metrics:type=ClientRequest,scope=*,name=Latency",
+			Name:         "cassandra_client_request_latency",
+			Labels:       map[string]string{"scope": "request_type"},
+			StaticLabels: map[string]string{"dc": "dc1"},
+			Attributes:   map[string]string{"Count": TypeCounter, "99thPercentile": TypeGauge, "Max": TypeGauge},
+		},
+		{
+			MBean:      "java.lang:type=Memory",
+			Name:       "jvm_memory",
+			Help:       "JVM memory usage",
+			Attributes: map[string]string{"HeapMemoryUsage": TypeGauge},
+		},
+		{
+			MBean:      "java.lang:type=Missing",
+			Name:       "missing",
+			Attributes: map[string]string{"Value": TypeGauge},
+		},
+	}}
+
+	w := httptest.NewRecorder()
+	NewExporter(client, config).ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body := w.Body.String()
+
+	assert.Equal(t, contentType, w.Header().Get("Content-Type"))
+	assert.Contains(t, body, "# TYPE cassandra_client_request_latency_count counter\n"+
+		`cassandra_client_request_latency_count{dc="dc1",request_type="Read"} 42`+"\n")
+	assert.Contains(t, body, `cassandra_client_request_latency_99th_percentile{dc="dc1",request_type="Read"} NaN`)
+	assert.Contains(t, body, `cassandra_client_request_latency_max{dc="dc1",request_type="Read"} 1250.5`)
+	assert.Contains(t, body, "# HELP jvm_memory_heap_memory_usage_used JVM memory usage\n")
+	assert.Contains(t, body, "jvm_memory_heap_memory_usage_max 4096\n")
+	assert.Contains(t, body, `caops_scrape_error{mbean="java.lang:type=Missing"} 1`)
+	assert.Contains(t, body, `caops_scrape_error{mbean="java.lang:type=Memory"} 0`)
+}
+
+func TestLoadExampleConfig(t *testing.T) {
+	config, err := LoadConfig("../../CaOps-metrics.yaml")
+	assert.Nil(t, err)
+	assert.NotEmpty(t, config.Rules)
+	assert.Equal(t, "table", config.Rules[3].Labels["scope"])
+}
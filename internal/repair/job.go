@@ -0,0 +1,220 @@
+package repair
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/CrossEngage/CaOps/internal/cassandra"
+)
+
+// JobStatus is the status of a repair job
+type JobStatus string
+
+// Statuses of a repair job
+const (
+	JobRunning   JobStatus = "running"
+	JobSucceeded JobStatus = "succeeded"
+	JobFailed    JobStatus = "failed"
+	JobCancelled JobStatus = "cancelled"
+)
+
+// SegmentStatus is the status of a segment of a repair job
+type SegmentStatus string
+
+// Statuses of a segment
+const (
+	SegmentPending   SegmentStatus = "pending"
+	SegmentRunning   SegmentStatus = "running"
+	SegmentSucceeded SegmentStatus = "succeeded"
+	SegmentFailed    SegmentStatus = "failed"
+)
+
+// Parallelism values of the repair options
+const (
+	ParallelismSequential = "sequential"
+	ParallelismParallel   = "parallel"
+	ParallelismDCParallel = "dc_parallel"
+)
+
+// Options tune how the segments of a job are repaired
+type Options struct {
+	// Parallelism is sequential, parallel or dc_parallel, like the repair option
+	Parallelism string `json:"parallelism"`
+	// SegmentsPerRange is the number of segments each token range of the ring is split into
+	SegmentsPerRange int `json:"segments_per_range"`
+	// Tables restricts the repair to some tables of the keyspaces, all of them when empty
+	Tables []string `json:"tables,omitempty"`
+}
+
+// Job repairs keyspaces, segment by segment
+type Job struct {
+	ID         string     `json:"id"`
+	Keyspaces  []string   `json:"keyspaces"`
+	Options    Options    `json:"options"`
+	Scheduled  bool       `json:"scheduled"`
+	Status     JobStatus  `json:"status"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt time.Time  `json:"finished_at"`
+	Segments   []*Segment `json:"segments,omitempty"`
+}
+
+// Copy returns a deep copy of the job, so it can be read while the orchestrator updates it
+func (j *Job) Copy() *Job {
+	c := *j
+	c.Keyspaces = copyStrings(j.Keyspaces)
+	c.Options.Tables = copyStrings(j.Options.Tables)
+	if j.Segments != nil {
+		c.Segments = make([]*Segment, 0, len(j.Segments))
+		for _, segment := range j.Segments {
+			s := *segment
+			s.Replicas = copyStrings(segment.Replicas)
+			s.Datacenters = copyStrings(segment.Datacenters)
+			c.Segments = append(c.Segments, &s)
+		}
+	}
+	return &c
+}
+
+// copyStrings copies the list, keeping it nil if it is
+func copyStrings(list []string) []string {
+	if list == nil {
+		return nil
+	}
+	return append([]string{}, list...)
+}
+
+// Segment is a subrange of the ring of a keyspace, repaired by a single repair command
+type Segment struct {
+	Keyspace    string        `json:"keyspace"`
+	Range       TokenRange    `json:"range"`
+	Replicas    []string      `json:"replicas"`
+	Datacenters []string      `json:"datacenters"`
+	Status      SegmentStatus `json:"status"`
+	Attempts    int           `json:"attempts"`
+	// Coordinator is the replica running the repair command of the last attempt
+	Coordinator   string    `json:"coordinator,omitempty"`
+	Command       int       `json:"command,omitempty"`
+	Error         string    `json:"error,omitempty"`
+	StartedAt     time.Time `json:"started_at"`
+	FinishedAt    time.Time `json:"finished_at"`
+	NextAttemptAt time.Time `json:"next_attempt_at"`
+}
+
+// PlanError is an error of the keyspaces or the options of a job, which can not be planned
+type PlanError struct {
+	Message string
+}
+
+func (e *PlanError) Error() string {
+	return e.Message
+}
+
+// Progress counts the segments of a job by status
+type Progress struct {
+	Total     int `json:"total"`
+	Pending   int `json:"pending"`
+	Running   int `json:"running"`
+	Succeeded int `json:"succeeded"`
+	Failed    int `json:"failed"`
+}
+
+// NewJob plans the repair of the keyspaces, splitting the token ranges of each of them, as
+// described by the ring, into segments. The partitioner tells how the tokens can be split.
+// A range without replicas, like the ranges of a keyspace replicated only to a datacenter
+// without nodes, can not be repaired, so the job is not planned.
+func NewJob(id, partitioner string, rings map[string][]cassandra.TokenRange, options Options, now time.Time) (*Job, error) {
+	if options.Parallelism == "" {
+		options.Parallelism = ParallelismParallel
+	}
+	switch options.Parallelism {
+	case ParallelismSequential, ParallelismParallel, ParallelismDCParallel:
+	default:
+		return nil, &PlanError{Message: fmt.Sprintf("Unknown parallelism '%s'", options.Parallelism)}
+	}
+	if options.SegmentsPerRange < 1 {
+		options.SegmentsPerRange = 1
+	}
+
+	job := &Job{ID: id, Options: options, Status: JobRunning, CreatedAt: now, Segments: make([]*Segment, 0)}
+	for keyspace := range rings {
+		job.Keyspaces = append(job.Keyspaces, keyspace)
+	}
+	sort.Strings(job.Keyspaces)
+	for _, keyspace := range job.Keyspaces {
+		for _, r := range rings[keyspace] {
+			if len(r.Endpoints) == 0 {
+				return nil, &PlanError{Message: fmt.Sprintf("The range (%s, %s] of %s has no replicas", r.Start, r.End, keyspace)}
+			}
+			datacenters := replicaDatacenters(r)
+			subranges, err := SplitRange(partitioner, TokenRange{Start: r.Start, End: r.End}, options.SegmentsPerRange)
+			if err != nil {
+				return nil, err
+			}
+			for _, subrange := range subranges {
+				job.Segments = append(job.Segments, &Segment{
+					Keyspace:    keyspace,
+					Range:       subrange,
+					Replicas:    r.Endpoints,
+					Datacenters: datacenters,
+					Status:      SegmentPending,
+				})
+			}
+		}
+	}
+	return job, nil
+}
+
+// replicaDatacenters returns the datacenters of the replicas of the range
+func replicaDatacenters(r cassandra.TokenRange) []string {
+	seen := make(map[string]bool)
+	datacenters := make([]string, 0)
+	for _, details := range r.EndpointDetails {
+		if !seen[details.Datacenter] {
+			seen[details.Datacenter] = true
+			datacenters = append(datacenters, details.Datacenter)
+		}
+	}
+	sort.Strings(datacenters)
+	return datacenters
+}
+
+// Progress counts the segments of the job by status
+func (j *Job) Progress() Progress {
+	p := Progress{Total: len(j.Segments)}
+	for _, segment := range j.Segments {
+		switch segment.Status {
+		case SegmentPending:
+			p.Pending++
+		case SegmentRunning:
+			p.Running++
+		case SegmentSucceeded:
+			p.Succeeded++
+		case SegmentFailed:
+			p.Failed++
+		}
+	}
+	return p
+}
+
+// Finished returns whether the job is over, even if some of its segments are still running
+func (j *Job) Finished() bool {
+	return j.Status != JobRunning
+}
+
+// RepairOptions returns the options of the repair command of the segment, for repairAsync
+func (j *Job) RepairOptions(segment *Segment) map[string]string {
+	options := map[string]string{
+		"parallelism":  j.Options.Parallelism,
+		"primaryRange": "false",
+		"incremental":  "false",
+		"ranges":       segment.Range.Start + ":" + segment.Range.End,
+		"jobThreads":   strconv.Itoa(1),
+	}
+	if len(j.Options.Tables) > 0 {
+		options["columnFamilies"] = strings.Join(j.Options.Tables, ",")
+	}
+	return options
+}
@@ -0,0 +1,78 @@
+package repair
+
+import (
+	"testing"
+	"time"
+
+	"github.com/CrossEngage/CaOps/internal/cassandra"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewJob(t *testing.T) {
+	now := time.Date(2018, 1, 1, 12, 0, 0, 0, time.UTC)
+	rings := map[string][]cassandra.TokenRange{
+		"ks1": {{
+			Start: "0", End: "100", Endpoints: []string{"127.0.0.1", "127.0.0.2"},
+			EndpointDetails: []cassandra.EndpointDetails{
+				{Host: "127.0.0.1", Datacenter: "dc2"},
+				{Host: "127.0.0.2", Datacenter: "dc1"},
+			},
+		}},
+	}
+	job, err := NewJob("job1", murmur3, rings, Options{SegmentsPerRange: 2, Tables: []string{"users", "events"}}, now)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"ks1"}, job.Keyspaces)
+	assert.Equal(t, ParallelismParallel, job.Options.Parallelism)
+	assert.Equal(t, JobRunning, job.Status)
+	assert.Equal(t, []*Segment{
+		{Keyspace: "ks1", Range: TokenRange{"0", "50"}, Replicas: []string{"127.0.0.1", "127.0.0.2"},
+			Datacenters: []string{"dc1", "dc2"}, Status: SegmentPending},
+		{Keyspace: "ks1", Range: TokenRange{"50", "100"}, Replicas: []string{"127.0.0.1", "127.0.0.2"},
+			Datacenters: []string{"dc1", "dc2"}, Status: SegmentPending},
+	}, job.Segments)
+	assert.Equal(t, Progress{Total: 2, Pending: 2}, job.Progress())
+	assert.Equal(t, map[string]string{
+		"parallelism":    "parallel",
+		"primaryRange":   "false",
+		"incremental":    "false",
+		"ranges":         "50:100",
+		"jobThreads":     "1",
+		"columnFamilies": "users,events",
+	}, job.RepairOptions(job.Segments[1]))
+
+	_, err = NewJob("job2", murmur3, rings, Options{Parallelism: "random"}, now)
+	assert.IsType(t, &PlanError{}, err)
+}
+
+func TestNewJobWithoutReplicas(t *testing.T) {
+	// ks2 is only replicated to a datacenter without nodes
+	rings := map[string][]cassandra.TokenRange{
+		"ks1": {{Start: "0", End: "100", Endpoints: []string{"127.0.0.1"}}},
+		"ks2": {
+			{Start: "0", End: "100", Endpoints: []string{}, EndpointDetails: []cassandra.EndpointDetails{}},
+			{Start: "100", End: "0", Endpoints: []string{}, EndpointDetails: []cassandra.EndpointDetails{}},
+		},
+	}
+	job, err := NewJob("job1", murmur3, rings, Options{}, time.Now())
+	assert.Nil(t, job)
+	if assert.IsType(t, &PlanError{}, err) {
+		assert.Equal(t, "The range (0, 100] of ks2 has no replicas", err.Error())
+	}
+}
+
+func TestJobCopy(t *testing.T) {
+	rings := map[string][]cassandra.TokenRange{
+		"ks1": {{Start: "0", End: "100", Endpoints: []string{"127.0.0.1"}}},
+	}
+	job, err := NewJob("job1", murmur3, rings, Options{}, time.Now())
+	assert.Nil(t, err)
+	c := job.Copy()
+	assert.Equal(t, job, c)
+
+	c.Keyspaces[0] = "ks2"
+	c.Segments[0].Status = SegmentFailed
+	c.Segments[0].Replicas[0] = "127.0.0.2"
+	assert.Equal(t, "ks1", job.Keyspaces[0])
+	assert.Equal(t, SegmentPending, job.Segments[0].Status)
+	assert.Equal(t, "127.0.0.1", job.Segments[0].Replicas[0])
+}
@@ -0,0 +1,500 @@
+package repair
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Default settings of the orchestrator
+const (
+	DefaultMaxPerNode       = 1
+	DefaultMaxPerDatacenter = 1
+	DefaultMaxAttempts      = 3
+	DefaultRetryDelay       = 5 * time.Minute
+	DefaultSegmentTimeout   = time.Hour
+	DefaultPollInterval     = 10 * time.Second
+	// DefaultKeptJobs is the number of finished jobs kept, besides the running ones
+	DefaultKeptJobs = 20
+)
+
+// Config holds the settings of the orchestrator. Unset settings get the defaults.
+type Config struct {
+	// MaxPerNode limits the segments being repaired at once on each node, counting all the
+	// replicas of a segment, and MaxPerDatacenter the ones having replicas in each datacenter
+	MaxPerNode       int
+	MaxPerDatacenter int
+	// MaxAttempts is how many times a segment is repaired before it is failed, waiting
+	// RetryDelay between attempts
+	MaxAttempts int
+	RetryDelay  time.Duration
+	// SegmentTimeout fails the attempts whose repair command has not finished in time
+	SegmentTimeout time.Duration
+	PollInterval   time.Duration
+	KeptJobs       int
+}
+
+func (config Config) withDefaults() Config {
+	if config.MaxPerNode == 0 {
+		config.MaxPerNode = DefaultMaxPerNode
+	}
+	if config.MaxPerDatacenter == 0 {
+		config.MaxPerDatacenter = DefaultMaxPerDatacenter
+	}
+	if config.MaxAttempts == 0 {
+		config.MaxAttempts = DefaultMaxAttempts
+	}
+	if config.RetryDelay == 0 {
+		config.RetryDelay = DefaultRetryDelay
+	}
+	if config.SegmentTimeout == 0 {
+		config.SegmentTimeout = DefaultSegmentTimeout
+	}
+	// the interval can not be negative, as the retries are delayed by up to twice of it
+	if config.PollInterval <= 0 {
+		config.PollInterval = DefaultPollInterval
+	}
+	if config.KeptJobs == 0 {
+		config.KeptJobs = DefaultKeptJobs
+	}
+	return config
+}
+
+// Runner runs the repair commands of the segments on their coordinators, which are replicas
+type Runner interface {
+	// StartRepair starts the repair of the keyspace with the options on the coordinator, and
+	// returns the number of the repair command, which is 0 when there is nothing to repair
+	StartRepair(ctx context.Context, coordinator, keyspace string, options map[string]string) (command int, err error)
+	// RepairStatus returns the status of the repair command on the coordinator, with a message
+	// telling why it failed
+	RepairStatus(ctx context.Context, coordinator string, command int) (status SegmentStatus, message string, err error)
+}
+
+// Peers tells the segments being repaired by the orchestrators of the other agents, which
+// count in the limits too
+type Peers interface {
+	RunningSegments(ctx context.Context) ([]*Segment, error)
+}
+
+// Orchestrator repairs the segments of its jobs, limiting how many are repaired at once per
+// node and per datacenter, and retrying the failed ones. The jobs are persisted after each
+// step, so they are resumed if the agent restarts, even the running repair commands.
+type Orchestrator struct {
+	runner Runner
+	peers  Peers
+	store  *Store
+	config Config
+	now    func() time.Time
+
+	// stepMtx serializes the steps, while mtx protects the jobs, so they can be read while
+	// the step waits for the runner
+	stepMtx sync.Mutex
+	mtx     sync.Mutex
+	jobs    []*Job
+}
+
+// NewOrchestrator builds an orchestrator, resuming the jobs of the store
+func NewOrchestrator(runner Runner, store *Store, config Config) (*Orchestrator, error) {
+	jobs, err := store.Load()
+	if err != nil {
+		return nil, err
+	}
+	return &Orchestrator{runner: runner, store: store, config: config.withDefaults(), now: time.Now, jobs: jobs}, nil
+}
+
+// SetClock replaces the clock telling the time of the steps
+func (o *Orchestrator) SetClock(now func() time.Time) {
+	o.now = now
+}
+
+// SetPeers makes the orchestrator count the segments repaired by the other agents in the
+// limits, before starting any segment
+func (o *Orchestrator) SetPeers(peers Peers) {
+	o.peers = peers
+}
+
+// Submit adds a job, whose segments are repaired from the next step on
+func (o *Orchestrator) Submit(job *Job) error {
+	o.mtx.Lock()
+	defer o.mtx.Unlock()
+	for _, j := range o.jobs {
+		if j.ID == job.ID {
+			return fmt.Errorf("Repair job %s already exists", job.ID)
+		}
+	}
+	o.jobs = append(o.jobs, job)
+	return o.save()
+}
+
+// Jobs returns copies of all the jobs, from the oldest to the newest
+func (o *Orchestrator) Jobs() []*Job {
+	o.mtx.Lock()
+	defer o.mtx.Unlock()
+	jobs := make([]*Job, 0, len(o.jobs))
+	for _, job := range o.jobs {
+		jobs = append(jobs, job.Copy())
+	}
+	return jobs
+}
+
+// Job returns a copy of the job, or nil if it does not exist
+func (o *Orchestrator) Job(id string) *Job {
+	o.mtx.Lock()
+	defer o.mtx.Unlock()
+	if job := o.find(id); job != nil {
+		return job.Copy()
+	}
+	return nil
+}
+
+// Cancel stops repairing the pending segments of the job. The running repair commands can
+// not be stopped, and are left to finish.
+func (o *Orchestrator) Cancel(id string) error {
+	o.mtx.Lock()
+	defer o.mtx.Unlock()
+	job := o.find(id)
+	if job == nil {
+		return fmt.Errorf("Repair job %s does not exist", id)
+	}
+	if job.Finished() {
+		return fmt.Errorf("Repair job %s is already %s", id, job.Status)
+	}
+	job.Status = JobCancelled
+	job.FinishedAt = o.now()
+	return o.save()
+}
+
+// RunningSegments returns copies of the segments being repaired, or about to be
+func (o *Orchestrator) RunningSegments() []*Segment {
+	o.mtx.Lock()
+	defer o.mtx.Unlock()
+	segments := make([]*Segment, 0)
+	for _, running := range o.runningSegments() {
+		segment := *running.segment
+		segments = append(segments, &segment)
+	}
+	return segments
+}
+
+// LastScheduled returns when the newest scheduled job was created, and whether it is running
+func (o *Orchestrator) LastScheduled() (createdAt time.Time, running bool) {
+	o.mtx.Lock()
+	defer o.mtx.Unlock()
+	for _, job := range o.jobs {
+		if job.Scheduled && !job.CreatedAt.Before(createdAt) {
+			createdAt, running = job.CreatedAt, !job.Finished()
+		}
+	}
+	return createdAt, running
+}
+
+// Run steps through the jobs at the poll interval, until the context is done
+func (o *Orchestrator) Run(ctx context.Context) {
+	ticker := time.NewTicker(o.config.PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			o.Step(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// runningSegment is a segment whose repair command is polled, or which is started, during a
+// step. A started segment keeps its state from before, in case it can not be started.
+type runningSegment struct {
+	job      *Job
+	segment  *Segment
+	command  int
+	previous Segment
+}
+
+// Step polls the running repair commands, then starts the repair of the pending segments
+// which fit in the limits, and finally updates and saves the jobs
+func (o *Orchestrator) Step(ctx context.Context) {
+	o.stepMtx.Lock()
+	defer o.stepMtx.Unlock()
+
+	o.mtx.Lock()
+	running := o.runningSegments()
+	o.mtx.Unlock()
+	for _, r := range running {
+		o.poll(ctx, r)
+	}
+	starting := o.startableSegments()
+	if o.peers != nil && len(starting) > 0 {
+		starting = o.checkPeers(ctx, starting)
+	}
+	for _, s := range starting {
+		o.start(ctx, s)
+	}
+
+	o.mtx.Lock()
+	defer o.mtx.Unlock()
+	for _, job := range o.jobs {
+		o.updateStatus(job)
+	}
+	o.prune()
+	if err := o.save(); err != nil {
+		logrus.Errorf("Could not save the repair jobs: %s", err)
+	}
+}
+
+// runningSegments returns the running segments of all the jobs, finished or not, with the
+// lock held
+func (o *Orchestrator) runningSegments() []runningSegment {
+	running := make([]runningSegment, 0)
+	for _, job := range o.jobs {
+		for _, segment := range job.Segments {
+			if segment.Status == SegmentRunning {
+				running = append(running, runningSegment{job: job, segment: segment, command: segment.Command})
+			}
+		}
+	}
+	return running
+}
+
+// poll updates the segment with the status of its repair command, failing the attempt when
+// it takes too long
+func (o *Orchestrator) poll(ctx context.Context, running runningSegment) {
+	o.mtx.Lock()
+	coordinator := running.segment.Coordinator
+	o.mtx.Unlock()
+	status, message, err := o.runner.RepairStatus(ctx, coordinator, running.command)
+
+	o.mtx.Lock()
+	defer o.mtx.Unlock()
+	segment := running.segment
+	now := o.now()
+	switch {
+	case err != nil:
+		logrus.Warnf("Could not get the status of repair command %d on %s: %s", running.command, coordinator, err)
+		if now.Sub(segment.StartedAt) > o.config.SegmentTimeout {
+			o.failAttempt(running.job, segment, fmt.Sprintf("Timed out after %s: %s", o.config.SegmentTimeout, err))
+		}
+	case status == SegmentSucceeded:
+		segment.Status = SegmentSucceeded
+		segment.FinishedAt = now
+		segment.Error = ""
+	case status == SegmentFailed:
+		o.failAttempt(running.job, segment, message)
+	case now.Sub(segment.StartedAt) > o.config.SegmentTimeout:
+		o.failAttempt(running.job, segment, fmt.Sprintf("Timed out after %s", o.config.SegmentTimeout))
+	}
+}
+
+// failAttempt sets the segment back to pending until its next attempt, or fails it when it
+// has no attempts left
+func (o *Orchestrator) failAttempt(job *Job, segment *Segment, message string) {
+	now := o.now()
+	logrus.Warnf("Repair of %s (%s, %s] of job %s failed on attempt %d: %s", segment.Keyspace,
+		segment.Range.Start, segment.Range.End, job.ID, segment.Attempts, message)
+	segment.Error = message
+	segment.FinishedAt = now
+	if segment.Attempts < o.config.MaxAttempts {
+		segment.Status = SegmentPending
+		segment.NextAttemptAt = now.Add(o.config.RetryDelay)
+	} else {
+		segment.Status = SegmentFailed
+	}
+}
+
+// limits counts the segments being repaired per node and per datacenter
+type limits struct {
+	config        Config
+	perNode       map[string]int
+	perDatacenter map[string]int
+}
+
+func newLimits(config Config) *limits {
+	return &limits{config: config, perNode: make(map[string]int), perDatacenter: make(map[string]int)}
+}
+
+func (l *limits) count(segment *Segment) {
+	for _, replica := range segment.Replicas {
+		l.perNode[replica]++
+	}
+	for _, dc := range segment.Datacenters {
+		l.perDatacenter[dc]++
+	}
+}
+
+// fits returns whether the segment can be repaired without exceeding the limits
+func (l *limits) fits(segment *Segment) bool {
+	for _, replica := range segment.Replicas {
+		if l.perNode[replica] >= l.config.MaxPerNode {
+			return false
+		}
+	}
+	for _, dc := range segment.Datacenters {
+		if l.perDatacenter[dc] >= l.config.MaxPerDatacenter {
+			return false
+		}
+	}
+	return true
+}
+
+// startableSegments picks the pending segments of the running jobs, oldest job first, which
+// can be repaired without exceeding the limits, marking them as running
+func (o *Orchestrator) startableSegments() []runningSegment {
+	o.mtx.Lock()
+	defer o.mtx.Unlock()
+	l := newLimits(o.config)
+	for _, running := range o.runningSegments() {
+		l.count(running.segment)
+	}
+
+	now := o.now()
+	starting := make([]runningSegment, 0)
+	for _, job := range o.jobs {
+		if job.Finished() {
+			continue
+		}
+		for _, segment := range job.Segments {
+			if segment.Status != SegmentPending || now.Before(segment.NextAttemptAt) || !l.fits(segment) {
+				continue
+			}
+			l.count(segment)
+			previous := *segment
+			segment.Status = SegmentRunning
+			segment.Attempts++
+			segment.StartedAt = now
+			segment.Command = 0
+			// the next attempt is coordinated by the next replica, in case the last one is at fault
+			segment.Coordinator = segment.Replicas[(segment.Attempts-1)%len(segment.Replicas)]
+			starting = append(starting, runningSegment{job: job, segment: segment, previous: previous})
+		}
+	}
+	return starting
+}
+
+// checkPeers keeps the starting segments which still fit in the limits once the segments
+// repaired by the other agents are counted, and sets the others back to pending. They are
+// marked as running before the other agents are asked, so that of two agents starting
+// segments at once, at least one sees the segments of the other and sets its own back.
+func (o *Orchestrator) checkPeers(ctx context.Context, starting []runningSegment) []runningSegment {
+	remote, err := o.peers.RunningSegments(ctx)
+
+	o.mtx.Lock()
+	defer o.mtx.Unlock()
+	if err != nil {
+		logrus.Warnf("Could not get the segments repaired by the other agents, the repairs wait: %s", err)
+		for _, s := range starting {
+			o.putBack(s)
+		}
+		return nil
+	}
+	l := newLimits(o.config)
+	for _, segment := range remote {
+		l.count(segment)
+	}
+	isStarting := make(map[*Segment]bool, len(starting))
+	for _, s := range starting {
+		isStarting[s.segment] = true
+	}
+	for _, running := range o.runningSegments() {
+		if !isStarting[running.segment] {
+			l.count(running.segment)
+		}
+	}
+	kept := make([]runningSegment, 0, len(starting))
+	for _, s := range starting {
+		if l.fits(s.segment) {
+			l.count(s.segment)
+			kept = append(kept, s)
+		} else {
+			o.putBack(s)
+		}
+	}
+	return kept
+}
+
+// putBack sets a segment which could not be started back to its previous state. It waits
+// up to two poll intervals before it is tried again, so that agents setting back the segments
+// of each other do not keep trying them at the same time.
+func (o *Orchestrator) putBack(s runningSegment) {
+	*s.segment = s.previous
+	s.segment.NextAttemptAt = o.now().Add(time.Duration(rand.Int63n(int64(2 * o.config.PollInterval))))
+}
+
+// start starts the repair command of the segment on its coordinator
+func (o *Orchestrator) start(ctx context.Context, starting runningSegment) {
+	o.mtx.Lock()
+	segment := starting.segment
+	coordinator, keyspace, options := segment.Coordinator, segment.Keyspace, starting.job.RepairOptions(segment)
+	o.mtx.Unlock()
+	logrus.Infof("Repairing %s (%s, %s] of job %s on %s", keyspace, segment.Range.Start, segment.Range.End,
+		starting.job.ID, coordinator)
+	command, err := o.runner.StartRepair(ctx, coordinator, keyspace, options)
+
+	o.mtx.Lock()
+	defer o.mtx.Unlock()
+	switch {
+	case err != nil:
+		o.failAttempt(starting.job, segment, fmt.Sprintf("Could not start the repair on %s: %s", coordinator, err))
+	case command == 0:
+		segment.Status = SegmentSucceeded
+		segment.FinishedAt = o.now()
+		segment.Error = ""
+	default:
+		segment.Command = command
+	}
+}
+
+// updateStatus finishes the job once none of its segments is pending or running
+func (o *Orchestrator) updateStatus(job *Job) {
+	if job.Finished() {
+		return
+	}
+	progress := job.Progress()
+	if progress.Pending > 0 || progress.Running > 0 {
+		return
+	}
+	job.FinishedAt = o.now()
+	if progress.Failed > 0 {
+		job.Status = JobFailed
+		logrus.Errorf("Repair job %s failed, %d of %d segments could not be repaired", job.ID, progress.Failed, progress.Total)
+	} else {
+		job.Status = JobSucceeded
+		logrus.Infof("Repair job %s succeeded, %d segments were repaired", job.ID, progress.Total)
+	}
+}
+
+// prune forgets the oldest finished jobs, beyond the number of kept jobs, unless some of
+// their segments are still running
+func (o *Orchestrator) prune() {
+	finished := 0
+	for _, job := range o.jobs {
+		if job.Finished() {
+			finished++
+		}
+	}
+	kept := make([]*Job, 0, len(o.jobs))
+	for _, job := range o.jobs {
+		if finished > o.config.KeptJobs && job.Finished() && job.Progress().Running == 0 {
+			finished--
+			continue
+		}
+		kept = append(kept, job)
+	}
+	o.jobs = kept
+}
+
+func (o *Orchestrator) find(id string) *Job {
+	for _, job := range o.jobs {
+		if job.ID == id {
+			return job
+		}
+	}
+	return nil
+}
+
+func (o *Orchestrator) save() error {
+	return o.store.Save(o.jobs)
+}
@@ -0,0 +1,284 @@
+package repair
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeRunner records the repair commands, whose status is set by the tests
+type fakeRunner struct {
+	mu       sync.Mutex
+	commands []fakeCommand
+	statuses map[int]SegmentStatus
+	failing  map[string]bool
+}
+
+type fakeCommand struct {
+	Coordinator string
+	Ranges      string
+}
+
+func newFakeRunner() *fakeRunner {
+	return &fakeRunner{statuses: make(map[int]SegmentStatus), failing: make(map[string]bool)}
+}
+
+func (r *fakeRunner) StartRepair(ctx context.Context, coordinator, keyspace string, options map[string]string) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.failing[coordinator] {
+		return 0, fmt.Errorf("No alive CaOps agent on %s", coordinator)
+	}
+	r.commands = append(r.commands, fakeCommand{Coordinator: coordinator, Ranges: options["ranges"]})
+	command := len(r.commands)
+	r.statuses[command] = SegmentRunning
+	return command, nil
+}
+
+func (r *fakeRunner) RepairStatus(ctx context.Context, coordinator string, command int) (SegmentStatus, string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	status, ok := r.statuses[command]
+	if !ok {
+		return "", "", fmt.Errorf("Unknown command %d", command)
+	}
+	if status == SegmentFailed {
+		return status, "Repair session failed", nil
+	}
+	return status, "", nil
+}
+
+// finish sets the status of all the running commands
+func (r *fakeRunner) finish(status SegmentStatus) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for command, s := range r.statuses {
+		if s == SegmentRunning {
+			r.statuses[command] = status
+		}
+	}
+}
+
+func (r *fakeRunner) started() []fakeCommand {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]fakeCommand{}, r.commands...)
+}
+
+type orchestratorTest struct {
+	t            *testing.T
+	dir          string
+	now          time.Time
+	runner       *fakeRunner
+	orchestrator *Orchestrator
+}
+
+func newOrchestratorTest(t *testing.T, config Config) *orchestratorTest {
+	dir, err := ioutil.TempDir("", "caops-repair")
+	assert.Nil(t, err)
+	test := &orchestratorTest{t: t, dir: dir, now: time.Date(2018, 1, 1, 12, 0, 0, 0, time.UTC), runner: newFakeRunner()}
+	test.orchestrator = test.newOrchestrator(config)
+	return test
+}
+
+// newOrchestrator builds an orchestrator on the state file of the test, like a restarted agent
+func (test *orchestratorTest) newOrchestrator(config Config) *Orchestrator {
+	store, err := NewStore(filepath.Join(test.dir, "repairs.json"))
+	assert.Nil(test.t, err)
+	orchestrator, err := NewOrchestrator(test.runner, store, config)
+	assert.Nil(test.t, err)
+	orchestrator.SetClock(func() time.Time { return test.now })
+	return orchestrator
+}
+
+func (test *orchestratorTest) close() {
+	os.RemoveAll(test.dir)
+}
+
+func (test *orchestratorTest) step() {
+	test.orchestrator.Step(context.Background())
+}
+
+// newTestJob has two segments replicated by the first two nodes in dc1, and one by the
+// third and fourth nodes in dc2
+func newTestJob(id string) *Job {
+	segment := func(start, end string, replicas []string, dc string) *Segment {
+		return &Segment{Keyspace: "ks1", Range: TokenRange{start, end}, Replicas: replicas,
+			Datacenters: []string{dc}, Status: SegmentPending}
+	}
+	return &Job{ID: id, Keyspaces: []string{"ks1"}, Options: Options{Parallelism: ParallelismParallel}, Status: JobRunning,
+		Segments: []*Segment{
+			segment("0", "10", []string{"10.0.1.1", "10.0.1.2"}, "dc1"),
+			segment("10", "20", []string{"10.0.1.2", "10.0.1.1"}, "dc1"),
+			segment("20", "30", []string{"10.0.2.1", "10.0.2.2"}, "dc2"),
+		}}
+}
+
+func TestOrchestratorLimits(t *testing.T) {
+	test := newOrchestratorTest(t, Config{})
+	defer test.close()
+	assert.Nil(t, test.orchestrator.Submit(newTestJob("job1")))
+	assert.NotNil(t, test.orchestrator.Submit(newTestJob("job1")))
+
+	// one segment at a time per datacenter
+	test.step()
+	assert.Equal(t, []fakeCommand{{"10.0.1.1", "0:10"}, {"10.0.2.1", "20:30"}}, test.runner.started())
+	assert.Equal(t, Progress{Total: 3, Pending: 1, Running: 2}, test.orchestrator.Job("job1").Progress())
+	test.step()
+	assert.Len(t, test.runner.started(), 2)
+
+	test.runner.finish(SegmentSucceeded)
+	test.step()
+	assert.Equal(t, fakeCommand{"10.0.1.2", "10:20"}, test.runner.started()[2])
+	test.runner.finish(SegmentSucceeded)
+	test.step()
+	job := test.orchestrator.Job("job1")
+	assert.Equal(t, JobSucceeded, job.Status)
+	assert.Equal(t, Progress{Total: 3, Succeeded: 3}, job.Progress())
+}
+
+// peersFunc returns the segments repaired by the other agents
+type peersFunc func(ctx context.Context) ([]*Segment, error)
+
+func (f peersFunc) RunningSegments(ctx context.Context) ([]*Segment, error) {
+	return f(ctx)
+}
+
+func TestOrchestratorPeers(t *testing.T) {
+	test := newOrchestratorTest(t, Config{})
+	defer test.close()
+	other := newOrchestratorTest(t, Config{})
+	defer other.close()
+	other.runner = test.runner
+	other.orchestrator = other.newOrchestrator(Config{})
+	assert.Nil(t, test.orchestrator.Submit(newTestJob("job1")))
+	assert.Nil(t, other.orchestrator.Submit(newTestJob("job2")))
+
+	// the agents start segments at once: the other one asks this one once this one marked its
+	// segments, so it sets its own back, and this one starts them
+	test.orchestrator.SetPeers(peersFunc(func(ctx context.Context) ([]*Segment, error) {
+		other.step()
+		return other.orchestrator.RunningSegments(), nil
+	}))
+	other.orchestrator.SetPeers(peersFunc(func(ctx context.Context) ([]*Segment, error) {
+		return test.orchestrator.RunningSegments(), nil
+	}))
+	test.step()
+	assert.Equal(t, []fakeCommand{{"10.0.1.1", "0:10"}, {"10.0.2.1", "20:30"}}, test.runner.started())
+	assert.Equal(t, Progress{Total: 3, Pending: 1, Running: 2}, test.orchestrator.Job("job1").Progress())
+	assert.Equal(t, Progress{Total: 3, Pending: 3}, other.orchestrator.Job("job2").Progress())
+	assert.Equal(t, 0, other.orchestrator.Job("job2").Segments[0].Attempts)
+
+	// the other agent waits for the segments of this one
+	other.now = other.now.Add(time.Minute)
+	other.step()
+	assert.Len(t, test.runner.started(), 2)
+	test.runner.finish(SegmentSucceeded)
+	test.orchestrator.SetPeers(peersFunc(func(ctx context.Context) ([]*Segment, error) {
+		return other.orchestrator.RunningSegments(), nil
+	}))
+	test.step()
+	other.now = other.now.Add(time.Minute)
+	other.step()
+	assert.Equal(t, []fakeCommand{{"10.0.1.2", "10:20"}, {"10.0.2.1", "20:30"}}, test.runner.started()[2:])
+	assert.Equal(t, Progress{Total: 3, Succeeded: 2, Running: 1}, test.orchestrator.Job("job1").Progress())
+	assert.Equal(t, Progress{Total: 3, Pending: 2, Running: 1}, other.orchestrator.Job("job2").Progress())
+
+	// nothing starts while the other agents can not be asked
+	test.runner.finish(SegmentSucceeded)
+	other.orchestrator.SetPeers(peersFunc(func(ctx context.Context) ([]*Segment, error) {
+		return nil, fmt.Errorf("No response from the agents of 10.0.0.1")
+	}))
+	other.now = other.now.Add(time.Minute)
+	other.step()
+	assert.Equal(t, Progress{Total: 3, Pending: 2, Succeeded: 1}, other.orchestrator.Job("job2").Progress())
+	assert.Len(t, test.runner.started(), 4)
+}
+
+func TestOrchestratorRetries(t *testing.T) {
+	test := newOrchestratorTest(t, Config{MaxPerDatacenter: 2, MaxAttempts: 2, RetryDelay: time.Minute})
+	defer test.close()
+	job := newTestJob("job1")
+	job.Segments = job.Segments[2:]
+	assert.Nil(t, test.orchestrator.Submit(job))
+
+	test.step()
+	test.runner.finish(SegmentFailed)
+	test.step()
+	segment := test.orchestrator.Job("job1").Segments[0]
+	assert.Equal(t, SegmentPending, segment.Status)
+	assert.Equal(t, "Repair session failed", segment.Error)
+	assert.Equal(t, test.now.Add(time.Minute), segment.NextAttemptAt)
+
+	// the next attempt waits for the retry delay, and is coordinated by the next replica
+	test.step()
+	assert.Len(t, test.runner.started(), 1)
+	test.now = test.now.Add(time.Minute)
+	test.runner.failing["10.0.2.2"] = true
+	test.step()
+	job = test.orchestrator.Job("job1")
+	assert.Equal(t, JobFailed, job.Status)
+	assert.Equal(t, 2, job.Segments[0].Attempts)
+	assert.Contains(t, job.Segments[0].Error, "Could not start the repair on 10.0.2.2")
+}
+
+func TestOrchestratorTimeout(t *testing.T) {
+	test := newOrchestratorTest(t, Config{MaxAttempts: 1, SegmentTimeout: time.Hour})
+	defer test.close()
+	job := newTestJob("job1")
+	job.Segments = job.Segments[2:]
+	assert.Nil(t, test.orchestrator.Submit(job))
+
+	test.step()
+	test.now = test.now.Add(time.Hour + time.Second)
+	test.step()
+	job = test.orchestrator.Job("job1")
+	assert.Equal(t, JobFailed, job.Status)
+	assert.Equal(t, "Timed out after 1h0m0s", job.Segments[0].Error)
+}
+
+func TestOrchestratorResume(t *testing.T) {
+	test := newOrchestratorTest(t, Config{})
+	defer test.close()
+	assert.Nil(t, test.orchestrator.Submit(newTestJob("job1")))
+	test.step()
+
+	// a restarted agent polls the commands started before it restarted
+	test.orchestrator = test.newOrchestrator(Config{})
+	assert.Equal(t, Progress{Total: 3, Pending: 1, Running: 2}, test.orchestrator.Job("job1").Progress())
+	test.runner.finish(SegmentSucceeded)
+	test.step()
+	test.runner.finish(SegmentSucceeded)
+	test.step()
+	assert.Equal(t, JobSucceeded, test.orchestrator.Job("job1").Status)
+	assert.Len(t, test.runner.started(), 3)
+}
+
+func TestOrchestratorCancel(t *testing.T) {
+	test := newOrchestratorTest(t, Config{})
+	defer test.close()
+	assert.Nil(t, test.orchestrator.Submit(newTestJob("job1")))
+	test.step()
+
+	assert.Nil(t, test.orchestrator.Cancel("job1"))
+	assert.NotNil(t, test.orchestrator.Cancel("job1"))
+	assert.NotNil(t, test.orchestrator.Cancel("job2"))
+	test.runner.finish(SegmentSucceeded)
+	test.step()
+	job := test.orchestrator.Job("job1")
+	assert.Equal(t, JobCancelled, job.Status)
+	assert.Equal(t, Progress{Total: 3, Pending: 1, Succeeded: 2}, job.Progress())
+	assert.Len(t, test.runner.started(), 2)
+}
+
+func TestConfigDefaults(t *testing.T) {
+	assert.Equal(t, DefaultPollInterval, Config{PollInterval: -time.Second}.withDefaults().PollInterval)
+	assert.Equal(t, time.Second, Config{PollInterval: time.Second}.withDefaults().PollInterval)
+}
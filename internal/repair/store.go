@@ -0,0 +1,50 @@
+package repair
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Store persists the repair jobs in a JSON file, so they are resumed when the agent restarts
+type Store struct {
+	path string
+}
+
+// NewStore builds a store keeping the jobs in the file, creating its directory if needed
+func NewStore(path string) (*Store, error) {
+	if err := os.MkdirAll(filepath.Dir(path), os.FileMode(0770)); err != nil {
+		return nil, err
+	}
+	return &Store{path: path}, nil
+}
+
+// Load reads the jobs, of which there are none when the file does not exist yet
+func (s *Store) Load() ([]*Job, error) {
+	buf, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return []*Job{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	jobs := make([]*Job, 0)
+	if err := json.Unmarshal(buf, &jobs); err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+// Save replaces the jobs of the file. They are written to a temporary file first, which is
+// then renamed, so a crash can not leave a truncated file behind.
+func (s *Store) Save(jobs []*Job) error {
+	buf, err := json.Marshal(jobs)
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, buf, os.FileMode(0660)); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
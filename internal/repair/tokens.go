@@ -0,0 +1,77 @@
+package repair
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// TokenRange is a range of the ring, from its start token, exclusive, to its end token, inclusive
+type TokenRange struct {
+	Start string `json:"start_token"`
+	End   string `json:"end_token"`
+}
+
+// tokenRing describes the numeric tokens of a partitioner, from min to min + size, exclusive
+type tokenRing struct {
+	min  *big.Int
+	size *big.Int
+}
+
+// ringOf returns the ring of the partitioner, or nil when its tokens are not numeric, like
+// the ones of the ByteOrderedPartitioner, whose ranges can not be split
+func ringOf(partitioner string) *tokenRing {
+	switch {
+	case strings.HasSuffix(partitioner, "Murmur3Partitioner"):
+		min := new(big.Int).Neg(new(big.Int).Lsh(big.NewInt(1), 63))
+		return &tokenRing{min: min, size: new(big.Int).Lsh(big.NewInt(1), 64)}
+	case strings.HasSuffix(partitioner, "RandomPartitioner"):
+		return &tokenRing{min: big.NewInt(0), size: new(big.Int).Add(new(big.Int).Lsh(big.NewInt(1), 127), big.NewInt(1))}
+	}
+	return nil
+}
+
+// SplitRange splits the range into count subranges of about the same number of tokens. A
+// range whose start is not before its end wraps around the ring. The ranges of partitioners
+// with non numeric tokens are not split.
+func SplitRange(partitioner string, r TokenRange, count int) ([]TokenRange, error) {
+	ring := ringOf(partitioner)
+	if ring == nil || count <= 1 {
+		return []TokenRange{r}, nil
+	}
+	start, ok := new(big.Int).SetString(r.Start, 10)
+	if !ok {
+		return nil, fmt.Errorf("Invalid token '%s'", r.Start)
+	}
+	end, ok := new(big.Int).SetString(r.End, 10)
+	if !ok {
+		return nil, fmt.Errorf("Invalid token '%s'", r.End)
+	}
+
+	// the width of a wrapping range, or of the whole ring when start and end are the same
+	width := new(big.Int).Sub(end, start)
+	if width.Sign() <= 0 {
+		width.Add(width, ring.size)
+	}
+	if width.Cmp(big.NewInt(int64(count))) < 0 {
+		count = int(width.Int64())
+	}
+
+	subranges := make([]TokenRange, 0, count)
+	previous := r.Start
+	for i := 1; i < count; i++ {
+		offset := new(big.Int).Mul(width, big.NewInt(int64(i)))
+		offset.Quo(offset, big.NewInt(int64(count)))
+		token := ring.wrap(offset.Add(offset, start))
+		subranges = append(subranges, TokenRange{Start: previous, End: token.String()})
+		previous = token.String()
+	}
+	return append(subranges, TokenRange{Start: previous, End: r.End}), nil
+}
+
+// wrap brings a token past the end of the ring back to its start
+func (ring *tokenRing) wrap(token *big.Int) *big.Int {
+	offset := new(big.Int).Sub(token, ring.min)
+	offset.Mod(offset, ring.size)
+	return offset.Add(offset, ring.min)
+}
@@ -0,0 +1,40 @@
+package repair
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const murmur3 = "org.apache.cassandra.dht.Murmur3Partitioner"
+
+func TestSplitRange(t *testing.T) {
+	subranges, err := SplitRange(murmur3, TokenRange{Start: "0", End: "100"}, 4)
+	assert.Nil(t, err)
+	assert.Equal(t, []TokenRange{{"0", "25"}, {"25", "50"}, {"50", "75"}, {"75", "100"}}, subranges)
+
+	// wraps around the end of the ring
+	subranges, err = SplitRange(murmur3, TokenRange{Start: "9223372036854775800", End: "-9223372036854775800"}, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, []TokenRange{
+		{"9223372036854775800", "-9223372036854775808"},
+		{"-9223372036854775808", "-9223372036854775800"},
+	}, subranges)
+
+	// a range with the same start and end covers the whole ring
+	subranges, err = SplitRange(murmur3, TokenRange{Start: "-9223372036854775808", End: "-9223372036854775808"}, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, []TokenRange{{"-9223372036854775808", "0"}, {"0", "-9223372036854775808"}}, subranges)
+
+	// a range can not be split into more subranges than it has tokens
+	subranges, err = SplitRange(murmur3, TokenRange{Start: "0", End: "2"}, 4)
+	assert.Nil(t, err)
+	assert.Equal(t, []TokenRange{{"0", "1"}, {"1", "2"}}, subranges)
+
+	subranges, err = SplitRange("org.apache.cassandra.dht.ByteOrderedPartitioner", TokenRange{Start: "00", End: "ff"}, 4)
+	assert.Nil(t, err)
+	assert.Equal(t, []TokenRange{{"00", "ff"}}, subranges)
+
+	_, err = SplitRange(murmur3, TokenRange{Start: "a", End: "100"}, 4)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,218 @@
+// Package rolling runs cluster operations, like restarts and upgrades, one node, or one rack,
+// at a time, waiting for the cluster to be healthy again before moving on to the next ones.
+package rolling
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// JobStatus is the status of a rolling job
+type JobStatus string
+
+// Statuses of a rolling job
+const (
+	JobRunning   JobStatus = "running"
+	JobSucceeded JobStatus = "succeeded"
+	JobFailed    JobStatus = "failed"
+	JobCancelled JobStatus = "cancelled"
+)
+
+// StepStatus is the status of a step of a node
+type StepStatus string
+
+// Statuses of a step
+const (
+	StepPending   StepStatus = "pending"
+	StepRunning   StepStatus = "running"
+	StepSucceeded StepStatus = "succeeded"
+	StepFailed    StepStatus = "failed"
+)
+
+// Step is one of the steps run on a node, like drain or restart
+type Step struct {
+	Name       string     `json:"name"`
+	Status     StepStatus `json:"status"`
+	Error      string     `json:"error,omitempty"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt time.Time  `json:"finished_at"`
+}
+
+// Node is a node of the cluster, with its steps
+type Node struct {
+	Address    string  `json:"address"`
+	Datacenter string  `json:"datacenter"`
+	Rack       string  `json:"rack"`
+	Steps      []*Step `json:"steps,omitempty"`
+}
+
+// Status returns the status of the node: pending until its first step starts, failed if
+// one of them failed, and succeeded once all of them succeeded
+func (n *Node) Status() StepStatus {
+	pending, succeeded := 0, 0
+	for _, step := range n.Steps {
+		switch step.Status {
+		case StepPending:
+			pending++
+		case StepSucceeded:
+			succeeded++
+		case StepFailed:
+			return StepFailed
+		}
+	}
+	switch {
+	case pending == len(n.Steps):
+		return StepPending
+	case succeeded == len(n.Steps):
+		return StepSucceeded
+	}
+	return StepRunning
+}
+
+// Job runs its node steps on each node in turn, on up to concurrency nodes at once when it is
+// set, or on the nodes of each rack at once when by rack, and then its final steps on each
+// node in turn, once the whole cluster went through the node steps
+type Job struct {
+	ID          string            `json:"id"`
+	Kind        string            `json:"kind"`
+	ByRack      bool              `json:"by_rack"`
+	Concurrency int               `json:"concurrency,omitempty"`
+	Params      map[string]string `json:"params,omitempty"`
+	NodeSteps   []string          `json:"node_steps"`
+	FinalSteps  []string          `json:"final_steps,omitempty"`
+	Status      JobStatus         `json:"status"`
+	Error       string            `json:"error,omitempty"`
+	CreatedAt   time.Time         `json:"created_at"`
+	FinishedAt  time.Time         `json:"finished_at"`
+	Nodes       []*Node           `json:"nodes"`
+}
+
+// Copy returns a deep copy of the job, so it can be read while its steps are run
+func (j *Job) Copy() *Job {
+	c := *j
+	if j.Params != nil {
+		c.Params = make(map[string]string, len(j.Params))
+		for key, value := range j.Params {
+			c.Params[key] = value
+		}
+	}
+	c.NodeSteps = copyStrings(j.NodeSteps)
+	c.FinalSteps = copyStrings(j.FinalSteps)
+	if j.Nodes != nil {
+		c.Nodes = make([]*Node, 0, len(j.Nodes))
+	}
+	for _, node := range j.Nodes {
+		n := *node
+		if node.Steps != nil {
+			n.Steps = make([]*Step, 0, len(node.Steps))
+		}
+		for _, step := range node.Steps {
+			s := *step
+			n.Steps = append(n.Steps, &s)
+		}
+		c.Nodes = append(c.Nodes, &n)
+	}
+	return &c
+}
+
+// copyStrings copies the list, keeping it nil if it is
+func copyStrings(list []string) []string {
+	if list == nil {
+		return nil
+	}
+	return append([]string{}, list...)
+}
+
+// Progress counts the nodes of a job by status
+type Progress struct {
+	Total     int `json:"total"`
+	Pending   int `json:"pending"`
+	Running   int `json:"running"`
+	Succeeded int `json:"succeeded"`
+	Failed    int `json:"failed"`
+}
+
+// NewJob plans a job running the steps on the nodes, which are sorted by datacenter, rack
+// and address
+func NewJob(id, kind string, nodes []Node, nodeSteps, finalSteps []string, byRack bool, now time.Time) (*Job, error) {
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("There are no nodes to run the %s on", kind)
+	}
+	if len(nodeSteps) == 0 {
+		return nil, fmt.Errorf("A %s job needs steps to run on the nodes", kind)
+	}
+	job := &Job{
+		ID:         id,
+		Kind:       kind,
+		ByRack:     byRack,
+		Params:     make(map[string]string),
+		NodeSteps:  nodeSteps,
+		FinalSteps: finalSteps,
+		Status:     JobRunning,
+		CreatedAt:  now,
+		Nodes:      make([]*Node, 0, len(nodes)),
+	}
+	for _, node := range nodes {
+		n := &Node{Address: node.Address, Datacenter: node.Datacenter, Rack: node.Rack}
+		for _, name := range append(append([]string{}, nodeSteps...), finalSteps...) {
+			n.Steps = append(n.Steps, &Step{Name: name, Status: StepPending})
+		}
+		job.Nodes = append(job.Nodes, n)
+	}
+	sort.Slice(job.Nodes, func(i, j int) bool {
+		a, b := job.Nodes[i], job.Nodes[j]
+		if a.Datacenter != b.Datacenter {
+			return a.Datacenter < b.Datacenter
+		}
+		if a.Rack != b.Rack {
+			return a.Rack < b.Rack
+		}
+		return a.Address < b.Address
+	})
+	return job, nil
+}
+
+// Finished returns whether the job succeeded, failed or was cancelled
+func (job *Job) Finished() bool {
+	return job.Status != JobRunning
+}
+
+// Progress counts the nodes of the job by status
+func (job *Job) Progress() Progress {
+	progress := Progress{Total: len(job.Nodes)}
+	for _, node := range job.Nodes {
+		switch node.Status() {
+		case StepPending:
+			progress.Pending++
+		case StepRunning:
+			progress.Running++
+		case StepSucceeded:
+			progress.Succeeded++
+		case StepFailed:
+			progress.Failed++
+		}
+	}
+	return progress
+}
+
+// groups returns the nodes which go through the node steps together: each node alone, up
+// to concurrency nodes, or the nodes of each rack when by rack
+func (job *Job) groups() [][]*Node {
+	groups := make([][]*Node, 0, len(job.Nodes))
+	for i, node := range job.Nodes {
+		if job.ByRack && i > 0 {
+			previous := job.Nodes[i-1]
+			if previous.Datacenter == node.Datacenter && previous.Rack == node.Rack {
+				groups[len(groups)-1] = append(groups[len(groups)-1], node)
+				continue
+			}
+		}
+		if !job.ByRack && i > 0 && len(groups[len(groups)-1]) < job.Concurrency {
+			groups[len(groups)-1] = append(groups[len(groups)-1], node)
+			continue
+		}
+		groups = append(groups, []*Node{node})
+	}
+	return groups
+}
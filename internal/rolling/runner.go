@@ -0,0 +1,252 @@
+package rolling
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// DefaultKeptJobs is the number of finished jobs kept by the runner
+const DefaultKeptJobs = 20
+
+// ErrJobRunning is returned when starting a job while another one is running
+var ErrJobRunning = errors.New("Another rolling job is running")
+
+// Executor runs the steps of the jobs on the nodes, and tells when the cluster is healthy
+type Executor interface {
+	// RunStep runs the step on the node, and returns once it is finished
+	RunStep(ctx context.Context, jobID, address, step string, params map[string]string) error
+	// WaitHealthy returns once the cluster is healthy, after the node steps of some nodes,
+	// or fails if it does not get healthy in time
+	WaitHealthy(ctx context.Context) error
+}
+
+// Runner runs one job at a time, halting it on the first failure. The jobs are only kept in
+// memory, so the running one is lost if the agent restarts.
+type Runner struct {
+	executor Executor
+	now      func() time.Time
+
+	mtx     sync.Mutex
+	jobs    []*Job
+	cancels map[string]context.CancelFunc
+}
+
+// NewRunner builds a runner of jobs, whose steps are run by the executor
+func NewRunner(executor Executor) *Runner {
+	return &Runner{executor: executor, now: time.Now, cancels: make(map[string]context.CancelFunc)}
+}
+
+// SetClock replaces the clock telling the time of the steps
+func (r *Runner) SetClock(now func() time.Time) {
+	r.now = now
+}
+
+// Start runs the job in the background, until it is finished, cancelled, or the context is
+// done. Fails if another job is running.
+func (r *Runner) Start(ctx context.Context, job *Job) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if running := r.running(); running != nil {
+		return fmt.Errorf("%s: %s %s", ErrJobRunning, running.Kind, running.ID)
+	}
+	if r.find(job.ID) != nil {
+		return fmt.Errorf("Rolling job %s already exists", job.ID)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	r.jobs = append(r.jobs, job)
+	r.cancels[job.ID] = cancel
+	r.prune()
+	go r.run(ctx, job)
+	return nil
+}
+
+// Running returns a copy of the running job, or nil if none is
+func (r *Runner) Running() *Job {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if job := r.running(); job != nil {
+		return job.Copy()
+	}
+	return nil
+}
+
+// Jobs returns copies of all the jobs, from the oldest to the newest
+func (r *Runner) Jobs() []*Job {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	jobs := make([]*Job, 0, len(r.jobs))
+	for _, job := range r.jobs {
+		jobs = append(jobs, job.Copy())
+	}
+	return jobs
+}
+
+// Job returns a copy of the job, or nil if it does not exist
+func (r *Runner) Job(id string) *Job {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if job := r.find(id); job != nil {
+		return job.Copy()
+	}
+	return nil
+}
+
+// Cancel stops the job. The step being run is interrupted, but what it started on its node,
+// like a command, may go on.
+func (r *Runner) Cancel(id string) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	job := r.find(id)
+	if job == nil {
+		return fmt.Errorf("Rolling job %s does not exist", id)
+	}
+	if job.Finished() {
+		return fmt.Errorf("Rolling job %s is already %s", id, job.Status)
+	}
+	job.Status = JobCancelled
+	job.FinishedAt = r.now()
+	r.cancels[id]()
+	delete(r.cancels, id)
+	return nil
+}
+
+func (r *Runner) run(ctx context.Context, job *Job) {
+	err := r.runSteps(ctx, job)
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if cancel, ok := r.cancels[job.ID]; ok {
+		cancel()
+		delete(r.cancels, job.ID)
+	}
+	if job.Finished() {
+		return // cancelled
+	}
+	job.FinishedAt = r.now()
+	if err != nil {
+		job.Status, job.Error = JobFailed, err.Error()
+		logrus.Errorf("The %s job %s failed: %s", job.Kind, job.ID, err)
+		return
+	}
+	job.Status = JobSucceeded
+	logrus.Infof("The %s job %s succeeded", job.Kind, job.ID)
+}
+
+func (r *Runner) runSteps(ctx context.Context, job *Job) error {
+	nodeSteps := len(job.NodeSteps)
+	for _, group := range job.groups() {
+		if err := r.runGroup(ctx, job, group, 0, nodeSteps); err != nil {
+			return err
+		}
+		if err := r.executor.WaitHealthy(ctx); err != nil {
+			return fmt.Errorf("The cluster did not get healthy after the %s of %s: %s", job.Kind, addresses(group), err)
+		}
+	}
+	for _, node := range job.Nodes {
+		if err := r.runGroup(ctx, job, []*Node{node}, nodeSteps, len(node.Steps)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runGroup runs the steps, from first to last excluded, on the nodes of the group at once
+func (r *Runner) runGroup(ctx context.Context, job *Job, group []*Node, first, last int) error {
+	if first == last {
+		return nil
+	}
+	errs := make(chan error, len(group))
+	for _, node := range group {
+		go func(node *Node) {
+			errs <- r.runNode(ctx, job, node, first, last)
+		}(node)
+	}
+	var err error
+	for range group {
+		if e := <-errs; e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+func (r *Runner) runNode(ctx context.Context, job *Job, node *Node, first, last int) error {
+	for _, step := range node.Steps[first:last] {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		r.update(func() {
+			step.Status, step.StartedAt = StepRunning, r.now()
+		})
+		logrus.Infof("Running the %s step of the %s job %s on %s", step.Name, job.Kind, job.ID, node.Address)
+		err := r.executor.RunStep(ctx, job.ID, node.Address, step.Name, job.Params)
+		r.update(func() {
+			step.FinishedAt = r.now()
+			if err != nil {
+				step.Status, step.Error = StepFailed, err.Error()
+			} else {
+				step.Status = StepSucceeded
+			}
+		})
+		if err != nil {
+			return fmt.Errorf("The %s step failed on %s: %s", step.Name, node.Address, err)
+		}
+	}
+	return nil
+}
+
+func (r *Runner) update(f func()) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	f()
+}
+
+func (r *Runner) running() *Job {
+	for _, job := range r.jobs {
+		if !job.Finished() {
+			return job
+		}
+	}
+	return nil
+}
+
+func (r *Runner) find(id string) *Job {
+	for _, job := range r.jobs {
+		if job.ID == id {
+			return job
+		}
+	}
+	return nil
+}
+
+// prune forgets the oldest finished jobs, beyond the kept ones
+func (r *Runner) prune() {
+	finished := 0
+	for _, job := range r.jobs {
+		if job.Finished() {
+			finished++
+		}
+	}
+	kept := make([]*Job, 0, len(r.jobs))
+	for _, job := range r.jobs {
+		if finished > DefaultKeptJobs && job.Finished() {
+			finished--
+			continue
+		}
+		kept = append(kept, job)
+	}
+	r.jobs = kept
+}
+
+func addresses(nodes []*Node) []string {
+	addrs := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		addrs = append(addrs, node.Address)
+	}
+	return addrs
+}
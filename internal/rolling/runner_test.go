@@ -0,0 +1,173 @@
+package rolling
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeExecutor records the steps and health checks, and fails the steps it is told to
+type fakeExecutor struct {
+	mtx      sync.Mutex
+	calls    []string
+	failures map[string]error
+	block    chan struct{}
+}
+
+func newFakeExecutor() *fakeExecutor {
+	return &fakeExecutor{failures: make(map[string]error)}
+}
+
+func (e *fakeExecutor) RunStep(ctx context.Context, jobID, address, step string, params map[string]string) error {
+	e.record(fmt.Sprintf("%s %s", step, address))
+	if e.block != nil && step == "restart" {
+		select {
+		case <-e.block:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	return e.failures[step+" "+address]
+}
+
+func (e *fakeExecutor) WaitHealthy(ctx context.Context) error {
+	e.record("healthy")
+	return nil
+}
+
+func (e *fakeExecutor) record(call string) {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	e.calls = append(e.calls, call)
+}
+
+func (e *fakeExecutor) Calls() []string {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	return append([]string{}, e.calls...)
+}
+
+var testNodes = []Node{
+	{Address: "10.0.1.2", Datacenter: "dc1", Rack: "r1"},
+	{Address: "10.0.2.1", Datacenter: "dc1", Rack: "r2"},
+	{Address: "10.0.1.1", Datacenter: "dc1", Rack: "r1"},
+}
+
+func waitFinished(t *testing.T, runner *Runner, id string) *Job {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if job := runner.Job(id); job.Finished() {
+			return job
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("Timed out waiting for the job %s", id)
+	return nil
+}
+
+func TestJobCopy(t *testing.T) {
+	job, err := NewJob("j1", "upgrade", testNodes, []string{"drain"}, nil, false, time.Now())
+	assert.Nil(t, err)
+	job.Params = map[string]string{"version": "3.11.4"}
+	c := job.Copy()
+	assert.Equal(t, job, c)
+
+	c.Params["version"] = "4.0.0"
+	c.NodeSteps[0] = "restart"
+	c.Nodes[0].Steps[0].Status = StepFailed
+	assert.Equal(t, "3.11.4", job.Params["version"])
+	assert.Equal(t, "drain", job.NodeSteps[0])
+	assert.Equal(t, StepPending, job.Nodes[0].Steps[0].Status)
+}
+
+func TestRunnerRunsNodesInTurn(t *testing.T) {
+	executor := newFakeExecutor()
+	runner := NewRunner(executor)
+	job, err := NewJob("j1", "upgrade", testNodes, []string{"drain", "restart"}, []string{"upgradesstables"}, false, time.Now())
+	assert.Nil(t, err)
+	assert.Nil(t, runner.Start(context.Background(), job))
+
+	job = waitFinished(t, runner, "j1")
+	assert.Equal(t, JobSucceeded, job.Status)
+	assert.Equal(t, Progress{Total: 3, Succeeded: 3}, job.Progress())
+	assert.Equal(t, []string{
+		"drain 10.0.1.1", "restart 10.0.1.1", "healthy",
+		"drain 10.0.1.2", "restart 10.0.1.2", "healthy",
+		"drain 10.0.2.1", "restart 10.0.2.1", "healthy",
+		"upgradesstables 10.0.1.1", "upgradesstables 10.0.1.2", "upgradesstables 10.0.2.1",
+	}, executor.Calls())
+}
+
+func TestRunnerRunsRacksAtOnce(t *testing.T) {
+	executor := newFakeExecutor()
+	runner := NewRunner(executor)
+	job, err := NewJob("j1", "restart", testNodes, []string{"restart"}, nil, true, time.Now())
+	assert.Nil(t, err)
+	assert.Nil(t, runner.Start(context.Background(), job))
+
+	waitFinished(t, runner, "j1")
+	calls := executor.Calls()
+	sort.Strings(calls[:2])
+	assert.Equal(t, []string{"restart 10.0.1.1", "restart 10.0.1.2"}, calls[:2])
+	assert.Equal(t, []string{"healthy", "restart 10.0.2.1", "healthy"}, calls[2:])
+}
+
+func TestRunnerRunsConcurrentNodes(t *testing.T) {
+	executor := newFakeExecutor()
+	runner := NewRunner(executor)
+	job, err := NewJob("j1", "cleanup", testNodes, []string{"cleanup"}, nil, false, time.Now())
+	assert.Nil(t, err)
+	job.Concurrency = 2
+	assert.Nil(t, runner.Start(context.Background(), job))
+
+	waitFinished(t, runner, "j1")
+	calls := executor.Calls()
+	sort.Strings(calls[:2])
+	assert.Equal(t, []string{"cleanup 10.0.1.1", "cleanup 10.0.1.2"}, calls[:2])
+	assert.Equal(t, []string{"healthy", "cleanup 10.0.2.1", "healthy"}, calls[2:])
+}
+
+func TestRunnerHaltsOnFailure(t *testing.T) {
+	executor := newFakeExecutor()
+	executor.failures["restart 10.0.1.2"] = fmt.Errorf("exit status 1")
+	runner := NewRunner(executor)
+	job, err := NewJob("j1", "restart", testNodes, []string{"drain", "restart"}, nil, false, time.Now())
+	assert.Nil(t, err)
+	assert.Nil(t, runner.Start(context.Background(), job))
+
+	job = waitFinished(t, runner, "j1")
+	assert.Equal(t, JobFailed, job.Status)
+	assert.Equal(t, "The restart step failed on 10.0.1.2: exit status 1", job.Error)
+	assert.Equal(t, Progress{Total: 3, Pending: 1, Succeeded: 1, Failed: 1}, job.Progress())
+	assert.Equal(t, []string{"drain 10.0.1.1", "restart 10.0.1.1", "healthy", "drain 10.0.1.2", "restart 10.0.1.2"}, executor.Calls())
+}
+
+func TestRunnerCancel(t *testing.T) {
+	executor := newFakeExecutor()
+	executor.block = make(chan struct{})
+	runner := NewRunner(executor)
+	job, err := NewJob("j1", "restart", testNodes, []string{"restart"}, nil, false, time.Now())
+	assert.Nil(t, err)
+	assert.Nil(t, runner.Start(context.Background(), job))
+
+	other, err := NewJob("j2", "restart", testNodes, []string{"restart"}, nil, false, time.Now())
+	assert.Nil(t, err)
+	assert.EqualError(t, runner.Start(context.Background(), other), "Another rolling job is running: restart j1")
+	assert.Equal(t, "j1", runner.Running().ID)
+
+	assert.Nil(t, runner.Cancel("j1"))
+	job = waitFinished(t, runner, "j1")
+	assert.Equal(t, JobCancelled, job.Status)
+	assert.EqualError(t, runner.Cancel("j1"), "Rolling job j1 is already cancelled")
+	assert.Nil(t, runner.Running())
+	assert.Nil(t, runner.Start(context.Background(), other))
+	close(executor.block)
+	assert.Equal(t, JobSucceeded, waitFinished(t, runner, "j2").Status)
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Role is the access level granted to an API token. Roles are ordered, so a
+// token with a higher role can also call every route that requires a lower one.
+type Role int
+
+// Known API roles, from the least to the most privileged
+const (
+	RoleReadOnly Role = iota + 1
+	RoleOperator
+	RoleAdmin
+)
+
+var roleNames = map[Role]string{
+	RoleReadOnly: "read-only",
+	RoleOperator: "operator",
+	RoleAdmin:    "admin",
+}
+
+func (r Role) String() string {
+	if name, ok := roleNames[r]; ok {
+		return name
+	}
+	return fmt.Sprintf("Role(%d)", int(r))
+}
+
+// ParseRole converts a role name, as used in the configuration file, into a Role
+func ParseRole(name string) (Role, error) {
+	for role, roleName := range roleNames {
+		if strings.EqualFold(name, roleName) {
+			return role, nil
+		}
+	}
+	return 0, fmt.Errorf("Unknown API role '%s'", name)
+}
+
+// APIToken is a bearer token allowed to call the HTTP API with a given role
+type APIToken struct {
+	Name  string `mapstructure:"name"`
+	Token string `mapstructure:"token"`
+	Role  string `mapstructure:"role"`
+}
+
+type apiCredential struct {
+	name  string
+	token []byte
+	role  Role
+}
+
+// tokenAuthenticator checks bearer tokens against the configured ones. When no
+// tokens are configured, authentication is disabled and every call is allowed.
+type tokenAuthenticator struct {
+	credentials []apiCredential
+}
+
+func newTokenAuthenticator(tokens []APIToken) (*tokenAuthenticator, error) {
+	auth := &tokenAuthenticator{credentials: make([]apiCredential, 0, len(tokens))}
+	for i, token := range tokens {
+		if token.Token == "" {
+			return nil, fmt.Errorf("API token %d (%s) is empty", i, token.Name)
+		}
+		role, err := ParseRole(token.Role)
+		if err != nil {
+			return nil, err
+		}
+		auth.credentials = append(auth.credentials, apiCredential{
+			name:  token.Name,
+			token: []byte(token.Token),
+			role:  role,
+		})
+	}
+	if !auth.enabled() {
+		logrus.Warn("No API tokens configured, the HTTP API is not authenticated")
+	}
+	return auth, nil
+}
+
+func (auth *tokenAuthenticator) enabled() bool {
+	return len(auth.credentials) > 0
+}
+
+// authenticate returns the credential matching the token. All the configured
+// tokens are always compared, to not leak which ones are close to a valid one.
+func (auth *tokenAuthenticator) authenticate(token string) (cred *apiCredential, ok bool) {
+	for i := range auth.credentials {
+		if subtle.ConstantTimeCompare(auth.credentials[i].token, []byte(token)) == 1 {
+			cred = &auth.credentials[i]
+		}
+	}
+	return cred, cred != nil
+}
+
+// require wraps the handler so it is only called with a token granting at least the given role
+func (auth *tokenAuthenticator) require(role Role, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !auth.enabled() {
+			handler(w, r)
+			return
+		}
+		token := bearerToken(r)
+		if token == "" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="CaOps"`)
+			http.Error(w, "Missing bearer token", http.StatusUnauthorized)
+			return
+		}
+		cred, ok := auth.authenticate(token)
+		if !ok {
+			logrus.Warnf("Rejected invalid API token from %s for %s %s", r.RemoteAddr, r.Method, r.URL.Path)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="CaOps", error="invalid_token"`)
+			http.Error(w, "Invalid bearer token", http.StatusUnauthorized)
+			return
+		}
+		if cred.role < role {
+			logrus.Warnf("API token '%s' (%s) is not allowed to %s %s", cred.name, cred.role, r.Method, r.URL.Path)
+			http.Error(w, fmt.Sprintf("The %s role is required", role), http.StatusForbidden)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+func bearerToken(r *http.Request) string {
+	const prefix = "bearer "
+	header := r.Header.Get("Authorization")
+	if len(header) < len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return ""
+	}
+	return strings.TrimSpace(header[len(prefix):])
+}
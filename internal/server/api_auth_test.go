@@ -0,0 +1,53 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenAuthenticatorRequire(t *testing.T) {
+	auth, err := newTokenAuthenticator([]APIToken{
+		{Name: "dashboards", Token: "ro-token", Role: "read-only"},
+		{Name: "ops", Token: "op-token", Role: "Operator"},
+	})
+	assert.Nil(t, err)
+	handler := auth.require(RoleOperator, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	for header, status := range map[string]int{
+		"":                 http.StatusUnauthorized,
+		"Bearer wrong":     http.StatusUnauthorized,
+		"Basic op-token":   http.StatusUnauthorized,
+		"Bearer ro-token":  http.StatusForbidden,
+		"Bearer op-token":  http.StatusNoContent,
+		"bearer  op-token": http.StatusNoContent,
+	} {
+		r := httptest.NewRequest("GET", "/status", nil)
+		if header != "" {
+			r.Header.Set("Authorization", header)
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		assert.Equal(t, status, w.Code, header)
+	}
+}
+
+func TestTokenAuthenticatorDisabled(t *testing.T) {
+	auth, err := newTokenAuthenticator(nil)
+	assert.Nil(t, err)
+	handler := auth.require(RoleAdmin, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("DELETE", "/snapshots", nil))
+	assert.Equal(t, http.StatusNoContent, w.Code)
+}
+
+func TestNewTokenAuthenticatorUnknownRole(t *testing.T) {
+	_, err := newTokenAuthenticator([]APIToken{{Name: "x", Token: "x", Role: "root"}})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// tlsReloader keeps the server certificate, and the CA used to verify client
+// certificates, loaded from disk, and reloads them when the files change, so
+// certificates can be rotated without restarting the agent.
+type tlsReloader struct {
+	certFile     string
+	keyFile      string
+	clientCAFile string
+	interval     time.Duration
+
+	mtx       sync.Mutex
+	checkedAt time.Time
+	modTimes  []time.Time
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+}
+
+func newTLSReloader(certFile, keyFile, clientCAFile string, interval time.Duration) (*tlsReloader, error) {
+	if certFile == "" || keyFile == "" {
+		return nil, fmt.Errorf("Both a TLS certificate and key are required")
+	}
+	tr := &tlsReloader{
+		certFile:     certFile,
+		keyFile:      keyFile,
+		clientCAFile: clientCAFile,
+		interval:     interval,
+	}
+	if err := tr.load(); err != nil {
+		return nil, err
+	}
+	return tr, nil
+}
+
+func (tr *tlsReloader) files() []string {
+	files := []string{tr.certFile, tr.keyFile}
+	if tr.clientCAFile != "" {
+		files = append(files, tr.clientCAFile)
+	}
+	return files
+}
+
+func (tr *tlsReloader) load() error {
+	modTimes := make([]time.Time, 0, 3)
+	for _, file := range tr.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		modTimes = append(modTimes, info.ModTime())
+	}
+
+	cert, err := tls.LoadX509KeyPair(tr.certFile, tr.keyFile)
+	if err != nil {
+		return err
+	}
+
+	var clientCAs *x509.CertPool
+	if tr.clientCAFile != "" {
+		pem, err := ioutil.ReadFile(tr.clientCAFile)
+		if err != nil {
+			return err
+		}
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("No certificates found in %s", tr.clientCAFile)
+		}
+	}
+
+	tr.cert, tr.clientCAs, tr.modTimes = &cert, clientCAs, modTimes
+	return nil
+}
+
+// maybeReload reloads the files if any of them changed since the last load. It
+// checks at most once per interval, and keeps the current ones if reloading fails.
+func (tr *tlsReloader) maybeReload() {
+	tr.mtx.Lock()
+	defer tr.mtx.Unlock()
+	if time.Since(tr.checkedAt) < tr.interval {
+		return
+	}
+	tr.checkedAt = time.Now()
+	for i, file := range tr.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			logrus.Errorf("Could not check TLS file %s: %s", file, err)
+			return
+		}
+		if !info.ModTime().Equal(tr.modTimes[i]) {
+			if err := tr.load(); err != nil {
+				logrus.Errorf("Could not reload TLS files, keeping the current ones: %s", err)
+				return
+			}
+			logrus.Info("Reloaded TLS certificates")
+			return
+		}
+	}
+}
+
+// serverConfig builds the TLS configuration for the HTTP API server
+func (tr *tlsReloader) serverConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		GetConfigForClient: tr.getConfigForClient,
+	}
+}
+
+func (tr *tlsReloader) getConfigForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	tr.maybeReload()
+	tr.mtx.Lock()
+	defer tr.mtx.Unlock()
+	config := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{*tr.cert},
+	}
+	if tr.clientCAs != nil {
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+		config.ClientCAs = tr.clientCAs
+	}
+	return config, nil
+}
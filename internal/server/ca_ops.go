@@ -9,77 +9,262 @@ import (
 	"time"
 
 	"github.com/CrossEngage/CaOps/internal/cassandra"
+	"github.com/CrossEngage/CaOps/internal/health"
+	"github.com/CrossEngage/CaOps/internal/jolokia"
+	"github.com/CrossEngage/CaOps/internal/metrics"
+	"github.com/CrossEngage/CaOps/internal/repair"
+	"github.com/CrossEngage/CaOps/internal/rolling"
+	"github.com/CrossEngage/CaOps/internal/webhook"
 	"github.com/Sirupsen/logrus"
 	"github.com/gorilla/mux"
 )
 
 // CaOps encapsulates all the CaOps server behavior
 type CaOps struct {
+	ctx      context.Context
+	cancel   context.CancelFunc
+	clock    clock
 	cassMngr *cassandra.Manager
 	gossiper *Gossiper
 	stopChan chan os.Signal
 	server   *http.Server
 	router   *mux.Router
+	auth     *tokenAuthenticator
+	tls      *tlsReloader
+
+	repairs      *repair.Orchestrator
+	repairConfig RepairConfig
+	// repairPeers keeps the last repair state seen of each other agent, by the repair checks
+	repairPeers map[string]*repairState
+	progress    *progressTracker
+
+	rolling       *rolling.Runner
+	rollingConfig RollingConfig
+	nodeSteps     *nodeSteps
+	rollingClaim  jobClaim
+	// runCommand runs the commands of the steps of the rolling jobs, with the environment
+	// variables added to the ones of the agent
+	runCommand func(ctx context.Context, command string, env ...string) error
+	// freeSpace returns the space available on the file system of a data directory
+	freeSpace func(path string) (uint64, error)
+	// ringOwners are the nodes owning tokens at the last check of the ring
+	ringOwners map[string]bool
+	removals   *nodeRemovals
+
+	replacements  *replacements
+	replaceConfig ReplaceConfig
+
+	health       *health.Registry
+	healthConfig HealthConfig
+
+	webhooks       *webhook.Notifier
+	webhooksConfig WebhooksConfig
+	watched        watchedState
+}
+
+// APIConfig holds the settings of the HTTP API server. TLS is enabled when a
+// certificate and key are given, and client certificates are required and
+// verified when a client CA is also given. Without tokens, the API is open.
+type APIConfig struct {
+	BindAddr          string
+	TLSCertFile       string
+	TLSKeyFile        string
+	TLSClientCAFile   string
+	TLSReloadInterval time.Duration
+	Tokens            []APIToken
+	// MetricsConfigFile lists the MBeans served by /metrics, which is disabled when empty
+	MetricsConfigFile string
 }
 
 // NewCaOps constructs a new CaOps server
-func NewCaOps(httpBindAddr, gossipBindAddr, gossipSnapshotPath, jolokiaAddr string) (*CaOps, error) {
+func NewCaOps(apiConfig APIConfig, gossipBindAddr, gossipSnapshotPath string, jolokiaConfig jolokia.Config,
+	repairConfig RepairConfig, rollingConfig RollingConfig, replaceConfig ReplaceConfig,
+	healthConfig HealthConfig, webhooksConfig WebhooksConfig) (*CaOps, error) {
 
 	// Create the Cassandra Manager
-	cassMngr, err := cassandra.NewManager(jolokiaAddr)
+	cassMngr, err := cassandra.NewManager(jolokiaConfig)
 	if err != nil {
 		return nil, err
 	}
 
-	// Create the Gossiper
-	gossiper, err := NewGossiper(gossipBindAddr, gossipSnapshotPath)
+	// Create the Gossiper, unless the node is managed through a Jolokia proxy, as its agent
+	// then runs on another host, and can not stand for the node in gossip
+	var gossiper *Gossiper
+	if target := cassMngr.JMXTarget(); target != "" {
+		logrus.Warnf("Managing the node through the Jolokia proxy target %s: gossip, backups, repairs, "+
+			"rolling jobs and the cluster status are disabled", target)
+	} else if gossiper, err = NewGossiper(gossipBindAddr, gossipSnapshotPath); err != nil {
+		return nil, err
+	}
+
+	caops, err := newCaOps(apiConfig, cassMngr, gossiper)
 	if err != nil {
 		return nil, err
 	}
+	caops.rollingConfig = rollingConfig.withDefaults()
+	// the repairs and the rolling jobs are coordinated through gossip
+	if gossiper != nil {
+		if err := caops.enableRepairs(repairConfig, gossipSnapshotPath); err != nil {
+			return nil, err
+		}
+		caops.enableRollingJobs()
+		caops.replaceConfig = replaceConfig.withDefaults()
+	}
+	caops.healthConfig = healthConfig.withDefaults()
+	for operation, checks := range caops.healthConfig.Required {
+		if err := caops.health.Require(operation, checks...); err != nil {
+			return nil, err
+		}
+	}
+	caops.webhooksConfig = webhooksConfig.withDefaults()
+	if caops.webhooks, err = webhook.NewNotifier(caops.webhooksConfig.Hooks); err != nil {
+		return nil, err
+	}
+	return caops, nil
+}
 
-	// subscribe to SIGINT signals
-	stopChan := make(chan os.Signal)
-	signal.Notify(stopChan, os.Interrupt)
+// newCaOps constructs a CaOps server around its Cassandra Manager and Gossiper, which is nil
+// when the node is managed through a Jolokia proxy
+func newCaOps(apiConfig APIConfig, cassMngr *cassandra.Manager, gossiper *Gossiper) (*CaOps, error) {
+	// Load the API tokens and, if configured, the TLS certificates
+	auth, err := newTokenAuthenticator(apiConfig.Tokens)
+	if err != nil {
+		return nil, err
+	}
+	var tlsRldr *tlsReloader
+	if apiConfig.TLSCertFile != "" || apiConfig.TLSKeyFile != "" {
+		tlsRldr, err = newTLSReloader(apiConfig.TLSCertFile, apiConfig.TLSKeyFile,
+			apiConfig.TLSClientCAFile, apiConfig.TLSReloadInterval)
+		if err != nil {
+			return nil, err
+		}
+	} else if apiConfig.TLSClientCAFile != "" {
+		return nil, fmt.Errorf("Client certificates can only be verified when TLS is enabled")
+	}
+
+	stopChan := make(chan os.Signal, 1)
 
 	router := mux.NewRouter()
+	server := &http.Server{Addr: apiConfig.BindAddr, Handler: router}
+	if tlsRldr != nil {
+		server.TLSConfig = tlsRldr.serverConfig()
+	}
+
+	// cancelled on shutdown, to stop the calls to Jolokia made by the event handlers
+	ctx, cancel := context.WithCancel(context.Background())
 
 	caops := &CaOps{
+		ctx:      ctx,
+		cancel:   cancel,
+		clock:    realClock{},
 		stopChan: stopChan,
-		server:   &http.Server{Addr: httpBindAddr, Handler: router},
+		server:   server,
 		router:   router,
+		auth:     auth,
+		tls:      tlsRldr,
 		cassMngr: cassMngr,
 		gossiper: gossiper,
+		progress: newProgressTracker(),
+
+		nodeSteps:  newNodeSteps(),
+		runCommand: runShellCommand,
+		freeSpace:  diskFreeSpace,
+		removals:   &nodeRemovals{},
+
+		replacements:  newReplacements(),
+		rollingConfig: RollingConfig{}.withDefaults(),
+		replaceConfig: ReplaceConfig{}.withDefaults(),
+		healthConfig:  HealthConfig{}.withDefaults(),
+
+		webhooksConfig: WebhooksConfig{}.withDefaults(),
+	}
+	if caops.health, err = caops.newHealthRegistry(); err != nil {
+		return nil, err
 	}
 
-	router.Methods("GET").
-		Path("/status").
-		HandlerFunc(caops.statusHandler)
-	router.Methods("GET").
-		Path("/backup-keyspaces/{keyspaceGlob}").
-		HandlerFunc(caops.backupHandler)
-	router.Methods("GET").
-		Path("/backup-tables/{keyspaceGlob}/{table}").
-		HandlerFunc(caops.backupHandler)
-	router.Methods("DELETE").
-		Path("/snapshots").
-		HandlerFunc(caops.clearSnapshotHandler)
+	caops.handle("GET", "/status", RoleReadOnly, caops.statusHandler)
+	caops.handle("GET", "/cluster/status", RoleReadOnly, caops.requireGossip(caops.clusterStatusHandler))
+	caops.handle("GET", "/health/checks", RoleReadOnly, caops.healthChecksHandler)
+	if apiConfig.MetricsConfigFile != "" {
+		metricsConfig, err := metrics.LoadConfig(apiConfig.MetricsConfigFile)
+		if err != nil {
+			return nil, err
+		}
+		exporter := metrics.NewExporter(cassMngr, metricsConfig)
+		caops.handle("GET", "/metrics", RoleReadOnly, exporter.ServeHTTP)
+	}
+	caops.handle("GET", "/progress", RoleReadOnly, caops.progressHandler)
+	caops.handle("GET", "/ring/{keyspace}", RoleReadOnly, caops.ringHandler)
+	caops.handle("GET", "/compactions", RoleReadOnly, caops.compactionsHandler)
+	caops.handle("POST", "/compactions", RoleOperator, caops.startCompactionHandler)
+	caops.handle("DELETE", "/compactions", RoleOperator, caops.stopCompactionsHandler)
+	caops.handle("GET", "/compactions/history", RoleReadOnly, caops.compactionHistoryHandler)
+	caops.handle("GET", "/compactions/throughput", RoleReadOnly, caops.compactionThroughputHandler)
+	caops.handle("PUT", "/compactions/throughput", RoleOperator, caops.setCompactionThroughputHandler)
+	caops.handle("GET", "/keyspaces/{keyspace}/tables/{table}/endpoints", RoleReadOnly, caops.endpointsHandler)
+	caops.handle("GET", "/schema-agreement", RoleReadOnly, caops.requireGossip(caops.schemaAgreementHandler))
+	caops.handle("POST", "/schema-agreement/reset", RoleAdmin, caops.requireGossip(caops.resetSchemaHandler))
+	caops.handle("GET", "/backup-keyspaces/{keyspaceGlob}", RoleOperator, caops.requireGossip(caops.backupHandler))
+	caops.handle("GET", "/backup-tables/{keyspaceGlob}/{table}", RoleOperator, caops.requireGossip(caops.backupHandler))
+	caops.handle("DELETE", "/snapshots", RoleAdmin, caops.requireGossip(caops.clearSnapshotHandler))
+	caops.handle("POST", "/repairs", RoleOperator, caops.requireGossip(caops.startRepairHandler))
+	caops.handle("GET", "/repairs", RoleReadOnly, caops.requireGossip(caops.listRepairsHandler))
+	caops.handle("GET", "/repairs/{id}", RoleReadOnly, caops.requireGossip(caops.repairHandler))
+	caops.handle("DELETE", "/repairs/{id}", RoleOperator, caops.requireGossip(caops.cancelRepairHandler))
+	caops.handle("POST", "/restarts", RoleAdmin, caops.requireGossip(caops.restartHandler))
+	caops.handle("POST", "/upgrades", RoleAdmin, caops.requireGossip(caops.upgradeHandler))
+	caops.handle("POST", "/cleanups", RoleOperator, caops.requireGossip(caops.cleanupHandler))
+	caops.handle("POST", "/decommission", RoleAdmin, caops.requireGossip(caops.decommissionHandler))
+	caops.handle("POST", "/removenode", RoleAdmin, caops.requireGossip(caops.removeNodeHandler))
+	caops.handle("GET", "/node-removal", RoleReadOnly, caops.requireGossip(caops.nodeRemovalHandler))
+	caops.handle("GET", "/replacements", RoleReadOnly, caops.requireGossip(caops.listReplacementsHandler))
+	caops.handle("POST", "/replacements", RoleAdmin, caops.requireGossip(caops.openReplacementHandler))
+	caops.handle("GET", "/replacements/{address}", RoleReadOnly, caops.requireGossip(caops.replacementHandler))
+	caops.handle("POST", "/replacements/{address}/confirm", RoleAdmin, caops.requireGossip(caops.confirmReplacementHandler))
+	caops.handle("DELETE", "/replacements/{address}", RoleAdmin, caops.requireGossip(caops.cancelReplacementHandler))
+	caops.handle("GET", "/rolling-jobs", RoleReadOnly, caops.requireGossip(caops.listRollingJobsHandler))
+	caops.handle("GET", "/rolling-jobs/{id}", RoleReadOnly, caops.requireGossip(caops.rollingJobHandler))
+	caops.handle("DELETE", "/rolling-jobs/{id}", RoleAdmin, caops.requireGossip(caops.cancelRollingJobHandler))
 
 	return caops, nil
 }
 
+// handle registers a route on the API, which can only be called with a token granting the role
+func (caops *CaOps) handle(method, path string, role Role, handler http.HandlerFunc) {
+	caops.router.Methods(method).
+		Path(path).
+		HandlerFunc(caops.auth.require(role, handler))
+}
+
+// requireGossip wraps the handlers of the features relying on gossip, which respond with
+// 501 Not Implemented when the node is managed through a Jolokia proxy
+func (caops *CaOps) requireGossip(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if caops.gossiper == nil {
+			http.Error(w, fmt.Sprintf("Not available for a node managed through the Jolokia proxy target %s, "+
+				"as it does not take part in gossip", caops.cassMngr.JMXTarget()), http.StatusNotImplemented)
+			return
+		}
+		handler(w, r)
+	}
+}
+
 func (caops *CaOps) waitForShutdown() {
 	<-caops.stopChan
+	caops.cancel()
 	logrus.Info("Shutting down HTTP server...")
 	// shut down gracefully, but wait no longer than 5 seconds before halting
 	// TODO make this configurable - maybe increase it for when there are uploads happening
 	ctx, cancelFun := context.WithTimeout(context.Background(), 5*time.Second)
-	cancelFun()
+	defer cancelFun()
 	caops.server.Shutdown(ctx)
 	logrus.Info("HTTP Server gracefully stopped")
+	caops.webhooks.Close()
 }
 
 // Run starts the agent and the HTTP API server, and blocks, until it is finished
 func (caops *CaOps) Run() {
+	caops.registerGossipHandlers()
 	for { // TODO add a timeout here
 		if err := caops.Init(); err != nil {
 			logrus.Error(err)
@@ -89,22 +274,68 @@ func (caops *CaOps) Run() {
 		}
 	}
 
-	caops.gossiper.RegisterEventHandler("backup", caops.backupEventHandler)
-	caops.gossiper.RegisterEventHandler("clearsnapshot", caops.clearSnapshotEventHandler)
+	go caops.trackProgress()
+	if caops.repairs != nil {
+		go caops.repairs.Run(caops.ctx)
+		go caops.coordinateRepairs()
+	}
+	if caops.rolling != nil {
+		go caops.watchTopology()
+		go caops.watchDeadNodes()
+	}
+	if caops.webhooks.Enabled() {
+		go caops.watchEvents()
+	}
 
+	// subscribe to SIGINT signals
+	signal.Notify(caops.stopChan, os.Interrupt)
 	go caops.waitForShutdown()
 
-	if err := caops.server.ListenAndServe(); err != nil {
+	var err error
+	if caops.tls != nil {
+		// the certificates are provided by the TLS config, so they can be reloaded
+		err = caops.server.ListenAndServeTLS("", "")
+	} else {
+		err = caops.server.ListenAndServe()
+	}
+	if err != nil && err != http.ErrServerClosed {
 		logrus.Fatal(err)
 	}
 }
 
-// Init starts gossiper, check cluster status, and triggers the event loop
+// registerGossipHandlers registers the handlers of the cluster-wide events and queries, before
+// joining the cluster, so none of them is missed
+func (caops *CaOps) registerGossipHandlers() {
+	if caops.gossiper == nil {
+		return
+	}
+	caops.gossiper.RegisterEventHandler("backup", caops.backupEventHandler)
+	caops.gossiper.RegisterEventHandler("clearsnapshot", caops.clearSnapshotEventHandler)
+	caops.gossiper.RegisterMemberHandler(caops.memberEventHandler)
+	caops.gossiper.RegisterQueryHandler(statusQueryName, caops.statusQueryHandler)
+	caops.gossiper.RegisterQueryHandler(repairStartQueryName, caops.repairStartQueryHandler)
+	caops.gossiper.RegisterQueryHandler(repairStatusQueryName, caops.repairStatusQueryHandler)
+	caops.gossiper.RegisterQueryHandler(repairStateQueryName, caops.repairStateQueryHandler)
+	caops.gossiper.RegisterQueryHandler(nodeStepStartQueryName, caops.nodeStepStartQueryHandler)
+	caops.gossiper.RegisterQueryHandler(nodeStepStatusQueryName, caops.nodeStepStatusQueryHandler)
+	caops.gossiper.RegisterQueryHandler(hintsQueryName, caops.hintsQueryHandler)
+	caops.gossiper.RegisterQueryHandler(rollingJobQueryName, caops.rollingJobQueryHandler)
+	caops.gossiper.RegisterQueryHandler(diskSpaceQueryName, caops.diskSpaceQueryHandler)
+	caops.gossiper.RegisterQueryHandler(leaveQueryName, caops.leaveQueryHandler)
+	caops.gossiper.RegisterQueryHandler(schemaVersionQueryName, caops.schemaVersionQueryHandler)
+	caops.gossiper.RegisterQueryHandler(resetSchemaQueryName, caops.resetSchemaQueryHandler)
+}
+
+// Init starts gossiper, check cluster status, and triggers the event loop. Nodes managed
+// through a Jolokia proxy only have their cluster status checked.
 func (caops *CaOps) Init() error {
-	if err := caops.cassMngr.CheckClusterStability(); err != nil {
+	if err := caops.cassMngr.CheckClusterStability(caops.ctx); err != nil {
 		return err
 	}
-	liveNodes, err := caops.cassMngr.LiveNodes()
+	if caops.gossiper == nil {
+		return nil
+	}
+	liveNodes, err := caops.cassMngr.LiveNodes(caops.ctx)
 	if err != nil {
 		return err
 	}
@@ -124,7 +355,10 @@ func (caops *CaOps) Init() error {
 // CheckClustersConsistency compares the live nodes of Cassandra with the live nodes of CaOps to
 // determine if both are consistent with each other, and returns error if not.
 func (caops *CaOps) CheckClustersConsistency() error {
-	liveNodes, err := caops.cassMngr.LiveNodes()
+	if caops.gossiper == nil {
+		return nil
+	}
+	liveNodes, err := caops.cassMngr.LiveNodes(caops.ctx)
 	if err != nil {
 		return err
 	}
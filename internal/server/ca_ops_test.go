@@ -0,0 +1,41 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/CrossEngage/CaOps/internal/cassandra"
+	"github.com/CrossEngage/CaOps/internal/cassandra/cassandratest"
+	"github.com/CrossEngage/CaOps/internal/jolokia"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequireGossipWhenProxied(t *testing.T) {
+	target := jolokia.JMXServiceURL("10.0.0.1", 7199)
+	cassMngr, err := cassandra.NewManager(jolokia.Config{URL: "http://127.0.0.1:8778/jolokia", ProxyTargetURL: target})
+	assert.Nil(t, err)
+	caops := &CaOps{cassMngr: cassMngr}
+
+	called := false
+	handler := caops.requireGossip(func(w http.ResponseWriter, r *http.Request) { called = true })
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", "/cluster/status", nil))
+	assert.False(t, called)
+	assert.Equal(t, http.StatusNotImplemented, w.Code)
+	assert.Contains(t, w.Body.String(), target)
+
+	caops.gossiper = &Gossiper{}
+	handler(httptest.NewRecorder(), httptest.NewRequest("GET", "/cluster/status", nil))
+	assert.True(t, called)
+}
+
+// newTestCaOps builds a CaOps without gossip, managing the node faked by the agent
+func newTestCaOps(t *testing.T) (*CaOps, *cassandratest.FakeAgent) {
+	agent := cassandratest.NewFakeAgent()
+	cassMngr, err := cassandra.NewManager(agent.Config())
+	assert.Nil(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	return &CaOps{ctx: ctx, cancel: cancel, clock: realClock{}, cassMngr: cassMngr, progress: newProgressTracker()}, agent
+}
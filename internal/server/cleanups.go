@@ -0,0 +1,228 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/CrossEngage/CaOps/internal/cassandra"
+	"github.com/CrossEngage/CaOps/internal/health"
+	"github.com/CrossEngage/CaOps/internal/rolling"
+	"github.com/Sirupsen/logrus"
+)
+
+// Default settings of the cleanups
+const (
+	DefaultCleanupConcurrency    = 1
+	DefaultCleanupDiskHeadroom   = 1.2
+	DefaultTopologyCheckInterval = time.Minute
+)
+
+// cleanupKeyspacesParam is the parameter of the cleanup jobs listing the keyspaces to clean
+// up, separated by commas, or empty for all the non-system ones
+const cleanupKeyspacesParam = "keyspaces"
+
+// CleanupConfig holds the settings of the cleanups, which run on up to concurrency nodes at
+// once. A node is only cleaned up when each of its data directories has room for the largest
+// table being cleaned up, times the disk headroom. When on join is set, the ring is checked
+// at the check interval, and the nodes are cleaned up once new nodes joined it.
+type CleanupConfig struct {
+	OnJoin        bool
+	Concurrency   int
+	DiskHeadroom  float64
+	CheckInterval time.Duration
+}
+
+func (config CleanupConfig) withDefaults() CleanupConfig {
+	if config.Concurrency == 0 {
+		config.Concurrency = DefaultCleanupConcurrency
+	}
+	if config.DiskHeadroom == 0 {
+		config.DiskHeadroom = DefaultCleanupDiskHeadroom
+	}
+	if config.CheckInterval == 0 {
+		config.CheckInterval = DefaultTopologyCheckInterval
+	}
+	return config
+}
+
+// CleanupRequest is the body of the requests starting a cleanup of the nodes, but the
+// excluded ones, like the nodes which just joined. Without keyspaces, all the non-system
+// ones are cleaned up.
+type CleanupRequest struct {
+	Keyspaces   []string `json:"keyspaces"`
+	Concurrency int      `json:"concurrency"`
+	Exclude     []string `json:"exclude"`
+}
+
+// splitKeyspaces splits the keyspaces parameter of a job, which is empty for all of them
+func splitKeyspaces(param string) []string {
+	if param == "" {
+		return nil
+	}
+	return strings.Split(param, ",")
+}
+
+// diskFreeSpace returns the space available to the agent on the file system of the path
+func diskFreeSpace(path string) (uint64, error) {
+	stat := syscall.Statfs_t{}
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+	return uint64(stat.Bavail) * uint64(stat.Bsize), nil
+}
+
+func formatMiB(bytes uint64) string {
+	return fmt.Sprintf("%.1f MiB", float64(bytes)/(1024*1024))
+}
+
+// checkDiskHeadroom checks that each data directory of the node has room for the largest of
+// the tables of the keyspaces, as the cleanup rewrites the SSTables of one table at a time
+func (caops *CaOps) checkDiskHeadroom(ctx context.Context, keyspaces []string) error {
+	if len(keyspaces) == 0 {
+		var err error
+		if keyspaces, err = caops.cassMngr.NonSystemKeyspaces(ctx); err != nil {
+			return err
+		}
+	}
+	var largest *cassandra.TableStats
+	for _, keyspace := range keyspaces {
+		tables, err := caops.cassMngr.TablesStats(ctx, keyspace, "*")
+		if err != nil {
+			return fmt.Errorf("Could not read the size of the tables of %s: %s", keyspace, err)
+		}
+		for _, table := range tables {
+			if largest == nil || table.SpaceUsedLive > largest.SpaceUsedLive {
+				largest = table
+			}
+		}
+	}
+	if largest == nil || largest.SpaceUsedLive <= 0 {
+		return nil
+	}
+
+	needed := uint64(float64(largest.SpaceUsedLive) * caops.rollingConfig.Cleanup.DiskHeadroom)
+	dirs, err := caops.cassMngr.AllDataFileLocations(ctx)
+	if err != nil {
+		return err
+	}
+	for _, dir := range dirs {
+		free, err := caops.freeSpace(dir)
+		if err != nil {
+			return fmt.Errorf("Could not read the free space of %s: %s", dir, err)
+		}
+		if free < needed {
+			return health.Failf("Only %s are free in %s, while the cleanup of %s.%s needs %s",
+				formatMiB(free), dir, largest.Keyspace, largest.Table, formatMiB(needed))
+		}
+	}
+	return nil
+}
+
+// planCleanup plans a job cleaning up the nodes, but the excluded ones, a few at a time
+func (caops *CaOps) planCleanup(ctx context.Context, request *CleanupRequest) (*rolling.Job, error) {
+	nodes, err := caops.rollingNodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	excluded := stringListToMapKeys(request.Exclude)
+	cleaned := make([]rolling.Node, 0, len(nodes))
+	for _, node := range nodes {
+		if !excluded[node.Address] {
+			cleaned = append(cleaned, node)
+		}
+	}
+	job, err := rolling.NewJob(caops.newJobID(), "cleanup", cleaned, []string{stepCheckDisk, stepCleanup}, nil, false, caops.clock.Now())
+	if err != nil {
+		return nil, err
+	}
+	job.Concurrency = request.Concurrency
+	if job.Concurrency == 0 {
+		job.Concurrency = caops.rollingConfig.Cleanup.Concurrency
+	}
+	job.Params[cleanupKeyspacesParam] = strings.Join(request.Keyspaces, ",")
+	return job, nil
+}
+
+// watchTopology cleans up the nodes whenever new nodes joined the ring, if enabled
+func (caops *CaOps) watchTopology() {
+	if !caops.rollingConfig.Cleanup.OnJoin {
+		return
+	}
+	for {
+		select {
+		case <-caops.clock.After(caops.rollingConfig.Cleanup.CheckInterval):
+			caops.cleanupAfterJoins()
+		case <-caops.ctx.Done():
+			return
+		}
+	}
+}
+
+// cleanupAfterJoins compares the nodes owning tokens with the ones seen at the previous check,
+// and starts the cleanup of the other nodes once new ones finished joining, if this agent has
+// the lowest IP of the cluster. The nodes seen are only updated once the cleanup is started,
+// so it is retried at the next check if it can not be started yet.
+func (caops *CaOps) cleanupAfterJoins() {
+	joining, err := caops.cassMngr.JoiningNodes(caops.ctx)
+	if err != nil {
+		logrus.Warnf("Could not read the joining nodes: %s", err)
+		return
+	}
+	if len(joining) > 0 {
+		return
+	}
+	tokens, err := caops.cassMngr.TokenToEndpointMap(caops.ctx)
+	if err != nil {
+		logrus.Warnf("Could not read the ring: %s", err)
+		return
+	}
+	owners := make(map[string]bool)
+	for _, address := range tokens {
+		owners[address] = true
+	}
+
+	joined := make([]string, 0)
+	for address := range owners {
+		if caops.ringOwners != nil && !caops.ringOwners[address] {
+			joined = append(joined, address)
+		}
+	}
+	sort.Strings(joined)
+	if len(joined) == 0 || !caops.isScheduler() {
+		caops.ringOwners = owners
+		return
+	}
+
+	job, err := caops.planCleanup(caops.ctx, &CleanupRequest{Exclude: joined})
+	if err == nil {
+		err = caops.runRollingJob(job)
+	}
+	if err != nil {
+		logrus.Warnf("Could not start the cleanup after %s joined the ring: %s", strings.Join(joined, ", "), err)
+		return
+	}
+	logrus.Infof("The cleanup job %s of %d nodes was started, as %s joined the ring", job.ID, len(job.Nodes), strings.Join(joined, ", "))
+	caops.ringOwners = owners
+}
+
+func (caops *CaOps) cleanupHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	request := &CleanupRequest{}
+	if err := json.NewDecoder(r.Body).Decode(request); err != nil && err != io.EOF {
+		http.Error(w, fmt.Sprintf("Invalid cleanup request: %s", err), http.StatusBadRequest)
+		return
+	}
+	if request.Concurrency < 0 {
+		http.Error(w, "The concurrency of the cleanup can not be negative", http.StatusBadRequest)
+		return
+	}
+	job, err := caops.planCleanup(r.Context(), request)
+	caops.startRollingJob(w, "cleanup", job, err)
+}
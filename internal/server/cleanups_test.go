@@ -0,0 +1,120 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/CrossEngage/CaOps/internal/cassandra/cassandratest"
+	"github.com/CrossEngage/CaOps/internal/rolling"
+	"github.com/stretchr/testify/assert"
+)
+
+// setCleanupCluster gives the nodes a ks1.users table of 100 MiB, and the free space of
+// their data directories
+func setCleanupCluster(h *harness, free map[string]uint64) {
+	h.AddTable("ks1", "users")
+	for _, node := range h.Nodes {
+		node := node
+		node.Agent.SetAttribute("org.apache.cassandra.metrics:type=Table,keyspace=ks1,scope=users,name=LiveDiskSpaceUsed", "Count", 100<<20)
+		node.Agent.SetOperation(cassandratest.StorageService, "forceKeyspaceCleanup", func(args []interface{}) (interface{}, error) {
+			return 0, nil
+		})
+		node.CaOps.freeSpace = func(path string) (uint64, error) {
+			if space, ok := free[node.IP]; ok {
+				return space, nil
+			}
+			return 1 << 30, nil
+		}
+	}
+}
+
+func TestCleanup(t *testing.T) {
+	h := newHarness(t, 3)
+	defer h.Close()
+	setRollingCluster(h, "")
+	setCleanupCluster(h, nil)
+	orchestrator := h.Nodes[0]
+	excluded := h.Nodes[2]
+
+	w := orchestrator.RequestWithBody("POST", "/cleanups", `{"concurrency": 2, "exclude": ["`+excluded.IP+`"]}`)
+	assertStatus(t, http.StatusCreated, w)
+	job := &RollingJobResponse{}
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(job))
+	assert.Equal(t, 2, job.Concurrency)
+	assert.Equal(t, 2, job.Progress.Total)
+
+	job = waitRollingJob(h, orchestrator, job.ID)
+	assert.Equal(t, rolling.JobSucceeded, job.Status, job.Error)
+	for _, node := range h.Nodes[:2] {
+		assert.Equal(t, [][]interface{}{{0.0, "ks1", []interface{}{}}},
+			node.Agent.Executed(cassandratest.StorageService, "forceKeyspaceCleanup"))
+	}
+	assert.Empty(t, excluded.Agent.Executed(cassandratest.StorageService, "forceKeyspaceCleanup"))
+
+	assertStatus(t, http.StatusBadRequest, orchestrator.RequestWithBody("POST", "/cleanups", `{"concurrency": -1}`))
+}
+
+func TestCleanupChecksDiskHeadroom(t *testing.T) {
+	h := newHarness(t, 2)
+	defer h.Close()
+	setRollingCluster(h, "")
+	full := h.Nodes[1]
+	setCleanupCluster(h, map[string]uint64{full.IP: 100 << 20})
+	orchestrator := h.Nodes[0]
+
+	w := orchestrator.RequestWithBody("POST", "/cleanups", `{"keyspaces": ["ks1"]}`)
+	assertStatus(t, http.StatusCreated, w)
+	job := &RollingJobResponse{}
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(job))
+
+	job = waitRollingJob(h, orchestrator, job.ID)
+	assert.Equal(t, rolling.JobFailed, job.Status)
+	assert.Equal(t, "The check-disk step failed on "+full.IP+": Only 100.0 MiB are free in /var/lib/cassandra/data, "+
+		"while the cleanup of ks1.users needs 120.0 MiB", job.Error)
+	assert.Len(t, orchestrator.Agent.Executed(cassandratest.StorageService, "forceKeyspaceCleanup"), 1)
+	assert.Empty(t, full.Agent.Executed(cassandratest.StorageService, "forceKeyspaceCleanup"))
+}
+
+func TestCleanupAfterJoins(t *testing.T) {
+	h := newHarness(t, 3)
+	defer h.Close()
+	setRollingCluster(h, "")
+	setCleanupCluster(h, nil)
+	scheduler, joined := h.Nodes[0], h.Nodes[2]
+	assert.True(t, scheduler.CaOps.isScheduler())
+	ring := scheduler.Agent.Attribute(cassandratest.StorageService, "TokenToEndpointMap").(map[string]string)
+	before := make(map[string]string)
+	for token, address := range ring {
+		if address != joined.IP {
+			before[token] = address
+		}
+	}
+
+	// the ring is first seen without the joining node
+	scheduler.Agent.SetAttribute(cassandratest.StorageService, "TokenToEndpointMap", before)
+	scheduler.CaOps.cleanupAfterJoins()
+	assert.Empty(t, scheduler.CaOps.rolling.Jobs())
+
+	// the cleanup waits for the node to finish joining
+	scheduler.Agent.SetAttribute(cassandratest.StorageService, "TokenToEndpointMap", ring)
+	scheduler.Agent.SetAttribute(cassandratest.StorageService, "JoiningNodes", []string{joined.IP})
+	scheduler.CaOps.cleanupAfterJoins()
+	assert.Empty(t, scheduler.CaOps.rolling.Jobs())
+
+	scheduler.Agent.SetAttribute(cassandratest.StorageService, "JoiningNodes", []string{})
+	scheduler.CaOps.cleanupAfterJoins()
+	jobs := scheduler.CaOps.rolling.Jobs()
+	if assert.Len(t, jobs, 1) {
+		job := waitRollingJob(h, scheduler, jobs[0].ID)
+		assert.Equal(t, rolling.JobSucceeded, job.Status, job.Error)
+		assert.Equal(t, 2, job.Progress.Total)
+		for _, node := range job.Nodes {
+			assert.NotEqual(t, joined.IP, node.Address)
+		}
+	}
+
+	// the same ring does not start another cleanup
+	scheduler.CaOps.cleanupAfterJoins()
+	assert.Len(t, scheduler.CaOps.rolling.Jobs(), 1)
+}
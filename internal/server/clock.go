@@ -0,0 +1,20 @@
+package server
+
+import "time"
+
+// clock tells the time, and waits for it. It is replaced in tests, so the time markers of
+// the cluster-wide events can be reached deterministically.
+type clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
@@ -0,0 +1,227 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/hashicorp/serf/serf"
+)
+
+const (
+	statusQueryName           = "status"
+	defaultClusterStatusQuery = 5 * time.Second
+	maxClusterStatusQuery     = time.Minute
+)
+
+// ClusterStatus merges the status documents of all the alive agents, keyed by their IPs
+type ClusterStatus struct {
+	Status        string                        `json:"status"`
+	Nodes         map[string]*ClusterNodeStatus `json:"nodes"`
+	Disagreements []Disagreement                `json:"disagreements"`
+}
+
+// ClusterNodeStatus holds the status document of an agent, or why it could not be got
+type ClusterNodeStatus struct {
+	Status *NodeStatus `json:"status,omitempty"`
+	Error  string      `json:"error,omitempty"`
+}
+
+// Disagreement describes an attribute for which the nodes report different values.
+// Values maps each reported value to the IPs of the nodes reporting it.
+type Disagreement struct {
+	Attribute string              `json:"attribute"`
+	Values    map[string][]string `json:"values"`
+}
+
+func (caops *CaOps) statusQueryHandler(query *serf.Query) ([]byte, error) {
+	// there is no point in waiting for Jolokia after the response deadline
+	ctx, cancel := context.WithDeadline(caops.ctx, query.Deadline())
+	defer cancel()
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(gz).Encode(caops.nodeStatus(ctx)); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeStatusQueryResponse(response []byte) (*NodeStatus, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(response))
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+	status := &NodeStatus{}
+	if err := json.NewDecoder(gz).Decode(status); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+func (caops *CaOps) clusterStatus(ctx context.Context, timeout time.Duration) (*ClusterStatus, error) {
+	results, err := caops.gossiper.Query(statusQueryName, &EmptyPayload{}, timeout)
+	if err != nil {
+		return nil, err
+	}
+	cs := &ClusterStatus{Nodes: make(map[string]*ClusterNodeStatus)}
+	for ip, response := range results.Responses {
+		status, err := decodeStatusQueryResponse(response)
+		if err != nil {
+			logrus.Errorf("Invalid status response from %s: %s", ip, err)
+			cs.Nodes[ip] = &ClusterNodeStatus{Error: fmt.Sprintf("Invalid response: %s", err)}
+			continue
+		}
+		cs.Nodes[ip] = &ClusterNodeStatus{Status: status}
+	}
+	for _, ip := range results.Missing {
+		cs.Nodes[ip] = &ClusterNodeStatus{Error: fmt.Sprintf("No response within %s", timeout)}
+	}
+	cs.addNodesWithoutAgent(caops.liveNodes(ctx))
+	cs.Disagreements = cs.findDisagreements()
+	cs.Status = cs.health()
+	return cs, nil
+}
+
+func (caops *CaOps) liveNodes(ctx context.Context) []string {
+	liveNodes, err := caops.cassMngr.LiveNodes(ctx)
+	if err != nil {
+		logrus.Warnf("Could not read the live Cassandra nodes: %s", err)
+	}
+	return liveNodes
+}
+
+// addNodesWithoutAgent adds the live Cassandra nodes which have no agent in gossip, like
+// the ones managed through a Jolokia proxy
+func (cs *ClusterStatus) addNodesWithoutAgent(liveNodes []string) {
+	for _, ip := range liveNodes {
+		if _, ok := cs.Nodes[ip]; !ok {
+			cs.Nodes[ip] = &ClusterNodeStatus{Error: "No CaOps agent in gossip, the node may be managed through a Jolokia proxy"}
+		}
+	}
+}
+
+// findDisagreements compares the cluster-wide attributes reported by each node, and the
+// nodes each of them sees as unreachable
+func (cs *ClusterStatus) findDisagreements() []Disagreement {
+	attributes := []struct {
+		name  string
+		value func(*NodeStatus) StatusString
+	}{
+		{"cluster_name", func(s *NodeStatus) StatusString { return s.ClusterName }},
+		{"cassandra_version", func(s *NodeStatus) StatusString { return s.CassandraVersion }},
+		{"schema_version", func(s *NodeStatus) StatusString { return s.SchemaVersion }},
+		{"partitioner_name", func(s *NodeStatus) StatusString { return s.PartitionerName }},
+	}
+
+	disagreements := make([]Disagreement, 0)
+	for _, attr := range attributes {
+		values := make(map[string][]string)
+		for ip, node := range cs.Nodes {
+			if node.Status == nil || attr.value(node.Status).Error != "" {
+				continue
+			}
+			value := attr.value(node.Status).Value
+			values[value] = append(values[value], ip)
+		}
+		if len(values) > 1 {
+			disagreements = append(disagreements, Disagreement{Attribute: attr.name, Values: sortedValues(values)})
+		}
+	}
+
+	unreachableBy := make(map[string][]string)
+	for ip, node := range cs.Nodes {
+		if node.Status == nil {
+			continue
+		}
+		for _, unreachable := range node.Status.UnreachableNodes.Value {
+			unreachableBy[unreachable] = append(unreachableBy[unreachable], ip)
+		}
+	}
+	unreachableIPs := make([]string, 0, len(unreachableBy))
+	for unreachable := range unreachableBy {
+		unreachableIPs = append(unreachableIPs, unreachable)
+	}
+	sort.Strings(unreachableIPs)
+	for _, unreachable := range unreachableIPs {
+		seenBy := stringListToMapKeys(unreachableBy[unreachable])
+		reachableBy := make([]string, 0)
+		for ip, node := range cs.Nodes {
+			if node.Status != nil && node.Status.UnreachableNodes.Error == "" && !seenBy[ip] {
+				reachableBy = append(reachableBy, ip)
+			}
+		}
+		disagreements = append(disagreements, Disagreement{
+			Attribute: fmt.Sprintf("reachability of %s", unreachable),
+			Values:    sortedValues(map[string][]string{"unreachable": unreachableBy[unreachable], "reachable": reachableBy}),
+		})
+	}
+	return disagreements
+}
+
+func sortedValues(values map[string][]string) map[string][]string {
+	for _, ips := range values {
+		sort.Strings(ips)
+	}
+	return values
+}
+
+// health is ok when all the nodes responded, are ok, and agree with each other, and down
+// when none of them responded.
+func (cs *ClusterStatus) health() string {
+	responded, ok := 0, 0
+	for _, node := range cs.Nodes {
+		if node.Status != nil {
+			responded++
+			if node.Status.Status == StatusOK {
+				ok++
+			}
+		}
+	}
+	switch {
+	case responded == 0:
+		return StatusDown
+	case ok < len(cs.Nodes) || len(cs.Disagreements) > 0:
+		return StatusDegraded
+	}
+	return StatusOK
+}
+
+// HTTPStatus returns the HTTP status code matching the cluster health
+func (cs *ClusterStatus) HTTPStatus() int {
+	if cs.Status == StatusOK {
+		return http.StatusOK
+	}
+	return http.StatusServiceUnavailable
+}
+
+func (caops *CaOps) clusterStatusHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	timeout := defaultClusterStatusQuery
+	if val := r.URL.Query().Get("timeout"); val != "" {
+		var err error
+		if timeout, err = time.ParseDuration(val); err != nil || timeout <= 0 || timeout > maxClusterStatusQuery {
+			http.Error(w, fmt.Sprintf("The timeout must be a duration up to %s", maxClusterStatusQuery), http.StatusBadRequest)
+			return
+		}
+	}
+
+	status, err := caops.clusterStatus(r.Context(), timeout)
+	if err != nil {
+		logrus.Error(err)
+		http.Error(w, fmt.Sprintf("Error while querying the cluster status: %s", err), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, status.HTTPStatus(), status)
+}
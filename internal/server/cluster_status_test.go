@@ -0,0 +1,50 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClusterStatusDisagreements(t *testing.T) {
+	node := func(schema string, unreachable ...string) *ClusterNodeStatus {
+		return &ClusterNodeStatus{Status: &NodeStatus{
+			Status:           StatusOK,
+			ClusterName:      StatusString{Value: "Test Cluster"},
+			SchemaVersion:    StatusString{Value: schema},
+			UnreachableNodes: StatusStringList{Value: unreachable},
+		}}
+	}
+	cs := &ClusterStatus{Nodes: map[string]*ClusterNodeStatus{
+		"10.0.0.1": node("a"),
+		"10.0.0.2": node("a", "10.0.0.4"),
+		"10.0.0.3": node("b"),
+		"10.0.0.4": {Error: "No response within 5s"},
+	}}
+
+	disagreements := cs.findDisagreements()
+	assert.Equal(t, []Disagreement{
+		{Attribute: "schema_version", Values: map[string][]string{
+			"a": {"10.0.0.1", "10.0.0.2"},
+			"b": {"10.0.0.3"},
+		}},
+		{Attribute: "reachability of 10.0.0.4", Values: map[string][]string{
+			"unreachable": {"10.0.0.2"},
+			"reachable":   {"10.0.0.1", "10.0.0.3"},
+		}},
+	}, disagreements)
+
+	cs.Disagreements = disagreements
+	assert.Equal(t, StatusDegraded, cs.health())
+}
+
+func TestClusterStatusNodesWithoutAgent(t *testing.T) {
+	cs := &ClusterStatus{Nodes: map[string]*ClusterNodeStatus{
+		"10.0.0.1": {Status: &NodeStatus{Status: StatusOK}},
+	}}
+	cs.addNodesWithoutAgent([]string{"10.0.0.1", "10.0.0.2"})
+	assert.Len(t, cs.Nodes, 2)
+	assert.NotNil(t, cs.Nodes["10.0.0.1"].Status)
+	assert.Contains(t, cs.Nodes["10.0.0.2"].Error, "Jolokia proxy")
+	assert.Equal(t, StatusDegraded, cs.health())
+}
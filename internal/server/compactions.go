@@ -0,0 +1,199 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/CrossEngage/CaOps/internal/cassandra"
+	"github.com/Sirupsen/logrus"
+)
+
+// The kinds of compactions which can be triggered
+const (
+	compactionMajor       = "major"
+	compactionUserDefined = "user-defined"
+	compactionTombstone   = "tombstone"
+)
+
+// defaultCompactionHistoryLimit is the number of compactions listed by the history, unless
+// another limit is given
+const defaultCompactionHistoryLimit = 100
+
+// CompactionRequest is the body of the requests triggering a compaction: a major or tombstone
+// compaction of the tables of the keyspace, or all of them, or the user-defined compaction of
+// the data files, of a single table
+type CompactionRequest struct {
+	Type        string   `json:"type"`
+	Keyspace    string   `json:"keyspace,omitempty"`
+	Tables      []string `json:"tables,omitempty"`
+	SplitOutput bool     `json:"split_output,omitempty"`
+	Granularity string   `json:"granularity,omitempty"`
+	DataFiles   []string `json:"data_files,omitempty"`
+}
+
+// CompactionThroughput is the compaction throughput limit, where 0 is unlimited
+type CompactionThroughput struct {
+	MbPerSec *uint64 `json:"mb_per_sec"`
+}
+
+func (caops *CaOps) compactionsHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	stats, err := caops.cassMngr.CompactionStats(r.Context())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error while reading the compactions: %s", err), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
+
+// compactionHistoryHandler lists the most recent compactions, up to the limit parameter
+func (caops *CaOps) compactionHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	limit := defaultCompactionHistoryLimit
+	if param := r.URL.Query().Get("limit"); param != "" {
+		var err error
+		if limit, err = strconv.Atoi(param); err != nil || limit <= 0 {
+			http.Error(w, fmt.Sprintf("Invalid limit %s", param), http.StatusBadRequest)
+			return
+		}
+	}
+	history, err := caops.cassMngr.CompactionHistory(r.Context())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error while reading the compaction history: %s", err), http.StatusInternalServerError)
+		return
+	}
+	if len(history) > limit {
+		history = history[:limit]
+	}
+	writeJSON(w, http.StatusOK, history)
+}
+
+func (caops *CaOps) compactionThroughputHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	mbPerSec, err := caops.cassMngr.CompactionThroughput(r.Context())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error while reading the compaction throughput: %s", err), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, &CompactionThroughput{MbPerSec: &mbPerSec})
+}
+
+func (caops *CaOps) setCompactionThroughputHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	request := &CompactionThroughput{}
+	if err := json.NewDecoder(r.Body).Decode(request); err != nil || request.MbPerSec == nil {
+		http.Error(w, "The compaction throughput must be given in mb_per_sec", http.StatusBadRequest)
+		return
+	}
+	if err := caops.cassMngr.SetCompactionThroughput(r.Context(), *request.MbPerSec); err != nil {
+		http.Error(w, fmt.Sprintf("Error while setting the compaction throughput: %s", err), http.StatusInternalServerError)
+		return
+	}
+	logrus.Infof("Compaction throughput set to %d MB/s", *request.MbPerSec)
+	writeJSON(w, http.StatusOK, request)
+}
+
+// checkCompactionRequest checks the compaction request, returning the status to respond with
+// when it is invalid
+func (caops *CaOps) checkCompactionRequest(ctx context.Context, request *CompactionRequest) (int, error) {
+	switch request.Type {
+	case compactionUserDefined:
+		if len(request.DataFiles) == 0 {
+			return http.StatusBadRequest, fmt.Errorf("A user-defined compaction needs data files")
+		}
+		return 0, nil
+	case compactionTombstone:
+		if request.Granularity == "" {
+			request.Granularity = cassandra.TombstoneRow
+		}
+		if request.Granularity != cassandra.TombstoneRow && request.Granularity != cassandra.TombstoneCell {
+			return http.StatusBadRequest, fmt.Errorf("Invalid granularity %s, which must be %s or %s",
+				request.Granularity, cassandra.TombstoneRow, cassandra.TombstoneCell)
+		}
+	case compactionMajor:
+	default:
+		return http.StatusBadRequest, fmt.Errorf("Invalid compaction type %q, which must be %s, %s or %s",
+			request.Type, compactionMajor, compactionUserDefined, compactionTombstone)
+	}
+
+	if request.Keyspace == "" {
+		return http.StatusBadRequest, fmt.Errorf("A %s compaction needs a keyspace", request.Type)
+	}
+	tables, err := caops.cassMngr.Tables(ctx, request.Keyspace)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("Error while reading the tables of %s: %s", request.Keyspace, err)
+	}
+	if _, ok := tables[request.Keyspace]; !ok {
+		return http.StatusNotFound, fmt.Errorf("Keyspace %s does not exist", request.Keyspace)
+	}
+	existing := stringListToMapKeys(tables[request.Keyspace])
+	for _, table := range request.Tables {
+		if !existing[table] {
+			return http.StatusNotFound, fmt.Errorf("Table %s.%s does not exist", request.Keyspace, table)
+		}
+	}
+	return 0, nil
+}
+
+// compact runs the compaction of the request, until it is finished
+func (caops *CaOps) compact(ctx context.Context, request *CompactionRequest) error {
+	switch request.Type {
+	case compactionUserDefined:
+		return caops.cassMngr.UserDefinedCompaction(ctx, request.DataFiles)
+	case compactionTombstone:
+		return caops.cassMngr.TombstoneCompaction(ctx, request.Granularity, request.Keyspace, request.Tables...)
+	}
+	return caops.cassMngr.MajorCompaction(ctx, request.Keyspace, request.SplitOutput, request.Tables...)
+}
+
+// startCompactionHandler starts the compaction in the background, as it may take hours,
+// responding once it is started. Its progress is listed by the compactions handler.
+func (caops *CaOps) startCompactionHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	request := &CompactionRequest{}
+	if err := json.NewDecoder(r.Body).Decode(request); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid compaction request: %s", err), http.StatusBadRequest)
+		return
+	}
+	if status, err := caops.checkCompactionRequest(r.Context(), request); err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	description := request.Type + " compaction of " + request.Keyspace
+	if request.Type == compactionUserDefined {
+		description = request.Type + " compaction of " + strings.Join(request.DataFiles, ", ")
+	} else if len(request.Tables) > 0 {
+		description += " (" + strings.Join(request.Tables, ", ") + ")"
+	}
+	logrus.Infof("Starting the %s", description)
+	go func() {
+		if err := caops.compact(caops.ctx, request); err != nil {
+			logrus.Errorf("The %s failed: %s", description, err)
+			return
+		}
+		logrus.Infof("The %s is finished", description)
+	}()
+	writeJSON(w, http.StatusAccepted, request)
+}
+
+// stopCompactionsHandler stops the running compactions of the type parameter
+func (caops *CaOps) stopCompactionsHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	compactionType := strings.ToUpper(r.URL.Query().Get("type"))
+	if !stringListToMapKeys(cassandra.CompactionTypes)[compactionType] {
+		http.Error(w, fmt.Sprintf("Invalid compaction type %q, which must be one of %s", compactionType,
+			strings.Join(cassandra.CompactionTypes, ", ")), http.StatusBadRequest)
+		return
+	}
+	if err := caops.cassMngr.StopCompactions(r.Context(), compactionType); err != nil {
+		http.Error(w, fmt.Sprintf("Error while stopping the %s compactions: %s", compactionType, err), http.StatusInternalServerError)
+		return
+	}
+	logrus.Infof("Stopped the running %s compactions", compactionType)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/CrossEngage/CaOps/internal/cassandra/cassandratest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompactionThroughputHandlers(t *testing.T) {
+	caops, agent := newTestCaOps(t)
+	defer agent.Close()
+	node := newRoutedNode(t, caops)
+
+	w := node.Request("GET", "/compactions/throughput")
+	assertStatus(t, http.StatusOK, w)
+	assert.JSONEq(t, `{"mb_per_sec": 16}`, w.Body.String())
+
+	assertStatus(t, http.StatusOK, node.RequestWithBody("PUT", "/compactions/throughput", `{"mb_per_sec": 0}`))
+	assert.EqualValues(t, 0, agent.Attribute(cassandratest.StorageService, "CompactionThroughputMbPerSec"))
+	assertStatus(t, http.StatusBadRequest, node.RequestWithBody("PUT", "/compactions/throughput", `{}`))
+}
+
+func TestStartCompactionHandler(t *testing.T) {
+	caops, agent := newTestCaOps(t)
+	defer agent.Close()
+	agent.AddTable("ks1", "users")
+	executed := make(chan []interface{}, 1)
+	agent.SetOperation(cassandratest.StorageService, "garbageCollect", func(args []interface{}) (interface{}, error) {
+		executed <- args
+		return 0, nil
+	})
+	node := newRoutedNode(t, caops)
+
+	w := node.RequestWithBody("POST", "/compactions", `{"type": "tombstone", "keyspace": "ks1", "tables": ["users"]}`)
+	assertStatus(t, http.StatusAccepted, w)
+	assert.Equal(t, []interface{}{"ROW", 0.0, "ks1", []interface{}{"users"}}, <-executed)
+
+	for body, status := range map[string]int{
+		`{"type": "minor", "keyspace": "ks1"}`:                           http.StatusBadRequest,
+		`{"type": "major"}`:                                              http.StatusBadRequest,
+		`{"type": "user-defined"}`:                                       http.StatusBadRequest,
+		`{"type": "tombstone", "keyspace": "ks1", "granularity": "ALL"}`: http.StatusBadRequest,
+		`{"type": "major", "keyspace": "ks2"}`:                           http.StatusNotFound,
+		`{"type": "major", "keyspace": "ks1", "tables": ["orders"]}`:     http.StatusNotFound,
+	} {
+		assertStatus(t, status, node.RequestWithBody("POST", "/compactions", body))
+	}
+}
+
+func TestCompactionHistoryHandler(t *testing.T) {
+	caops, agent := newTestCaOps(t)
+	defer agent.Close()
+	agent.SetAttribute("org.apache.cassandra.db:type=CompactionManager", "CompactionHistory", cassandratest.CompactionHistory(
+		map[string]interface{}{"id": "a1", "keyspace_name": "ks1", "columnfamily_name": "users", "compacted_at": 1530000000000,
+			"bytes_in": 2048, "bytes_out": 1024, "rows_merged": "{1:3, 2:1}"},
+		map[string]interface{}{"id": "b2", "keyspace_name": "ks1", "columnfamily_name": "orders", "compacted_at": 1530000060000,
+			"bytes_in": 100, "bytes_out": 100, "rows_merged": "{1:1}"},
+	))
+	node := newRoutedNode(t, caops)
+
+	w := node.Request("GET", "/compactions/history?limit=1")
+	assertStatus(t, http.StatusOK, w)
+	var history []map[string]interface{}
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&history))
+	if assert.Len(t, history, 1) {
+		assert.Equal(t, "b2", history[0]["id"])
+	}
+	assertStatus(t, http.StatusBadRequest, node.Request("GET", "/compactions/history?limit=none"))
+}
+
+func TestStopCompactionsHandler(t *testing.T) {
+	caops, agent := newTestCaOps(t)
+	defer agent.Close()
+	compactionManager := "org.apache.cassandra.db:type=CompactionManager"
+	agent.SetOperation(compactionManager, "stopCompaction", func(args []interface{}) (interface{}, error) {
+		return nil, nil
+	})
+	node := newRoutedNode(t, caops)
+
+	assertStatus(t, http.StatusNoContent, node.Request("DELETE", "/compactions?type=validation"))
+	assert.Equal(t, [][]interface{}{{"VALIDATION"}}, agent.Executed(compactionManager, "stopCompaction"))
+	assertStatus(t, http.StatusBadRequest, node.Request("DELETE", "/compactions?type=all"))
+}
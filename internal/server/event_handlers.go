@@ -1,6 +1,8 @@
 package server
 
 import (
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/Sirupsen/logrus"
@@ -26,41 +28,59 @@ func (caops *CaOps) backupEventHandler(event serf.UserEvent) (breakLoop bool, er
 		return false, err
 	}
 	logrus.Infof("Going to do snapshot of %s.%s at %s", bp.KeyspaceGlob, bp.Table, bp.TimeMarker.Format(time.RFC3339))
-
-	keyspaces, err := caops.cassMngr.MatchKeyspaces(bp.KeyspaceGlob)
-	if err != nil {
+	details := map[string]string{
+		"keyspaces":   bp.KeyspaceGlob,
+		"table":       bp.Table,
+		"time_marker": bp.TimeMarker.Format(time.RFC3339),
+	}
+	failed := func(err error) (bool, error) {
 		logrus.Error(err)
+		caops.notify(eventBackupFailed, fmt.Sprintf("Snapshot of %s.%s failed: %s", bp.KeyspaceGlob, bp.Table, err), details)
 		return false, err
 	}
 
-	<-time.After(bp.TimeMarker.Sub(time.Now()))
+	keyspaces, err := caops.cassMngr.MatchKeyspaces(caops.ctx, bp.KeyspaceGlob)
+	if err != nil {
+		return failed(err)
+	}
+
+	select {
+	case <-caops.clock.After(bp.TimeMarker.Sub(caops.clock.Now())):
+	case <-caops.ctx.Done():
+		return true, caops.ctx.Err()
+	}
+	caops.notify(eventBackupStarted, fmt.Sprintf("Snapshot of %s.%s started", bp.KeyspaceGlob, bp.Table), details)
 
+	tags := make([]string, 0)
 	if bp.Table == "" || bp.Table == "*" {
-		_, tag, err := caops.cassMngr.SnapshotKeyspaces(keyspaces)
+		_, tag, err := caops.cassMngr.SnapshotKeyspaces(caops.ctx, keyspaces)
 		if err != nil {
-			logrus.Error(err)
-			return false, err
+			return failed(err)
 		}
 		logrus.Infof("Snapshot of keyspaces (%#v) is done and tagged as %s ", keyspaces, tag)
+		tags = append(tags, tag)
 	} else {
 		for _, keyspace := range keyspaces {
-			tag, err := caops.cassMngr.SnapshotTable(keyspace, bp.Table)
+			tag, err := caops.cassMngr.SnapshotTable(caops.ctx, keyspace, bp.Table)
 			if err != nil {
-				logrus.Error(err)
-				return false, err
+				return failed(err)
 			}
 			logrus.Infof("Snapshot of %s.%s is done and tagged as %s ", keyspace, bp.Table, tag)
+			tags = append(tags, tag)
 		}
 	}
 
+	details["tags"] = strings.Join(tags, ",")
+	caops.notify(eventBackupCompleted, fmt.Sprintf("Snapshot of %s.%s completed", bp.KeyspaceGlob, bp.Table), details)
 	return false, nil
 }
 
 func (caops *CaOps) clearSnapshotEventHandler(event serf.UserEvent) (breakLoop bool, err error) {
 	logrus.Info("Clearing snapshots...")
-	if err := caops.cassMngr.ClearSnapshot(); err != nil {
+	if err := caops.cassMngr.ClearSnapshot(caops.ctx); err != nil {
 		logrus.Error(err)
 		return false, err
 	}
+	caops.notify(eventSnapshotCleared, "Snapshots cleared", nil)
 	return false, nil
 }
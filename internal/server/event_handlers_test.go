@@ -0,0 +1,60 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/CrossEngage/CaOps/internal/cassandra/cassandratest"
+	"github.com/hashicorp/serf/serf"
+	"github.com/stretchr/testify/assert"
+)
+
+func backupEvent(keyspaceGlob, table string) serf.UserEvent {
+	payload := &BackupPayload{KeyspaceGlob: keyspaceGlob, Table: table, TimeMarker: time.Now().Truncate(time.Second)}
+	return serf.UserEvent{Name: "backup", Payload: payload.Encode()}
+}
+
+func TestBackupEventHandlerKeyspaces(t *testing.T) {
+	caops, agent := newTestCaOps(t)
+	defer agent.Close()
+	agent.AddTable("ks1", "users")
+	agent.AddTable("ks2", "orders")
+
+	_, err := caops.backupEventHandler(backupEvent("ks*", "*"))
+	assert.Nil(t, err)
+	snapshots := agent.Snapshots()
+	assert.Len(t, snapshots, 2)
+	assert.Equal(t, "ks1", snapshots[0].Keyspace)
+	assert.Equal(t, "ks2", snapshots[1].Keyspace)
+	assert.Equal(t, snapshots[0].Tag, snapshots[1].Tag)
+}
+
+func TestBackupEventHandlerTable(t *testing.T) {
+	caops, agent := newTestCaOps(t)
+	defer agent.Close()
+	agent.AddTable("ks1", "users")
+	agent.AddTable("ks1", "events")
+
+	_, err := caops.backupEventHandler(backupEvent("ks1", "users"))
+	assert.Nil(t, err)
+	snapshots := agent.Snapshots()
+	assert.Len(t, snapshots, 1)
+	assert.Equal(t, "users", snapshots[0].Table)
+
+	agent.Fail(cassandratest.StorageService, "takeTableSnapshot", cassandratest.Failure{Message: "disk full"})
+	_, err = caops.backupEventHandler(backupEvent("ks1", "users"))
+	assert.NotNil(t, err)
+}
+
+func TestClearSnapshotEventHandler(t *testing.T) {
+	caops, agent := newTestCaOps(t)
+	defer agent.Close()
+	agent.AddTable("ks1", "users")
+
+	_, err := caops.backupEventHandler(backupEvent("ks1", "*"))
+	assert.Nil(t, err)
+	assert.Len(t, agent.Snapshots(), 1)
+	_, err = caops.clearSnapshotEventHandler(serf.UserEvent{Name: "clearsnapshot"})
+	assert.Nil(t, err)
+	assert.Empty(t, agent.Snapshots())
+}
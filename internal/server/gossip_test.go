@@ -0,0 +1,107 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackupAcrossAgents(t *testing.T) {
+	h := newHarness(t, 3)
+	defer h.Close()
+	h.AddTable("ks1", "users")
+	h.AddTable("ks1", "events")
+	h.AddTable("other", "orders")
+
+	w := h.Nodes[1].Request("GET", "/backup-keyspaces/ks*")
+	assertStatus(t, http.StatusAccepted, w)
+	assert.Contains(t, w.Body.String(), "2018-01-01T12:00:15Z")
+
+	// every agent waits for the time marker before taking its snapshot
+	h.eventually("all the agents wait for the time marker", func() bool { return h.Clock.Pending() == len(h.Nodes) })
+	for ip, tables := range h.snapshotsByNode() {
+		assert.Empty(t, tables, "node %s took a snapshot before the time marker", ip)
+	}
+
+	h.Clock.Advance(13 * time.Second)
+	assert.Equal(t, len(h.Nodes), h.Clock.Pending())
+	h.Clock.Advance(time.Second)
+	h.eventually("all the agents take their snapshot", func() bool {
+		for _, tables := range h.snapshotsByNode() {
+			if len(tables) == 0 {
+				return false
+			}
+		}
+		return true
+	})
+	for ip, tables := range h.snapshotsByNode() {
+		assert.Equal(t, []string{"ks1.events", "ks1.users"}, tables, "node %s", ip)
+	}
+}
+
+func TestBackupTableAcrossAgents(t *testing.T) {
+	h := newHarness(t, 2)
+	defer h.Close()
+	h.AddTable("ks1", "users")
+	h.AddTable("ks1", "events")
+
+	assertStatus(t, http.StatusAccepted, h.Nodes[0].Request("GET", "/backup-tables/ks1/users"))
+	h.eventually("all the agents wait for the time marker", func() bool { return h.Clock.Pending() == len(h.Nodes) })
+	h.Clock.Advance(15 * time.Second)
+	h.eventually("all the agents take their snapshot", func() bool {
+		for _, node := range h.Nodes {
+			if len(node.Agent.Snapshots()) == 0 {
+				return false
+			}
+		}
+		return true
+	})
+	for ip, tables := range h.snapshotsByNode() {
+		assert.Equal(t, []string{"ks1.users"}, tables, "node %s", ip)
+	}
+}
+
+func TestClearSnapshotsAcrossAgents(t *testing.T) {
+	h := newHarness(t, 3)
+	defer h.Close()
+	h.AddTable("ks1", "users")
+	for _, node := range h.Nodes {
+		_, err := node.CaOps.cassMngr.SnapshotTable(node.CaOps.ctx, "ks1", "users")
+		assert.Nil(t, err)
+	}
+
+	assertStatus(t, http.StatusAccepted, h.Nodes[2].Request("DELETE", "/snapshots"))
+	h.eventually("all the agents clear their snapshots", func() bool {
+		for _, node := range h.Nodes {
+			if len(node.Agent.Snapshots()) > 0 {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+func TestClusterStatusAcrossAgents(t *testing.T) {
+	h := newHarness(t, 3)
+	defer h.Close()
+	h.Nodes[2].Agent.SetAttribute("org.apache.cassandra.db:type=StorageService", "SchemaVersion", "other")
+
+	w := h.Nodes[0].Request("GET", "/cluster/status?timeout=5s")
+	assertStatus(t, http.StatusServiceUnavailable, w)
+	cs := &ClusterStatus{}
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(cs))
+	assert.Equal(t, StatusDegraded, cs.Status)
+	assert.Len(t, cs.Nodes, 3)
+	for _, node := range h.Nodes {
+		if assert.NotNil(t, cs.Nodes[node.IP].Status, "node %s", node.IP) {
+			assert.Equal(t, StatusOK, cs.Nodes[node.IP].Status.Status)
+		}
+	}
+	if assert.Len(t, cs.Disagreements, 1) {
+		assert.Equal(t, "schema_version", cs.Disagreements[0].Attribute)
+		assert.Equal(t, []string{"127.0.0.3"}, cs.Disagreements[0].Values["other"])
+	}
+}
@@ -1,10 +1,13 @@
 package server
 
 import (
+	"fmt"
 	"net"
 	"os"
 	"path/filepath"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/hashicorp/serf/serf"
@@ -17,17 +20,45 @@ type EventHandler func(event serf.UserEvent) (breakLoop bool, err error)
 // EventHandlersMap is a map of event IDs to its collection of handlers
 type EventHandlersMap map[string][]EventHandler
 
+// QueryHandler receives a query, and must return the response to be sent back to the
+// querying agent, or an error, in which case no response is sent
+type QueryHandler func(query *serf.Query) (response []byte, err error)
+
+// MemberHandler receives the events of the agents joining, leaving or failing
+type MemberHandler func(event serf.MemberEvent)
+
+// QueryResults holds the responses of a query, keyed by the IP of the responding agent,
+// and the IPs of the agents that were alive when the query was sent, but did not respond
+type QueryResults struct {
+	Responses map[string][]byte
+	Missing   []string
+}
+
 // Gossiper handles CaOps cluster-wide communication. It is used to send cluster-wide commands,
 type Gossiper struct {
 	eventCh          chan serf.Event
 	serf             *serf.Serf
 	eventHandlers    EventHandlersMap
 	eventHandlersMtx sync.Mutex
+	queryHandlers    map[string]QueryHandler
+	queryHandlersMtx sync.Mutex
+	memberHandlers   []MemberHandler
+	memberHandlerMtx sync.Mutex
 	shutdownCh       chan struct{}
 }
 
-// NewGossiper constructs a new Gossiper object
+// queryResponseSizeLimit raises the Serf default of 1KB, so agents can respond with whole
+// status documents. Responses are sent in a single UDP packet, so it must stay below 64KB.
+const queryResponseSizeLimit = 16 * 1024
+
+// NewGossiper constructs a new Gossiper object, named after the host
 func NewGossiper(bindTo, snapshotPath string) (*Gossiper, error) {
+	return newGossiper(bindTo, snapshotPath, "")
+}
+
+// newGossiper constructs a new Gossiper object with the given name, which must be unique in
+// the cluster, or named after the host when empty
+func newGossiper(bindTo, snapshotPath, nodeName string) (*Gossiper, error) {
 	serfBindAddr, err := net.ResolveTCPAddr("tcp", bindTo)
 	if err != nil {
 		return nil, err
@@ -39,10 +70,14 @@ func NewGossiper(bindTo, snapshotPath string) (*Gossiper, error) {
 	eventCh := make(chan serf.Event, 256)
 	config := serf.DefaultConfig()
 	config.Init()
+	if nodeName != "" {
+		config.NodeName = nodeName
+	}
 	config.MemberlistConfig.BindAddr = serfBindAddr.IP.String()
 	config.MemberlistConfig.BindPort = serfBindAddr.Port
 	config.EventCh = eventCh
 	config.SnapshotPath = snapshotPath
+	config.QueryResponseSizeLimit = queryResponseSizeLimit
 	// TODO route Serf logs to logrus
 
 	serfCli, err := serf.Create(config)
@@ -54,11 +89,38 @@ func NewGossiper(bindTo, snapshotPath string) (*Gossiper, error) {
 		eventCh:       eventCh,
 		serf:          serfCli,
 		eventHandlers: make(EventHandlersMap),
-		shutdownCh:    make(chan struct{}), // TODO handle shutdowns
+		queryHandlers: make(map[string]QueryHandler),
+		shutdownCh:    make(chan struct{}),
 	}
 	return gossiper, nil
 }
 
+// Shutdown stops the event loop, and leaves the cluster
+func (g *Gossiper) Shutdown() error {
+	close(g.shutdownCh)
+	if err := g.serf.Leave(); err != nil {
+		logrus.Warnf("Could not leave the cluster gracefully: %s", err)
+	}
+	return g.serf.Shutdown()
+}
+
+// Leave makes this agent leave the cluster for good, so the other agents forget it at once,
+// while it keeps running until it is shut down
+func (g *Gossiper) Leave() error {
+	return g.serf.Leave()
+}
+
+// RemoveFailedMember makes the cluster forget the failed agent with the IP at once, instead
+// of waiting for it to come back until the reconnect timeout
+func (g *Gossiper) RemoveFailedMember(ip string) error {
+	for _, member := range g.serf.Members() {
+		if member.Addr.String() == ip && member.Status == serf.StatusFailed {
+			return g.serf.RemoveFailedNode(member.Name)
+		}
+	}
+	return fmt.Errorf("No failed CaOps agent on %s", ip)
+}
+
 // Join the cluster formed by nodes
 func (g *Gossiper) Join(nodes []string) error {
 	logrus.Debug("Joining gossiper to nodes: ", nodes)
@@ -82,6 +144,17 @@ func (g *Gossiper) AliveMembers() []string {
 	return ips
 }
 
+// FailedMembers return the IPs of the CaOps agents which failed, without leaving the cluster
+func (g *Gossiper) FailedMembers() []string {
+	ips := make([]string, 0)
+	for _, member := range g.serf.Members() {
+		if member.Status == serf.StatusFailed {
+			ips = append(ips, member.Addr.String())
+		}
+	}
+	return ips
+}
+
 // EventLoop watches for events and calls the proper triggers
 func (g *Gossiper) EventLoop() {
 	for {
@@ -91,8 +164,10 @@ func (g *Gossiper) EventLoop() {
 			switch ev := e.(type) {
 			case serf.MemberEvent:
 				logrus.Debug("[84] Event member", ev.EventType())
+				go g.handleMemberEvent(ev)
 			case *serf.Query:
 				logrus.Debug("[86] Event query", ev.EventType())
+				go g.handleQuery(ev)
 			case serf.UserEvent:
 				logrus.Debug("[88] Event user", ev.String())
 				g.handleUserEvent(ev)
@@ -127,6 +202,22 @@ func (g *Gossiper) handleUserEvent(event serf.UserEvent) {
 	}
 }
 
+func (g *Gossiper) handleMemberEvent(event serf.MemberEvent) {
+	g.memberHandlerMtx.Lock()
+	handlers := append([]MemberHandler{}, g.memberHandlers...)
+	g.memberHandlerMtx.Unlock()
+	for _, handler := range handlers {
+		handler(event)
+	}
+}
+
+// RegisterMemberHandler adds a handler of the member events
+func (g *Gossiper) RegisterMemberHandler(handler MemberHandler) {
+	g.memberHandlerMtx.Lock()
+	defer g.memberHandlerMtx.Unlock()
+	g.memberHandlers = append(g.memberHandlers, handler)
+}
+
 // RegisterEventHandler adds a new event handler
 func (g *Gossiper) RegisterEventHandler(name string, handler EventHandler) {
 	g.eventHandlersMtx.Lock()
@@ -147,6 +238,107 @@ func (g *Gossiper) isEventHandlerNameRegistered(name string) bool {
 	return ok
 }
 
+func (g *Gossiper) handleQuery(query *serf.Query) {
+	g.queryHandlersMtx.Lock()
+	handler, ok := g.queryHandlers[query.Name]
+	g.queryHandlersMtx.Unlock()
+	if !ok {
+		logrus.Errorf("Unknown query type '%s'", query.Name)
+		return
+	}
+	response, err := handler(query)
+	if err != nil {
+		logrus.Errorf("Error when running handler for query '%s': %s", query.Name, err)
+		return
+	}
+	if err := query.Respond(response); err != nil {
+		logrus.Errorf("Could not respond to query '%s': %s", query.Name, err)
+	}
+}
+
+// RegisterQueryHandler sets the handler of a query, replacing any previous one
+func (g *Gossiper) RegisterQueryHandler(name string, handler QueryHandler) {
+	g.queryHandlersMtx.Lock()
+	defer g.queryHandlersMtx.Unlock()
+	g.queryHandlers[name] = handler
+}
+
+// Query sends a query to all the agents, including this one, and collects their responses
+// until all the alive agents responded, or the timeout is reached.
+func (g *Gossiper) Query(name string, payload EventPayload, timeout time.Duration) (*QueryResults, error) {
+	addrs := make(map[string]string)
+	for _, member := range g.serf.Members() {
+		addrs[member.Name] = member.Addr.String()
+	}
+	expected := stringListToMapKeys(g.AliveMembers())
+
+	params := g.serf.DefaultQueryParams()
+	params.Timeout = timeout
+	resp, err := g.serf.Query(name, payload.Encode(), params)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Close()
+
+	results := &QueryResults{Responses: make(map[string][]byte), Missing: make([]string, 0)}
+	for answered := 0; answered < len(expected); {
+		nodeResp, ok := <-resp.ResponseCh()
+		if !ok {
+			break // the timeout was reached
+		}
+		addr, ok := addrs[nodeResp.From]
+		if !ok {
+			logrus.Warnf("Response to query '%s' from unknown agent %s", name, nodeResp.From)
+			continue
+		}
+		if _, dup := results.Responses[addr]; !dup && expected[addr] {
+			answered++
+		}
+		results.Responses[addr] = nodeResp.Payload
+	}
+	for addr := range expected {
+		if _, ok := results.Responses[addr]; !ok {
+			results.Missing = append(results.Missing, addr)
+		}
+	}
+	sort.Strings(results.Missing)
+	return results, nil
+}
+
+// QueryNode sends a query to the agent with the IP, which may be this one, and waits for its
+// response until the timeout is reached
+func (g *Gossiper) QueryNode(name, ip string, payload EventPayload, timeout time.Duration) ([]byte, error) {
+	var nodeName string
+	for _, member := range g.serf.Members() {
+		if member.Addr.String() == ip && member.Status == serf.StatusAlive {
+			nodeName = member.Name
+		}
+	}
+	if nodeName == "" {
+		return nil, fmt.Errorf("No alive CaOps agent on %s", ip)
+	}
+
+	params := g.serf.DefaultQueryParams()
+	params.Timeout = timeout
+	params.FilterNodes = []string{nodeName}
+	resp, err := g.serf.Query(name, payload.Encode(), params)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Close()
+	for nodeResp := range resp.ResponseCh() {
+		if nodeResp.From == nodeName {
+			return nodeResp.Payload, nil
+		}
+	}
+	return nil, fmt.Errorf("No response to query '%s' from %s within %s", name, ip, timeout)
+}
+
+// LocalAddr returns the IP of this agent
+func (g *Gossiper) LocalAddr() string {
+	return g.serf.LocalMember().Addr.String()
+}
+
 // EventPayload ...
 type EventPayload interface {
 	Encode() []byte
@@ -0,0 +1,252 @@
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/CrossEngage/CaOps/internal/cassandra"
+	"github.com/CrossEngage/CaOps/internal/cassandra/cassandratest"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeClock only moves when advanced, firing the timers which are then due
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []fakeTimer
+}
+
+type fakeTimer struct {
+	at time.Time
+	ch chan time.Time
+}
+
+func newFakeClock(now time.Time) *fakeClock {
+	return &fakeClock{now: now}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+	c.timers = append(c.timers, fakeTimer{at: c.now.Add(d), ch: ch})
+	return ch
+}
+
+// Advance moves the clock forward, firing the timers which are due
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	pending := c.timers[:0]
+	for _, timer := range c.timers {
+		if timer.at.After(c.now) {
+			pending = append(pending, timer)
+		} else {
+			timer.ch <- c.now
+		}
+	}
+	c.timers = pending
+}
+
+// Pending returns the number of timers which are not due yet
+func (c *fakeClock) Pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.timers)
+}
+
+// harnessNode is a CaOps agent of the harness, with the fake agent of its Cassandra node, and
+// the endpoint of its webhook
+type harnessNode struct {
+	IP      string
+	CaOps   *CaOps
+	Agent   *cassandratest.FakeAgent
+	Webhook *webhookEndpoint
+
+	closeWebhook func()
+}
+
+// Request calls the HTTP API of the node
+func (n *harnessNode) Request(method, path string) *httptest.ResponseRecorder {
+	return n.RequestWithBody(method, path, "")
+}
+
+// RequestWithBody calls the HTTP API of the node, sending the body
+func (n *harnessNode) RequestWithBody(method, path, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	n.CaOps.router.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+	return w
+}
+
+// harness runs a cluster of CaOps agents in the test process. Each agent gossips on its own
+// loopback IP, 127.0.0.1, 127.0.0.2 and so on, so the agents are told apart by their IPs like
+// in a real cluster. All the agents share the same fake clock.
+type harness struct {
+	t      *testing.T
+	Clock  *fakeClock
+	Nodes  []*harnessNode
+	tmpDir string
+}
+
+// newHarness starts a cluster of agents, and waits until all of them see each other
+func newHarness(t *testing.T, size int) *harness {
+	ips := make([]string, 0, size)
+	for i := 1; i <= size; i++ {
+		ips = append(ips, fmt.Sprintf("127.0.0.%d", i))
+	}
+	port, err := freePort(ips)
+	if err != nil {
+		t.Skipf("Can not bind the loopback IPs of the harness: %s", err)
+	}
+	tmpDir, err := ioutil.TempDir("", "caops-harness")
+	assert.Nil(t, err)
+
+	h := &harness{
+		t:      t,
+		Clock:  newFakeClock(time.Date(2018, 1, 1, 12, 0, 1, 0, time.UTC)),
+		tmpDir: tmpDir,
+	}
+	for _, ip := range ips {
+		h.Nodes = append(h.Nodes, h.startNode(ip, port, ips))
+	}
+	h.eventually("all the agents see each other", func() bool {
+		for _, node := range h.Nodes {
+			if len(node.CaOps.gossiper.AliveMembers()) != size {
+				return false
+			}
+		}
+		return true
+	})
+	return h
+}
+
+func (h *harness) startNode(ip string, port int, ips []string) *harnessNode {
+	agent := cassandratest.NewFakeAgent()
+	agent.SetAttribute(cassandratest.StorageService, "LiveNodes", ips)
+	cassMngr, err := cassandra.NewManager(agent.Config())
+	assert.Nil(h.t, err)
+
+	bindAddr := net.JoinHostPort(ip, strconv.Itoa(port))
+	gossiper, err := newGossiper(bindAddr, filepath.Join(h.tmpDir, ip, "serf.snapshot"), bindAddr)
+	if !assert.Nil(h.t, err) {
+		h.t.FailNow()
+	}
+	caops, err := newCaOps(APIConfig{}, cassMngr, gossiper)
+	assert.Nil(h.t, err)
+	caops.clock = h.Clock
+	repairConfig := RepairConfig{StatePath: filepath.Join(h.tmpDir, ip, "repairs.json")}
+	if !assert.Nil(h.t, caops.enableRepairs(repairConfig, "")) {
+		h.t.FailNow()
+	}
+	caops.enableRollingJobs()
+	webhook, closeWebhook := setWebhook(h.t, caops)
+	caops.registerGossipHandlers()
+	if !assert.Nil(h.t, caops.Init()) {
+		h.t.FailNow()
+	}
+	return &harnessNode{IP: ip, CaOps: caops, Agent: agent, Webhook: webhook, closeWebhook: closeWebhook}
+}
+
+// Close stops all the agents
+func (h *harness) Close() {
+	for _, node := range h.Nodes {
+		node.CaOps.cancel()
+		node.CaOps.gossiper.Shutdown()
+		node.Agent.Close()
+		node.closeWebhook()
+	}
+	os.RemoveAll(h.tmpDir)
+}
+
+// AddTable adds the table to the Cassandra node of every agent
+func (h *harness) AddTable(keyspace, table string) {
+	for _, node := range h.Nodes {
+		node.Agent.AddTable(keyspace, table)
+	}
+}
+
+// eventually waits until the condition is met, failing the test after 10 seconds
+func (h *harness) eventually(what string, condition func() bool) {
+	deadline := time.Now().Add(10 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			h.t.Fatalf("Timed out waiting until %s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// freePort finds a port which is free on all the IPs, for both TCP and UDP, as Serf binds
+// the same port on each of them, so the agents can join each other by IP
+func freePort(ips []string) (int, error) {
+	var lastErr error
+	for attempt := 0; attempt < 10; attempt++ {
+		l, err := net.Listen("tcp", net.JoinHostPort(ips[0], "0"))
+		if err != nil {
+			return 0, err
+		}
+		port := l.Addr().(*net.TCPAddr).Port
+		l.Close()
+		if lastErr = checkPort(ips, port); lastErr == nil {
+			return port, nil
+		}
+	}
+	return 0, lastErr
+}
+
+func checkPort(ips []string, port int) error {
+	for _, ip := range ips {
+		addr := net.JoinHostPort(ip, strconv.Itoa(port))
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			return err
+		}
+		l.Close()
+		c, err := net.ListenPacket("udp", addr)
+		if err != nil {
+			return err
+		}
+		c.Close()
+	}
+	return nil
+}
+
+// snapshotsByNode returns the keyspace.table of the snapshots of each node, keyed by its IP
+func (h *harness) snapshotsByNode() map[string][]string {
+	byNode := make(map[string][]string)
+	for _, node := range h.Nodes {
+		tables := make([]string, 0)
+		for _, snapshot := range node.Agent.Snapshots() {
+			tables = append(tables, snapshot.Keyspace+"."+snapshot.Table)
+		}
+		sort.Strings(tables)
+		byNode[node.IP] = tables
+	}
+	return byNode
+}
+
+func assertStatus(t *testing.T, expected int, w *httptest.ResponseRecorder) {
+	assert.Equal(t, expected, w.Code, "%s %s", http.StatusText(w.Code), w.Body.String())
+}
@@ -0,0 +1,275 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/CrossEngage/CaOps/internal/health"
+)
+
+// DefaultMaxPendingCompactions is the number of pending compactions over which the node is
+// considered behind on its compactions
+const DefaultMaxPendingCompactions = 100
+
+// Names of the health checks
+const (
+	checkUnreachableNodes  = "unreachable-nodes"
+	checkJoiningNodes      = "joining-nodes"
+	checkLeavingNodes      = "leaving-nodes"
+	checkMovingNodes       = "moving-nodes"
+	checkSchemaAgreement   = "schema-agreement"
+	checkPendingCompaction = "pending-compactions"
+	checkDroppedMutations  = "dropped-mutations"
+	checkHintBacklog       = "hint-backlog"
+	checkDiskHeadroom      = "disk-headroom"
+	checkJolokia           = "jolokia"
+)
+
+// nodeChecks are the checks of the node of this agent, while the other checks are of the
+// whole cluster, and give the same results on every agent
+var nodeChecks = []string{checkPendingCompaction, checkDroppedMutations, checkDiskHeadroom, checkJolokia}
+
+// Operations whose health checks must pass before they run. The rolling step checks are the
+// ones waited for before moving on to the next nodes of a rolling job.
+const (
+	operationBackup      = "backup"
+	operationRepair      = "repair"
+	operationRollingJob  = "rolling-job"
+	operationRollingStep = "rolling-step"
+	operationNodeRemoval = "node-removal"
+)
+
+// HealthConfig holds the thresholds of the health checks, and the checks required by the
+// operations on top of the ones they always require, by operation
+type HealthConfig struct {
+	MaxPendingCompactions int64
+	// MaxDroppedMutations is the number of mutations the node may drop between two runs of the
+	// check by the same caller, like the webhook watcher or the checks of an operation
+	MaxDroppedMutations int64
+	Required            map[string][]string
+}
+
+func (config HealthConfig) withDefaults() HealthConfig {
+	if config.MaxPendingCompactions == 0 {
+		config.MaxPendingCompactions = DefaultMaxPendingCompactions
+	}
+	return config
+}
+
+// Callers of the health checks, besides the operations, each comparing the results of the
+// checks against their own previous run
+const (
+	healthCallerEndpoint = "endpoint"
+	healthCallerWatcher  = "watcher"
+)
+
+// healthCallerKey is the key of the context value naming the caller of the health checks
+type healthCallerKey struct{}
+
+// withHealthCaller returns a context running the health checks for the caller
+func withHealthCaller(ctx context.Context, caller string) context.Context {
+	return context.WithValue(ctx, healthCallerKey{}, caller)
+}
+
+// healthCaller returns the caller of the health checks run with the context
+func healthCaller(ctx context.Context) string {
+	caller, _ := ctx.Value(healthCallerKey{}).(string)
+	return caller
+}
+
+// HealthChecksResponse is the response of the health checks handler, listing the results of
+// the checks and the checks required by each operation
+type HealthChecksResponse struct {
+	Healthy    bool                `json:"healthy"`
+	Checks     []*health.Result    `json:"checks"`
+	Operations map[string][]string `json:"operations"`
+}
+
+// newHealthRegistry registers the health checks of the cluster, as seen by the node of this
+// agent, and declares the operations with the checks they require
+func (caops *CaOps) newHealthRegistry() (*health.Registry, error) {
+	registry := health.NewRegistry()
+	dropped := &droppedMutationsCheck{caops: caops, last: make(map[string]int64)}
+	for _, check := range []health.Check{
+		{Name: checkUnreachableNodes, Severity: health.Critical, Run: nodeListCheck(caops.cassMngr.UnreachableNodes, "unreachable")},
+		{Name: checkJoiningNodes, Severity: health.Critical, Run: nodeListCheck(caops.cassMngr.JoiningNodes, "joining")},
+		{Name: checkLeavingNodes, Severity: health.Critical, Run: nodeListCheck(caops.cassMngr.LeavingNodes, "leaving")},
+		{Name: checkMovingNodes, Severity: health.Critical, Run: nodeListCheck(caops.cassMngr.MovingNodes, "moving")},
+		{Name: checkSchemaAgreement, Severity: health.Critical, Run: caops.checkSchemaAgreement},
+		{Name: checkPendingCompaction, Severity: health.Warning, Run: caops.checkPendingCompactions},
+		{Name: checkDroppedMutations, Severity: health.Warning, Run: dropped.run},
+		{Name: checkHintBacklog, Severity: health.Warning, Run: caops.checkHintBacklog},
+		{Name: checkDiskHeadroom, Severity: health.Warning, Run: caops.checkDiskHeadroomHealth},
+		{Name: checkJolokia, Severity: health.Critical, Run: caops.checkJolokia},
+	} {
+		if err := registry.Register(check); err != nil {
+			return nil, err
+		}
+	}
+
+	stability := []string{checkUnreachableNodes, checkJoiningNodes, checkLeavingNodes, checkMovingNodes}
+	for operation, checks := range map[string][]string{
+		operationBackup:      {checkJolokia, checkSchemaAgreement},
+		operationRepair:      {checkJolokia, checkSchemaAgreement},
+		operationRollingJob:  append([]string{checkJolokia, checkSchemaAgreement}, stability...),
+		operationRollingStep: append([]string{checkHintBacklog}, stability...),
+		operationNodeRemoval: append([]string{checkJolokia, checkSchemaAgreement}, stability...),
+	} {
+		if err := registry.Declare(operation, checks...); err != nil {
+			return nil, err
+		}
+	}
+	return registry, nil
+}
+
+// checkOperation runs the health checks required by the operation, whose failures prevent it
+func (caops *CaOps) checkOperation(ctx context.Context, operation string, excluded ...string) error {
+	err := caops.health.CheckOperation(withHealthCaller(ctx, operation), operation, excluded...)
+	if _, ok := err.(*health.OperationError); ok {
+		return &clusterCheckError{err}
+	}
+	return err
+}
+
+// nodeListCheck fails when the list read has other nodes than the excluded ones
+func nodeListCheck(read func(context.Context) ([]string, error), state string) health.CheckFunc {
+	return func(ctx context.Context, excluded []string) error {
+		nodes, err := read(ctx)
+		if err != nil {
+			return err
+		}
+		ignored := stringListToMapKeys(excluded)
+		found := make([]string, 0)
+		for _, node := range nodes {
+			if !ignored[node] {
+				found = append(found, node)
+			}
+		}
+		if len(found) > 0 {
+			return health.Failf("Nodes %s are %s", strings.Join(found, ", "), state)
+		}
+		return nil
+	}
+}
+
+func (caops *CaOps) checkPendingCompactions(ctx context.Context, excluded []string) error {
+	stats, err := caops.cassMngr.CompactionStats(ctx)
+	if err != nil {
+		return err
+	}
+	if max := caops.healthConfig.MaxPendingCompactions; stats.PendingTasks > max {
+		return health.Failf("%d compactions are pending, over %d", stats.PendingTasks, max)
+	}
+	return nil
+}
+
+// droppedMutationsCheck fails when the node dropped more mutations than allowed since the
+// previous run of the check by the same caller, as the counter of Cassandra only grows until
+// the node restarts. Each caller has its own baseline, so that the runs of the others, like
+// the requests to the health checks endpoint, do not hide the mutations dropped in between.
+type droppedMutationsCheck struct {
+	caops *CaOps
+	mtx   sync.Mutex
+	last  map[string]int64
+}
+
+func (c *droppedMutationsCheck) run(ctx context.Context, excluded []string) error {
+	stats, err := c.caops.cassMngr.ThreadPoolsStats(ctx)
+	if err != nil {
+		return err
+	}
+	dropped := stats.DroppedMessages["MUTATION"]
+	caller := healthCaller(ctx)
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	previous, ok := c.last[caller]
+	c.last[caller] = dropped
+	if !ok || dropped < previous {
+		return nil
+	}
+	if max := c.caops.healthConfig.MaxDroppedMutations; dropped-previous > max {
+		return health.Failf("%d mutations were dropped since the previous check", dropped-previous)
+	}
+	return nil
+}
+
+// checkHintBacklog fails while hints are being written by the nodes, asking every agent when
+// the node takes part in gossip, so the nodes which were down got the writes they missed
+func (caops *CaOps) checkHintBacklog(ctx context.Context, excluded []string) error {
+	if caops.gossiper == nil {
+		hints, err := caops.cassMngr.HintsInProgress(ctx)
+		if err != nil {
+			return err
+		}
+		if hints > 0 {
+			return health.Failf("%d hints are still in progress", hints)
+		}
+		return nil
+	}
+	results, err := caops.gossiper.Query(hintsQueryName, &EmptyPayload{}, rollingQueryTimeout)
+	if err != nil {
+		return err
+	}
+	if len(results.Missing) > 0 {
+		return fmt.Errorf("No response from the agents of %s", strings.Join(results.Missing, ", "))
+	}
+	var hints int64
+	for ip, response := range results.Responses {
+		count, err := strconv.ParseInt(string(response), 10, 64)
+		if err != nil {
+			return fmt.Errorf("Could not read the hints in progress of %s: %s", ip, response)
+		}
+		hints += count
+	}
+	if hints > 0 {
+		return health.Failf("%d hints are still in progress", hints)
+	}
+	return nil
+}
+
+// checkDiskHeadroomHealth checks that the node has room to rewrite its largest table. It can
+// not tell when the node is managed through a Jolokia proxy, as its data directories are on
+// another host.
+func (caops *CaOps) checkDiskHeadroomHealth(ctx context.Context, excluded []string) error {
+	if target := caops.cassMngr.JMXTarget(); target != "" {
+		return fmt.Errorf("The free space of the node can not be read through the Jolokia proxy target %s", target)
+	}
+	return caops.checkDiskHeadroom(ctx, nil)
+}
+
+func (caops *CaOps) checkJolokia(ctx context.Context, excluded []string) error {
+	if _, err := caops.cassMngr.JolokiaAgentVersion(ctx); err != nil {
+		return health.Failf("Jolokia is unreachable: %s", err)
+	}
+	return nil
+}
+
+// healthChecksHandler runs all the health checks, or the ones required by the operation
+// parameter, responding with 503 Service Unavailable when a critical check did not pass
+func (caops *CaOps) healthChecksHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	operations := caops.health.Operations()
+	var names []string
+	if operation := r.URL.Query().Get("operation"); operation != "" {
+		var ok bool
+		if names, ok = operations[operation]; !ok {
+			http.Error(w, fmt.Sprintf("Unknown operation %s", operation), http.StatusNotFound)
+			return
+		}
+	}
+	results, err := caops.health.Run(withHealthCaller(r.Context(), healthCallerEndpoint), nil, names...)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error while running the health checks: %s", err), http.StatusInternalServerError)
+		return
+	}
+	response := &HealthChecksResponse{Healthy: health.Healthy(results), Checks: results, Operations: operations}
+	status := http.StatusOK
+	if !response.Healthy {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, response)
+}
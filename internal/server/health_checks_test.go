@@ -0,0 +1,126 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/CrossEngage/CaOps/internal/cassandra"
+	"github.com/CrossEngage/CaOps/internal/cassandra/cassandratest"
+	"github.com/CrossEngage/CaOps/internal/health"
+	"github.com/CrossEngage/CaOps/internal/jolokia"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	pendingCompactionsMBean = "org.apache.cassandra.metrics:type=Compaction,name=PendingTasks"
+	droppedMutationsMBean   = "org.apache.cassandra.metrics:type=DroppedMessage,scope=MUTATION,name=Dropped"
+)
+
+// healthChecks calls the health checks handler, returning the status of each check by name
+func healthChecks(t *testing.T, node *harnessNode, path string, expected int) (*HealthChecksResponse, map[string]health.Status) {
+	w := node.Request("GET", path)
+	assertStatus(t, expected, w)
+	response := &HealthChecksResponse{}
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(response))
+	statuses := make(map[string]health.Status)
+	for _, result := range response.Checks {
+		statuses[result.Name] = result.Status
+	}
+	return response, statuses
+}
+
+func TestHealthChecks(t *testing.T) {
+	h := newHarness(t, 2)
+	defer h.Close()
+	node := h.Nodes[0]
+	node.Agent.SetAttribute("org.apache.cassandra.db:type=CompactionManager", "Compactions", []map[string]string{})
+	node.Agent.SetAttribute(pendingCompactionsMBean, "Value", 3)
+	node.Agent.SetAttribute("org.apache.cassandra.metrics:type=Compaction,name=TotalCompactionsCompleted", "Count", 42)
+	node.Agent.SetAttribute("org.apache.cassandra.metrics:type=ThreadPools,path=request,scope=MutationStage,name=ActiveTasks", "Value", 0)
+	node.Agent.SetAttribute(droppedMutationsMBean, "Count", 5)
+
+	response, statuses := healthChecks(t, node, "/health/checks", http.StatusOK)
+	assert.True(t, response.Healthy)
+	assert.Len(t, response.Checks, 10)
+	for name, status := range statuses {
+		assert.Equal(t, health.Passed, status, name)
+	}
+	assert.Equal(t, []string{checkJolokia, checkSchemaAgreement}, response.Operations[operationBackup])
+	assert.NotNil(t, node.CaOps.health.Require("backups", checkDiskHeadroom))
+
+	// all the failures are reported at once, but only the critical ones make the cluster unhealthy
+	node.Agent.SetAttribute(pendingCompactionsMBean, "Value", 500)
+	node.Agent.SetAttribute(droppedMutationsMBean, "Count", 8)
+	response, statuses = healthChecks(t, node, "/health/checks", http.StatusOK)
+	assert.True(t, response.Healthy)
+	assert.Equal(t, health.Failed, statuses[checkPendingCompaction])
+	assert.Equal(t, health.Failed, statuses[checkDroppedMutations])
+	node.Agent.SetAttribute(cassandratest.StorageService, "LiveNodes", []string{"127.0.0.1"})
+	node.Agent.SetAttribute(cassandratest.StorageService, "UnreachableNodes", []string{"127.0.0.2"})
+	node.Agent.SetAttribute(cassandratest.StorageService, "LeavingNodes", []string{"127.0.0.2"})
+	response, statuses = healthChecks(t, node, "/health/checks", http.StatusServiceUnavailable)
+	assert.False(t, response.Healthy)
+	assert.Equal(t, health.Failed, statuses[checkUnreachableNodes])
+	assert.Equal(t, health.Failed, statuses[checkLeavingNodes])
+	assert.Equal(t, health.Passed, statuses[checkJoiningNodes])
+	assert.Equal(t, health.Passed, statuses[checkDroppedMutations])
+
+	// the checks of an operation, which must all pass before it
+	_, statuses = healthChecks(t, node, "/health/checks?operation="+operationRollingStep, http.StatusServiceUnavailable)
+	assert.Len(t, statuses, 5)
+	assertStatus(t, http.StatusNotFound, node.Request("GET", "/health/checks?operation=unknown"))
+	err := node.CaOps.checkOperation(node.CaOps.ctx, operationRollingStep)
+	if assert.IsType(t, &clusterCheckError{}, err) {
+		assert.Contains(t, err.Error(), "unreachable-nodes failed: Nodes 127.0.0.2 are unreachable")
+		assert.Contains(t, err.Error(), "leaving-nodes failed: Nodes 127.0.0.2 are leaving")
+	}
+	assert.Nil(t, node.CaOps.checkOperation(node.CaOps.ctx, operationRollingStep, "127.0.0.2"))
+
+	// Jolokia is down, so the other checks can not tell
+	node.Agent.Close()
+	_, statuses = healthChecks(t, node, "/health/checks?operation="+operationBackup, http.StatusServiceUnavailable)
+	assert.Equal(t, health.Failed, statuses[checkJolokia])
+	assert.Equal(t, health.Errored, statuses[checkSchemaAgreement])
+}
+
+func TestDroppedMutationsByCaller(t *testing.T) {
+	h := newHarness(t, 1)
+	defer h.Close()
+	node := h.Nodes[0]
+	node.Agent.SetAttribute("org.apache.cassandra.metrics:type=ThreadPools,path=request,scope=MutationStage,name=ActiveTasks", "Value", 0)
+	node.Agent.SetAttribute(droppedMutationsMBean, "Count", 5)
+	watcher := withHealthCaller(context.Background(), healthCallerWatcher)
+	run := func(ctx context.Context) health.Status {
+		results, err := node.CaOps.health.Run(ctx, nil, checkDroppedMutations)
+		assert.Nil(t, err)
+		return results[0].Status
+	}
+	assert.Equal(t, health.Passed, run(watcher))
+
+	// the requests to the endpoint do not move the baseline of the watcher
+	node.Agent.SetAttribute(droppedMutationsMBean, "Count", 8)
+	_, statuses := healthChecks(t, node, "/health/checks", http.StatusOK)
+	assert.Equal(t, health.Passed, statuses[checkDroppedMutations])
+	_, statuses = healthChecks(t, node, "/health/checks", http.StatusOK)
+	assert.Equal(t, health.Passed, statuses[checkDroppedMutations])
+	assert.Equal(t, health.Failed, run(watcher))
+	assert.Equal(t, health.Passed, run(watcher))
+}
+
+func TestDiskHeadroomCheckWhenProxied(t *testing.T) {
+	target := jolokia.JMXServiceURL("10.0.0.1", 7199)
+	cassMngr, err := cassandra.NewManager(jolokia.Config{URL: "http://127.0.0.1:8778/jolokia", ProxyTargetURL: target})
+	assert.Nil(t, err)
+	caops, err := newCaOps(APIConfig{}, cassMngr, nil)
+	assert.Nil(t, err)
+	assert.NotZero(t, caops.rollingConfig.Cleanup.DiskHeadroom)
+
+	results, err := caops.health.Run(context.Background(), nil, checkDiskHeadroom)
+	assert.Nil(t, err)
+	if assert.Len(t, results, 1) {
+		assert.Equal(t, health.Errored, results[0].Status)
+		assert.Contains(t, results[0].Message, target)
+	}
+}
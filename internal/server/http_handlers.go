@@ -49,19 +49,37 @@ func (caops *CaOps) backupHandler(w http.ResponseWriter, r *http.Request) {
 		table = val
 	}
 
+	// a backup taken while the nodes disagree on the schema may not be restorable
+	if err := caops.checkOperation(r.Context(), operationBackup); err != nil {
+		status := http.StatusInternalServerError
+		if _, ok := err.(*clusterCheckError); ok {
+			status = http.StatusConflict
+		}
+		caops.notifyBackupNotTriggered(keyspaceGlob, table, err)
+		http.Error(w, fmt.Sprintf("Error while triggering snapshot: %s", err), status)
+		return
+	}
 	timeMarker, err := caops.backup(keyspaceGlob, table)
 	if err != nil {
-		w.WriteHeader(http.StatusAccepted)
-		fmt.Fprintf(w, "Snapshot of %s.%s at %s was requested", keyspaceGlob, table, timeMarker.Format(time.RFC3339))
-	} else {
+		caops.notifyBackupNotTriggered(keyspaceGlob, table, err)
 		w.WriteHeader(http.StatusInternalServerError)
 		fmt.Fprintf(w, "Error while triggering snapshot: %s", err)
+	} else {
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprintf(w, "Snapshot of %s.%s at %s was requested", keyspaceGlob, table, timeMarker.Format(time.RFC3339))
 	}
 }
 
+// notifyBackupNotTriggered notifies the webhooks of a backup which failed before any node
+// took its snapshot
+func (caops *CaOps) notifyBackupNotTriggered(keyspaceGlob, table string, err error) {
+	caops.notify(eventBackupFailed, fmt.Sprintf("Snapshot of %s.%s was not triggered: %s", keyspaceGlob, table, err),
+		map[string]string{"keyspaces": keyspaceGlob, "table": table})
+}
+
 func (caops *CaOps) backup(keyspaceGlob, table string) (timeMarker time.Time, err error) {
 	// TODO make this time configurable or based on some existing metric (some soft of cluster thrift)
-	timeMarker = getNextRoundedTimeWithin(time.Now(), 15*time.Second)
+	timeMarker = getNextRoundedTimeWithin(caops.clock.Now(), 15*time.Second)
 	logrus.Infof("Backup of %s.%s requested for %s", keyspaceGlob, table, timeMarker.Format(time.RFC3339))
 	payload := &BackupPayload{KeyspaceGlob: keyspaceGlob, Table: table, TimeMarker: timeMarker}
 	err = caops.gossiper.SendEvent("backup", payload)
@@ -80,51 +98,23 @@ func (p *EmptyPayload) Encode() []byte {
 func (caops *CaOps) clearSnapshotHandler(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 
-	if caops.gossiper.SendEvent("clearsnapshot", &EmptyPayload{}) != nil {
-		w.WriteHeader(http.StatusAccepted)
-	} else {
+	if err := caops.gossiper.SendEvent("clearsnapshot", &EmptyPayload{}); err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "Error while triggering the clearing of snapshots: %s", err)
+	} else {
+		w.WriteHeader(http.StatusAccepted)
 	}
 }
 
 func (caops *CaOps) statusHandler(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 
-	w.WriteHeader(http.StatusOK)
-	jolokiaVersion, err := caops.cassMngr.JolokiaAgentVersion()
-	fmt.Fprintln(w, "Jolokia Agent Version:", jolokiaVersion, getErrStr(err))
-
-	version, err := caops.cassMngr.CassandraVersion()
-	fmt.Fprintln(w, "C* Version:", version, getErrStr(err))
-	schemaVersion, err := caops.cassMngr.SchemaVersion()
-	fmt.Fprintln(w, "C* Schema Version:", schemaVersion, getErrStr(err))
-	dataFileLocs, err := caops.cassMngr.AllDataFileLocations()
-	fmt.Fprintln(w, "C* Data File Locs:", strings.Join(dataFileLocs, ", "), getErrStr(err))
-	commitLogLoc, err := caops.cassMngr.CommitLogLocation()
-	fmt.Fprintln(w, "C* CommitLog Loc:", commitLogLoc, getErrStr(err))
-	savedCacheLoc, err := caops.cassMngr.SavedCachesLocation()
-	fmt.Fprintln(w, "C* Saved Caches Loc:", savedCacheLoc, getErrStr(err))
-	locHostID, err := caops.cassMngr.LocalHostID()
-	fmt.Fprintln(w, "C* Local Host ID:", locHostID, getErrStr(err))
-	partitionerName, err := caops.cassMngr.PartitionerName()
-	fmt.Fprintln(w, "C* Partitoner Name:", partitionerName, getErrStr(err))
-	opMode, err := caops.cassMngr.OperationMode()
-	fmt.Fprintln(w, "C* Operation Mode:", opMode, getErrStr(err))
-	incrBkpEnabled, err := caops.cassMngr.IncrementalBackupsEnabled()
-	fmt.Fprintln(w, "C* Incremental Backups Enabled:", incrBkpEnabled, getErrStr(err))
-
-	clusterName, err := caops.cassMngr.ClusterName()
-	fmt.Fprintln(w, "C* Cluster Name:", clusterName, getErrStr(err))
-	liveNodes, err := caops.cassMngr.LiveNodes()
-	fmt.Fprintln(w, "C* Cluster Live Nodes:", strings.Join(liveNodes, ", "), getErrStr(err))
-	joiningNodes, err := caops.cassMngr.JoiningNodes()
-	fmt.Fprintln(w, "C* Cluster Joining Nodes:", strings.Join(joiningNodes, ", "), getErrStr(err))
-	leavingNodes, err := caops.cassMngr.LeavingNodes()
-	fmt.Fprintln(w, "C* Cluster Leaving Nodes:", strings.Join(leavingNodes, ", "), getErrStr(err))
-	movingNodes, err := caops.cassMngr.MovingNodes()
-	fmt.Fprintln(w, "C* Cluster Moving Nodes:", strings.Join(movingNodes, ", "), getErrStr(err))
-	keyspaces, err := caops.cassMngr.Keyspaces()
-	fmt.Fprintln(w, "C* Keyspaces:", strings.Join(keyspaces, ", "), getErrStr(err))
-	nonSysKeyspaces, err := caops.cassMngr.NonSystemKeyspaces()
-	fmt.Fprintln(w, "C* Non-System Keyspaces:", strings.Join(nonSysKeyspaces, ", "), getErrStr(err))
+	status := caops.nodeStatus(r.Context())
+	if negotiateContentType(r, contentTypeJSON, contentTypeText) == contentTypeText {
+		w.Header().Set("Content-Type", contentTypeText+"; charset=utf-8")
+		w.WriteHeader(status.HTTPStatus())
+		status.WriteText(w)
+		return
+	}
+	writeJSON(w, status.HTTPStatus(), status)
 }
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/CrossEngage/CaOps/internal/cassandra/cassandratest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatusHandler(t *testing.T) {
+	caops, agent := newTestCaOps(t)
+	defer agent.Close()
+
+	w := httptest.NewRecorder()
+	caops.statusHandler(w, httptest.NewRequest("GET", "/status", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	status := &NodeStatus{}
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(status))
+	assert.Equal(t, StatusOK, status.Status)
+	assert.Equal(t, "Test Cluster", status.ClusterName.Value)
+	assert.Equal(t, []string{"127.0.0.1"}, status.LiveNodes.Value)
+
+	agent.SetAttribute(cassandratest.StorageService, "OperationMode", "JOINING")
+	r := httptest.NewRequest("GET", "/status", nil)
+	r.Header.Set("Accept", "text/plain")
+	w = httptest.NewRecorder()
+	caops.statusHandler(w, r)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), "Status: degraded")
+	assert.Contains(t, w.Body.String(), "C* Operation Mode: JOINING")
+}
+
+func TestStatusHandlerAgentDown(t *testing.T) {
+	caops, agent := newTestCaOps(t)
+	defer agent.Close()
+	agent.FailHTTP(http.StatusBadGateway)
+
+	w := httptest.NewRecorder()
+	caops.statusHandler(w, httptest.NewRequest("GET", "/status", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	status := &NodeStatus{}
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(status))
+	assert.Equal(t, StatusDown, status.Status)
+	assert.NotEmpty(t, status.ClusterName.Error)
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/CrossEngage/CaOps/internal/cassandra"
+	"github.com/Sirupsen/logrus"
+)
+
+const (
+	// progressPollInterval is how often the progress notifications are pulled from the agent
+	progressPollInterval = time.Second
+	// progressRetryDelay is how long to wait before registering again for the notifications
+	progressRetryDelay = 30 * time.Second
+	// progressRetention is how long the progress of the finished operations is kept
+	progressRetention = 24 * time.Hour
+)
+
+// progressTracker keeps the progress of the operations of the node, by tag, as reported by
+// its progress notifications
+type progressTracker struct {
+	mu         sync.Mutex
+	operations map[string]*cassandra.OperationProgress
+}
+
+func newProgressTracker() *progressTracker {
+	return &progressTracker{operations: make(map[string]*cassandra.OperationProgress)}
+}
+
+// apply updates the progress of the operation of the event, and forgets the operations which
+// finished before the retention
+func (t *progressTracker) apply(event cassandra.ProgressEvent, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	operation, ok := t.operations[event.Tag]
+	if !ok {
+		operation = &cassandra.OperationProgress{}
+		t.operations[event.Tag] = operation
+	}
+	operation.Apply(event)
+	for tag, operation := range t.operations {
+		if operation.Finished && now.Sub(operation.UpdatedAt) > progressRetention {
+			delete(t.operations, tag)
+		}
+	}
+}
+
+// get returns a copy of the progress of the operation, or nil if it is not known
+func (t *progressTracker) get(tag string) *cassandra.OperationProgress {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	operation, ok := t.operations[tag]
+	if !ok {
+		return nil
+	}
+	progress := *operation
+	progress.Errors = append([]string{}, operation.Errors...)
+	return &progress
+}
+
+// list returns copies of the progress of all the operations, the latest started first
+func (t *progressTracker) list() []*cassandra.OperationProgress {
+	t.mu.Lock()
+	tags := make([]string, 0, len(t.operations))
+	for tag := range t.operations {
+		tags = append(tags, tag)
+	}
+	t.mu.Unlock()
+	operations := make([]*cassandra.OperationProgress, 0, len(tags))
+	for _, tag := range tags {
+		if operation := t.get(tag); operation != nil {
+			operations = append(operations, operation)
+		}
+	}
+	sort.Slice(operations, func(i, j int) bool {
+		if !operations[i].StartedAt.Equal(operations[j].StartedAt) {
+			return operations[i].StartedAt.After(operations[j].StartedAt)
+		}
+		return operations[i].Tag < operations[j].Tag
+	})
+	return operations
+}
+
+// trackProgress follows the progress notifications of the node, until the agent is shut down.
+// Notifications are not supported through a Jolokia proxy, so they are not followed then.
+func (caops *CaOps) trackProgress() {
+	if target := caops.cassMngr.JMXTarget(); target != "" {
+		return
+	}
+	for {
+		events, err := caops.cassMngr.WatchProgress(caops.ctx, progressPollInterval)
+		if err != nil {
+			logrus.Warnf("Could not register for the progress notifications, retrying in %s: %s", progressRetryDelay, err)
+			select {
+			case <-caops.clock.After(progressRetryDelay):
+				continue
+			case <-caops.ctx.Done():
+				return
+			}
+		}
+		for event := range events {
+			logrus.Debugf("Progress of %s: %s %d/%d %s", event.Tag, event.Type, event.Progress, event.Total, event.Message)
+			caops.progress.apply(event, caops.clock.Now())
+		}
+		// the events are only closed once the agent is shut down
+		return
+	}
+}
+
+// progressHandler lists the progress of the operations of the node, like its repairs
+func (caops *CaOps) progressHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	if target := caops.cassMngr.JMXTarget(); target != "" {
+		http.Error(w, fmt.Sprintf("Not available for a node managed through the Jolokia proxy target %s, "+
+			"as the proxy does not forward notifications", target), http.StatusNotImplemented)
+		return
+	}
+	writeJSON(w, http.StatusOK, caops.progress.list())
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/CrossEngage/CaOps/internal/cassandra"
+	"github.com/CrossEngage/CaOps/internal/repair"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProgressHandler(t *testing.T) {
+	caops, agent := newTestCaOps(t)
+	defer agent.Close()
+	node := newRoutedNode(t, caops)
+	start := time.Date(2018, 1, 1, 12, 0, 0, 0, time.UTC)
+	node.CaOps.progress.apply(cassandra.ProgressEvent{Tag: "repair:1", Type: cassandra.ProgressStart, Time: start}, start)
+	node.CaOps.progress.apply(cassandra.ProgressEvent{Tag: "repair:2", Type: cassandra.ProgressStart, Total: 4, Time: start.Add(time.Hour)}, start)
+
+	w := node.Request("GET", "/progress")
+	assertStatus(t, http.StatusOK, w)
+	operations := make([]*cassandra.OperationProgress, 0)
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&operations))
+	if assert.Len(t, operations, 2) {
+		assert.Equal(t, "repair:2", operations[0].Tag)
+		assert.Equal(t, int64(4), operations[0].Total)
+		assert.Equal(t, "repair:1", operations[1].Tag)
+	}
+
+	// the finished operations are forgotten after the retention
+	node.CaOps.progress.apply(cassandra.ProgressEvent{Tag: "repair:1", Type: cassandra.ProgressComplete, Time: start}, start)
+	node.CaOps.progress.apply(cassandra.ProgressEvent{Tag: "repair:3", Type: cassandra.ProgressStart, Time: start}, start.Add(progressRetention+time.Second))
+	assert.Nil(t, node.CaOps.progress.get("repair:1"))
+	assert.NotNil(t, node.CaOps.progress.get("repair:2"))
+}
+
+func TestRepairStatusFromProgress(t *testing.T) {
+	caops, agent := newTestCaOps(t)
+	defer agent.Close()
+	ctx := context.Background()
+	now := time.Now()
+
+	// getParentRepairStatus is not implemented by the fake node, like on Cassandra 3
+	response := caops.repairStatus(ctx, 1)
+	assert.NotEmpty(t, response.Error)
+
+	caops.progress.apply(cassandra.ProgressEvent{Tag: "repair:1", Type: cassandra.ProgressStart, Message: "Starting", Time: now}, now)
+	assert.Equal(t, &repairQueryResponse{Status: repair.SegmentRunning, Message: "Starting"}, caops.repairStatus(ctx, 1))
+
+	caops.progress.apply(cassandra.ProgressEvent{Tag: "repair:1", Type: cassandra.ProgressSuccess, Time: now}, now)
+	caops.progress.apply(cassandra.ProgressEvent{Tag: "repair:1", Type: cassandra.ProgressComplete, Time: now}, now)
+	assert.Equal(t, &repairQueryResponse{Status: repair.SegmentSucceeded}, caops.repairStatus(ctx, 1))
+
+	caops.progress.apply(cassandra.ProgressEvent{Tag: "repair:2", Type: cassandra.ProgressError, Message: "Sync failed", Time: now}, now)
+	caops.progress.apply(cassandra.ProgressEvent{Tag: "repair:2", Type: cassandra.ProgressComplete, Time: now}, now)
+	assert.Equal(t, &repairQueryResponse{Status: repair.SegmentFailed, Message: "Sync failed"}, caops.repairStatus(ctx, 2))
+}
@@ -0,0 +1,376 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CrossEngage/CaOps/internal/cassandra"
+	"github.com/CrossEngage/CaOps/internal/rolling"
+	"github.com/Sirupsen/logrus"
+	"github.com/hashicorp/serf/serf"
+)
+
+const (
+	diskSpaceQueryName = "disk-space"
+	leaveQueryName     = "leave"
+)
+
+// The kinds of node removals
+const (
+	removalDecommission = "decommission"
+	removalRemoveNode   = "removenode"
+)
+
+// localKeyspaces are replicated by every node on its own, so they do not constrain removals
+var localKeyspaces = map[string]bool{"system": true, "system_schema": true}
+
+// RemoveNodeRequest is the body of the requests removing a dead node, given by its address
+type RemoveNodeRequest struct {
+	Address string `json:"address"`
+}
+
+// NodeRemoval follows the decommission of the node of this agent, or the removal of a dead
+// node coordinated by it, with the streams of the node and the removal status
+type NodeRemoval struct {
+	Kind          string              `json:"kind"`
+	Address       string              `json:"address"`
+	HostID        string              `json:"host_id"`
+	Status        rolling.JobStatus   `json:"status"`
+	Error         string              `json:"error,omitempty"`
+	Mode          string              `json:"mode,omitempty"`
+	RemovalStatus string              `json:"removal_status,omitempty"`
+	Streams       []*cassandra.Stream `json:"streams"`
+	StartedAt     time.Time           `json:"started_at"`
+	FinishedAt    time.Time           `json:"finished_at"`
+}
+
+// nodeRemovals keeps the last node removal run by this agent, which only runs one at a time
+type nodeRemovals struct {
+	mtx  sync.Mutex
+	last *NodeRemoval
+}
+
+// start records the removal, unless another one is running
+func (r *nodeRemovals) start(removal *NodeRemoval) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if r.last != nil && r.last.Status == rolling.JobRunning {
+		return fmt.Errorf("The %s of %s is running", r.last.Kind, r.last.Address)
+	}
+	r.last = removal
+	return nil
+}
+
+func (r *nodeRemovals) running() bool {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return r.last != nil && r.last.Status == rolling.JobRunning
+}
+
+func (r *nodeRemovals) update(f func(removal *NodeRemoval)) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	f(r.last)
+}
+
+// get returns a copy of the last removal, or nil if there was none
+func (r *nodeRemovals) get() *NodeRemoval {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if r.last == nil {
+		return nil
+	}
+	removal := *r.last
+	removal.Streams = append([]*cassandra.Stream{}, r.last.Streams...)
+	return &removal
+}
+
+// diskSpaceQueryHandler responds with the space available in the data directory of the node
+// with the least of it
+func (caops *CaOps) diskSpaceQueryHandler(query *serf.Query) ([]byte, error) {
+	ctx, cancel := context.WithDeadline(caops.ctx, query.Deadline())
+	defer cancel()
+	dirs, err := caops.cassMngr.AllDataFileLocations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var least uint64
+	for i, dir := range dirs {
+		free, err := caops.freeSpace(dir)
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 || free < least {
+			least = free
+		}
+	}
+	return []byte(strconv.FormatUint(least, 10)), nil
+}
+
+// leaveQueryHandler makes this agent leave the cluster, once it responded, as its node was
+// removed from the ring
+func (caops *CaOps) leaveQueryHandler(query *serf.Query) ([]byte, error) {
+	logrus.Info("Leaving the cluster, as the node was removed from the ring")
+	go func() {
+		if err := caops.gossiper.Leave(); err != nil {
+			logrus.Errorf("Could not leave the cluster: %s", err)
+		}
+	}()
+	return []byte{}, nil
+}
+
+// checkNodeRemoval checks that the cluster is stable but for the target, and agrees on the
+// schema, that every keyspace still has enough nodes for its replicas in each datacenter
+// without it, and that the other nodes of its datacenter have room for their share of its data
+func (caops *CaOps) checkNodeRemoval(ctx context.Context, target *cassandra.Endpoint, endpoints []*cassandra.Endpoint) error {
+	if err := caops.checkRollingJobs(""); err != nil {
+		return err
+	}
+	if err := caops.checkOperation(ctx, operationNodeRemoval, target.Address); err != nil {
+		return err
+	}
+
+	remaining := make(map[string]int)
+	remainingInDC := make([]string, 0)
+	for _, e := range endpoints {
+		if e.Address == target.Address {
+			continue
+		}
+		remaining[e.Datacenter]++
+		if e.Datacenter == target.Datacenter {
+			remainingInDC = append(remainingInDC, e.Address)
+		}
+	}
+	keyspaces, err := caops.cassMngr.Keyspaces(ctx)
+	if err != nil {
+		return err
+	}
+	for _, keyspace := range keyspaces {
+		if localKeyspaces[keyspace] {
+			continue
+		}
+		ranges, err := caops.cassMngr.DescribeRingJMX(ctx, keyspace)
+		if err != nil {
+			return fmt.Errorf("Could not describe the ring of %s: %s", keyspace, err)
+		}
+		for dc, replicas := range replicasByDatacenter(ranges) {
+			if remaining[dc] < replicas {
+				return &clusterCheckError{fmt.Errorf("Keyspace %s has %d replicas in %s, which would only have %d nodes left",
+					keyspace, replicas, dc, remaining[dc])}
+			}
+		}
+	}
+
+	load, err := cassandra.ParseDataSize(target.Load)
+	if err != nil {
+		return fmt.Errorf("Could not read the load of %s: %s", target.Address, err)
+	}
+	if len(remainingInDC) == 0 || load == 0 {
+		return nil
+	}
+	// the ranges of the node are assumed to be spread evenly over the other nodes of its datacenter
+	share := uint64(float64(load) / float64(len(remainingInDC)) * caops.rollingConfig.Cleanup.DiskHeadroom)
+	results, err := caops.gossiper.Query(diskSpaceQueryName, &EmptyPayload{}, rollingQueryTimeout)
+	if err != nil {
+		return err
+	}
+	for _, address := range remainingInDC {
+		response, ok := results.Responses[address]
+		if !ok {
+			return &clusterCheckError{fmt.Errorf("No response from the agent of %s, whose free space is unknown", address)}
+		}
+		free, err := strconv.ParseUint(string(response), 10, 64)
+		if err != nil {
+			return fmt.Errorf("Could not read the free space of %s: %s", address, response)
+		}
+		if free < share {
+			return &clusterCheckError{fmt.Errorf("Only %s are free on %s, which would get about %s of the data of %s",
+				formatMiB(free), address, formatMiB(share), target.Address)}
+		}
+	}
+	return nil
+}
+
+// replicasByDatacenter returns the number of replicas of the ranges in each datacenter
+func replicasByDatacenter(ranges []cassandra.TokenRange) map[string]int {
+	replicas := make(map[string]int)
+	for _, r := range ranges {
+		inRange := make(map[string]int)
+		for _, details := range r.EndpointDetails {
+			inRange[details.Datacenter]++
+		}
+		for dc, count := range inRange {
+			if count > replicas[dc] {
+				replicas[dc] = count
+			}
+		}
+	}
+	return replicas
+}
+
+// runNodeRemoval runs the removal, following the streams of the node until it is finished,
+// and then makes the agent of the removed node leave the cluster
+func (caops *CaOps) runNodeRemoval(removal *NodeRemoval, remove func(ctx context.Context) error, leave func() error) {
+	done := make(chan error, 1)
+	go func() {
+		done <- remove(caops.ctx)
+	}()
+	var err error
+	for running := true; running; {
+		select {
+		case err = <-done:
+			running = false
+		case <-caops.clock.After(caops.rollingConfig.PollInterval):
+			caops.followNodeRemoval(removal.Kind)
+		}
+	}
+	if err == nil {
+		err = leave()
+	}
+
+	caops.removals.update(func(removal *NodeRemoval) {
+		removal.FinishedAt = caops.clock.Now()
+		removal.Streams = []*cassandra.Stream{}
+		if err != nil {
+			removal.Status, removal.Error = rolling.JobFailed, err.Error()
+			return
+		}
+		removal.Status = rolling.JobSucceeded
+	})
+	if err != nil {
+		logrus.Errorf("The %s of %s failed: %s", removal.Kind, removal.Address, err)
+		return
+	}
+	logrus.Infof("The %s of %s succeeded", removal.Kind, removal.Address)
+}
+
+// followNodeRemoval records the operation mode and the streams of the node, and the status of
+// the removal it coordinates
+func (caops *CaOps) followNodeRemoval(kind string) {
+	stats, err := caops.cassMngr.NetStats(caops.ctx)
+	if err != nil {
+		logrus.Warnf("Could not follow the streams of the %s: %s", kind, err)
+		return
+	}
+	status := ""
+	if kind == removalRemoveNode {
+		if status, err = caops.cassMngr.RemovalStatus(caops.ctx); err != nil {
+			logrus.Warnf("Could not read the status of the %s: %s", kind, err)
+		}
+	}
+	caops.removals.update(func(removal *NodeRemoval) {
+		removal.Mode, removal.Streams, removal.RemovalStatus = stats.Mode, stats.Streams, status
+	})
+}
+
+// startNodeRemoval checks and starts the removal of the target, responding with it
+func (caops *CaOps) startNodeRemoval(w http.ResponseWriter, r *http.Request, kind, address string) {
+	if caops.removals.running() {
+		http.Error(w, "Another node removal is running on this agent", http.StatusConflict)
+		return
+	}
+	endpoints, err := caops.cassMngr.Endpoints(r.Context(), "")
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error while reading the nodes: %s", err), http.StatusInternalServerError)
+		return
+	}
+	var target *cassandra.Endpoint
+	for _, e := range endpoints {
+		if e.Address == address {
+			target = e
+		}
+	}
+	switch {
+	case target == nil:
+		http.Error(w, fmt.Sprintf("Node %s is not in the ring", address), http.StatusNotFound)
+		return
+	case kind == removalRemoveNode && target.Status != cassandra.EndpointDown:
+		http.Error(w, fmt.Sprintf("Node %s is up, and must be decommissioned instead", address), http.StatusConflict)
+		return
+	}
+	if err := caops.checkNodeRemoval(r.Context(), target, endpoints); err != nil {
+		status := http.StatusInternalServerError
+		if _, ok := err.(*clusterCheckError); ok {
+			status = http.StatusConflict
+		}
+		http.Error(w, fmt.Sprintf("Error while checking the %s of %s: %s", kind, address, err), status)
+		return
+	}
+
+	removal := &NodeRemoval{Kind: kind, Address: address, HostID: target.HostID, Status: rolling.JobRunning,
+		Streams: []*cassandra.Stream{}, StartedAt: caops.clock.Now()}
+	if err := caops.removals.start(removal); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	logrus.Infof("The %s of %s was requested", kind, address)
+	if kind == removalDecommission {
+		go caops.runNodeRemoval(removal, caops.cassMngr.Decommission, caops.gossiper.Leave)
+	} else {
+		go caops.runNodeRemoval(removal, func(ctx context.Context) error {
+			return caops.cassMngr.RemoveNode(ctx, target.HostID)
+		}, func() error {
+			return caops.removeAgent(address)
+		})
+	}
+	writeJSON(w, http.StatusAccepted, caops.removals.get())
+}
+
+// removeAgent makes the agent of the removed node leave the cluster, or makes the cluster
+// forget it if it failed
+func (caops *CaOps) removeAgent(address string) error {
+	for _, member := range caops.gossiper.AliveMembers() {
+		if member == address {
+			_, err := caops.gossiper.QueryNode(leaveQueryName, address, &EmptyPayload{}, rollingQueryTimeout)
+			return err
+		}
+	}
+	if err := caops.gossiper.RemoveFailedMember(address); err != nil {
+		// the node may have had no agent, or its agent was already forgotten
+		logrus.Warnf("Could not remove the agent of %s from the cluster: %s", address, err)
+	}
+	return nil
+}
+
+// decommissionHandler decommissions the node of this agent
+func (caops *CaOps) decommissionHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	caops.startNodeRemoval(w, r, removalDecommission, caops.gossiper.LocalAddr())
+}
+
+// removeNodeHandler removes a dead node from the ring, with this node coordinating the removal
+func (caops *CaOps) removeNodeHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	request := &RemoveNodeRequest{}
+	if err := json.NewDecoder(r.Body).Decode(request); err != nil && err != io.EOF {
+		http.Error(w, fmt.Sprintf("Invalid removenode request: %s", err), http.StatusBadRequest)
+		return
+	}
+	request.Address = strings.TrimSpace(request.Address)
+	if request.Address == "" {
+		http.Error(w, "The address of the node to remove must be given", http.StatusBadRequest)
+		return
+	}
+	if request.Address == caops.gossiper.LocalAddr() {
+		http.Error(w, "The node of this agent must be decommissioned instead", http.StatusConflict)
+		return
+	}
+	caops.startNodeRemoval(w, r, removalRemoveNode, request.Address)
+}
+
+func (caops *CaOps) nodeRemovalHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	removal := caops.removals.get()
+	if removal == nil {
+		http.Error(w, "No node removal was run by this agent", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, removal)
+}
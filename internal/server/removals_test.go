@@ -0,0 +1,139 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/CrossEngage/CaOps/internal/cassandra/cassandratest"
+	"github.com/CrossEngage/CaOps/internal/rolling"
+	"github.com/stretchr/testify/assert"
+)
+
+// setRemovalCluster sets up the ring of the nodes, each with a load of 1 GiB and the free
+// space given, or 10 GiB, and a host ID
+func setRemovalCluster(h *harness, free map[string]uint64) {
+	setRollingCluster(h, "")
+	setRepairRing(h)
+	load, hostIDs := make(map[string]string), make(map[string]string)
+	for i, node := range h.Nodes {
+		load[node.IP] = "1.000 GiB"
+		hostIDs[node.IP] = fmt.Sprintf("00000000-0000-0000-0000-00000000000%d", i+1)
+	}
+	for _, node := range h.Nodes {
+		node := node
+		node.Agent.SetAttribute(cassandratest.StorageService, "LoadMap", load)
+		node.Agent.SetAttribute(cassandratest.StorageService, "EndpointToHostId", hostIDs)
+		node.CaOps.freeSpace = func(path string) (uint64, error) {
+			if space, ok := free[node.IP]; ok {
+				return space, nil
+			}
+			return 10 << 30, nil
+		}
+	}
+}
+
+// waitNodeRemoval advances the clock until the removal run by the node is finished, and
+// returns it
+func waitNodeRemoval(h *harness, node *harnessNode) *NodeRemoval {
+	h.eventually("the node removal is finished", func() bool {
+		h.Clock.Advance(DefaultRollingPollInterval)
+		return !node.CaOps.removals.running()
+	})
+	w := node.Request("GET", "/node-removal")
+	assertStatus(h.t, http.StatusOK, w)
+	removal := &NodeRemoval{}
+	assert.Nil(h.t, json.NewDecoder(w.Body).Decode(removal))
+	return removal
+}
+
+// waitAgentLeft waits until the other agents no longer see the agent of the node
+func waitAgentLeft(h *harness, left *harnessNode) {
+	h.eventually("the agent of "+left.IP+" left the cluster", func() bool {
+		for _, node := range h.Nodes {
+			if node != left && len(node.CaOps.gossiper.AliveMembers()) != len(h.Nodes)-1 {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+func TestDecommission(t *testing.T) {
+	h := newHarness(t, 3)
+	defer h.Close()
+	setRemovalCluster(h, nil)
+	leaving := h.Nodes[2]
+	leaving.Agent.SetOperation(cassandratest.StorageService, "decommission", func(args []interface{}) (interface{}, error) {
+		leaving.Agent.SetAttribute(cassandratest.StorageService, "OperationMode", "DECOMMISSIONED")
+		return nil, nil
+	})
+
+	w := leaving.Request("POST", "/decommission")
+	assertStatus(t, http.StatusAccepted, w)
+	removal := &NodeRemoval{}
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(removal))
+	assert.Equal(t, removalDecommission, removal.Kind)
+	assert.Equal(t, leaving.IP, removal.Address)
+	assert.Equal(t, "00000000-0000-0000-0000-000000000003", removal.HostID)
+
+	removal = waitNodeRemoval(h, leaving)
+	assert.Equal(t, rolling.JobSucceeded, removal.Status, removal.Error)
+	assert.Len(t, leaving.Agent.Executed(cassandratest.StorageService, "decommission"), 1)
+	waitAgentLeft(h, leaving)
+}
+
+func TestDecommissionChecks(t *testing.T) {
+	h := newHarness(t, 3)
+	defer h.Close()
+	setRemovalCluster(h, map[string]uint64{"127.0.0.2": 400 << 20})
+	leaving := h.Nodes[2]
+
+	// the other nodes of the datacenter each get about 600 MiB, with the headroom
+	w := leaving.Request("POST", "/decommission")
+	assertStatus(t, http.StatusConflict, w)
+	assert.Contains(t, w.Body.String(), "Only 400.0 MiB are free on 127.0.0.2, which would get about 614.4 MiB of the data of 127.0.0.3")
+
+	// every node replicates every range
+	ring := []string{"TokenRange(start_token:0, end_token:100, endpoints:[127.0.0.1, 127.0.0.2, 127.0.0.3], rpc_endpoints:[], " +
+		"endpoint_details:[EndpointDetails(host:127.0.0.1, datacenter:dc1, rack:r1), EndpointDetails(host:127.0.0.2, datacenter:dc1, rack:r1), " +
+		"EndpointDetails(host:127.0.0.3, datacenter:dc1, rack:r1)])"}
+	leaving.Agent.SetOperation(cassandratest.StorageService, "describeRingJMX", func(args []interface{}) (interface{}, error) {
+		return ring, nil
+	})
+	w = leaving.Request("POST", "/decommission")
+	assertStatus(t, http.StatusConflict, w)
+	assert.Contains(t, w.Body.String(), "Keyspace ks1 has 3 replicas in dc1, which would only have 2 nodes left")
+
+	leaving.Agent.SetAttribute(cassandratest.StorageService, "MovingNodes", []string{"127.0.0.1"})
+	assertStatus(t, http.StatusConflict, leaving.Request("POST", "/decommission"))
+	assert.Nil(t, leaving.CaOps.removals.get())
+}
+
+func TestRemoveNode(t *testing.T) {
+	h := newHarness(t, 3)
+	defer h.Close()
+	setRemovalCluster(h, nil)
+	coordinator, dead := h.Nodes[0], h.Nodes[2]
+	coordinator.Agent.SetAttribute(cassandratest.StorageService, "LiveNodes", []string{coordinator.IP, h.Nodes[1].IP})
+	coordinator.Agent.SetAttribute(cassandratest.StorageService, "UnreachableNodes", []string{dead.IP})
+	coordinator.Agent.SetAttribute(cassandratest.StorageService, "RemovalStatus", "No token removals in process.")
+	coordinator.Agent.SetOperation(cassandratest.StorageService, "removeNode", func(args []interface{}) (interface{}, error) {
+		return nil, nil
+	})
+
+	assertStatus(t, http.StatusBadRequest, coordinator.RequestWithBody("POST", "/removenode", `{}`))
+	assertStatus(t, http.StatusNotFound, coordinator.RequestWithBody("POST", "/removenode", `{"address": "127.0.0.9"}`))
+	assertStatus(t, http.StatusConflict, coordinator.RequestWithBody("POST", "/removenode", `{"address": "127.0.0.2"}`))
+	assertStatus(t, http.StatusConflict, coordinator.RequestWithBody("POST", "/removenode", `{"address": "127.0.0.1"}`))
+
+	w := coordinator.RequestWithBody("POST", "/removenode", `{"address": "`+dead.IP+`"}`)
+	assertStatus(t, http.StatusAccepted, w)
+	removal := waitNodeRemoval(h, coordinator)
+	assert.Equal(t, rolling.JobSucceeded, removal.Status, removal.Error)
+	assert.Equal(t, [][]interface{}{{"00000000-0000-0000-0000-000000000003"}},
+		coordinator.Agent.Executed(cassandratest.StorageService, "removeNode"))
+	// the agent of the removed node was still alive, so it was told to leave
+	waitAgentLeft(h, dead)
+}
@@ -0,0 +1,516 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/CrossEngage/CaOps/internal/cassandra"
+	"github.com/CrossEngage/CaOps/internal/repair"
+	"github.com/Sirupsen/logrus"
+	"github.com/gorilla/mux"
+	"github.com/hashicorp/serf/serf"
+)
+
+const (
+	repairStartQueryName  = "repair-start"
+	repairStatusQueryName = "repair-status"
+	repairStateQueryName  = "repair-state"
+	repairQueryTimeout    = 10 * time.Second
+	// repairScheduleCheck is how often the agents check the repairs of the others, and the
+	// scheduler whether a repair is due
+	repairScheduleCheck = time.Minute
+)
+
+// RepairConfig holds the settings of the repair orchestrator, which keeps its jobs in the
+// state file, next to the gossip snapshot by default. When the schedule interval is set,
+// the keyspaces, or all the non-system ones, are repaired at that interval, by the agent
+// with the lowest IP, so only one of them schedules the repairs. The limits count the
+// segments repaired by the orchestrators of all the agents.
+type RepairConfig struct {
+	StatePath         string
+	Orchestrator      repair.Config
+	ScheduleInterval  time.Duration
+	ScheduleKeyspaces []string
+	ScheduleOptions   repair.Options
+}
+
+// RepairRequest is the body of the requests starting a repair job. Without keyspaces, all
+// the non-system ones are repaired.
+type RepairRequest struct {
+	Keyspaces []string `json:"keyspaces"`
+	repair.Options
+}
+
+// RepairJobResponse describes a repair job, with its progress
+type RepairJobResponse struct {
+	*repair.Job
+	Progress repair.Progress `json:"progress"`
+}
+
+func newRepairJobResponse(job *repair.Job) *RepairJobResponse {
+	return &RepairJobResponse{Job: job, Progress: job.Progress()}
+}
+
+// repairQuery is the payload of the queries starting a repair command on its coordinator,
+// or polling its status
+type repairQuery struct {
+	Keyspace string            `json:"keyspace,omitempty"`
+	Options  map[string]string `json:"options,omitempty"`
+	Command  int               `json:"command,omitempty"`
+}
+
+// Encode ...
+func (q *repairQuery) Encode() []byte {
+	buf, _ := json.Marshal(q)
+	return buf
+}
+
+type repairQueryResponse struct {
+	Command int                  `json:"command,omitempty"`
+	Status  repair.SegmentStatus `json:"status,omitempty"`
+	Message string               `json:"message,omitempty"`
+	Error   string               `json:"error,omitempty"`
+}
+
+// repairState is the response to the repair-state queries, telling the segments repaired by
+// the orchestrator of an agent, when its last scheduled job was created, its unfinished jobs,
+// without their segments, and the ids of all its jobs
+type repairState struct {
+	Running          []*repair.Segment `json:"running"`
+	LastScheduled    time.Time         `json:"last_scheduled"`
+	ScheduledRunning bool              `json:"scheduled_running"`
+	Unfinished       []*repair.Job     `json:"unfinished"`
+	JobIDs           []string          `json:"job_ids"`
+}
+
+// enableRepairs builds the repair orchestrator, resuming the jobs of its state file
+func (caops *CaOps) enableRepairs(config RepairConfig, gossipSnapshotPath string) error {
+	if config.StatePath == "" {
+		config.StatePath = filepath.Join(filepath.Dir(gossipSnapshotPath), "repairs.json")
+	}
+	store, err := repair.NewStore(config.StatePath)
+	if err != nil {
+		return err
+	}
+	runner := &gossipRepairRunner{caops: caops}
+	orchestrator, err := repair.NewOrchestrator(runner, store, config.Orchestrator)
+	if err != nil {
+		return err
+	}
+	orchestrator.SetClock(func() time.Time { return caops.clock.Now() })
+	orchestrator.SetPeers(runner)
+	caops.repairs = orchestrator
+	caops.repairConfig = config
+	caops.repairPeers = make(map[string]*repairState)
+	return nil
+}
+
+// gossipRepairRunner runs the repair commands on their coordinators through gossip queries,
+// as each agent only talks to its own Cassandra node, and asks the other agents for the
+// segments they repair
+type gossipRepairRunner struct {
+	caops *CaOps
+}
+
+func (r *gossipRepairRunner) query(name, coordinator string, query *repairQuery) (*repairQueryResponse, error) {
+	payload, err := r.caops.gossiper.QueryNode(name, coordinator, query, repairQueryTimeout)
+	if err != nil {
+		return nil, err
+	}
+	response := &repairQueryResponse{}
+	if err := json.Unmarshal(payload, response); err != nil {
+		return nil, err
+	}
+	if response.Error != "" {
+		return nil, fmt.Errorf("%s", response.Error)
+	}
+	return response, nil
+}
+
+func (r *gossipRepairRunner) StartRepair(ctx context.Context, coordinator, keyspace string, options map[string]string) (int, error) {
+	response, err := r.query(repairStartQueryName, coordinator, &repairQuery{Keyspace: keyspace, Options: options})
+	if err != nil {
+		return 0, err
+	}
+	return response.Command, nil
+}
+
+func (r *gossipRepairRunner) RepairStatus(ctx context.Context, coordinator string, command int) (repair.SegmentStatus, string, error) {
+	response, err := r.query(repairStatusQueryName, coordinator, &repairQuery{Command: command})
+	if err != nil {
+		return "", "", err
+	}
+	return response.Status, response.Message, nil
+}
+
+func (r *gossipRepairRunner) RunningSegments(ctx context.Context) ([]*repair.Segment, error) {
+	states, err := r.caops.repairStates()
+	if err != nil {
+		return nil, err
+	}
+	segments := make([]*repair.Segment, 0)
+	for _, state := range states {
+		segments = append(segments, state.Running...)
+	}
+	return segments, nil
+}
+
+// repairStates returns the repair states of the other agents, keyed by their IP, failing
+// unless all the alive ones responded
+func (caops *CaOps) repairStates() (map[string]*repairState, error) {
+	results, err := caops.gossiper.Query(repairStateQueryName, &EmptyPayload{}, repairQueryTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if len(results.Missing) > 0 {
+		return nil, fmt.Errorf("No response from the agents of %s", strings.Join(results.Missing, ", "))
+	}
+	states := make(map[string]*repairState, len(results.Responses))
+	for ip, response := range results.Responses {
+		if ip == caops.gossiper.LocalAddr() {
+			continue
+		}
+		state := &repairState{}
+		if err := json.Unmarshal(response, state); err != nil {
+			return nil, fmt.Errorf("Invalid repair state from the agent of %s: %s", ip, err)
+		}
+		states[ip] = state
+	}
+	return states, nil
+}
+
+func (caops *CaOps) repairStateQueryHandler(query *serf.Query) ([]byte, error) {
+	state := &repairState{Running: make([]*repair.Segment, 0), Unfinished: make([]*repair.Job, 0), JobIDs: make([]string, 0)}
+	if caops.repairs != nil {
+		state.Running = caops.repairs.RunningSegments()
+		state.LastScheduled, state.ScheduledRunning = caops.repairs.LastScheduled()
+		for _, job := range caops.repairs.Jobs() {
+			state.JobIDs = append(state.JobIDs, job.ID)
+			if !job.Finished() {
+				job.Segments = nil
+				state.Unfinished = append(state.Unfinished, job)
+			}
+		}
+	}
+	return json.Marshal(state)
+}
+
+func (caops *CaOps) repairStartQueryHandler(query *serf.Query) ([]byte, error) {
+	ctx, cancel := context.WithDeadline(caops.ctx, query.Deadline())
+	defer cancel()
+	q := &repairQuery{}
+	if err := json.Unmarshal(query.Payload, q); err != nil {
+		return nil, err
+	}
+	logrus.Infof("Starting the repair of %s, with %v", q.Keyspace, q.Options)
+	response := &repairQueryResponse{}
+	if command, err := caops.cassMngr.RepairAsync(ctx, q.Keyspace, q.Options); err != nil {
+		response.Error = err.Error()
+	} else {
+		response.Command = command
+	}
+	return json.Marshal(response)
+}
+
+func (caops *CaOps) repairStatusQueryHandler(query *serf.Query) ([]byte, error) {
+	ctx, cancel := context.WithDeadline(caops.ctx, query.Deadline())
+	defer cancel()
+	q := &repairQuery{}
+	if err := json.Unmarshal(query.Payload, q); err != nil {
+		return nil, err
+	}
+	return json.Marshal(caops.repairStatus(ctx, q.Command))
+}
+
+// repairStatus returns the status of a repair command of the node. Nodes older than Cassandra
+// 4.0 can not tell it, so it is then taken from the progress notifications of the command.
+func (caops *CaOps) repairStatus(ctx context.Context, command int) *repairQueryResponse {
+	response := &repairQueryResponse{}
+	status, messages, err := caops.cassMngr.ParentRepairStatus(ctx, command)
+	if err != nil {
+		progress := caops.progress.get(fmt.Sprintf("repair:%d", command))
+		switch {
+		case progress == nil:
+			response.Error = err.Error()
+		case progress.Finished && progress.Failed:
+			response.Status = repair.SegmentFailed
+			response.Message = strings.Join(progress.Errors, "; ")
+		case progress.Finished:
+			response.Status = repair.SegmentSucceeded
+		default:
+			response.Status = repair.SegmentRunning
+			response.Message = progress.Message
+		}
+		return response
+	}
+	switch status {
+	case "IN_PROGRESS":
+		response.Status = repair.SegmentRunning
+	case "COMPLETED":
+		response.Status = repair.SegmentSucceeded
+	case "FAILED":
+		response.Status = repair.SegmentFailed
+		response.Message = strings.Join(messages, "; ")
+	default:
+		response.Status = repair.SegmentFailed
+		response.Message = fmt.Sprintf("Repair command %d is unknown to the node, which may have restarted", command)
+	}
+	return response
+}
+
+// planRepair builds a job repairing the keyspaces, or all the non-system ones, split along
+// the ring of each of them, once the health checks of the repairs passed
+func (caops *CaOps) planRepair(ctx context.Context, keyspaces []string, options repair.Options) (*repair.Job, error) {
+	if err := caops.checkOperation(ctx, operationRepair); err != nil {
+		return nil, err
+	}
+	if len(keyspaces) == 0 {
+		var err error
+		if keyspaces, err = caops.cassMngr.NonSystemKeyspaces(ctx); err != nil {
+			return nil, err
+		}
+	} else {
+		existing, err := caops.cassMngr.Keyspaces(ctx)
+		if err != nil {
+			return nil, err
+		}
+		known := stringListToMapKeys(existing)
+		for _, keyspace := range keyspaces {
+			if !known[keyspace] {
+				return nil, &keyspaceNotFoundError{keyspace}
+			}
+		}
+	}
+	partitioner, err := caops.cassMngr.PartitionerName(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rings := make(map[string][]cassandra.TokenRange, len(keyspaces))
+	for _, keyspace := range keyspaces {
+		ranges, err := caops.cassMngr.DescribeRingJMX(ctx, keyspace)
+		if err != nil {
+			return nil, fmt.Errorf("Could not describe the ring of %s: %s", keyspace, err)
+		}
+		rings[keyspace] = ranges
+	}
+	return repair.NewJob(caops.newJobID(), partitioner, rings, options, caops.clock.Now())
+}
+
+// keyspaceNotFoundError is a keyspace to repair which does not exist
+type keyspaceNotFoundError struct {
+	keyspace string
+}
+
+func (e *keyspaceNotFoundError) Error() string {
+	return fmt.Sprintf("Keyspace %s does not exist", e.keyspace)
+}
+
+// newJobID returns the id of a new job, made of its creation time and a random suffix
+func (caops *CaOps) newJobID() string {
+	return fmt.Sprintf("%s-%04x", caops.clock.Now().UTC().Format("20060102-150405"), rand.Intn(0x10000))
+}
+
+// coordinateRepairs checks the repairs of the other agents every minute, so the scheduler
+// starts a repair job whenever the last scheduled one is older than the schedule interval,
+// and plans again the jobs of the agents which failed
+func (caops *CaOps) coordinateRepairs() {
+	for {
+		select {
+		case <-caops.clock.After(repairScheduleCheck):
+			caops.checkRepairs()
+		case <-caops.ctx.Done():
+			return
+		}
+	}
+}
+
+// isScheduler returns whether this agent has the lowest IP of the cluster, which makes it the
+// one starting the scheduled jobs
+func (caops *CaOps) isScheduler() bool {
+	members := caops.gossiper.AliveMembers()
+	sort.Strings(members)
+	return len(members) > 0 && members[0] == caops.gossiper.LocalAddr()
+}
+
+// checkRepairs keeps the repair states of the other agents, even once they failed, then
+// cancels the jobs also held by an agent with a lower IP. The scheduler then adopts the jobs
+// of the failed agents, and schedules a repair if one is due.
+func (caops *CaOps) checkRepairs() {
+	states, err := caops.repairStates()
+	if err != nil {
+		logrus.Warnf("Could not check the repairs of the other agents: %s", err)
+		return
+	}
+	for ip, state := range states {
+		caops.repairPeers[ip] = state
+	}
+	caops.cancelDuplicateRepairs(states)
+	if !caops.isScheduler() {
+		return
+	}
+	caops.adoptRepairs(states)
+	caops.scheduleRepairIfDue(states)
+}
+
+// cancelDuplicateRepairs cancels the unfinished jobs which an agent with a lower IP also
+// holds, like the jobs adopted while their agent was thought to have failed
+func (caops *CaOps) cancelDuplicateRepairs(states map[string]*repairState) {
+	for _, job := range caops.repairs.Jobs() {
+		if job.Finished() {
+			continue
+		}
+		for ip, state := range states {
+			if ip < caops.gossiper.LocalAddr() && stringListToMapKeys(state.JobIDs)[job.ID] {
+				logrus.Warnf("Cancelling the repair job %s, which the agent of %s also holds", job.ID, ip)
+				if err := caops.repairs.Cancel(job.ID); err != nil {
+					logrus.Errorf("Could not cancel the repair job %s: %s", job.ID, err)
+				}
+				break
+			}
+		}
+	}
+}
+
+// adoptRepairs plans again, with the same ids, the unfinished jobs of the agents which failed,
+// unless an alive agent holds them. Their segments are all repaired again, as the progress of
+// the failed agents is not known.
+func (caops *CaOps) adoptRepairs(states map[string]*repairState) {
+	held := make(map[string]bool)
+	for _, job := range caops.repairs.Jobs() {
+		held[job.ID] = true
+	}
+	for _, state := range states {
+		for _, id := range state.JobIDs {
+			held[id] = true
+		}
+	}
+	for ip, failed := range caops.repairPeers {
+		if _, ok := states[ip]; ok {
+			continue
+		}
+		left := make([]*repair.Job, 0)
+		for _, orphan := range failed.Unfinished {
+			if held[orphan.ID] {
+				continue
+			}
+			job, err := caops.planRepair(caops.ctx, orphan.Keyspaces, orphan.Options)
+			if err == nil {
+				job.ID, job.Scheduled, job.CreatedAt = orphan.ID, orphan.Scheduled, orphan.CreatedAt
+				err = caops.repairs.Submit(job)
+			}
+			if err != nil {
+				logrus.Errorf("Could not adopt the repair job %s of the failed agent of %s: %s", orphan.ID, ip, err)
+				left = append(left, orphan)
+				continue
+			}
+			held[orphan.ID] = true
+			logrus.Infof("Adopted the repair job %s of the failed agent of %s, in %d segments", job.ID, ip, len(job.Segments))
+		}
+		failed.Unfinished = left
+	}
+}
+
+// scheduleRepairIfDue starts a repair job, unless a scheduled one is running on an alive agent,
+// or the last one, even of a failed agent, is more recent than the schedule interval
+func (caops *CaOps) scheduleRepairIfDue(states map[string]*repairState) {
+	if caops.repairConfig.ScheduleInterval <= 0 {
+		return
+	}
+	lastScheduled, running := caops.repairs.LastScheduled()
+	for ip, state := range caops.repairPeers {
+		if state.LastScheduled.After(lastScheduled) {
+			lastScheduled = state.LastScheduled
+		}
+		if _, alive := states[ip]; alive {
+			running = running || state.ScheduledRunning
+		}
+	}
+	if running || caops.clock.Now().Sub(lastScheduled) < caops.repairConfig.ScheduleInterval {
+		return
+	}
+	job, err := caops.planRepair(caops.ctx, caops.repairConfig.ScheduleKeyspaces, caops.repairConfig.ScheduleOptions)
+	if err != nil {
+		logrus.Errorf("Could not plan the scheduled repair: %s", err)
+		return
+	}
+	job.Scheduled = true
+	if err := caops.repairs.Submit(job); err != nil {
+		logrus.Errorf("Could not start the scheduled repair: %s", err)
+		return
+	}
+	logrus.Infof("Scheduled repair job %s of %s, in %d segments", job.ID, strings.Join(job.Keyspaces, ", "), len(job.Segments))
+}
+
+func (caops *CaOps) startRepairHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	request := &RepairRequest{}
+	if err := json.NewDecoder(r.Body).Decode(request); err != nil && err != io.EOF {
+		http.Error(w, fmt.Sprintf("Invalid repair request: %s", err), http.StatusBadRequest)
+		return
+	}
+	job, err := caops.planRepair(r.Context(), request.Keyspaces, request.Options)
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch err.(type) {
+		case *clusterCheckError:
+			status = http.StatusConflict
+		case *repair.PlanError:
+			status = http.StatusBadRequest
+		case *keyspaceNotFoundError:
+			status = http.StatusNotFound
+		}
+		http.Error(w, fmt.Sprintf("Error while planning the repair: %s", err), status)
+		return
+	}
+	if err := caops.repairs.Submit(job); err != nil {
+		http.Error(w, fmt.Sprintf("Error while starting the repair: %s", err), http.StatusInternalServerError)
+		return
+	}
+	logrus.Infof("Repair job %s of %s requested, in %d segments", job.ID, strings.Join(job.Keyspaces, ", "), len(job.Segments))
+	// the job is now updated by the orchestrator, so a copy is responded
+	writeJSON(w, http.StatusCreated, newRepairJobResponse(caops.repairs.Job(job.ID)))
+}
+
+func (caops *CaOps) listRepairsHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	jobs := make([]*RepairJobResponse, 0)
+	for _, job := range caops.repairs.Jobs() {
+		response := newRepairJobResponse(job)
+		response.Segments = nil
+		jobs = append(jobs, response)
+	}
+	writeJSON(w, http.StatusOK, jobs)
+}
+
+func (caops *CaOps) repairHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	id := mux.Vars(r)["id"]
+	job := caops.repairs.Job(id)
+	if job == nil {
+		http.Error(w, fmt.Sprintf("Repair job %s does not exist", id), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, newRepairJobResponse(job))
+}
+
+func (caops *CaOps) cancelRepairHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	id := mux.Vars(r)["id"]
+	if caops.repairs.Job(id) == nil {
+		http.Error(w, fmt.Sprintf("Repair job %s does not exist", id), http.StatusNotFound)
+		return
+	}
+	if err := caops.repairs.Cancel(id); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	writeJSON(w, http.StatusOK, newRepairJobResponse(caops.repairs.Job(id)))
+}
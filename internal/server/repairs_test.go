@@ -0,0 +1,184 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/CrossEngage/CaOps/internal/cassandra/cassandratest"
+	"github.com/CrossEngage/CaOps/internal/repair"
+	"github.com/stretchr/testify/assert"
+)
+
+// setRepairRing sets up a ring of three ranges replicated twice on the nodes, whose repair
+// commands complete once they are polled
+func setRepairRing(h *harness) {
+	ring := make([]string, 0, len(h.Nodes))
+	for i, node := range h.Nodes {
+		next := h.Nodes[(i+1)%len(h.Nodes)]
+		ring = append(ring, fmt.Sprintf("TokenRange(start_token:%d, end_token:%d, endpoints:[%s, %s], rpc_endpoints:[%s, %s], "+
+			"endpoint_details:[EndpointDetails(host:%s, datacenter:dc1, rack:r1), EndpointDetails(host:%s, datacenter:dc1, rack:r1)])",
+			i*100, ((i+1)%len(h.Nodes))*100, node.IP, next.IP, node.IP, next.IP, node.IP, next.IP))
+	}
+	for _, node := range h.Nodes {
+		node.Agent.AddTable("ks1", "users")
+		node.Agent.SetOperation(cassandratest.StorageService, "describeRingJMX", func(args []interface{}) (interface{}, error) {
+			return ring, nil
+		})
+		var mu sync.Mutex
+		commands := 0
+		node.Agent.SetOperation(cassandratest.StorageService, "repairAsync", func(args []interface{}) (interface{}, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			commands++
+			return commands, nil
+		})
+		node.Agent.SetOperation(cassandratest.StorageService, "getParentRepairStatus", func(args []interface{}) (interface{}, error) {
+			return []string{"COMPLETED", "Repair completed successfully"}, nil
+		})
+	}
+}
+
+func TestRepairAcrossAgents(t *testing.T) {
+	h := newHarness(t, 3)
+	defer h.Close()
+	setRepairRing(h)
+	orchestrator := h.Nodes[0]
+
+	w := orchestrator.RequestWithBody("POST", "/repairs", `{"keyspaces": ["ks1"], "segments_per_range": 2}`)
+	assertStatus(t, http.StatusCreated, w)
+	job := &RepairJobResponse{}
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(job))
+	assert.Equal(t, repair.Progress{Total: 6, Pending: 6}, job.Progress)
+
+	// each segment has a replica in common with the others, so they are repaired one by one,
+	// the repair of each segment completing by the next step
+	for i := 0; i < 7; i++ {
+		orchestrator.CaOps.repairs.Step(context.Background())
+	}
+	w = orchestrator.Request("GET", "/repairs/"+job.ID)
+	assertStatus(t, http.StatusOK, w)
+	job = &RepairJobResponse{}
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(job))
+	assert.Equal(t, repair.JobSucceeded, job.Status)
+	assert.Equal(t, repair.Progress{Total: 6, Succeeded: 6}, job.Progress)
+
+	// every segment was repaired by its first replica
+	for i, node := range h.Nodes {
+		ranges := make([]string, 0)
+		for _, args := range node.Agent.Executed(cassandratest.StorageService, "repairAsync") {
+			options := args[1].(map[string]interface{})
+			ranges = append(ranges, fmt.Sprint(args[0], " ", options["ranges"]))
+		}
+		if assert.Len(t, ranges, 2, "node %s", node.IP) {
+			assert.True(t, strings.HasPrefix(ranges[0], fmt.Sprintf("ks1 %d:", i*100)), ranges[0])
+			assert.True(t, strings.HasSuffix(ranges[1], fmt.Sprintf(":%d", ((i+1)%len(h.Nodes))*100)), ranges[1])
+		}
+	}
+
+	w = orchestrator.Request("GET", "/repairs")
+	assertStatus(t, http.StatusOK, w)
+	var jobs []map[string]interface{}
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&jobs))
+	if assert.Len(t, jobs, 1) {
+		assert.Equal(t, job.ID, jobs[0]["id"])
+		assert.NotContains(t, jobs[0], "segments")
+	}
+	assertStatus(t, http.StatusConflict, orchestrator.Request("DELETE", "/repairs/"+job.ID))
+	assertStatus(t, http.StatusNotFound, orchestrator.Request("DELETE", "/repairs/unknown"))
+	assertStatus(t, http.StatusNotFound, orchestrator.Request("GET", "/repairs/unknown"))
+	assertStatus(t, http.StatusBadRequest, orchestrator.RequestWithBody("POST", "/repairs", "{"))
+	assertStatus(t, http.StatusNotFound, orchestrator.RequestWithBody("POST", "/repairs", `{"keyspaces": ["unknown"]}`))
+}
+
+func TestRepairWithoutReplicas(t *testing.T) {
+	h := newHarness(t, 1)
+	defer h.Close()
+	node := h.Nodes[0]
+	// ks2 is only replicated to a datacenter without nodes, so its ranges have no endpoints
+	node.Agent.AddTable("ks2", "users")
+	node.Agent.SetOperation(cassandratest.StorageService, "describeRingJMX", func(args []interface{}) (interface{}, error) {
+		return []string{"TokenRange(start_token:0, end_token:100, endpoints:[], rpc_endpoints:[], endpoint_details:[])"}, nil
+	})
+	w := node.RequestWithBody("POST", "/repairs", `{"keyspaces": ["ks2"]}`)
+	assertStatus(t, http.StatusBadRequest, w)
+	assert.Contains(t, w.Body.String(), "The range (0, 100] of ks2 has no replicas")
+	assert.Empty(t, node.CaOps.repairs.Jobs())
+}
+
+func TestRepairLimitsAcrossAgents(t *testing.T) {
+	h := newHarness(t, 3)
+	defer h.Close()
+	setRepairRing(h)
+	first, second := h.Nodes[0], h.Nodes[1]
+	assertStatus(t, http.StatusCreated, first.RequestWithBody("POST", "/repairs", `{"keyspaces": ["ks1"]}`))
+	assertStatus(t, http.StatusCreated, second.RequestWithBody("POST", "/repairs", `{"keyspaces": ["ks1"]}`))
+
+	// every segment has a replica in common with the others, so the second agent waits for
+	// the segment repaired by the first one
+	first.CaOps.repairs.Step(context.Background())
+	second.CaOps.repairs.Step(context.Background())
+	repaired := 0
+	for _, node := range h.Nodes {
+		repaired += len(node.Agent.Executed(cassandratest.StorageService, "repairAsync"))
+	}
+	assert.Equal(t, 1, repaired)
+	assert.Len(t, first.CaOps.repairs.RunningSegments(), 1)
+	assert.Empty(t, second.CaOps.repairs.RunningSegments())
+	assert.Equal(t, repair.Progress{Total: 3, Pending: 3}, second.CaOps.repairs.Jobs()[0].Progress())
+}
+
+func TestRepairScheduleAcrossAgents(t *testing.T) {
+	h := newHarness(t, 3)
+	defer h.Close()
+	setRepairRing(h)
+	scheduler, failing, other := h.Nodes[0], h.Nodes[1], h.Nodes[2]
+	scheduler.CaOps.repairConfig.ScheduleInterval = 24 * time.Hour
+
+	// the scheduled job of another agent, like a former scheduler, is not scheduled again
+	job, err := failing.CaOps.planRepair(context.Background(), []string{"ks1"}, repair.Options{})
+	assert.Nil(t, err)
+	job.Scheduled = true
+	assert.Nil(t, failing.CaOps.repairs.Submit(job))
+	for _, node := range h.Nodes {
+		node.CaOps.checkRepairs()
+	}
+	assert.Empty(t, scheduler.CaOps.repairs.Jobs())
+
+	// once its agent failed, the job is planned again by the scheduler, with the same id
+	killNode(h, failing)
+	scheduler.CaOps.checkRepairs()
+	adopted := scheduler.CaOps.repairs.Job(job.ID)
+	if assert.NotNil(t, adopted) {
+		assert.True(t, adopted.Scheduled)
+		assert.Equal(t, job.CreatedAt.Unix(), adopted.CreatedAt.Unix())
+		assert.Equal(t, repair.Progress{Total: 3, Pending: 3}, adopted.Progress())
+	}
+	assert.Len(t, scheduler.CaOps.repairs.Jobs(), 1)
+
+	// an agent with a higher IP holding the same job cancels its copy
+	duplicate := job.Copy()
+	assert.Nil(t, other.CaOps.repairs.Submit(duplicate))
+	other.CaOps.checkRepairs()
+	assert.Equal(t, repair.JobCancelled, other.CaOps.repairs.Job(job.ID).Status)
+	scheduler.CaOps.checkRepairs()
+	assert.Equal(t, repair.JobRunning, scheduler.CaOps.repairs.Job(job.ID).Status)
+
+	// the next job is scheduled once the interval passed since the adopted one
+	assert.Nil(t, scheduler.CaOps.repairs.Cancel(job.ID))
+	h.Clock.Advance(23 * time.Hour)
+	scheduler.CaOps.checkRepairs()
+	assert.Len(t, scheduler.CaOps.repairs.Jobs(), 1)
+	h.Clock.Advance(2 * time.Hour)
+	scheduler.CaOps.checkRepairs()
+	jobs := scheduler.CaOps.repairs.Jobs()
+	if assert.Len(t, jobs, 2) {
+		assert.True(t, jobs[1].Scheduled)
+		assert.NotEqual(t, job.ID, jobs[1].ID)
+	}
+}
@@ -0,0 +1,402 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CrossEngage/CaOps/internal/cassandra"
+	"github.com/Sirupsen/logrus"
+	"github.com/gorilla/mux"
+)
+
+// Default settings of the replacements of dead nodes
+const (
+	DefaultDeadThreshold         = 30 * time.Minute
+	DefaultDeadNodeCheckInterval = time.Minute
+	DefaultBootstrapTimeout      = 24 * time.Hour
+)
+
+// ReplacementStatus is the status of the replacement of a dead node
+type ReplacementStatus string
+
+// Statuses of a replacement: it is open until an operator confirms the replacement node was
+// started with the JVM options of the plan, and then bootstrapping until the replacement node
+// owns the tokens of the dead one
+const (
+	ReplacementOpen          ReplacementStatus = "open"
+	ReplacementBootstrapping ReplacementStatus = "bootstrapping"
+	ReplacementCompleted     ReplacementStatus = "completed"
+	ReplacementFailed        ReplacementStatus = "failed"
+	ReplacementCancelled     ReplacementStatus = "cancelled"
+)
+
+// ReplaceConfig holds the settings of the replacements of dead nodes. A replacement is opened
+// for the nodes whose agent failed and which are unreachable for longer than the dead
+// threshold, as checked at the check interval by the agent with the lowest IP. It fails if the
+// replacement node does not own the tokens of the dead one within the bootstrap timeout.
+type ReplaceConfig struct {
+	DeadThreshold    time.Duration
+	CheckInterval    time.Duration
+	BootstrapTimeout time.Duration
+}
+
+func (config ReplaceConfig) withDefaults() ReplaceConfig {
+	if config.DeadThreshold == 0 {
+		config.DeadThreshold = DefaultDeadThreshold
+	}
+	if config.CheckInterval == 0 {
+		config.CheckInterval = DefaultDeadNodeCheckInterval
+	}
+	if config.BootstrapTimeout == 0 {
+		config.BootstrapTimeout = DefaultBootstrapTimeout
+	}
+	return config
+}
+
+// ReplacementRequest is the body of the requests opening the replacement of a dead node
+type ReplacementRequest struct {
+	Address string `json:"address"`
+}
+
+// Replacement is the plan replacing a dead node by a new one, which takes over its tokens
+// when started with the JVM options, and then follows the bootstrap of the new node
+type Replacement struct {
+	DeadAddress        string            `json:"dead_address"`
+	HostID             string            `json:"host_id"`
+	Datacenter         string            `json:"datacenter"`
+	Rack               string            `json:"rack"`
+	Tokens             []string          `json:"tokens"`
+	JVMOptions         []string          `json:"jvm_options"`
+	Instructions       []string          `json:"instructions"`
+	Status             ReplacementStatus `json:"status"`
+	Error              string            `json:"error,omitempty"`
+	ReplacementAddress string            `json:"replacement_address,omitempty"`
+	OpenedAt           time.Time         `json:"opened_at"`
+	ConfirmedAt        time.Time         `json:"confirmed_at"`
+	FinishedAt         time.Time         `json:"finished_at"`
+}
+
+func (r *Replacement) finished() bool {
+	return r.Status != ReplacementOpen && r.Status != ReplacementBootstrapping
+}
+
+// replacements keeps the replacements opened by this agent, by the address of the dead node,
+// and since when the dead nodes were seen dead
+type replacements struct {
+	mtx       sync.Mutex
+	byAddress map[string]*Replacement
+	deadSince map[string]time.Time
+}
+
+func newReplacements() *replacements {
+	return &replacements{byAddress: make(map[string]*Replacement), deadSince: make(map[string]time.Time)}
+}
+
+// open records the replacement, unless one of the same node is not finished yet
+func (r *replacements) open(replacement *Replacement) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if existing, ok := r.byAddress[replacement.DeadAddress]; ok && !existing.finished() {
+		return fmt.Errorf("The replacement of %s is %s", existing.DeadAddress, existing.Status)
+	}
+	r.byAddress[replacement.DeadAddress] = replacement
+	return nil
+}
+
+// update runs the function on the replacement of the node, if any, returning a copy of it
+func (r *replacements) update(address string, f func(replacement *Replacement) error) (*Replacement, error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	replacement, ok := r.byAddress[address]
+	if !ok {
+		return nil, nil
+	}
+	if err := f(replacement); err != nil {
+		return nil, err
+	}
+	return copyReplacement(replacement), nil
+}
+
+// get returns a copy of the replacement of the node, or nil if there is none
+func (r *replacements) get(address string) *Replacement {
+	replacement, _ := r.update(address, func(*Replacement) error { return nil })
+	return replacement
+}
+
+// list returns copies of the replacements, sorted by the address of the dead node
+func (r *replacements) list() []*Replacement {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	list := make([]*Replacement, 0, len(r.byAddress))
+	for _, replacement := range r.byAddress {
+		list = append(list, copyReplacement(replacement))
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].DeadAddress < list[j].DeadAddress })
+	return list
+}
+
+func copyReplacement(replacement *Replacement) *Replacement {
+	c := *replacement
+	c.Tokens = append([]string{}, replacement.Tokens...)
+	c.JVMOptions = append([]string{}, replacement.JVMOptions...)
+	c.Instructions = append([]string{}, replacement.Instructions...)
+	return &c
+}
+
+// seenDead records the nodes seen dead now, returning the ones seen dead since the threshold
+func (r *replacements) seenDead(dead []string, now time.Time, threshold time.Duration) []string {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	current := stringListToMapKeys(dead)
+	for address := range r.deadSince {
+		if !current[address] {
+			delete(r.deadSince, address)
+		}
+	}
+	expired := make([]string, 0)
+	for _, address := range dead {
+		since, ok := r.deadSince[address]
+		if !ok {
+			r.deadSince[address] = now
+			continue
+		}
+		if now.Sub(since) >= threshold {
+			expired = append(expired, address)
+		}
+	}
+	sort.Strings(expired)
+	return expired
+}
+
+// planReplacement plans the replacement of the dead node, from its host ID, tokens and
+// location
+func (caops *CaOps) planReplacement(ctx context.Context, address string) (*Replacement, error) {
+	endpoints, err := caops.cassMngr.Endpoints(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	var dead *cassandra.Endpoint
+	for _, e := range endpoints {
+		if e.Address == address {
+			dead = e
+		}
+	}
+	if dead == nil {
+		return nil, &clusterCheckError{fmt.Errorf("Node %s is not in the ring", address)}
+	}
+	if dead.Status != cassandra.EndpointDown {
+		return nil, &clusterCheckError{fmt.Errorf("Node %s is up", address)}
+	}
+	option := "-Dcassandra.replace_address_first_boot=" + address
+	tokens := append([]string{}, dead.Tokens...)
+	sort.Strings(tokens)
+	return &Replacement{
+		DeadAddress: address,
+		HostID:      dead.HostID,
+		Datacenter:  dead.Datacenter,
+		Rack:        dead.Rack,
+		Tokens:      tokens,
+		JVMOptions:  []string{option},
+		Instructions: []string{
+			fmt.Sprintf("Install the same Cassandra version on the replacement node, in datacenter %s and rack %s, "+
+				"with num_tokens set to %d", dead.Datacenter, dead.Rack, len(dead.Tokens)),
+			"Do not list the replacement node as a seed, and leave auto_bootstrap enabled",
+			fmt.Sprintf("Add %s to the JVM options of the replacement node, like in cassandra-env.sh, and start it", option),
+			fmt.Sprintf("Confirm the replacement with POST /replacements/%s/confirm, to follow its bootstrap", address),
+			"Remove the JVM option once the replacement is completed",
+		},
+		Status:   ReplacementOpen,
+		OpenedAt: caops.clock.Now(),
+	}, nil
+}
+
+// openReplacement plans the replacement of the dead node and records it
+func (caops *CaOps) openReplacement(ctx context.Context, address string) (*Replacement, error) {
+	replacement, err := caops.planReplacement(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+	if err := caops.replacements.open(replacement); err != nil {
+		return nil, &clusterCheckError{err}
+	}
+	logrus.Warnf("Opened the replacement of the dead node %s, which must be confirmed once the replacement node "+
+		"is started with %s", address, strings.Join(replacement.JVMOptions, " "))
+	return copyReplacement(replacement), nil
+}
+
+// watchDeadNodes opens the replacements of the dead nodes, and follows the confirmed ones
+func (caops *CaOps) watchDeadNodes() {
+	for {
+		select {
+		case <-caops.clock.After(caops.replaceConfig.CheckInterval):
+			caops.checkDeadNodes()
+			caops.followReplacements()
+		case <-caops.ctx.Done():
+			return
+		}
+	}
+}
+
+// checkDeadNodes opens the replacements of the nodes whose agent failed, and which Cassandra
+// sees unreachable, since the dead threshold, if this agent has the lowest IP of the cluster
+func (caops *CaOps) checkDeadNodes() {
+	if !caops.isScheduler() {
+		return
+	}
+	unreachable, err := caops.cassMngr.UnreachableNodes(caops.ctx)
+	if err != nil {
+		logrus.Warnf("Could not read the unreachable nodes: %s", err)
+		return
+	}
+	failed := stringListToMapKeys(caops.gossiper.FailedMembers())
+	dead := make([]string, 0)
+	for _, address := range unreachable {
+		if failed[address] {
+			dead = append(dead, address)
+		}
+	}
+	for _, address := range caops.replacements.seenDead(dead, caops.clock.Now(), caops.replaceConfig.DeadThreshold) {
+		if caops.replacements.get(address) != nil {
+			continue
+		}
+		if _, err := caops.openReplacement(caops.ctx, address); err != nil {
+			logrus.Errorf("Could not open the replacement of the dead node %s: %s", address, err)
+		}
+	}
+}
+
+// followReplacements completes the confirmed replacements once a node which is up and normal
+// owns all the tokens of the dead node, or fails them after the bootstrap timeout
+func (caops *CaOps) followReplacements() {
+	bootstrapping := make([]*Replacement, 0)
+	for _, replacement := range caops.replacements.list() {
+		if replacement.Status == ReplacementBootstrapping {
+			bootstrapping = append(bootstrapping, replacement)
+		}
+	}
+	if len(bootstrapping) == 0 {
+		return
+	}
+	endpoints, err := caops.cassMngr.Endpoints(caops.ctx, "")
+	if err != nil {
+		logrus.Warnf("Could not follow the replacements: %s", err)
+		return
+	}
+	owners := make(map[string]*cassandra.Endpoint)
+	for _, e := range endpoints {
+		for _, token := range e.Tokens {
+			owners[token] = e
+		}
+	}
+
+	now := caops.clock.Now()
+	for _, replacement := range bootstrapping {
+		var owner *cassandra.Endpoint
+		for _, token := range replacement.Tokens {
+			if o := owners[token]; owner == nil || o == owner {
+				owner = o
+				continue
+			}
+			owner = nil
+			break
+		}
+		caops.replacements.update(replacement.DeadAddress, func(r *Replacement) error {
+			switch {
+			case owner != nil && owner.Status == cassandra.EndpointUp && owner.State == cassandra.EndpointNormal:
+				r.Status, r.ReplacementAddress, r.FinishedAt = ReplacementCompleted, owner.Address, now
+				logrus.Infof("The dead node %s was replaced by %s", r.DeadAddress, owner.Address)
+			case now.Sub(r.ConfirmedAt) > caops.replaceConfig.BootstrapTimeout:
+				r.Status, r.FinishedAt = ReplacementFailed, now
+				r.Error = fmt.Sprintf("No node owned the tokens of %s within %s", r.DeadAddress, caops.replaceConfig.BootstrapTimeout)
+				logrus.Errorf("The replacement of %s failed: %s", r.DeadAddress, r.Error)
+			}
+			return nil
+		})
+	}
+}
+
+func (caops *CaOps) listReplacementsHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	writeJSON(w, http.StatusOK, caops.replacements.list())
+}
+
+// openReplacementHandler opens the replacement of a dead node, without waiting for the dead
+// threshold
+func (caops *CaOps) openReplacementHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	request := &ReplacementRequest{}
+	if err := json.NewDecoder(r.Body).Decode(request); err != nil && err != io.EOF {
+		http.Error(w, fmt.Sprintf("Invalid replacement request: %s", err), http.StatusBadRequest)
+		return
+	}
+	if request.Address == "" {
+		http.Error(w, "The address of the dead node must be given", http.StatusBadRequest)
+		return
+	}
+	replacement, err := caops.openReplacement(r.Context(), request.Address)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if _, ok := err.(*clusterCheckError); ok {
+			status = http.StatusConflict
+		}
+		http.Error(w, fmt.Sprintf("Error while opening the replacement of %s: %s", request.Address, err), status)
+		return
+	}
+	writeJSON(w, http.StatusCreated, replacement)
+}
+
+func (caops *CaOps) replacementHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	address := mux.Vars(r)["address"]
+	replacement := caops.replacements.get(address)
+	if replacement == nil {
+		http.Error(w, fmt.Sprintf("No replacement of %s was opened by this agent", address), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, replacement)
+}
+
+// updateReplacement changes the status of the open replacement, responding with it
+func (caops *CaOps) updateReplacement(w http.ResponseWriter, r *http.Request, status ReplacementStatus) {
+	defer r.Body.Close()
+	address := mux.Vars(r)["address"]
+	now := caops.clock.Now()
+	replacement, err := caops.replacements.update(address, func(replacement *Replacement) error {
+		if replacement.Status != ReplacementOpen && !(status == ReplacementCancelled && !replacement.finished()) {
+			return fmt.Errorf("The replacement of %s is %s", address, replacement.Status)
+		}
+		replacement.Status = status
+		if status == ReplacementBootstrapping {
+			replacement.ConfirmedAt = now
+		} else {
+			replacement.FinishedAt = now
+		}
+		return nil
+	})
+	switch {
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusConflict)
+	case replacement == nil:
+		http.Error(w, fmt.Sprintf("No replacement of %s was opened by this agent", address), http.StatusNotFound)
+	default:
+		logrus.Infof("The replacement of %s is %s", address, status)
+		writeJSON(w, http.StatusOK, replacement)
+	}
+}
+
+// confirmReplacementHandler confirms the replacement node was started with the JVM options of
+// the plan, so its bootstrap is followed
+func (caops *CaOps) confirmReplacementHandler(w http.ResponseWriter, r *http.Request) {
+	caops.updateReplacement(w, r, ReplacementBootstrapping)
+}
+
+func (caops *CaOps) cancelReplacementHandler(w http.ResponseWriter, r *http.Request) {
+	caops.updateReplacement(w, r, ReplacementCancelled)
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/CrossEngage/CaOps/internal/cassandra/cassandratest"
+	"github.com/stretchr/testify/assert"
+)
+
+// killNode stops the agent of the node without leaving the cluster, like when its host dies,
+// and makes the Cassandra node of every other agent see it unreachable
+func killNode(h *harness, dead *harnessNode) {
+	assert.Nil(h.t, dead.CaOps.gossiper.serf.Shutdown())
+	h.eventually("the agent of "+dead.IP+" failed", func() bool {
+		for _, node := range h.Nodes {
+			if node != dead && len(node.CaOps.gossiper.FailedMembers()) != 1 {
+				return false
+			}
+		}
+		return true
+	})
+	live := make([]string, 0)
+	for _, node := range h.Nodes {
+		if node != dead {
+			live = append(live, node.IP)
+		}
+	}
+	for _, node := range h.Nodes {
+		node.Agent.SetAttribute(cassandratest.StorageService, "LiveNodes", live)
+		node.Agent.SetAttribute(cassandratest.StorageService, "UnreachableNodes", []string{dead.IP})
+	}
+}
+
+func TestReplaceDeadNode(t *testing.T) {
+	h := newHarness(t, 3)
+	defer h.Close()
+	setRemovalCluster(h, nil)
+	scheduler, dead := h.Nodes[0], h.Nodes[2]
+	killNode(h, dead)
+
+	// the replacement is only opened once the node is dead since the threshold
+	scheduler.CaOps.checkDeadNodes()
+	h.Clock.Advance(DefaultDeadThreshold - time.Minute)
+	scheduler.CaOps.checkDeadNodes()
+	assert.Empty(t, scheduler.CaOps.replacements.list())
+	h.Clock.Advance(time.Minute)
+	scheduler.CaOps.checkDeadNodes()
+	h.Nodes[1].CaOps.checkDeadNodes()
+	assert.Empty(t, h.Nodes[1].CaOps.replacements.list())
+
+	w := scheduler.Request("GET", "/replacements")
+	assertStatus(t, http.StatusOK, w)
+	var replacements []*Replacement
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&replacements))
+	if !assert.Len(t, replacements, 1) {
+		return
+	}
+	replacement := replacements[0]
+	assert.Equal(t, ReplacementOpen, replacement.Status)
+	assert.Equal(t, dead.IP, replacement.DeadAddress)
+	assert.Equal(t, "00000000-0000-0000-0000-000000000003", replacement.HostID)
+	assert.Equal(t, "dc1", replacement.Datacenter)
+	assert.Equal(t, "r1", replacement.Rack)
+	assert.Equal(t, []string{"200"}, replacement.Tokens)
+	assert.Equal(t, []string{"-Dcassandra.replace_address_first_boot=" + dead.IP}, replacement.JVMOptions)
+	assert.NotEmpty(t, replacement.Instructions)
+
+	// the bootstrap is only followed once confirmed
+	assertStatus(t, http.StatusConflict, scheduler.RequestWithBody("POST", "/replacements", `{"address": "`+dead.IP+`"}`))
+	assertStatus(t, http.StatusOK, scheduler.Request("POST", "/replacements/"+dead.IP+"/confirm"))
+	assertStatus(t, http.StatusConflict, scheduler.Request("POST", "/replacements/"+dead.IP+"/confirm"))
+
+	// the replacement node bootstraps, before taking over the tokens of the dead node
+	for _, node := range h.Nodes {
+		node.Agent.SetAttribute(cassandratest.StorageService, "LiveNodes", []string{"127.0.0.1", "127.0.0.2", "127.0.0.9"})
+		node.Agent.SetAttribute(cassandratest.StorageService, "JoiningNodes", []string{"127.0.0.9"})
+	}
+	scheduler.CaOps.followReplacements()
+	assert.Equal(t, ReplacementBootstrapping, scheduler.CaOps.replacements.get(dead.IP).Status)
+	for _, node := range h.Nodes {
+		node.Agent.SetAttribute(cassandratest.StorageService, "TokenToEndpointMap",
+			map[string]string{"0": "127.0.0.1", "100": "127.0.0.2", "200": "127.0.0.9"})
+		node.Agent.SetAttribute(cassandratest.StorageService, "JoiningNodes", []string{})
+		node.Agent.SetAttribute(cassandratest.StorageService, "UnreachableNodes", []string{})
+	}
+	scheduler.CaOps.followReplacements()
+
+	w = scheduler.Request("GET", "/replacements/"+dead.IP)
+	assertStatus(t, http.StatusOK, w)
+	replacement = &Replacement{}
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(replacement))
+	assert.Equal(t, ReplacementCompleted, replacement.Status)
+	assert.Equal(t, "127.0.0.9", replacement.ReplacementAddress)
+	assertStatus(t, http.StatusConflict, scheduler.Request("DELETE", "/replacements/"+dead.IP))
+	assertStatus(t, http.StatusNotFound, scheduler.Request("GET", "/replacements/127.0.0.8"))
+}
+
+func TestReplacementChecks(t *testing.T) {
+	h := newHarness(t, 3)
+	defer h.Close()
+	setRemovalCluster(h, nil)
+	node := h.Nodes[0]
+
+	assertStatus(t, http.StatusBadRequest, node.RequestWithBody("POST", "/replacements", `{}`))
+	assertStatus(t, http.StatusConflict, node.RequestWithBody("POST", "/replacements", `{"address": "127.0.0.8"}`))
+	assertStatus(t, http.StatusConflict, node.RequestWithBody("POST", "/replacements", `{"address": "127.0.0.3"}`))
+
+	// a replacement can be opened by hand, and cancelled, once the node is down
+	for _, n := range h.Nodes {
+		n.Agent.SetAttribute(cassandratest.StorageService, "LiveNodes", []string{"127.0.0.1", "127.0.0.2"})
+	}
+	assertStatus(t, http.StatusCreated, node.RequestWithBody("POST", "/replacements", `{"address": "127.0.0.3"}`))
+	assertStatus(t, http.StatusOK, node.Request("POST", "/replacements/127.0.0.3/confirm"))
+	w := node.Request("DELETE", "/replacements/127.0.0.3")
+	assertStatus(t, http.StatusOK, w)
+	replacement := &Replacement{}
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(replacement))
+	assert.Equal(t, ReplacementCancelled, replacement.Status)
+	assertStatus(t, http.StatusNotFound, node.Request("POST", "/replacements/127.0.0.8/confirm"))
+
+	// the bootstrap fails when no node owns the tokens within the timeout
+	assertStatus(t, http.StatusCreated, node.RequestWithBody("POST", "/replacements", `{"address": "127.0.0.3"}`))
+	assertStatus(t, http.StatusOK, node.Request("POST", "/replacements/127.0.0.3/confirm"))
+	h.Clock.Advance(DefaultBootstrapTimeout + time.Minute)
+	node.CaOps.followReplacements()
+	replacement = node.CaOps.replacements.get("127.0.0.3")
+	assert.Equal(t, ReplacementFailed, replacement.Status)
+	assert.NotEmpty(t, replacement.Error)
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// ReplicasResponse lists the replicas of a partition key
+type ReplicasResponse struct {
+	Keyspace  string   `json:"keyspace"`
+	Table     string   `json:"table"`
+	Key       string   `json:"key"`
+	Endpoints []string `json:"endpoints"`
+}
+
+// ringHandler describes the ring of a keyspace: its ranges with their replicas, the pending
+// ranges, and the effective ownership of the nodes
+func (caops *CaOps) ringHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	keyspace := mux.Vars(r)["keyspace"]
+
+	tables, err := caops.cassMngr.Tables(r.Context(), keyspace)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error while reading the tables of %s: %s", keyspace, err), http.StatusInternalServerError)
+		return
+	}
+	if _, ok := tables[keyspace]; !ok {
+		http.Error(w, fmt.Sprintf("Keyspace %s does not exist", keyspace), http.StatusNotFound)
+		return
+	}
+
+	description, err := caops.cassMngr.DescribeRing(r.Context(), keyspace)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error while describing the ring of %s: %s", keyspace, err), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, description)
+}
+
+// endpointsHandler lists the replicas of the partition key given by the key parameter, which
+// is written as CQL would print it, with the components of composite keys separated by colons
+func (caops *CaOps) endpointsHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	vars := mux.Vars(r)
+	keyspace, table := vars["keyspace"], vars["table"]
+	key, ok := r.URL.Query()["key"]
+	if !ok || len(key) != 1 {
+		http.Error(w, "A single partition key must be given with the key parameter", http.StatusBadRequest)
+		return
+	}
+
+	tables, err := caops.cassMngr.Tables(r.Context(), keyspace)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error while reading the tables of %s: %s", keyspace, err), http.StatusInternalServerError)
+		return
+	}
+	if !stringListToMapKeys(tables[keyspace])[table] {
+		http.Error(w, fmt.Sprintf("Table %s.%s does not exist", keyspace, table), http.StatusNotFound)
+		return
+	}
+
+	endpoints, err := caops.cassMngr.NaturalEndpoints(r.Context(), keyspace, table, key[0])
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error while locating the replicas of '%s': %s", key[0], err), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, &ReplicasResponse{Keyspace: keyspace, Table: table, Key: key[0], Endpoints: endpoints})
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/CrossEngage/CaOps/internal/cassandra/cassandratest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEndpointsHandler(t *testing.T) {
+	caops, agent := newTestCaOps(t)
+	defer agent.Close()
+	agent.AddTable("ks1", "users")
+	agent.SetOperation(cassandratest.StorageService, "getNaturalEndpoints", func(args []interface{}) (interface{}, error) {
+		return []string{"/127.0.0.1"}, nil
+	})
+	node := newRoutedNode(t, caops)
+
+	w := node.Request("GET", "/keyspaces/ks1/tables/users/endpoints?key=42")
+	assertStatus(t, http.StatusOK, w)
+	replicas := &ReplicasResponse{}
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(replicas))
+	assert.Equal(t, &ReplicasResponse{Keyspace: "ks1", Table: "users", Key: "42", Endpoints: []string{"127.0.0.1"}}, replicas)
+
+	assertStatus(t, http.StatusBadRequest, node.Request("GET", "/keyspaces/ks1/tables/users/endpoints"))
+	assertStatus(t, http.StatusNotFound, node.Request("GET", "/keyspaces/ks1/tables/orders/endpoints?key=42"))
+	assertStatus(t, http.StatusNotFound, node.Request("GET", "/keyspaces/ks2/tables/users/endpoints?key=42"))
+}
+
+func TestRingHandler(t *testing.T) {
+	caops, agent := newTestCaOps(t)
+	defer agent.Close()
+	agent.AddTable("ks1", "users")
+	ss := cassandratest.StorageService
+	agent.SetOperation(ss, "describeRingJMX", func(args []interface{}) (interface{}, error) {
+		return []string{"TokenRange(start_token:0, end_token:0, endpoints:[127.0.0.1], rpc_endpoints:[127.0.0.1], endpoint_details:[])"}, nil
+	})
+	agent.SetOperation(ss, "getPendingRangeToEndpointMap", func(args []interface{}) (interface{}, error) {
+		return map[string][]string{}, nil
+	})
+	agent.SetOperation(ss, "effectiveOwnership", func(args []interface{}) (interface{}, error) {
+		return map[string]float64{"/127.0.0.1": 1}, nil
+	})
+	node := newRoutedNode(t, caops)
+
+	w := node.Request("GET", "/ring/ks1")
+	assertStatus(t, http.StatusOK, w)
+	var description map[string]interface{}
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&description))
+	assert.Equal(t, "ks1", description["keyspace"])
+	assert.Len(t, description["ranges"], 1)
+	assert.Equal(t, map[string]interface{}{"127.0.0.1": 1.0}, description["ownership"])
+
+	assertStatus(t, http.StatusNotFound, node.Request("GET", "/ring/ks2"))
+}
+
+// newRoutedNode wraps the server with its routes, to call its HTTP API
+func newRoutedNode(t *testing.T, caops *CaOps) *harnessNode {
+	routed, err := newCaOps(APIConfig{}, caops.cassMngr, nil)
+	assert.Nil(t, err)
+	return &harnessNode{CaOps: routed}
+}
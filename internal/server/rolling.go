@@ -0,0 +1,561 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CrossEngage/CaOps/internal/cassandra"
+	"github.com/CrossEngage/CaOps/internal/rolling"
+	"github.com/Sirupsen/logrus"
+	"github.com/gorilla/mux"
+	"github.com/hashicorp/serf/serf"
+)
+
+const (
+	nodeStepStartQueryName  = "node-step-start"
+	nodeStepStatusQueryName = "node-step-status"
+	hintsQueryName          = "hints"
+	rollingJobQueryName     = "rolling-job"
+	rollingQueryTimeout     = 10 * time.Second
+)
+
+// Default settings of the rolling jobs
+const (
+	DefaultStepTimeout         = time.Hour
+	DefaultHealthTimeout       = 30 * time.Minute
+	DefaultRollingPollInterval = 10 * time.Second
+)
+
+// The steps run on each node by the rolling jobs
+const (
+	stepSnapshot        = "snapshot"
+	stepDrain           = "drain"
+	stepRestart         = "restart"
+	stepStop            = "stop"
+	stepInstall         = "install"
+	stepStart           = "start"
+	stepWaitNormal      = "wait-normal"
+	stepVerifyVersion   = "verify-version"
+	stepUpgradeSSTables = "upgradesstables"
+	stepCheckDisk       = "check-disk"
+	stepCleanup         = "cleanup"
+)
+
+// RollingConfig holds the settings of the jobs going through the nodes one at a time, like
+// the rolling restarts and upgrades. The commands are run by a shell on the host of each
+// node, like systemctl restart cassandra, and {version} is replaced by the version to
+// install in the install command. A step fails when it is not finished within the step
+// timeout, and the job halts when the cluster is not healthy again within the health timeout.
+type RollingConfig struct {
+	RestartCommand string
+	StopCommand    string
+	InstallCommand string
+	StartCommand   string
+	StepTimeout    time.Duration
+	HealthTimeout  time.Duration
+	PollInterval   time.Duration
+	Cleanup        CleanupConfig
+}
+
+func (config RollingConfig) withDefaults() RollingConfig {
+	if config.StepTimeout == 0 {
+		config.StepTimeout = DefaultStepTimeout
+	}
+	if config.HealthTimeout == 0 {
+		config.HealthTimeout = DefaultHealthTimeout
+	}
+	if config.PollInterval == 0 {
+		config.PollInterval = DefaultRollingPollInterval
+	}
+	config.Cleanup = config.Cleanup.withDefaults()
+	return config
+}
+
+// RestartRequest is the body of the requests starting a rolling restart. The nodes are
+// restarted one at a time, or the nodes of a rack at once when by rack.
+type RestartRequest struct {
+	ByRack bool `json:"by_rack"`
+}
+
+// RollingJobResponse describes a rolling job, with its progress
+type RollingJobResponse struct {
+	*rolling.Job
+	Progress rolling.Progress `json:"progress"`
+}
+
+func newRollingJobResponse(job *rolling.Job) *RollingJobResponse {
+	return &RollingJobResponse{Job: job, Progress: job.Progress()}
+}
+
+// clusterCheckError is a check of the cluster failing before an operation, like a rolling
+// job, is started
+type clusterCheckError struct {
+	error
+}
+
+// nodeStepQuery is the payload of the queries starting a step of a job on the agent of a node,
+// or polling its status
+type nodeStepQuery struct {
+	Job    string            `json:"job"`
+	Step   string            `json:"step"`
+	Params map[string]string `json:"params,omitempty"`
+}
+
+// Encode ...
+func (q *nodeStepQuery) Encode() []byte {
+	buf, _ := json.Marshal(q)
+	return buf
+}
+
+type nodeStepQueryResponse struct {
+	Status rolling.StepStatus `json:"status,omitempty"`
+	Error  string             `json:"error,omitempty"`
+}
+
+// nodeStep is a step of a job run by this agent, on its node
+type nodeStep struct {
+	job    string
+	step   string
+	status rolling.StepStatus
+	err    string
+}
+
+// nodeSteps keeps the steps run by this agent, which only runs one of them at a time
+type nodeSteps struct {
+	mtx   sync.Mutex
+	steps map[string]*nodeStep
+}
+
+func newNodeSteps() *nodeSteps {
+	return &nodeSteps{steps: make(map[string]*nodeStep)}
+}
+
+// start runs the step in the background, unless it is already running. Fails if another
+// step is running.
+func (s *nodeSteps) start(job, step string, run func() error) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	key := job + "/" + step
+	for k, running := range s.steps {
+		if running.status != rolling.StepRunning {
+			continue
+		}
+		if k == key {
+			return nil
+		}
+		return fmt.Errorf("The %s step of job %s is running on this node", running.step, running.job)
+	}
+	ns := &nodeStep{job: job, step: step, status: rolling.StepRunning}
+	s.steps[key] = ns
+	go func() {
+		err := run()
+		s.mtx.Lock()
+		defer s.mtx.Unlock()
+		if err != nil {
+			ns.status, ns.err = rolling.StepFailed, err.Error()
+			return
+		}
+		ns.status = rolling.StepSucceeded
+	}()
+	return nil
+}
+
+// status returns the status of the step, which is failed when it is unknown, as the agent
+// may have restarted since it was started
+func (s *nodeSteps) status(job, step string) *nodeStepQueryResponse {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	ns, ok := s.steps[job+"/"+step]
+	if !ok {
+		return &nodeStepQueryResponse{Status: rolling.StepFailed,
+			Error: fmt.Sprintf("The %s step of job %s is unknown to the agent, which may have restarted", step, job)}
+	}
+	return &nodeStepQueryResponse{Status: ns.status, Error: ns.err}
+}
+
+// enableRollingJobs builds the runner of the rolling jobs, whose steps are run by the agents
+// of the nodes
+func (caops *CaOps) enableRollingJobs() {
+	caops.rolling = rolling.NewRunner(&gossipStepExecutor{caops: caops})
+	caops.rolling.SetClock(func() time.Time { return caops.clock.Now() })
+}
+
+// runShellCommand runs the command with a shell, adding the environment variables to the ones
+// of the agent, and returning its output in the error if it fails
+func runShellCommand(ctx context.Context, command string, env ...string) error {
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Env = append(os.Environ(), env...)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// gossipStepExecutor runs the steps of the rolling jobs on the agents of their nodes, through
+// gossip queries, and checks the health of the cluster
+type gossipStepExecutor struct {
+	caops *CaOps
+}
+
+func (e *gossipStepExecutor) query(name, address string, query *nodeStepQuery) (*nodeStepQueryResponse, error) {
+	payload, err := e.caops.gossiper.QueryNode(name, address, query, rollingQueryTimeout)
+	if err != nil {
+		return nil, err
+	}
+	response := &nodeStepQueryResponse{}
+	if err := json.Unmarshal(payload, response); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// RunStep starts the step on the agent of the node, and polls it until it is finished
+func (e *gossipStepExecutor) RunStep(ctx context.Context, jobID, address, step string, params map[string]string) error {
+	caops, config := e.caops, e.caops.rollingConfig
+	query := &nodeStepQuery{Job: jobID, Step: step, Params: params}
+	response, err := e.query(nodeStepStartQueryName, address, query)
+	if err != nil {
+		return err
+	}
+	if response.Error != "" {
+		return fmt.Errorf("%s", response.Error)
+	}
+
+	deadline := caops.clock.Now().Add(config.StepTimeout)
+	for {
+		select {
+		case <-caops.clock.After(config.PollInterval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		response, err := e.query(nodeStepStatusQueryName, address, query)
+		switch {
+		case err != nil:
+			logrus.Warnf("Could not poll the %s step of job %s on %s: %s", step, jobID, address, err)
+		case response.Status == rolling.StepSucceeded:
+			return nil
+		case response.Status == rolling.StepFailed:
+			return fmt.Errorf("%s", response.Error)
+		}
+		if caops.clock.Now().After(deadline) {
+			return fmt.Errorf("Not finished within %s", config.StepTimeout)
+		}
+	}
+}
+
+// WaitHealthy polls the health of the cluster until it is healthy, or the health timeout
+func (e *gossipStepExecutor) WaitHealthy(ctx context.Context) error {
+	caops, config := e.caops, e.caops.rollingConfig
+	deadline := caops.clock.Now().Add(config.HealthTimeout)
+	for {
+		err := caops.checkClusterHealth(ctx)
+		if err == nil {
+			return nil
+		}
+		if caops.clock.Now().After(deadline) {
+			return err
+		}
+		logrus.Infof("Waiting for the cluster to be healthy: %s", err)
+		select {
+		case <-caops.clock.After(config.PollInterval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// checkClusterHealth runs the health checks of the rolling steps, like the cluster being
+// stable, as seen by this node, and no hint being written by the nodes, so the restarted
+// nodes got the writes they missed
+func (caops *CaOps) checkClusterHealth(ctx context.Context) error {
+	return caops.health.CheckOperation(withHealthCaller(ctx, operationRollingStep), operationRollingStep)
+}
+
+func (caops *CaOps) hintsQueryHandler(query *serf.Query) ([]byte, error) {
+	ctx, cancel := context.WithDeadline(caops.ctx, query.Deadline())
+	defer cancel()
+	hints, err := caops.cassMngr.HintsInProgress(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(strconv.FormatInt(hints, 10)), nil
+}
+
+// rollingJobQueryHandler responds with the rolling job running on this agent, or the one it is
+// about to start
+func (caops *CaOps) rollingJobQueryHandler(query *serf.Query) ([]byte, error) {
+	if job := caops.rolling.Running(); job != nil {
+		return []byte(job.ID), nil
+	}
+	return []byte(caops.rollingClaim.get()), nil
+}
+
+// jobClaim is the rolling job this agent is about to start, which it announces to the other
+// agents until the job runs
+type jobClaim struct {
+	mtx sync.Mutex
+	id  string
+}
+
+// claim claims the job, unless another job is already claimed
+func (c *jobClaim) claim(id string) bool {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.id != "" {
+		return false
+	}
+	c.id = id
+	return true
+}
+
+func (c *jobClaim) release() {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.id = ""
+}
+
+func (c *jobClaim) get() string {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.id
+}
+
+func (caops *CaOps) nodeStepStartQueryHandler(query *serf.Query) ([]byte, error) {
+	q := &nodeStepQuery{}
+	if err := json.Unmarshal(query.Payload, q); err != nil {
+		return nil, err
+	}
+	logrus.Infof("Starting the %s step of job %s", q.Step, q.Job)
+	response := &nodeStepQueryResponse{Status: rolling.StepRunning}
+	err := caops.nodeSteps.start(q.Job, q.Step, func() error {
+		ctx, cancel := context.WithTimeout(caops.ctx, caops.rollingConfig.StepTimeout)
+		defer cancel()
+		err := caops.runNodeStep(ctx, q.Step, q.Params)
+		if err != nil {
+			logrus.Errorf("The %s step of job %s failed: %s", q.Step, q.Job, err)
+		}
+		return err
+	})
+	if err != nil {
+		response.Error = err.Error()
+	}
+	return json.Marshal(response)
+}
+
+func (caops *CaOps) nodeStepStatusQueryHandler(query *serf.Query) ([]byte, error) {
+	q := &nodeStepQuery{}
+	if err := json.Unmarshal(query.Payload, q); err != nil {
+		return nil, err
+	}
+	return json.Marshal(caops.nodeSteps.status(q.Job, q.Step))
+}
+
+// runNodeStep runs a step of a rolling job on the node of this agent
+func (caops *CaOps) runNodeStep(ctx context.Context, step string, params map[string]string) error {
+	switch step {
+	case stepDrain:
+		return caops.cassMngr.Drain(ctx)
+	case stepRestart:
+		return caops.runConfiguredCommand(ctx, "restart", caops.rollingConfig.RestartCommand)
+	case stepWaitNormal:
+		return caops.waitNormal(ctx)
+	case stepSnapshot:
+		return caops.cassMngr.TakeSnapshot(ctx, params[upgradeSnapshotTagParam])
+	case stepStop:
+		return caops.runConfiguredCommand(ctx, "stop", caops.rollingConfig.StopCommand)
+	case stepInstall:
+		// the version is passed to the shell in a variable, so it is never run as a command
+		version := params[upgradeVersionParam]
+		if err := checkUpgradeVersion(version); err != nil {
+			return err
+		}
+		command := strings.Replace(caops.rollingConfig.InstallCommand, "{version}", `"$`+upgradeVersionEnv+`"`, -1)
+		return caops.runConfiguredCommand(ctx, "install", command, upgradeVersionEnv+"="+version)
+	case stepStart:
+		return caops.runConfiguredCommand(ctx, "start", caops.rollingConfig.StartCommand)
+	case stepVerifyVersion:
+		return caops.verifyVersion(ctx, params[upgradeVersionParam])
+	case stepUpgradeSSTables:
+		return caops.cassMngr.UpgradeSSTables(ctx)
+	case stepCheckDisk:
+		return caops.checkDiskHeadroom(ctx, splitKeyspaces(params[cleanupKeyspacesParam]))
+	case stepCleanup:
+		return caops.cassMngr.CleanupKeyspaces(ctx, splitKeyspaces(params[cleanupKeyspacesParam])...)
+	}
+	return fmt.Errorf("Unknown step %s", step)
+}
+
+func (caops *CaOps) runConfiguredCommand(ctx context.Context, name, command string, env ...string) error {
+	if command == "" {
+		return fmt.Errorf("No %s command is configured", name)
+	}
+	logrus.Infof("Running the %s command: %s %s", name, strings.Join(env, " "), command)
+	return caops.runCommand(ctx, command, env...)
+}
+
+// waitNormal polls the operation mode of the node until it is NORMAL, or the context is done
+func (caops *CaOps) waitNormal(ctx context.Context) error {
+	for {
+		mode, err := caops.cassMngr.OperationMode(ctx)
+		if err == nil && mode == "NORMAL" {
+			return nil
+		}
+		if err == nil {
+			err = fmt.Errorf("The node is %s", mode)
+		}
+		select {
+		case <-caops.clock.After(caops.rollingConfig.PollInterval):
+		case <-ctx.Done():
+			return fmt.Errorf("The node did not get back to NORMAL: %s", err)
+		}
+	}
+}
+
+// checkRollingJobs fails if a rolling job is running, or about to start, on any agent of the
+// cluster, but for the job claimed by this agent
+func (caops *CaOps) checkRollingJobs(claimed string) error {
+	results, err := caops.gossiper.Query(rollingJobQueryName, &EmptyPayload{}, rollingQueryTimeout)
+	if err != nil {
+		return err
+	}
+	if len(results.Missing) > 0 {
+		return &clusterCheckError{fmt.Errorf("No response from the agents of %s", strings.Join(results.Missing, ", "))}
+	}
+	for ip, response := range results.Responses {
+		if len(response) > 0 && (claimed == "" || string(response) != claimed) {
+			return &clusterCheckError{fmt.Errorf("The rolling job %s is running on the agent of %s", response, ip)}
+		}
+	}
+	return nil
+}
+
+// runRollingJob starts the job, once no other agent runs or starts a rolling job. The job is
+// claimed before the agents are checked again, so that of two agents starting jobs at once,
+// at least one sees the job of the other, and does not start its own.
+func (caops *CaOps) runRollingJob(job *rolling.Job) error {
+	if !caops.rollingClaim.claim(job.ID) {
+		return &clusterCheckError{fmt.Errorf("Another rolling job is being started")}
+	}
+	defer caops.rollingClaim.release()
+	if err := caops.checkRollingJobs(job.ID); err != nil {
+		return err
+	}
+	return caops.rolling.Start(caops.ctx, job)
+}
+
+// planRollingJob checks that the cluster is stable, with all its nodes up and having an agent,
+// and that no other rolling job is running, then plans the job running the steps on them
+func (caops *CaOps) planRollingJob(ctx context.Context, kind string, nodeSteps, finalSteps []string, byRack bool) (*rolling.Job, error) {
+	nodes, err := caops.rollingNodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return rolling.NewJob(caops.newJobID(), kind, nodes, nodeSteps, finalSteps, byRack, caops.clock.Now())
+}
+
+// rollingNodes checks that the cluster is stable, with all its nodes up, having an agent and
+// agreeing on the schema, and that no rolling job is running, then returns the nodes
+func (caops *CaOps) rollingNodes(ctx context.Context) ([]rolling.Node, error) {
+	if err := caops.checkRollingJobs(""); err != nil {
+		return nil, err
+	}
+	if err := caops.checkOperation(ctx, operationRollingJob); err != nil {
+		return nil, err
+	}
+	endpoints, err := caops.cassMngr.Endpoints(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	agents := stringListToMapKeys(caops.gossiper.AliveMembers())
+	nodes := make([]rolling.Node, 0, len(endpoints))
+	for _, e := range endpoints {
+		if e.Status != cassandra.EndpointUp || e.State != cassandra.EndpointNormal {
+			return nil, &clusterCheckError{fmt.Errorf("Node %s is %s and %s", e.Address, e.Status, e.State)}
+		}
+		if !agents[e.Address] {
+			return nil, &clusterCheckError{fmt.Errorf("Node %s has no CaOps agent", e.Address)}
+		}
+		nodes = append(nodes, rolling.Node{Address: e.Address, Datacenter: e.Datacenter, Rack: e.Rack})
+	}
+	return nodes, nil
+}
+
+// startRollingJob starts the job, responding with it
+func (caops *CaOps) startRollingJob(w http.ResponseWriter, kind string, job *rolling.Job, err error) {
+	if err != nil {
+		status := http.StatusInternalServerError
+		if _, ok := err.(*clusterCheckError); ok {
+			status = http.StatusConflict
+		}
+		http.Error(w, fmt.Sprintf("Error while planning the %s: %s", kind, err), status)
+		return
+	}
+	if err := caops.runRollingJob(job); err != nil {
+		http.Error(w, fmt.Sprintf("Error while starting the %s: %s", kind, err), http.StatusConflict)
+		return
+	}
+	logrus.Infof("The %s job %s of %d nodes was requested", job.Kind, job.ID, len(job.Nodes))
+	// the job is now updated by the runner, so a copy is responded
+	writeJSON(w, http.StatusCreated, newRollingJobResponse(caops.rolling.Job(job.ID)))
+}
+
+func (caops *CaOps) restartHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	request := &RestartRequest{}
+	if err := json.NewDecoder(r.Body).Decode(request); err != nil && err != io.EOF {
+		http.Error(w, fmt.Sprintf("Invalid restart request: %s", err), http.StatusBadRequest)
+		return
+	}
+	if caops.rollingConfig.RestartCommand == "" {
+		http.Error(w, "No restart command is configured", http.StatusNotImplemented)
+		return
+	}
+	job, err := caops.planRollingJob(r.Context(), "restart", []string{stepDrain, stepRestart, stepWaitNormal}, nil, request.ByRack)
+	caops.startRollingJob(w, "restart", job, err)
+}
+
+func (caops *CaOps) listRollingJobsHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	jobs := make([]*RollingJobResponse, 0)
+	for _, job := range caops.rolling.Jobs() {
+		response := newRollingJobResponse(job)
+		response.Nodes = nil
+		jobs = append(jobs, response)
+	}
+	writeJSON(w, http.StatusOK, jobs)
+}
+
+func (caops *CaOps) rollingJobHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	id := mux.Vars(r)["id"]
+	job := caops.rolling.Job(id)
+	if job == nil {
+		http.Error(w, fmt.Sprintf("Rolling job %s does not exist", id), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, newRollingJobResponse(job))
+}
+
+func (caops *CaOps) cancelRollingJobHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	id := mux.Vars(r)["id"]
+	if caops.rolling.Job(id) == nil {
+		http.Error(w, fmt.Sprintf("Rolling job %s does not exist", id), http.StatusNotFound)
+		return
+	}
+	if err := caops.rolling.Cancel(id); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	writeJSON(w, http.StatusOK, newRollingJobResponse(caops.rolling.Job(id)))
+}
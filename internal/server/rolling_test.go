@@ -0,0 +1,160 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/CrossEngage/CaOps/internal/cassandra/cassandratest"
+	"github.com/CrossEngage/CaOps/internal/rolling"
+	"github.com/stretchr/testify/assert"
+)
+
+// setRollingCluster sets up the ring of the nodes, all in the same rack, and a restart
+// command bringing the drained node back to NORMAL, which fails on the failing node. The
+// returned function tells the most nodes which were drained at once.
+func setRollingCluster(h *harness, failing string) func() int {
+	tokens := make(map[string]string)
+	for i, node := range h.Nodes {
+		tokens[fmt.Sprint(i*100)] = node.IP
+	}
+	var mtx sync.Mutex
+	maxDrained := 0
+	for _, node := range h.Nodes {
+		node := node
+		node.Agent.SetAttribute(cassandratest.StorageService, "TokenToEndpointMap", tokens)
+		snitch := "org.apache.cassandra.db:type=EndpointSnitchInfo"
+		node.Agent.SetOperation(snitch, "getDatacenter", func(args []interface{}) (interface{}, error) { return "dc1", nil })
+		node.Agent.SetOperation(snitch, "getRack", func(args []interface{}) (interface{}, error) { return "r1", nil })
+		node.CaOps.rollingConfig = RollingConfig{RestartCommand: "systemctl restart cassandra"}.withDefaults()
+		node.CaOps.runCommand = func(ctx context.Context, command string, env ...string) error {
+			drained := 0
+			for _, n := range h.Nodes {
+				if n.Agent.Attribute(cassandratest.StorageService, "OperationMode") == "DRAINED" {
+					drained++
+				}
+			}
+			mtx.Lock()
+			if drained > maxDrained {
+				maxDrained = drained
+			}
+			mtx.Unlock()
+			if node.IP == failing {
+				return fmt.Errorf("exit status 1: Job for cassandra.service failed")
+			}
+			node.Agent.SetAttribute(cassandratest.StorageService, "OperationMode", "NORMAL")
+			node.Agent.SetAttribute(cassandratest.StorageService, "GossipRunning", true)
+			node.Agent.SetAttribute(cassandratest.StorageService, "NativeTransportRunning", true)
+			return nil
+		}
+	}
+	return func() int {
+		mtx.Lock()
+		defer mtx.Unlock()
+		return maxDrained
+	}
+}
+
+// waitRollingJob advances the clock until the job is finished, and returns it
+func waitRollingJob(h *harness, node *harnessNode, id string) *RollingJobResponse {
+	h.eventually("the rolling job is finished", func() bool {
+		h.Clock.Advance(DefaultRollingPollInterval)
+		return node.CaOps.rolling.Job(id).Finished()
+	})
+	w := node.Request("GET", "/rolling-jobs/"+id)
+	assertStatus(h.t, http.StatusOK, w)
+	job := &RollingJobResponse{}
+	assert.Nil(h.t, json.NewDecoder(w.Body).Decode(job))
+	return job
+}
+
+func TestRollingRestart(t *testing.T) {
+	h := newHarness(t, 3)
+	defer h.Close()
+	maxDrained := setRollingCluster(h, "")
+	orchestrator := h.Nodes[1]
+
+	w := orchestrator.Request("POST", "/restarts")
+	assertStatus(t, http.StatusCreated, w)
+	job := &RollingJobResponse{}
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(job))
+	assert.Equal(t, 3, job.Progress.Total)
+
+	// another agent can not start a rolling job at the same time
+	assertStatus(t, http.StatusConflict, h.Nodes[2].Request("POST", "/restarts"))
+
+	job = waitRollingJob(h, orchestrator, job.ID)
+	assert.Equal(t, rolling.JobSucceeded, job.Status)
+	assert.Equal(t, rolling.Progress{Total: 3, Succeeded: 3}, job.Progress)
+	assert.Equal(t, 1, maxDrained())
+	for i, node := range h.Nodes {
+		assert.Equal(t, h.Nodes[i].IP, job.Nodes[i].Address)
+		for _, operation := range []string{"stopGossiping", "stopNativeTransport", "drain"} {
+			assert.Len(t, node.Agent.Executed(cassandratest.StorageService, operation), 1, "%s on %s", operation, node.IP)
+		}
+	}
+}
+
+func TestRollingJobClaims(t *testing.T) {
+	h := newHarness(t, 3)
+	defer h.Close()
+	setRollingCluster(h, "")
+
+	// a job claimed by another agent once this one planned its job prevents its start
+	job, err := h.Nodes[0].CaOps.planRollingJob(h.Nodes[0].CaOps.ctx, "restart", []string{stepRestart}, nil, false)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.True(t, h.Nodes[2].CaOps.rollingClaim.claim("other"))
+	err = h.Nodes[0].CaOps.runRollingJob(job)
+	assert.IsType(t, &clusterCheckError{}, err)
+	assert.Contains(t, err.Error(), "other")
+	assert.Empty(t, h.Nodes[0].CaOps.rolling.Jobs())
+	h.Nodes[2].CaOps.rollingClaim.release()
+
+	// of the agents starting jobs at once, at most one starts its job
+	var wg sync.WaitGroup
+	codes := make([]int, len(h.Nodes))
+	for i, node := range h.Nodes {
+		wg.Add(1)
+		go func(i int, node *harnessNode) {
+			defer wg.Done()
+			codes[i] = node.Request("POST", "/restarts").Code
+		}(i, node)
+	}
+	wg.Wait()
+	started := 0
+	for _, code := range codes {
+		if code == http.StatusCreated {
+			started++
+		} else {
+			assert.Equal(t, http.StatusConflict, code)
+		}
+	}
+	assert.True(t, started <= 1, "%d rolling jobs were started at once", started)
+}
+
+func TestRollingRestartHaltsOnFailure(t *testing.T) {
+	h := newHarness(t, 3)
+	defer h.Close()
+	setRollingCluster(h, h.Nodes[1].IP)
+	orchestrator := h.Nodes[0]
+
+	w := orchestrator.RequestWithBody("POST", "/restarts", `{"by_rack": false}`)
+	assertStatus(t, http.StatusCreated, w)
+	job := &RollingJobResponse{}
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(job))
+
+	job = waitRollingJob(h, orchestrator, job.ID)
+	assert.Equal(t, rolling.JobFailed, job.Status)
+	assert.Equal(t, fmt.Sprintf("The restart step failed on %s: exit status 1: Job for cassandra.service failed", h.Nodes[1].IP), job.Error)
+	assert.Equal(t, rolling.Progress{Total: 3, Pending: 1, Succeeded: 1, Failed: 1}, job.Progress)
+	assert.Empty(t, h.Nodes[2].Agent.Executed(cassandratest.StorageService, "drain"))
+
+	// a new job can not start while a node is down
+	h.Nodes[0].Agent.SetAttribute(cassandratest.StorageService, "UnreachableNodes", []string{h.Nodes[1].IP})
+	assertStatus(t, http.StatusConflict, orchestrator.Request("POST", "/restarts"))
+}
@@ -0,0 +1,248 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/CrossEngage/CaOps/internal/cassandra"
+	"github.com/CrossEngage/CaOps/internal/health"
+	"github.com/Sirupsen/logrus"
+	"github.com/hashicorp/serf/serf"
+)
+
+const (
+	schemaVersionQueryName = "schema-version"
+	resetSchemaQueryName   = "reset-schema"
+)
+
+// SchemaAgreement tells whether the nodes agree on the schema, with the schema versions seen by
+// the node of this agent and the ones reported by the node of each agent. The outliers are the
+// nodes whose version differs from the one of most nodes.
+type SchemaAgreement struct {
+	Agreed bool `json:"agreed"`
+	// Versions maps each schema version to the nodes having it, as seen by the node of this agent
+	Versions    map[string][]string `json:"versions"`
+	Unreachable []string            `json:"unreachable"`
+	// Agents maps the IP of each agent to the schema version of its node
+	Agents     map[string]string `json:"agents"`
+	Unanswered []string          `json:"unanswered"`
+	Outliers   []string          `json:"outliers"`
+}
+
+// Disagreement describes the disagreement, or returns an empty string when the nodes agree
+func (a *SchemaAgreement) Disagreement() string {
+	if a.Agreed {
+		return ""
+	}
+	versions := make([]string, 0, len(a.Versions))
+	for version, nodes := range a.Versions {
+		versions = append(versions, fmt.Sprintf("%s on %s", version, strings.Join(nodes, ", ")))
+	}
+	sort.Strings(versions)
+	agents := make([]string, 0, len(a.Agents))
+	for ip, version := range a.Agents {
+		if !stringListToMapKeys(a.Versions[version])[ip] {
+			agents = append(agents, fmt.Sprintf("%s according to the agent of %s", version, ip))
+		}
+	}
+	sort.Strings(agents)
+	versions = append(versions, agents...)
+	if len(a.Unanswered) > 0 {
+		versions = append(versions, "unknown on "+strings.Join(a.Unanswered, ", "))
+	}
+	return "The nodes disagree on the schema version: " + strings.Join(versions, "; ")
+}
+
+// ResetSchemaRequest is the body of the requests resetting the schema of the outliers, or of
+// some of them, which must be confirmed
+type ResetSchemaRequest struct {
+	Addresses []string `json:"addresses"`
+	Confirm   bool     `json:"confirm"`
+}
+
+// schemaVersionQueryHandler responds with the schema version of the node of this agent
+func (caops *CaOps) schemaVersionQueryHandler(query *serf.Query) ([]byte, error) {
+	ctx, cancel := context.WithDeadline(caops.ctx, query.Deadline())
+	defer cancel()
+	version, err := caops.cassMngr.SchemaVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(version), nil
+}
+
+// resetSchemaQueryHandler resets the schema of the node of this agent, once it responded, as
+// the node pulls the schema from the others until it has all of it
+func (caops *CaOps) resetSchemaQueryHandler(query *serf.Query) ([]byte, error) {
+	logrus.Warn("Resetting the schema of the node, as it disagrees with the cluster")
+	go func() {
+		if err := caops.cassMngr.ResetLocalSchema(caops.ctx); err != nil {
+			logrus.Errorf("Could not reset the schema of the node: %s", err)
+			return
+		}
+		logrus.Info("The schema of the node was reset")
+	}()
+	return []byte{}, nil
+}
+
+// schemaAgreement compares the schema versions seen by the node of this agent with the ones
+// reported by the node of each agent, ignoring the excluded nodes. The unreachable nodes do not
+// break the agreement, but the agents which do not respond do, as their schema is unknown.
+func (caops *CaOps) schemaAgreement(ctx context.Context, excluded []string) (*SchemaAgreement, error) {
+	seen, err := caops.cassMngr.SchemaVersions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ignored := stringListToMapKeys(excluded)
+	withoutExcluded := func(nodes []string) []string {
+		kept := make([]string, 0, len(nodes))
+		for _, node := range nodes {
+			if !ignored[node] {
+				kept = append(kept, node)
+			}
+		}
+		return kept
+	}
+	versions := make(map[string][]string)
+	for version, nodes := range seen {
+		if nodes = withoutExcluded(nodes); len(nodes) > 0 {
+			versions[version] = nodes
+		}
+	}
+	agreement := &SchemaAgreement{
+		Versions:    versions,
+		Unreachable: make([]string, 0),
+		Agents:      make(map[string]string),
+		Unanswered:  make([]string, 0),
+		Outliers:    make([]string, 0),
+	}
+	if unreachable, ok := versions[cassandra.UnreachableSchema]; ok {
+		agreement.Unreachable = unreachable
+		delete(versions, cassandra.UnreachableSchema)
+	}
+	if caops.gossiper != nil {
+		results, err := caops.gossiper.Query(schemaVersionQueryName, &EmptyPayload{}, rollingQueryTimeout)
+		if err != nil {
+			return nil, err
+		}
+		for ip, response := range results.Responses {
+			if !ignored[ip] {
+				agreement.Agents[ip] = string(response)
+			}
+		}
+		agreement.Unanswered = withoutExcluded(results.Missing)
+	}
+
+	// the version of most nodes is taken as the right one, unless there is a tie
+	var majority string
+	tie := false
+	for version := range versions {
+		if majority == "" || len(versions[version]) > len(versions[majority]) {
+			majority, tie = version, false
+		} else if len(versions[version]) == len(versions[majority]) {
+			tie = true
+		}
+	}
+	agreed := len(versions) == 1 && len(agreement.Unanswered) == 0
+	outliers := make(map[string]bool)
+	for version, nodes := range versions {
+		if version != majority {
+			for _, node := range nodes {
+				outliers[node] = true
+			}
+		}
+	}
+	for ip, version := range agreement.Agents {
+		if version != majority {
+			agreed = false
+			outliers[ip] = true
+		}
+	}
+	agreement.Agreed = agreed
+	if !tie {
+		for node := range outliers {
+			agreement.Outliers = append(agreement.Outliers, node)
+		}
+		sort.Strings(agreement.Outliers)
+	}
+	return agreement, nil
+}
+
+// checkSchemaAgreement fails unless the nodes agree on the schema, as the cluster operations
+// and backups must not run after a failed schema change left the nodes with different schemas.
+// The excluded nodes, like a node being removed, do not count.
+func (caops *CaOps) checkSchemaAgreement(ctx context.Context, excluded []string) error {
+	agreement, err := caops.schemaAgreement(ctx, excluded)
+	if err != nil {
+		return err
+	}
+	if !agreement.Agreed {
+		return health.Failf("%s", agreement.Disagreement())
+	}
+	return nil
+}
+
+func (caops *CaOps) schemaAgreementHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	agreement, err := caops.schemaAgreement(r.Context(), nil)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error while checking the schema agreement: %s", err), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, agreement)
+}
+
+// resetSchemaHandler resets the schema of the outliers, or of the given ones, once confirmed,
+// responding once their agents were asked to. The agreement is then checked again by the
+// schema agreement handler.
+func (caops *CaOps) resetSchemaHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	request := &ResetSchemaRequest{}
+	if err := json.NewDecoder(r.Body).Decode(request); err != nil && err != io.EOF {
+		http.Error(w, fmt.Sprintf("Invalid schema reset request: %s", err), http.StatusBadRequest)
+		return
+	}
+	agreement, err := caops.schemaAgreement(r.Context(), nil)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error while checking the schema agreement: %s", err), http.StatusInternalServerError)
+		return
+	}
+	if agreement.Agreed {
+		http.Error(w, "The nodes agree on the schema", http.StatusConflict)
+		return
+	}
+	if len(agreement.Outliers) == 0 {
+		http.Error(w, "No schema version is held by most nodes, so the outliers are unknown: "+agreement.Disagreement(),
+			http.StatusConflict)
+		return
+	}
+	outliers := stringListToMapKeys(agreement.Outliers)
+	if len(request.Addresses) == 0 {
+		request.Addresses = agreement.Outliers
+	}
+	for _, address := range request.Addresses {
+		if !outliers[address] {
+			http.Error(w, fmt.Sprintf("Node %s agrees with most nodes on the schema", address), http.StatusConflict)
+			return
+		}
+	}
+	if !request.Confirm {
+		http.Error(w, fmt.Sprintf("The reset of the schema of %s must be confirmed", strings.Join(request.Addresses, ", ")),
+			http.StatusBadRequest)
+		return
+	}
+
+	for _, address := range request.Addresses {
+		if _, err := caops.gossiper.QueryNode(resetSchemaQueryName, address, &EmptyPayload{}, rollingQueryTimeout); err != nil {
+			http.Error(w, fmt.Sprintf("Error while resetting the schema of %s: %s", address, err), http.StatusInternalServerError)
+			return
+		}
+		logrus.Warnf("Requested the reset of the schema of %s", address)
+	}
+	writeJSON(w, http.StatusAccepted, request)
+}
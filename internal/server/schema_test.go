@@ -0,0 +1,99 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/CrossEngage/CaOps/internal/cassandra/cassandratest"
+	"github.com/stretchr/testify/assert"
+)
+
+const outlierSchema = "86afa796-d883-3932-aa73-6b017cef0d19"
+
+func TestSchemaAgreement(t *testing.T) {
+	h := newHarness(t, 3)
+	defer h.Close()
+	setRollingCluster(h, "")
+	node, outlier := h.Nodes[0], h.Nodes[2]
+	schema := node.Agent.Attribute(cassandratest.StorageService, "SchemaVersion").(string)
+
+	w := node.Request("GET", "/schema-agreement")
+	assertStatus(t, http.StatusOK, w)
+	agreement := &SchemaAgreement{}
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(agreement))
+	assert.True(t, agreement.Agreed)
+	assert.Equal(t, map[string][]string{schema: {"127.0.0.1", "127.0.0.2", "127.0.0.3"}}, agreement.Versions)
+	assert.Len(t, agreement.Agents, 3)
+	assert.Empty(t, agreement.Outliers)
+	assertStatus(t, http.StatusConflict, node.RequestWithBody("POST", "/schema-agreement/reset", `{"confirm": true}`))
+
+	// a failed schema change left the third node with another schema
+	outlier.Agent.SetAttribute(cassandratest.StorageService, "SchemaVersion", outlierSchema)
+	node.Agent.SetAttribute(cassandratest.StorageProxy, "SchemaVersions", map[string][]string{
+		schema:        {"127.0.0.1", "127.0.0.2"},
+		outlierSchema: {"127.0.0.3"},
+	})
+	w = node.Request("GET", "/schema-agreement")
+	assertStatus(t, http.StatusOK, w)
+	agreement = &SchemaAgreement{}
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(agreement))
+	assert.False(t, agreement.Agreed)
+	assert.Equal(t, outlierSchema, agreement.Agents[outlier.IP])
+	assert.Equal(t, []string{outlier.IP}, agreement.Outliers)
+
+	// the backups and cluster operations are blocked
+	w = node.Request("GET", "/backup-keyspaces/ks1")
+	assertStatus(t, http.StatusConflict, w)
+	assert.Contains(t, w.Body.String(), outlierSchema+" on 127.0.0.3")
+	assertStatus(t, http.StatusConflict, node.RequestWithBody("POST", "/restarts", `{}`))
+	assertStatus(t, http.StatusConflict, node.RequestWithBody("POST", "/repairs", `{}`))
+	assert.Empty(t, h.snapshotsByNode()[node.IP])
+
+	// the schema of the outlier is only reset once confirmed
+	outlier.Agent.SetOperation(cassandratest.StorageService, "resetLocalSchema", func(args []interface{}) (interface{}, error) {
+		outlier.Agent.SetAttribute(cassandratest.StorageService, "SchemaVersion", schema)
+		return nil, nil
+	})
+	assertStatus(t, http.StatusBadRequest, node.RequestWithBody("POST", "/schema-agreement/reset", `{}`))
+	assertStatus(t, http.StatusConflict, node.RequestWithBody("POST", "/schema-agreement/reset",
+		`{"addresses": ["127.0.0.1"], "confirm": true}`))
+	assertStatus(t, http.StatusAccepted, node.RequestWithBody("POST", "/schema-agreement/reset", `{"confirm": true}`))
+	h.eventually("the schema of the outlier is reset", func() bool {
+		return len(outlier.Agent.Executed(cassandratest.StorageService, "resetLocalSchema")) == 1
+	})
+	for _, n := range h.Nodes[:2] {
+		assert.Empty(t, n.Agent.Executed(cassandratest.StorageService, "resetLocalSchema"))
+	}
+
+	node.Agent.SetAttribute(cassandratest.StorageProxy, "SchemaVersions", map[string][]string{
+		schema: {"127.0.0.1", "127.0.0.2", "127.0.0.3"},
+	})
+	assert.Nil(t, node.CaOps.checkSchemaAgreement(node.CaOps.ctx, nil))
+}
+
+func TestSchemaAgreementTie(t *testing.T) {
+	h := newHarness(t, 2)
+	defer h.Close()
+	node := h.Nodes[0]
+	schema := node.Agent.Attribute(cassandratest.StorageService, "SchemaVersion").(string)
+	h.Nodes[1].Agent.SetAttribute(cassandratest.StorageService, "SchemaVersion", outlierSchema)
+	node.Agent.SetAttribute(cassandratest.StorageProxy, "SchemaVersions", map[string][]string{
+		schema:        {"127.0.0.1"},
+		outlierSchema: {"127.0.0.2"},
+		"UNREACHABLE": {"127.0.0.3"},
+	})
+
+	agreement, err := node.CaOps.schemaAgreement(node.CaOps.ctx, nil)
+	assert.Nil(t, err)
+	assert.False(t, agreement.Agreed)
+	assert.Equal(t, []string{"127.0.0.3"}, agreement.Unreachable)
+	assert.Len(t, agreement.Versions, 2)
+	// neither version is held by most nodes, so the outliers are unknown
+	assert.Empty(t, agreement.Outliers)
+	assertStatus(t, http.StatusConflict, node.RequestWithBody("POST", "/schema-agreement/reset", `{"confirm": true}`))
+
+	// the disagreeing node does not count once excluded, like when it is about to be removed
+	assert.NotNil(t, node.CaOps.checkSchemaAgreement(node.CaOps.ctx, nil))
+	assert.Nil(t, node.CaOps.checkSchemaAgreement(node.CaOps.ctx, []string{"127.0.0.2"}))
+}
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Overall node health, as reported by the status document
+const (
+	StatusOK       = "ok"
+	StatusDegraded = "degraded"
+	StatusDown     = "down"
+)
+
+// StatusString is a string attribute of the status document, with the error got while reading it
+type StatusString struct {
+	Value string `json:"value"`
+	Error string `json:"error,omitempty"`
+}
+
+// StatusStringList is a string list attribute of the status document, with the error got while reading it
+type StatusStringList struct {
+	Value []string `json:"value"`
+	Error string   `json:"error,omitempty"`
+}
+
+// StatusBool is a boolean attribute of the status document, with the error got while reading it
+type StatusBool struct {
+	Value bool   `json:"value"`
+	Error string `json:"error,omitempty"`
+}
+
+func newStatusString(value string, err error) StatusString {
+	return StatusString{Value: value, Error: getErrStr(err)}
+}
+
+func newStatusStringList(value []string, err error) StatusStringList {
+	if value == nil {
+		value = []string{}
+	}
+	return StatusStringList{Value: value, Error: getErrStr(err)}
+}
+
+func newStatusBool(value bool, err error) StatusBool {
+	return StatusBool{Value: value, Error: getErrStr(err)}
+}
+
+// NodeStatus is the status document of the local Cassandra node, and of the cluster as seen by it
+type NodeStatus struct {
+	Status string `json:"status"`
+	// JMXTarget is set when the node is managed through a Jolokia proxy
+	JMXTarget string `json:"jmx_target,omitempty"`
+
+	JolokiaAgentVersion       StatusString     `json:"jolokia_agent_version"`
+	CassandraVersion          StatusString     `json:"cassandra_version"`
+	SchemaVersion             StatusString     `json:"schema_version"`
+	DataFileLocations         StatusStringList `json:"data_file_locations"`
+	CommitLogLocation         StatusString     `json:"commit_log_location"`
+	SavedCachesLocation       StatusString     `json:"saved_caches_location"`
+	LocalHostID               StatusString     `json:"local_host_id"`
+	PartitionerName           StatusString     `json:"partitioner_name"`
+	OperationMode             StatusString     `json:"operation_mode"`
+	IncrementalBackupsEnabled StatusBool       `json:"incremental_backups_enabled"`
+
+	ClusterName        StatusString     `json:"cluster_name"`
+	LiveNodes          StatusStringList `json:"live_nodes"`
+	UnreachableNodes   StatusStringList `json:"unreachable_nodes"`
+	JoiningNodes       StatusStringList `json:"joining_nodes"`
+	LeavingNodes       StatusStringList `json:"leaving_nodes"`
+	MovingNodes        StatusStringList `json:"moving_nodes"`
+	Keyspaces          StatusStringList `json:"keyspaces"`
+	NonSystemKeyspaces StatusStringList `json:"non_system_keyspaces"`
+}
+
+func (caops *CaOps) nodeStatus(ctx context.Context) *NodeStatus {
+	cs := caops.cassMngr.NodeStatus(ctx)
+	s := &NodeStatus{JMXTarget: caops.cassMngr.JMXTarget()}
+	s.JolokiaAgentVersion = newStatusString(cs.JolokiaAgentVersion.Value, cs.JolokiaAgentVersion.Err())
+	s.CassandraVersion = newStatusString(cs.ReleaseVersion.Value, cs.ReleaseVersion.Err())
+	s.SchemaVersion = newStatusString(cs.SchemaVersion.Value, cs.SchemaVersion.Err())
+	s.DataFileLocations = newStatusStringList(cs.AllDataFileLocations.Value, cs.AllDataFileLocations.Err())
+	s.CommitLogLocation = newStatusString(cs.CommitLogLocation.Value, cs.CommitLogLocation.Err())
+	s.SavedCachesLocation = newStatusString(cs.SavedCachesLocation.Value, cs.SavedCachesLocation.Err())
+	s.LocalHostID = newStatusString(cs.LocalHostID.Value, cs.LocalHostID.Err())
+	s.PartitionerName = newStatusString(cs.PartitionerName.Value, cs.PartitionerName.Err())
+	s.OperationMode = newStatusString(cs.OperationMode.Value, cs.OperationMode.Err())
+	s.IncrementalBackupsEnabled = newStatusBool(cs.IncrementalBackupsEnabled.Value, cs.IncrementalBackupsEnabled.Err())
+
+	s.ClusterName = newStatusString(cs.ClusterName.Value, cs.ClusterName.Err())
+	s.LiveNodes = newStatusStringList(cs.LiveNodes.Value, cs.LiveNodes.Err())
+	s.UnreachableNodes = newStatusStringList(cs.UnreachableNodes.Value, cs.UnreachableNodes.Err())
+	s.JoiningNodes = newStatusStringList(cs.JoiningNodes.Value, cs.JoiningNodes.Err())
+	s.LeavingNodes = newStatusStringList(cs.LeavingNodes.Value, cs.LeavingNodes.Err())
+	s.MovingNodes = newStatusStringList(cs.MovingNodes.Value, cs.MovingNodes.Err())
+	s.Keyspaces = newStatusStringList(cs.Keyspaces.Value, cs.Keyspaces.Err())
+	s.NonSystemKeyspaces = newStatusStringList(cs.NonSystemKeyspaces.Value, cs.NonSystemKeyspaces.Err())
+
+	s.Status = s.health()
+	return s
+}
+
+// errors returns the errors of all the attributes of the document, empty or not
+func (s *NodeStatus) errors() []string {
+	return []string{
+		s.JolokiaAgentVersion.Error, s.CassandraVersion.Error, s.SchemaVersion.Error,
+		s.DataFileLocations.Error, s.CommitLogLocation.Error, s.SavedCachesLocation.Error,
+		s.LocalHostID.Error, s.PartitionerName.Error, s.OperationMode.Error,
+		s.IncrementalBackupsEnabled.Error, s.ClusterName.Error, s.LiveNodes.Error,
+		s.UnreachableNodes.Error, s.JoiningNodes.Error, s.LeavingNodes.Error, s.MovingNodes.Error,
+		s.Keyspaces.Error, s.NonSystemKeyspaces.Error,
+	}
+}
+
+// health is down when no attribute could be read, and degraded when some could not, when
+// the node is not in the NORMAL operation mode, or when it sees unreachable nodes.
+func (s *NodeStatus) health() string {
+	errs := s.errors()
+	failed := 0
+	for _, err := range errs {
+		if err != "" {
+			failed++
+		}
+	}
+	switch {
+	case failed == len(errs):
+		return StatusDown
+	case failed > 0 || s.OperationMode.Value != "NORMAL" || len(s.UnreachableNodes.Value) > 0:
+		return StatusDegraded
+	}
+	return StatusOK
+}
+
+// HTTPStatus returns the HTTP status code matching the node health
+func (s *NodeStatus) HTTPStatus() int {
+	if s.Status == StatusOK {
+		return http.StatusOK
+	}
+	return http.StatusServiceUnavailable
+}
+
+// WriteText writes the status document in the human readable text format
+func (s *NodeStatus) WriteText(w io.Writer) {
+	fmt.Fprintln(w, "Status:", s.Status)
+	if s.JMXTarget != "" {
+		fmt.Fprintln(w, "JMX Proxy Target:", s.JMXTarget)
+	}
+	fmt.Fprintln(w, "Jolokia Agent Version:", s.JolokiaAgentVersion.Value, s.JolokiaAgentVersion.Error)
+
+	fmt.Fprintln(w, "C* Version:", s.CassandraVersion.Value, s.CassandraVersion.Error)
+	fmt.Fprintln(w, "C* Schema Version:", s.SchemaVersion.Value, s.SchemaVersion.Error)
+	fmt.Fprintln(w, "C* Data File Locs:", strings.Join(s.DataFileLocations.Value, ", "), s.DataFileLocations.Error)
+	fmt.Fprintln(w, "C* CommitLog Loc:", s.CommitLogLocation.Value, s.CommitLogLocation.Error)
+	fmt.Fprintln(w, "C* Saved Caches Loc:", s.SavedCachesLocation.Value, s.SavedCachesLocation.Error)
+	fmt.Fprintln(w, "C* Local Host ID:", s.LocalHostID.Value, s.LocalHostID.Error)
+	fmt.Fprintln(w, "C* Partitoner Name:", s.PartitionerName.Value, s.PartitionerName.Error)
+	fmt.Fprintln(w, "C* Operation Mode:", s.OperationMode.Value, s.OperationMode.Error)
+	fmt.Fprintln(w, "C* Incremental Backups Enabled:", s.IncrementalBackupsEnabled.Value, s.IncrementalBackupsEnabled.Error)
+
+	fmt.Fprintln(w, "C* Cluster Name:", s.ClusterName.Value, s.ClusterName.Error)
+	fmt.Fprintln(w, "C* Cluster Live Nodes:", strings.Join(s.LiveNodes.Value, ", "), s.LiveNodes.Error)
+	fmt.Fprintln(w, "C* Cluster Unreachable Nodes:", strings.Join(s.UnreachableNodes.Value, ", "), s.UnreachableNodes.Error)
+	fmt.Fprintln(w, "C* Cluster Joining Nodes:", strings.Join(s.JoiningNodes.Value, ", "), s.JoiningNodes.Error)
+	fmt.Fprintln(w, "C* Cluster Leaving Nodes:", strings.Join(s.LeavingNodes.Value, ", "), s.LeavingNodes.Error)
+	fmt.Fprintln(w, "C* Cluster Moving Nodes:", strings.Join(s.MovingNodes.Value, ", "), s.MovingNodes.Error)
+	fmt.Fprintln(w, "C* Keyspaces:", strings.Join(s.Keyspaces.Value, ", "), s.Keyspaces.Error)
+	fmt.Fprintln(w, "C* Non-System Keyspaces:", strings.Join(s.NonSystemKeyspaces.Value, ", "), s.NonSystemKeyspaces.Error)
+}
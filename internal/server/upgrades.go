@@ -0,0 +1,196 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The parameters of the upgrade jobs
+const (
+	upgradeVersionParam     = "version"
+	upgradeSnapshotTagParam = "snapshot_tag"
+)
+
+// upgradeVersionEnv is the environment variable holding the version for the install command
+const upgradeVersionEnv = "CASSANDRA_VERSION"
+
+// upgradeVersionPattern matches the versions Cassandra can be upgraded to, like 3.11.4 or
+// 4.0-beta1, which are passed to the install command
+var upgradeVersionPattern = regexp.MustCompile(`^\d+\.\d+(\.\d+)?(-[A-Za-z0-9.]+)?$`)
+
+// UpgradeRequest is the body of the requests starting a rolling upgrade of Cassandra to the
+// version, one node at a time, or the nodes of a rack at once when by rack
+type UpgradeRequest struct {
+	Version string `json:"version"`
+	ByRack  bool   `json:"by_rack"`
+}
+
+// cassandraVersion is a Cassandra release version, like 3.11.4
+type cassandraVersion [3]int
+
+// parseCassandraVersion parses a release version, ignoring its suffix, like -SNAPSHOT
+func parseCassandraVersion(version string) (cassandraVersion, error) {
+	var v cassandraVersion
+	parts := strings.Split(strings.SplitN(version, "-", 2)[0], ".")
+	if len(parts) < 2 || len(parts) > 3 {
+		return v, fmt.Errorf("Invalid Cassandra version %s", version)
+	}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return v, fmt.Errorf("Invalid Cassandra version %s", version)
+		}
+		v[i] = n
+	}
+	return v, nil
+}
+
+// checkUpgradeVersion checks that the version requested for an upgrade is a release version,
+// and nothing else
+func checkUpgradeVersion(version string) error {
+	if !upgradeVersionPattern.MatchString(version) {
+		return fmt.Errorf("Invalid Cassandra version %s", version)
+	}
+	_, err := parseCassandraVersion(version)
+	return err
+}
+
+func (v cassandraVersion) String() string {
+	return fmt.Sprintf("%d.%d.%d", v[0], v[1], v[2])
+}
+
+func (v cassandraVersion) less(other cassandraVersion) bool {
+	for i := range v {
+		if v[i] != other[i] {
+			return v[i] < other[i]
+		}
+	}
+	return false
+}
+
+// minimumUpgradeFrom is the oldest version each major version can be upgraded from, as told
+// by the upgrade notes of Cassandra
+var minimumUpgradeFrom = map[int]cassandraVersion{
+	3: {2, 1, 9},
+	4: {3, 0, 0},
+}
+
+// checkVersionJump checks that Cassandra can be upgraded from a version to the other: a newer
+// version of the same major version, or of the next one, from a recent enough version
+func checkVersionJump(from, to string) error {
+	current, err := parseCassandraVersion(from)
+	if err != nil {
+		return err
+	}
+	target, err := parseCassandraVersion(to)
+	if err != nil {
+		return err
+	}
+	switch {
+	case !current.less(target):
+		return fmt.Errorf("Version %s is not newer than %s", to, from)
+	case target[0] > current[0]+1:
+		return fmt.Errorf("Cassandra must be upgraded one major version at a time, from %s to %d.x first", from, current[0]+1)
+	case target[0] == current[0]+1:
+		if minimum, ok := minimumUpgradeFrom[target[0]]; ok && current.less(minimum) {
+			return fmt.Errorf("Cassandra %s can only be upgraded to %s from %s or later", from, to, minimum)
+		}
+	}
+	return nil
+}
+
+// checkUpgrade checks, with the status of each agent, that all the nodes are up, agree on a
+// single schema version, and can be upgraded from their version to the target one
+func (caops *CaOps) checkUpgrade(ctx context.Context, target string) error {
+	cs, err := caops.clusterStatus(ctx, defaultClusterStatusQuery)
+	if err != nil {
+		return err
+	}
+	ips := make([]string, 0, len(cs.Nodes))
+	for ip := range cs.Nodes {
+		ips = append(ips, ip)
+	}
+	sort.Strings(ips)
+	schemas := make(map[string][]string)
+	for _, ip := range ips {
+		node := cs.Nodes[ip]
+		if node.Status == nil {
+			return &clusterCheckError{fmt.Errorf("Node %s: %s", ip, node.Error)}
+		}
+		status := node.Status
+		for _, attr := range []StatusString{status.CassandraVersion, status.SchemaVersion} {
+			if attr.Error != "" {
+				return &clusterCheckError{fmt.Errorf("Node %s: %s", ip, attr.Error)}
+			}
+		}
+		if len(status.UnreachableNodes.Value) > 0 {
+			return &clusterCheckError{fmt.Errorf("Node %s sees %s down", ip, strings.Join(status.UnreachableNodes.Value, ", "))}
+		}
+		if err := checkVersionJump(status.CassandraVersion.Value, target); err != nil {
+			return &clusterCheckError{fmt.Errorf("Node %s: %s", ip, err)}
+		}
+		schemas[status.SchemaVersion.Value] = append(schemas[status.SchemaVersion.Value], ip)
+	}
+	if len(schemas) > 1 {
+		versions := make([]string, 0, len(schemas))
+		for schema, nodes := range schemas {
+			versions = append(versions, fmt.Sprintf("%s on %s", schema, strings.Join(nodes, ", ")))
+		}
+		sort.Strings(versions)
+		return &clusterCheckError{fmt.Errorf("The nodes disagree on the schema version: %s", strings.Join(versions, "; "))}
+	}
+	return nil
+}
+
+// verifyVersion checks that the node runs the version it was upgraded to, the missing
+// components of the versions counting as 0, so 3.0 is 3.0.0
+func (caops *CaOps) verifyVersion(ctx context.Context, version string) error {
+	running, err := caops.cassMngr.CassandraVersion(ctx)
+	if err != nil {
+		return err
+	}
+	target, err := parseCassandraVersion(version)
+	if err != nil {
+		return err
+	}
+	if current, err := parseCassandraVersion(running); err != nil || current != target {
+		return fmt.Errorf("The node runs Cassandra %s instead of %s", running, version)
+	}
+	return nil
+}
+
+func (caops *CaOps) upgradeHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	request := &UpgradeRequest{}
+	if err := json.NewDecoder(r.Body).Decode(request); err != nil && err != io.EOF {
+		http.Error(w, fmt.Sprintf("Invalid upgrade request: %s", err), http.StatusBadRequest)
+		return
+	}
+	if err := checkUpgradeVersion(request.Version); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid upgrade request: %s", err), http.StatusBadRequest)
+		return
+	}
+	config := caops.rollingConfig
+	if config.StopCommand == "" || config.InstallCommand == "" || config.StartCommand == "" {
+		http.Error(w, "The stop, install and start commands must be configured", http.StatusNotImplemented)
+		return
+	}
+	if err := caops.checkUpgrade(r.Context(), request.Version); err != nil {
+		caops.startRollingJob(w, "upgrade", nil, err)
+		return
+	}
+	nodeSteps := []string{stepSnapshot, stepDrain, stepStop, stepInstall, stepStart, stepWaitNormal, stepVerifyVersion}
+	job, err := caops.planRollingJob(r.Context(), "upgrade", nodeSteps, []string{stepUpgradeSSTables}, request.ByRack)
+	if err == nil {
+		job.Params[upgradeVersionParam] = request.Version
+		job.Params[upgradeSnapshotTagParam] = "pre-upgrade-" + job.ID
+	}
+	caops.startRollingJob(w, "upgrade", job, err)
+}
@@ -0,0 +1,149 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/CrossEngage/CaOps/internal/cassandra/cassandratest"
+	"github.com/CrossEngage/CaOps/internal/rolling"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckVersionJump(t *testing.T) {
+	assert.Nil(t, checkVersionJump("2.2.13", "3.0.17"))
+	assert.Nil(t, checkVersionJump("3.0.17", "3.11.4"))
+	assert.Nil(t, checkVersionJump("3.11.4-SNAPSHOT", "4.0.0"))
+	assert.EqualError(t, checkVersionJump("3.0.17", "3.0.17"), "Version 3.0.17 is not newer than 3.0.17")
+	assert.EqualError(t, checkVersionJump("2.2.13", "4.0.1"), "Cassandra must be upgraded one major version at a time, from 2.2.13 to 3.x first")
+	assert.EqualError(t, checkVersionJump("2.1.5", "3.0.17"), "Cassandra 2.1.5 can only be upgraded to 3.0.17 from 2.1.9 or later")
+	assert.EqualError(t, checkVersionJump("2.2.13", "latest"), "Invalid Cassandra version latest")
+}
+
+func TestCheckUpgradeVersion(t *testing.T) {
+	for _, version := range []string{"3.0", "3.11.4", "4.0-beta1", "3.11.4-SNAPSHOT"} {
+		assert.Nil(t, checkUpgradeVersion(version), version)
+	}
+	for _, version := range []string{"latest", "3", "3.11.4-x; touch /tmp/pwned #", "3.11.4 ", "$(id).0", "3.11.4-"} {
+		assert.NotNil(t, checkUpgradeVersion(version), version)
+	}
+}
+
+func TestVerifyVersion(t *testing.T) {
+	caops, agent := newTestCaOps(t)
+	defer agent.Close()
+	agent.SetAttribute(cassandratest.StorageService, "ReleaseVersion", "3.0.0")
+	assert.Nil(t, caops.verifyVersion(context.Background(), "3.0"))
+	assert.Nil(t, caops.verifyVersion(context.Background(), "3.0.0"))
+	assert.EqualError(t, caops.verifyVersion(context.Background(), "3.0.1"), "The node runs Cassandra 3.0.0 instead of 3.0.1")
+}
+
+func TestRunShellCommandEnv(t *testing.T) {
+	dir, err := ioutil.TempDir("", "caops-upgrade")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	marker := filepath.Join(dir, "pwned")
+
+	// the variables are expanded by the shell, but never run
+	version := "3.11.4-x; touch " + marker + " #"
+	assert.Nil(t, runShellCommand(context.Background(), `test "$CASSANDRA_VERSION" = "`+version+`"`, upgradeVersionEnv+"="+version))
+	_, err = os.Stat(marker)
+	assert.True(t, os.IsNotExist(err))
+}
+
+// setUpgradeCommands makes the commands of the nodes stop them, and start them with the
+// version installed, recording the commands run
+func setUpgradeCommands(h *harness) func() []string {
+	var mtx sync.Mutex
+	commands := make([]string, 0)
+	for _, node := range h.Nodes {
+		node := node
+		node.Agent.SetOperation(cassandratest.StorageService, "upgradeSSTables", func(args []interface{}) (interface{}, error) {
+			return 0, nil
+		})
+		node.CaOps.rollingConfig.StopCommand = "systemctl stop cassandra"
+		node.CaOps.rollingConfig.InstallCommand = "apt-get install -y cassandra={version}"
+		node.CaOps.rollingConfig.StartCommand = "systemctl start cassandra"
+		installed := ""
+		node.CaOps.runCommand = func(ctx context.Context, command string, env ...string) error {
+			mtx.Lock()
+			defer mtx.Unlock()
+			commands = append(commands, strings.Join(append([]string{node.IP}, append(env, command)...), " "))
+			switch {
+			case strings.HasPrefix(command, "apt-get"):
+				installed = strings.TrimPrefix(env[0], upgradeVersionEnv+"=")
+			case command == "systemctl start cassandra":
+				node.Agent.SetAttribute(cassandratest.StorageService, "ReleaseVersion", installed)
+				node.Agent.SetAttribute(cassandratest.StorageService, "OperationMode", "NORMAL")
+			}
+			return nil
+		}
+	}
+	return func() []string {
+		mtx.Lock()
+		defer mtx.Unlock()
+		return append([]string{}, commands...)
+	}
+}
+
+func TestRollingUpgrade(t *testing.T) {
+	h := newHarness(t, 2)
+	defer h.Close()
+	h.AddTable("ks1", "users")
+	setRollingCluster(h, "")
+	for _, node := range h.Nodes {
+		node.Agent.SetAttribute(cassandratest.StorageService, "ReleaseVersion", "2.2.13")
+	}
+	commands := setUpgradeCommands(h)
+	orchestrator := h.Nodes[0]
+
+	w := orchestrator.RequestWithBody("POST", "/upgrades", `{"version": "3.0.17"}`)
+	assertStatus(t, http.StatusCreated, w)
+	job := &RollingJobResponse{}
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(job))
+	assert.Equal(t, "3.0.17", job.Params["version"])
+
+	job = waitRollingJob(h, orchestrator, job.ID)
+	assert.Equal(t, rolling.JobSucceeded, job.Status, job.Error)
+	ip1, ip2 := h.Nodes[0].IP, h.Nodes[1].IP
+	assert.Equal(t, []string{
+		ip1 + " systemctl stop cassandra", ip1 + ` CASSANDRA_VERSION=3.0.17 apt-get install -y cassandra="$CASSANDRA_VERSION"`, ip1 + " systemctl start cassandra",
+		ip2 + " systemctl stop cassandra", ip2 + ` CASSANDRA_VERSION=3.0.17 apt-get install -y cassandra="$CASSANDRA_VERSION"`, ip2 + " systemctl start cassandra",
+	}, commands())
+	for _, node := range h.Nodes {
+		assert.Equal(t, "3.0.17", node.Agent.Attribute(cassandratest.StorageService, "ReleaseVersion"))
+		snapshots := node.Agent.Snapshots()
+		if assert.NotEmpty(t, snapshots) {
+			assert.Equal(t, "pre-upgrade-"+job.ID, snapshots[0].Tag)
+		}
+		// the SSTables of all the keyspaces are upgraded
+		assert.Len(t, node.Agent.Executed(cassandratest.StorageService, "upgradeSSTables"), 3)
+	}
+}
+
+func TestRollingUpgradeChecks(t *testing.T) {
+	h := newHarness(t, 2)
+	defer h.Close()
+	setRollingCluster(h, "")
+	setUpgradeCommands(h)
+	orchestrator := h.Nodes[0]
+	h.Nodes[1].Agent.SetAttribute(cassandratest.StorageService, "SchemaVersion", "8e3b3f2c-1c4b-3f9e-8a2d-5b6c7d8e9f00")
+
+	assertStatus(t, http.StatusBadRequest, orchestrator.RequestWithBody("POST", "/upgrades", `{"version": "latest"}`))
+	assertStatus(t, http.StatusBadRequest, orchestrator.RequestWithBody("POST", "/upgrades", `{"version": "4.0.0-x; touch /tmp/pwned #"}`))
+	w := orchestrator.RequestWithBody("POST", "/upgrades", `{"version": "4.0.0"}`)
+	assertStatus(t, http.StatusConflict, w)
+	assert.Contains(t, w.Body.String(), "The nodes disagree on the schema version")
+
+	h.Nodes[1].Agent.SetAttribute(cassandratest.StorageService, "SchemaVersion", "59adb24e-f3cd-3e02-97f0-5b395827453f")
+	w = orchestrator.RequestWithBody("POST", "/upgrades", `{"version": "3.11.1"}`)
+	assertStatus(t, http.StatusConflict, w)
+	assert.Contains(t, w.Body.String(), "Version 3.11.1 is not newer than 3.11.4")
+	assert.Empty(t, orchestrator.CaOps.rolling.Jobs())
+}
@@ -1,7 +1,14 @@
 package server
 
 import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/Sirupsen/logrus"
 )
 
 func stringListToMapKeys(list []string) map[string]bool {
@@ -22,3 +29,51 @@ func getErrStr(err error) string {
 	}
 	return ""
 }
+
+const (
+	contentTypeJSON = "application/json"
+	contentTypeText = "text/plain"
+)
+
+// negotiateContentType returns the offered content type the client prefers most, according
+// to its Accept header. The first offer is the default, when no preference is expressed.
+func negotiateContentType(r *http.Request, offers ...string) string {
+	best, bestQ := offers[0], 0.0
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if qStr, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(qStr, 64); err != nil {
+				continue
+			}
+		}
+		for _, offer := range offers {
+			if q > bestQ && acceptsMediaType(mediaType, offer) {
+				best, bestQ = offer, q
+			}
+		}
+	}
+	return best
+}
+
+func acceptsMediaType(accepted, offer string) bool {
+	if accepted == "*/*" || accepted == offer {
+		return true
+	}
+	return strings.HasSuffix(accepted, "/*") &&
+		strings.HasPrefix(offer, strings.TrimSuffix(accepted, "*"))
+}
+
+// writeJSON writes the value as an indented JSON document, with the given HTTP status
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(value); err != nil {
+		logrus.Error(err)
+	}
+}
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"net/http/httptest"
 	"testing"
 	"time"
 
@@ -18,3 +19,19 @@ func TestGetNextRoundedTimeWithin(t *testing.T) {
 	next = getNextRoundedTimeWithin(start, 1*time.Minute)
 	assert.Equal(t, "2017-09-13 11:07:00", next.Format(format))
 }
+
+func TestNegotiateContentType(t *testing.T) {
+	for accept, expected := range map[string]string{
+		"":                                   contentTypeJSON,
+		"*/*":                                contentTypeJSON,
+		"text/plain":                         contentTypeText,
+		"text/*":                             contentTypeText,
+		"application/json, text/plain":       contentTypeJSON,
+		"application/json;q=0.5, text/plain": contentTypeText,
+		"text/html":                          contentTypeJSON,
+	} {
+		r := httptest.NewRequest("GET", "/status", nil)
+		r.Header.Set("Accept", accept)
+		assert.Equal(t, expected, negotiateContentType(r, contentTypeJSON, contentTypeText), accept)
+	}
+}
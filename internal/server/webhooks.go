@@ -0,0 +1,185 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/CrossEngage/CaOps/internal/health"
+	"github.com/CrossEngage/CaOps/internal/webhook"
+	"github.com/Sirupsen/logrus"
+	"github.com/hashicorp/serf/serf"
+)
+
+// DefaultWebhooksCheckInterval is the interval at which the unreachable nodes and the health
+// checks are compared with the previous check, to notify their changes
+const DefaultWebhooksCheckInterval = time.Minute
+
+// Types of the events notified to the webhooks
+const (
+	eventBackupStarted    = "backup.started"
+	eventBackupCompleted  = "backup.completed"
+	eventBackupFailed     = "backup.failed"
+	eventSnapshotCleared  = "snapshot.cleared"
+	eventMemberJoined     = "member.joined"
+	eventMemberFailed     = "member.failed"
+	eventNodesUnreachable = "cassandra.unreachable"
+	eventNodesReachable   = "cassandra.reachable"
+	eventHealthFlipped    = "health.flipped"
+)
+
+// WebhooksConfig holds the webhooks notified of the events, and the interval at which the
+// unreachable nodes and the health checks are checked for changes
+type WebhooksConfig struct {
+	Hooks         []webhook.Config
+	CheckInterval time.Duration
+}
+
+func (config WebhooksConfig) withDefaults() WebhooksConfig {
+	if config.CheckInterval == 0 {
+		config.CheckInterval = DefaultWebhooksCheckInterval
+	}
+	return config
+}
+
+// watchedState is the state seen at the previous check of the changes notified to the
+// webhooks: the unreachable nodes and whether each health check passed
+type watchedState struct {
+	unreachable map[string]bool
+	passed      map[string]bool
+}
+
+// notify notifies the webhooks of the event of this node, if any
+func (caops *CaOps) notify(eventType, message string, details map[string]string) {
+	if !caops.webhooks.Enabled() {
+		return
+	}
+	node := caops.cassMngr.JMXTarget()
+	if caops.gossiper != nil {
+		node = caops.gossiper.LocalAddr()
+	}
+	// the event is sent in the background, while the caller may go on updating its details
+	var copied map[string]string
+	if details != nil {
+		copied = make(map[string]string, len(details))
+		for key, value := range details {
+			copied[key] = value
+		}
+	}
+	caops.webhooks.Notify(webhook.Event{
+		Type:    eventType,
+		Time:    caops.clock.Now(),
+		Node:    node,
+		Message: message,
+		Details: copied,
+	})
+}
+
+// memberEventHandler notifies the agents joining or failing, if this agent has the lowest IP
+// of the cluster, so they are notified once
+func (caops *CaOps) memberEventHandler(event serf.MemberEvent) {
+	eventType := map[serf.EventType]string{
+		serf.EventMemberJoin:   eventMemberJoined,
+		serf.EventMemberFailed: eventMemberFailed,
+	}[event.Type]
+	if eventType == "" || !caops.isScheduler() {
+		return
+	}
+	for _, member := range event.Members {
+		address := member.Addr.String()
+		message := fmt.Sprintf("The CaOps agent of %s joined the cluster", address)
+		if event.Type == serf.EventMemberFailed {
+			message = fmt.Sprintf("The CaOps agent of %s failed", address)
+		}
+		caops.notify(eventType, message, map[string]string{"member": member.Name, "address": address})
+	}
+}
+
+// watchEvents notifies the webhooks of the changes of the unreachable nodes and the health
+// checks, at the check interval
+func (caops *CaOps) watchEvents() {
+	for {
+		select {
+		case <-caops.clock.After(caops.webhooksConfig.CheckInterval):
+			caops.checkUnreachableNodes()
+			caops.checkHealthFlips()
+		case <-caops.ctx.Done():
+			return
+		}
+	}
+}
+
+// checkUnreachableNodes notifies the nodes which became unreachable, or reachable again, since
+// the previous check, if this agent has the lowest IP of the cluster
+func (caops *CaOps) checkUnreachableNodes() {
+	if caops.gossiper != nil && !caops.isScheduler() {
+		caops.watched.unreachable = nil
+		return
+	}
+	nodes, err := caops.cassMngr.UnreachableNodes(caops.ctx)
+	if err != nil {
+		logrus.Warnf("Could not read the unreachable nodes: %s", err)
+		return
+	}
+	unreachable := stringListToMapKeys(nodes)
+	previous := caops.watched.unreachable
+	caops.watched.unreachable = unreachable
+	if previous == nil {
+		return
+	}
+	down, up := make([]string, 0), make([]string, 0)
+	for node := range unreachable {
+		if !previous[node] {
+			down = append(down, node)
+		}
+	}
+	for node := range previous {
+		if !unreachable[node] {
+			up = append(up, node)
+		}
+	}
+	sort.Strings(down)
+	sort.Strings(up)
+	if len(down) > 0 {
+		caops.notify(eventNodesUnreachable, fmt.Sprintf("Cassandra nodes %s became unreachable", strings.Join(down, ", ")),
+			map[string]string{"nodes": strings.Join(down, ","), "unreachable": strings.Join(nodes, ",")})
+	}
+	if len(up) > 0 {
+		caops.notify(eventNodesReachable, fmt.Sprintf("Cassandra nodes %s are reachable again", strings.Join(up, ", ")),
+			map[string]string{"nodes": strings.Join(up, ","), "unreachable": strings.Join(nodes, ",")})
+	}
+}
+
+// checkHealthFlips runs the health checks, notifying the ones which started or stopped passing
+// since the previous check. Every agent runs the checks of its node, but only the agent with
+// the lowest IP of the cluster runs the checks of the whole cluster, so they are notified once.
+func (caops *CaOps) checkHealthFlips() {
+	names := nodeChecks
+	if caops.gossiper == nil || caops.isScheduler() {
+		names = caops.health.Names()
+	}
+	results, err := caops.health.Run(withHealthCaller(caops.ctx, healthCallerWatcher), nil, names...)
+	if err != nil {
+		logrus.Warnf("Could not run the health checks: %s", err)
+		return
+	}
+	previous := caops.watched.passed
+	caops.watched.passed = make(map[string]bool, len(results))
+	for _, result := range results {
+		passed := result.Status == health.Passed
+		caops.watched.passed[result.Name] = passed
+		if was, ok := previous[result.Name]; !ok || was == passed {
+			continue
+		}
+		message := fmt.Sprintf("Health check %s passes again", result.Name)
+		if !passed {
+			message = fmt.Sprintf("Health check %s %s: %s", result.Name, result.Status, result.Message)
+		}
+		caops.notify(eventHealthFlipped, message, map[string]string{
+			"check":    result.Name,
+			"severity": string(result.Severity),
+			"status":   string(result.Status),
+		})
+	}
+}
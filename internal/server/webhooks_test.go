@@ -0,0 +1,188 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/CrossEngage/CaOps/internal/cassandra/cassandratest"
+	"github.com/CrossEngage/CaOps/internal/webhook"
+	"github.com/hashicorp/serf/serf"
+	"github.com/stretchr/testify/assert"
+)
+
+// webhookEndpoint records the events notified to it
+type webhookEndpoint struct {
+	mtx    sync.Mutex
+	events []webhook.Event
+}
+
+func (e *webhookEndpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	event := webhook.Event{}
+	if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	e.events = append(e.events, event)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Take waits until the notifications of the agent are done, and returns the events of the types
+// notified so far, forgetting them
+func (e *webhookEndpoint) Take(caops *CaOps, types ...string) []webhook.Event {
+	caops.webhooks.Wait()
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	taken, kept := make([]webhook.Event, 0), make([]webhook.Event, 0)
+	for _, event := range e.events {
+		if stringListToMapKeys(types)[event.Type] {
+			taken = append(taken, event)
+		} else {
+			kept = append(kept, event)
+		}
+	}
+	e.events = kept
+	return taken
+}
+
+// setWebhook makes the agent notify the events to a recording endpoint
+func setWebhook(t *testing.T, caops *CaOps) (*webhookEndpoint, func()) {
+	e := &webhookEndpoint{}
+	server := httptest.NewServer(e)
+	notifier, err := webhook.NewNotifier([]webhook.Config{{URL: server.URL}})
+	assert.Nil(t, err)
+	caops.webhooks = notifier
+	return e, func() {
+		notifier.Close()
+		server.Close()
+	}
+}
+
+func eventTypes(events []webhook.Event) []string {
+	types := make([]string, 0, len(events))
+	for _, event := range events {
+		types = append(types, event.Type)
+	}
+	return types
+}
+
+func TestBackupWebhooks(t *testing.T) {
+	caops, agent := newTestCaOps(t)
+	defer agent.Close()
+	e, closeWebhook := setWebhook(t, caops)
+	defer closeWebhook()
+	agent.AddTable("ks1", "users")
+	backupEvents := []string{eventBackupStarted, eventBackupCompleted, eventBackupFailed}
+
+	_, err := caops.backupEventHandler(backupEvent("ks1", "users"))
+	assert.Nil(t, err)
+	events := e.Take(caops, backupEvents...)
+	if assert.Len(t, events, 2) {
+		assert.Equal(t, eventBackupStarted, events[0].Type)
+		assert.NotContains(t, events[0].Details, "tags")
+		completed := events[1]
+		assert.Equal(t, eventBackupCompleted, completed.Type)
+		assert.Equal(t, "ks1", completed.Details["keyspaces"])
+		assert.Equal(t, "users", completed.Details["table"])
+		assert.Equal(t, agent.Snapshots()[0].Tag, completed.Details["tags"])
+	}
+
+	agent.Fail(cassandratest.StorageService, "takeTableSnapshot", cassandratest.Failure{Message: "disk full"})
+	_, err = caops.backupEventHandler(backupEvent("ks1", "users"))
+	assert.NotNil(t, err)
+	events = e.Take(caops, backupEvents...)
+	assert.Equal(t, []string{eventBackupStarted, eventBackupFailed}, eventTypes(events))
+	if assert.Len(t, events, 2) {
+		assert.Contains(t, events[1].Message, "disk full")
+	}
+
+	_, err = caops.clearSnapshotEventHandler(serf.UserEvent{Name: "clearsnapshot"})
+	assert.Nil(t, err)
+	assert.Len(t, e.Take(caops, eventSnapshotCleared), 1)
+}
+
+func TestBackupNotTriggeredWebhook(t *testing.T) {
+	h := newHarness(t, 2)
+	defer h.Close()
+	node := h.Nodes[0]
+	node.Agent.SetAttribute(cassandratest.StorageProxy, "SchemaVersions", map[string][]string{
+		"59adb24e-f3cd-3e02-97f0-5b395827453f": {"127.0.0.1"},
+		outlierSchema:                          {"127.0.0.2"},
+	})
+
+	assertStatus(t, http.StatusConflict, node.Request("GET", "/backup-tables/ks1/users"))
+	events := node.Webhook.Take(node.CaOps, eventBackupFailed)
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, "ks1", events[0].Details["keyspaces"])
+		assert.Equal(t, "users", events[0].Details["table"])
+		assert.Contains(t, events[0].Message, "The nodes disagree on the schema version")
+	}
+}
+
+func TestClusterWebhooks(t *testing.T) {
+	h := newHarness(t, 3)
+	defer h.Close()
+	scheduler, other, dead := h.Nodes[0], h.Nodes[1], h.Nodes[2]
+	scheduler.Agent.SetAttribute("org.apache.cassandra.db:type=CompactionManager", "Compactions", []map[string]string{})
+	scheduler.Agent.SetAttribute(pendingCompactionsMBean, "Value", 3)
+	scheduler.Agent.SetAttribute("org.apache.cassandra.metrics:type=Compaction,name=TotalCompactionsCompleted", "Count", 42)
+	scheduler.Agent.SetAttribute("org.apache.cassandra.metrics:type=ThreadPools,path=request,scope=MutationStage,name=ActiveTasks", "Value", 0)
+	scheduler.Agent.SetAttribute(droppedMutationsMBean, "Count", 0)
+	nodeEvents := []string{eventMemberFailed, eventNodesUnreachable, eventNodesReachable}
+
+	// the first check only records the state to compare with
+	scheduler.CaOps.checkUnreachableNodes()
+	scheduler.CaOps.checkHealthFlips()
+	assert.Empty(t, scheduler.Webhook.Take(scheduler.CaOps, append(nodeEvents, eventHealthFlipped)...))
+
+	// the failed agent and its unreachable Cassandra node are only notified by the scheduler
+	killNode(h, dead)
+	for _, node := range h.Nodes[:2] {
+		node.CaOps.checkUnreachableNodes()
+	}
+	events := make([]webhook.Event, 0)
+	h.eventually("the failed agent is notified", func() bool {
+		events = append(events, scheduler.Webhook.Take(scheduler.CaOps, nodeEvents...)...)
+		return len(events) == 2
+	})
+	assert.Contains(t, eventTypes(events), eventMemberFailed)
+	assert.Contains(t, eventTypes(events), eventNodesUnreachable)
+	for _, event := range events {
+		assert.Equal(t, scheduler.IP, event.Node)
+		assert.Equal(t, dead.IP, event.Details["address"]+event.Details["nodes"])
+	}
+	assert.Empty(t, other.Webhook.Take(other.CaOps, nodeEvents...))
+
+	for _, node := range h.Nodes {
+		node.Agent.SetAttribute(cassandratest.StorageService, "UnreachableNodes", []string{})
+	}
+	scheduler.CaOps.checkUnreachableNodes()
+	assert.Equal(t, []string{eventNodesReachable}, eventTypes(scheduler.Webhook.Take(scheduler.CaOps, nodeEvents...)))
+
+	// the health checks are notified when they start or stop passing, the checks of the
+	// cluster only by the scheduler
+	other.CaOps.checkHealthFlips()
+	assert.Len(t, other.CaOps.watched.passed, len(nodeChecks))
+	for _, name := range nodeChecks {
+		assert.Contains(t, other.CaOps.watched.passed, name)
+	}
+	scheduler.CaOps.checkHealthFlips()
+	assert.Len(t, scheduler.CaOps.watched.passed, len(scheduler.CaOps.health.Names()))
+	scheduler.Webhook.Take(scheduler.CaOps, eventHealthFlipped)
+	scheduler.Agent.SetAttribute(pendingCompactionsMBean, "Value", 500)
+	scheduler.CaOps.checkHealthFlips()
+	scheduler.CaOps.checkHealthFlips()
+	scheduler.Agent.SetAttribute(pendingCompactionsMBean, "Value", 3)
+	scheduler.CaOps.checkHealthFlips()
+	events = scheduler.Webhook.Take(scheduler.CaOps, eventHealthFlipped)
+	if assert.Len(t, events, 2) {
+		assert.Equal(t, checkPendingCompaction, events[0].Details["check"])
+		assert.Equal(t, "failed", events[0].Details["status"])
+		assert.Equal(t, checkPendingCompaction, events[1].Details["check"])
+		assert.Equal(t, "passed", events[1].Details["status"])
+	}
+}
@@ -0,0 +1,246 @@
+// Package webhook posts JSON notifications of events, like the end of a backup, to HTTP
+// endpoints. The bodies are rendered from templates, signed with HMAC, and retried until the
+// endpoints take them.
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Headers of the notifications
+const (
+	// SignatureHeader holds the hex encoded HMAC-SHA256 of the body, keyed by the secret of the
+	// webhook, as sha256=<signature>
+	SignatureHeader = "X-CaOps-Signature"
+	EventHeader     = "X-CaOps-Event"
+)
+
+// Default settings of a webhook
+const (
+	DefaultTimeout     = 10 * time.Second
+	DefaultMaxAttempts = 5
+	DefaultRetryDelay  = 5 * time.Second
+)
+
+// Config holds the settings of a webhook. It is notified of the events whose type matches one
+// of its event patterns, like backup.* or member.failed, or of all of them without patterns.
+// The body is the JSON of the event, unless a template is given, which is rendered with the
+// event, and must render JSON. The failed notifications are retried after the retry delay,
+// which doubles after each attempt.
+type Config struct {
+	Name        string        `mapstructure:"name"`
+	URL         string        `mapstructure:"url"`
+	Secret      string        `mapstructure:"secret"`
+	Events      []string      `mapstructure:"events"`
+	Template    string        `mapstructure:"template"`
+	Timeout     time.Duration `mapstructure:"timeout"`
+	MaxAttempts int           `mapstructure:"max_attempts"`
+	RetryDelay  time.Duration `mapstructure:"retry_delay"`
+}
+
+func (config Config) withDefaults() Config {
+	if config.Name == "" {
+		config.Name = config.URL
+	}
+	if config.Timeout == 0 {
+		config.Timeout = DefaultTimeout
+	}
+	if config.MaxAttempts == 0 {
+		config.MaxAttempts = DefaultMaxAttempts
+	}
+	if config.RetryDelay == 0 {
+		config.RetryDelay = DefaultRetryDelay
+	}
+	return config
+}
+
+// Event is an event notified to the webhooks
+type Event struct {
+	Type    string            `json:"type"`
+	Time    time.Time         `json:"time"`
+	Node    string            `json:"node"`
+	Message string            `json:"message"`
+	Details map[string]string `json:"details,omitempty"`
+}
+
+type hook struct {
+	config   Config
+	template *template.Template
+	client   *http.Client
+}
+
+// matches tells whether the webhook is notified of the events of the type
+func (h *hook) matches(eventType string) bool {
+	if len(h.config.Events) == 0 {
+		return true
+	}
+	for _, pattern := range h.config.Events {
+		if ok, _ := path.Match(pattern, eventType); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// body renders the body of the notification of the event
+func (h *hook) body(event Event) ([]byte, error) {
+	if h.template == nil {
+		return json.Marshal(event)
+	}
+	var buf bytes.Buffer
+	if err := h.template.Execute(&buf, event); err != nil {
+		return nil, err
+	}
+	if !json.Valid(buf.Bytes()) {
+		return nil, fmt.Errorf("The template did not render JSON: %s", buf.String())
+	}
+	return buf.Bytes(), nil
+}
+
+// sign returns the signature of the body, keyed by the secret
+func sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// templateFuncs are the functions of the templates: json renders a value as JSON, like a
+// string with its quotes escaped
+var templateFuncs = template.FuncMap{
+	"json": func(value interface{}) (string, error) {
+		data, err := json.Marshal(value)
+		return string(data), err
+	},
+}
+
+// Notifier notifies the webhooks of the events, in the background
+type Notifier struct {
+	hooks []*hook
+	wg    sync.WaitGroup
+	stop  chan struct{}
+	once  sync.Once
+}
+
+// NewNotifier checks the settings of the webhooks, and parses their templates
+func NewNotifier(configs []Config) (*Notifier, error) {
+	n := &Notifier{stop: make(chan struct{})}
+	for _, config := range configs {
+		config = config.withDefaults()
+		if config.URL == "" {
+			return nil, fmt.Errorf("Webhook %s has no URL", config.Name)
+		}
+		h := &hook{config: config, client: &http.Client{Timeout: config.Timeout}}
+		if config.Template != "" {
+			tmpl, err := template.New(config.Name).Funcs(templateFuncs).Parse(config.Template)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid template of webhook %s: %s", config.Name, err)
+			}
+			h.template = tmpl
+		}
+		n.hooks = append(n.hooks, h)
+	}
+	return n, nil
+}
+
+// Enabled tells whether any webhook is notified. A nil notifier has none.
+func (n *Notifier) Enabled() bool {
+	return n != nil && len(n.hooks) > 0
+}
+
+// Notify notifies the webhooks matching the event in the background, retrying until they take
+// it, or the attempts are exhausted
+func (n *Notifier) Notify(event Event) {
+	if !n.Enabled() {
+		return
+	}
+	for _, h := range n.hooks {
+		if !h.matches(event.Type) {
+			continue
+		}
+		n.wg.Add(1)
+		go func(h *hook) {
+			defer n.wg.Done()
+			if err := n.deliver(h, event); err != nil {
+				logrus.Errorf("Could not notify webhook %s of %s: %s", h.config.Name, event.Type, err)
+			}
+		}(h)
+	}
+}
+
+// Wait waits until the notifications in progress are done
+func (n *Notifier) Wait() {
+	if n != nil {
+		n.wg.Wait()
+	}
+}
+
+// Close stops retrying the notifications in progress, and waits until they are done
+func (n *Notifier) Close() {
+	if n == nil {
+		return
+	}
+	n.once.Do(func() { close(n.stop) })
+	n.wg.Wait()
+}
+
+func (n *Notifier) deliver(h *hook, event Event) error {
+	body, err := h.body(event)
+	if err != nil {
+		return err
+	}
+	delay := h.config.RetryDelay
+	for attempt := 1; ; attempt++ {
+		retry, err := h.post(event.Type, body)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= h.config.MaxAttempts {
+			return fmt.Errorf("%s, after %d attempts", err, attempt)
+		}
+		logrus.Warnf("Could not notify webhook %s of %s, retrying in %s: %s", h.config.Name, event.Type, delay, err)
+		select {
+		case <-time.After(delay):
+			delay *= 2
+		case <-n.stop:
+			return fmt.Errorf("%s, and the notifier was closed", err)
+		}
+	}
+}
+
+// post sends the body, returning whether it should be retried when it failed: the requests
+// rejected by the endpoint are not, unless it is throttled
+func (h *hook) post(eventType string, body []byte) (retry bool, err error) {
+	req, err := http.NewRequest("POST", h.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, eventType)
+	if h.config.Secret != "" {
+		req.Header.Set(SignatureHeader, sign(h.config.Secret, body))
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	err = fmt.Errorf("The webhook responded with %s", resp.Status)
+	rejected := resp.StatusCode >= 400 && resp.StatusCode < 500 &&
+		resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests
+	return !rejected, err
+}
@@ -0,0 +1,150 @@
+package webhook
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// endpoint records the notifications it receives, responding with the statuses in turn, and
+// then with 204 No Content
+type endpoint struct {
+	mtx      sync.Mutex
+	statuses []int
+	requests []*http.Request
+	bodies   []string
+}
+
+func (e *endpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	e.requests = append(e.requests, r)
+	e.bodies = append(e.bodies, string(body))
+	status := http.StatusNoContent
+	if len(e.statuses) > 0 {
+		status, e.statuses = e.statuses[0], e.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+func newEndpoint(statuses ...int) (*endpoint, *httptest.Server) {
+	e := &endpoint{statuses: statuses}
+	return e, httptest.NewServer(e)
+}
+
+var event = Event{
+	Type:    "backup.failed",
+	Time:    time.Date(2018, 1, 1, 12, 0, 0, 0, time.UTC),
+	Node:    "127.0.0.1",
+	Message: `Snapshot of "ks1" failed`,
+	Details: map[string]string{"keyspaces": "ks1"},
+}
+
+func TestNotify(t *testing.T) {
+	e, server := newEndpoint()
+	defer server.Close()
+	n, err := NewNotifier([]Config{
+		{Name: "all", URL: server.URL, Secret: "s3cret"},
+		{Name: "members", URL: server.URL, Events: []string{"member.*"}},
+	})
+	assert.Nil(t, err)
+	assert.True(t, n.Enabled())
+	n.Notify(event)
+	n.Wait()
+
+	if assert.Len(t, e.requests, 1) {
+		r := e.requests[0]
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.Equal(t, "backup.failed", r.Header.Get(EventHeader))
+		assert.Equal(t, sign("s3cret", []byte(e.bodies[0])), r.Header.Get(SignatureHeader))
+		assert.Len(t, r.Header.Get(SignatureHeader), len("sha256=")+64)
+		notified := Event{}
+		assert.Nil(t, json.Unmarshal([]byte(e.bodies[0]), &notified))
+		assert.Equal(t, event, notified)
+	}
+}
+
+func TestNotifyTemplate(t *testing.T) {
+	e, server := newEndpoint()
+	defer server.Close()
+	n, err := NewNotifier([]Config{{
+		URL:      server.URL,
+		Template: `{"text": {{json (printf "%s on %s: %s" .Type .Node .Message)}}, "keyspaces": {{json .Details.keyspaces}}}`,
+	}})
+	assert.Nil(t, err)
+	n.Notify(event)
+	n.Wait()
+	if assert.Len(t, e.bodies, 1) {
+		assert.JSONEq(t, `{"text": "backup.failed on 127.0.0.1: Snapshot of \"ks1\" failed", "keyspaces": "ks1"}`, e.bodies[0])
+		assert.Empty(t, e.requests[0].Header.Get(SignatureHeader))
+	}
+
+	// the bodies which are not JSON are not sent
+	n, err = NewNotifier([]Config{{URL: server.URL, Template: `text: {{.Message}}`}})
+	assert.Nil(t, err)
+	n.Notify(event)
+	n.Wait()
+	assert.Len(t, e.bodies, 1)
+
+	_, err = NewNotifier([]Config{{URL: server.URL, Template: `{{.Message`}})
+	assert.NotNil(t, err)
+	_, err = NewNotifier([]Config{{Name: "nowhere"}})
+	assert.NotNil(t, err)
+}
+
+func TestNotifyRetries(t *testing.T) {
+	e, server := newEndpoint(http.StatusBadGateway, http.StatusTooManyRequests)
+	defer server.Close()
+	n, err := NewNotifier([]Config{{URL: server.URL, RetryDelay: time.Millisecond}})
+	assert.Nil(t, err)
+	n.Notify(event)
+	n.Wait()
+	assert.Len(t, e.requests, 3)
+
+	// the rejected notifications are not retried, nor the ones whose attempts are exhausted
+	e, server = newEndpoint(http.StatusBadRequest)
+	defer server.Close()
+	n, err = NewNotifier([]Config{{URL: server.URL, RetryDelay: time.Millisecond}})
+	assert.Nil(t, err)
+	n.Notify(event)
+	n.Wait()
+	assert.Len(t, e.requests, 1)
+
+	e, server = newEndpoint(http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError)
+	defer server.Close()
+	n, err = NewNotifier([]Config{{URL: server.URL, RetryDelay: time.Millisecond, MaxAttempts: 2}})
+	assert.Nil(t, err)
+	n.Notify(event)
+	n.Wait()
+	assert.Len(t, e.requests, 2)
+
+	// closing the notifier stops the retries
+	e, server = newEndpoint(http.StatusInternalServerError)
+	defer server.Close()
+	n, err = NewNotifier([]Config{{URL: server.URL, RetryDelay: time.Hour}})
+	assert.Nil(t, err)
+	n.Notify(event)
+	for {
+		e.mtx.Lock()
+		sent := len(e.requests)
+		e.mtx.Unlock()
+		if sent > 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	n.Close()
+	assert.Len(t, e.requests, 1)
+
+	var disabled *Notifier
+	assert.False(t, disabled.Enabled())
+	disabled.Notify(event)
+	disabled.Close()
+}